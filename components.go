@@ -0,0 +1,249 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"tmios/internal/cloudsync"
+	"tmios/internal/cmp"
+	"tmios/internal/config"
+	"tmios/internal/discovery"
+	"tmios/internal/grpc"
+	"tmios/internal/history"
+	"tmios/internal/http"
+	"tmios/internal/iot"
+	"tmios/internal/plugin"
+	"tmios/internal/proxy"
+	"tmios/internal/rbac"
+	"tmios/internal/storage"
+	"tmios/internal/upgrade"
+	"tmios/internal/user"
+	"tmios/pkg/api"
+)
+
+const defaultRole = "edge"
+
+// roles 各角色默认运行的组件：边缘网关采集设备并向中心同步，中心接收同步数据，代理只做TCP转发
+var roles = map[string][]string{
+	"edge":   {"config", "storage", "user", "rbac", "plugin", "history", "registry", "sync", "discovery", "upgrade", "proxy", "grpc", "http"},
+	"center": {"config", "storage", "user", "rbac", "history", "grpc", "http"},
+	"proxy":  {"config", "proxy"},
+}
+
+// httpAPIs 接口及其需要的组件，需要的组件全部启用时才注册；接口都用rbac鉴权
+var httpAPIs = []struct {
+	needs []string
+	opts  []http.Option
+}{
+	{[]string{"user", "rbac"}, []http.Option{api.WithUser(), api.WithRBAC()}},
+	{[]string{"rbac", "registry"}, []http.Option{api.WithDevice(), api.WithStream()}},
+	{[]string{"rbac", "plugin"}, []http.Option{api.WithPlugin()}},
+	{[]string{"rbac", "storage"}, []http.Option{api.WithSync()}},
+	{[]string{"rbac", "registry", "history"}, []http.Option{api.WithHistory()}},
+	{[]string{"rbac", "discovery"}, []http.Option{api.WithDiscovery()}},
+	{[]string{"rbac", "upgrade"}, []http.Option{api.WithUpgrade()}},
+}
+
+func init() {
+	cmp.Register("config", func(map[string]bool) (cmp.Srv, error) { return config.NewConfig(), nil })
+	cmp.Register("storage", func(map[string]bool) (cmp.Srv, error) { return storage.NewStorage(), nil })
+	cmp.Register("user", func(map[string]bool) (cmp.Srv, error) { return user.NewUsers(), nil })
+	cmp.Register("rbac", func(map[string]bool) (cmp.Srv, error) { return rbac.NewRBAC(), nil })
+	cmp.Register("plugin", func(map[string]bool) (cmp.Srv, error) { return plugin.NewManager(), nil })
+	cmp.Register("history", func(map[string]bool) (cmp.Srv, error) { return history.NewHistory(), nil })
+	cmp.Register("registry", func(map[string]bool) (cmp.Srv, error) { return iot.NewRegistry(), nil })
+	cmp.Register("sync", func(map[string]bool) (cmp.Srv, error) { return cloudsync.NewEdge(), nil })
+	cmp.Register("discovery", func(map[string]bool) (cmp.Srv, error) { return discovery.NewDiscovery(), nil })
+	cmp.Register("upgrade", func(map[string]bool) (cmp.Srv, error) { return upgrade.NewUpgrader(), nil })
+	cmp.Register("proxy", func(map[string]bool) (cmp.Srv, error) { return proxy.NewProxy(), nil })
+	cmp.Register("grpc", newGrpc)
+	cmp.Register("http", newHttp)
+}
+
+// newGrpc 设备服务需要registry和rbac，同步服务(中心)需要storage，启用history时同步的数据写入历史库
+func newGrpc(enabled map[string]bool) (cmp.Srv, error) {
+	var (
+		opts []grpc.Option
+		deps []string
+	)
+	if enabled["registry"] && enabled["rbac"] {
+		opts = append(opts, api.WithDeviceService())
+		deps = append(deps, "registry", "rbac")
+	}
+	if enabled["storage"] {
+		opts = append(opts, api.WithSyncService())
+		deps = append(deps, "storage")
+		if enabled["history"] {
+			deps = append(deps, "history")
+		}
+	}
+	return grpc.NewGrpc(append(opts, grpc.WithDependsOn(deps...))...), nil
+}
+
+// newHttp 只注册已启用组件的接口，并在这些组件之后启动
+func newHttp(enabled map[string]bool) (cmp.Srv, error) {
+	var (
+		opts = []http.Option{api.WithTest()}
+		deps []string
+		seen = make(map[string]bool)
+	)
+	for _, a := range httpAPIs {
+		if !all(enabled, a.needs) {
+			continue
+		}
+		opts = append(opts, a.opts...)
+		for _, name := range a.needs {
+			if !seen[name] {
+				seen[name] = true
+				deps = append(deps, name)
+			}
+		}
+	}
+	opts = append(opts, api.WithHealth(), http.WithDependsOn(deps...))
+	return http.NewHttp(opts...), nil
+}
+
+func all(enabled map[string]bool, names []string) bool {
+	for _, name := range names {
+		if !enabled[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// enabledComponents 角色的组件加上Enable，去掉Disable
+func enabledComponents(conf config.Components) ([]string, error) {
+	role := conf.Role
+	if role == "" {
+		role = defaultRole
+	}
+	names, ok := roles[role]
+	if !ok {
+		return nil, fmt.Errorf("components: unknown role %q", role)
+	}
+
+	disabled := make(map[string]bool)
+	for _, name := range conf.Disable {
+		disabled[name] = true
+	}
+	var (
+		enabled []string
+		seen    = make(map[string]bool)
+	)
+	for _, name := range append(append([]string(nil), names...), conf.Enable...) {
+		if seen[name] || disabled[name] {
+			continue
+		}
+		seen[name] = true
+		enabled = append(enabled, name)
+	}
+	return enabled, nil
+}
+
+// checkComponents 检查[Components]中的组件名和启用的组件需要的配置
+func checkComponents(conf *config.CnfFile) error {
+	var errs config.ConfigError
+	names, err := enabledComponents(conf.Components)
+	if err != nil {
+		errs.Add(fmt.Errorf("Components.Role: unknown role %q, expected one of center, edge, proxy", conf.Components.Role))
+		return errs
+	}
+
+	registered := make(map[string]bool)
+	for _, name := range cmp.Registered() {
+		registered[name] = true
+	}
+	for _, list := range []struct {
+		field string
+		names []string
+	}{{"Enable", conf.Components.Enable}, {"Disable", conf.Components.Disable}} {
+		for i, name := range list.names {
+			if !registered[name] {
+				errs.Add(fmt.Errorf("Components.%s[%d]: unknown component %q, expected one of %v", list.field, i, name, cmp.Registered()))
+			}
+		}
+	}
+
+	enabled := make(map[string]bool)
+	for _, name := range names {
+		enabled[name] = true
+	}
+	for name := range conf.Components.Policies {
+		if !enabled[name] {
+			errs.Add(fmt.Errorf("Components.Policies[%s]: component is not enabled", name))
+		}
+	}
+	if enabled["storage"] {
+		for _, f := range []struct{ name, value string }{
+			{"Ip", conf.MySQL.Ip}, {"Username", conf.MySQL.Username}, {"DatabaseName", conf.MySQL.DatabaseName},
+		} {
+			if f.value == "" {
+				errs.Add(fmt.Errorf("MySQL.%s: is required by the storage component", f.name))
+			}
+		}
+	}
+	return errs.Err()
+}
+
+// checkCmd tmios config check，做与启动时相同的检查，不启动任何组件
+func checkCmd(args []string) error {
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	loader := config.NewLoader(config.DefaultConfigFile)
+	loader.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	conf, _, err := loader.Load()
+	if conf == nil {
+		return err
+	}
+	var errs config.ConfigError
+	errs.Add(err)
+	errs.Add(checkComponents(conf))
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %w", loader.File(), errs)
+	}
+
+	fmt.Println(loader.File(), "ok")
+	return nil
+}
+
+// newCmp 按配置文件的[Components]创建组件并覆盖重启策略
+func newCmp(conf config.Components) (*cmp.Cmp, error) {
+	names, err := enabledComponents(conf)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cmp.Build(names...)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, override := range conf.Policies {
+		p, ok := c.Policy(name)
+		if !ok {
+			return nil, fmt.Errorf("components: policy for %s, which is not enabled", name)
+		}
+		if override.Restart != "" {
+			p.Restart = override.Restart
+		}
+		if override.Optional != nil {
+			p.Optional = *override.Optional
+		}
+		if override.MaxRestarts > 0 {
+			p.MaxRestarts = override.MaxRestarts
+		}
+		if override.Window != "" {
+			if p.Window, err = time.ParseDuration(override.Window); err != nil {
+				return nil, fmt.Errorf("components: policy for %s: %w", name, err)
+			}
+		}
+		if err := c.SetPolicy(name, p); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,388 @@
+package cloudsync
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"tmios/internal/config"
+	"tmios/internal/history"
+	"tmios/lib/errors"
+	"tmios/lib/sql"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+	"tmios/pkg/proto/syncv1"
+)
+
+// Sink 中心端应用边缘记录，默认写入数据库和历史库
+type Sink interface {
+	Device(edgeID string, data syncv1.DeviceData) error
+	Props(edgeID string, snapshot bool, t time.Time, data syncv1.PropsData) error
+	Alarm(edgeID string, t time.Time, data syncv1.AlarmData) error
+}
+
+// EdgeStatus 已连接的边缘节点
+type EdgeStatus struct {
+	EdgeID    string    `json:"edge_id"`
+	Cursor    uint64    `json:"cursor"`
+	Connected time.Time `json:"connected"`
+}
+
+type session struct {
+	status EdgeStatus
+
+	sendMutex sync.Mutex
+	stream    syncv1.StreamServer
+
+	mutex   sync.Mutex
+	pending map[string]chan *syncv1.ActionResult
+}
+
+func (s *session) send(m *syncv1.Downstream) error {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	return s.stream.Send(m)
+}
+
+// Central 接收边缘节点上送的数据，并向边缘节点下发设备操作
+type Central struct {
+	cnf  *config.Config
+	sink Sink
+
+	migrateOnce sync.Once
+	migrateErr  error
+
+	mutex    sync.RWMutex
+	sessions map[string]*session
+	actionID uint64
+}
+
+type CentralOption func(c *Central)
+
+func WithSink(sink Sink) CentralOption {
+	return func(c *Central) {
+		c.sink = sink
+	}
+}
+
+var (
+	central     *Central
+	centralOnce sync.Once
+)
+
+// NewCentral 单例，只有第一次调用的opts生效
+func NewCentral(opts ...CentralOption) *Central {
+	centralOnce.Do(func() {
+		central = &Central{
+			cnf:      config.NewConfig(),
+			sessions: make(map[string]*session),
+		}
+		for _, opt := range opts {
+			opt(central)
+		}
+		if central.sink == nil {
+			central.sink = &dbSink{cnf: central.cnf, hist: history.NewHistory()}
+		}
+	})
+	return central
+}
+
+func (c *Central) db() (*gorm.DB, error) {
+	c.migrateOnce.Do(func() {
+		c.migrateErr = c.cnf.Db.AutoMigrate(&model.EdgeDevice{}, &model.EdgeAlarm{}, &model.SyncCursor{})
+	})
+
+	return c.cnf.Db, c.migrateErr
+}
+
+func cursorName(edgeID string) string {
+	return "central:" + edgeID
+}
+
+func (c *Central) cursor(db *gorm.DB, edgeID string) (uint64, error) {
+	cursor, err := sql.GetModel[model.SyncCursor](db, func(q *gorm.DB) *gorm.DB {
+		return q.Where("name = ?", cursorName(edgeID))
+	})
+	if err != nil || cursor == nil {
+		return 0, err
+	}
+
+	return cursor.Seq, nil
+}
+
+// Stream 实现syncv1.SyncServer
+func (c *Central) Stream(stream syncv1.StreamServer) error {
+	db, err := c.db()
+	if err != nil {
+		return err
+	}
+
+	m, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if m.Hello == nil || m.Hello.EdgeID == "" {
+		return errm.ErrSyncProtocol.SetDetail("first message must be hello")
+	}
+	edgeID := m.Hello.EdgeID
+
+	cursor, err := c.cursor(db, edgeID)
+	if err != nil {
+		return err
+	}
+
+	s := &session{
+		status:  EdgeStatus{EdgeID: edgeID, Cursor: cursor, Connected: time.Now()},
+		stream:  stream,
+		pending: make(map[string]chan *syncv1.ActionResult),
+	}
+	c.mutex.Lock()
+	c.sessions[edgeID] = s
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		if c.sessions[edgeID] == s {
+			delete(c.sessions, edgeID)
+		}
+		c.mutex.Unlock()
+	}()
+
+	log := logrus.WithField("edge", edgeID)
+	log.WithField("cursor", cursor).Info("sync: edge connected")
+
+	// 边缘端可能在收到ack前断开，告知已应用的游标避免重复上送
+	if err := s.send(&syncv1.Downstream{Ack: &cursor}); err != nil {
+		return err
+	}
+
+	for {
+		m, err := stream.Recv()
+		if err != nil {
+			log.WithError(err).Info("sync: edge disconnected")
+			return nil
+		}
+
+		if m.ActionResult != nil {
+			s.mutex.Lock()
+			ch, ok := s.pending[m.ActionResult.ID]
+			delete(s.pending, m.ActionResult.ID)
+			s.mutex.Unlock()
+			if ok {
+				ch <- m.ActionResult
+			}
+		}
+
+		for _, record := range m.Snapshot {
+			if err := c.apply(edgeID, record); err != nil {
+				log.WithError(err).WithField("kind", record.Kind).Error("sync: apply snapshot failed")
+				return err
+			}
+		}
+
+		if len(m.Records) == 0 {
+			continue
+		}
+
+		for _, record := range m.Records {
+			if record.Seq <= cursor {
+				continue
+			}
+			if err := c.apply(edgeID, record); err != nil {
+				log.WithError(err).WithField("seq", record.Seq).Error("sync: apply record failed")
+				return err
+			}
+			cursor = record.Seq
+		}
+
+		if err := db.Save(&model.SyncCursor{Name: cursorName(edgeID), Seq: cursor}).Error; err != nil {
+			return err
+		}
+
+		c.mutex.Lock()
+		s.status.Cursor = cursor
+		c.mutex.Unlock()
+
+		if err := s.send(&syncv1.Downstream{Ack: &cursor}); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Central) apply(edgeID string, record syncv1.Record) error {
+	switch record.Kind {
+	case syncv1.KindDevice:
+		var data syncv1.DeviceData
+		if err := json.Unmarshal(record.Data, &data); err != nil {
+			return err
+		}
+		return c.sink.Device(edgeID, data)
+	case syncv1.KindSnapshot, syncv1.KindPoint:
+		var data syncv1.PropsData
+		if err := json.Unmarshal(record.Data, &data); err != nil {
+			return err
+		}
+		return c.sink.Props(edgeID, record.Kind == syncv1.KindSnapshot, record.Time, data)
+	case syncv1.KindAlarm:
+		var data syncv1.AlarmData
+		if err := json.Unmarshal(record.Data, &data); err != nil {
+			return err
+		}
+		return c.sink.Alarm(edgeID, record.Time, data)
+	default:
+		// 新版本边缘端的未知记录跳过，不阻塞同步
+		logrus.WithField("kind", record.Kind).Warn("sync: unknown record kind")
+		return nil
+	}
+}
+
+// Action 通过边缘节点的连接执行设备操作
+func (c *Central) Action(ctx context.Context, edgeID string, deviceID uint, name string, args []byte) ([]byte, error) {
+	c.mutex.RLock()
+	s, ok := c.sessions[edgeID]
+	c.mutex.RUnlock()
+	if !ok {
+		return nil, errm.ErrEdgeOffline.SetDetail("%s", edgeID)
+	}
+
+	var (
+		id = strconv.FormatUint(atomic.AddUint64(&c.actionID, 1), 10)
+		ch = make(chan *syncv1.ActionResult, 1)
+	)
+	s.mutex.Lock()
+	s.pending[id] = ch
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.pending, id)
+		s.mutex.Unlock()
+	}()
+
+	if err := s.send(&syncv1.Downstream{Action: &syncv1.Action{
+		ID:       id,
+		DeviceID: deviceID,
+		Name:     name,
+		Args:     args,
+	}}); err != nil {
+		return nil, errm.ErrEdgeOffline.SetDetail("%s: %s", edgeID, err.Error())
+	}
+
+	select {
+	case result := <-ch:
+		if result.Error != "" {
+			// 边缘端返回的可能不是lib/errors.Error，没有错误码时按内部错误处理，不能当作成功
+			if e := errors.Parse(result.Error); e.Code != 0 {
+				return nil, e
+			}
+			return nil, errors.InternalNew(result.Error, result.Error)
+		}
+		return result.Rets, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Edges 当前连接的边缘节点
+func (c *Central) Edges() []EdgeStatus {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	arr := make([]EdgeStatus, 0, len(c.sessions))
+	for _, s := range c.sessions {
+		arr = append(arr, s.status)
+	}
+
+	return arr
+}
+
+type dbSink struct {
+	cnf  *config.Config
+	hist *history.History
+}
+
+func (d *dbSink) edgeDevice(db *gorm.DB, edgeID string, deviceID uint) (*model.EdgeDevice, error) {
+	row, err := sql.GetModel[model.EdgeDevice](db, func(q *gorm.DB) *gorm.DB {
+		return q.Where("edge_id = ? AND device_id = ?", edgeID, deviceID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		row = &model.EdgeDevice{EdgeID: edgeID, DeviceID: deviceID}
+	}
+
+	return row, nil
+}
+
+func (d *dbSink) Device(edgeID string, data syncv1.DeviceData) error {
+	return d.cnf.Db.Transaction(func(tx *gorm.DB) error {
+		row, err := d.edgeDevice(tx, edgeID, data.ID)
+		if err != nil {
+			return err
+		}
+
+		row.Deleted = data.Deleted
+		if !data.Deleted {
+			row.Name = data.Name
+			row.ModelName = data.Model
+			row.Config = string(data.Config)
+		}
+
+		return tx.Save(row).Error
+	})
+}
+
+func (d *dbSink) Props(edgeID string, snapshot bool, t time.Time, data syncv1.PropsData) error {
+	err := d.cnf.Db.Transaction(func(tx *gorm.DB) error {
+		row, err := d.edgeDevice(tx, edgeID, data.DeviceID)
+		if err != nil {
+			return err
+		}
+		if row.ModelName == "" {
+			row.ModelName = data.Model
+		}
+
+		vals := make(map[string]interface{})
+		if !snapshot && row.Vals != "" {
+			if err := json.Unmarshal([]byte(row.Vals), &vals); err != nil {
+				return err
+			}
+		}
+		for name, val := range data.Vals {
+			vals[name] = val
+		}
+
+		b, err := json.Marshal(vals)
+		if err != nil {
+			return err
+		}
+		row.Vals = string(b)
+		row.UpdateAt = t.Unix()
+
+		return tx.Save(row).Error
+	})
+	if err != nil || snapshot || len(data.Vals) == 0 {
+		return err
+	}
+
+	tags := map[string]string{
+		"edge_id":   edgeID,
+		"device_id": strconv.FormatUint(uint64(data.DeviceID), 10),
+	}
+	return d.hist.WritePoint(context.Background(), data.Model, tags, data.Vals, t)
+}
+
+func (d *dbSink) Alarm(edgeID string, t time.Time, data syncv1.AlarmData) error {
+	return sql.CreateModel(d.cnf.Db, &model.EdgeAlarm{
+		EdgeID:   edgeID,
+		DeviceID: data.DeviceID,
+		Level:    data.Level,
+		Name:     data.Name,
+		Message:  data.Message,
+		Time:     t,
+	})
+}
@@ -0,0 +1,504 @@
+package cloudsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"gorm.io/gorm"
+	"tmios/internal/cmp"
+	"tmios/internal/config"
+	"tmios/internal/iot"
+	"tmios/lib/rpc"
+	"tmios/lib/sql"
+	"tmios/lib/ssl"
+	"tmios/pkg/model"
+	"tmios/pkg/proto/syncv1"
+)
+
+const (
+	edgeCursor       = "edge"
+	defaultBatchSize = 200
+	// defaultMaxRecords outbox的上限，断线一天左右的历史数据
+	defaultMaxRecords = 100000
+	// trimEvery 每写入这么多条记录检查一次outbox是否超过上限
+	trimEvery     = 1000
+	ackTimeout    = 30 * time.Second
+	actionTimeout = 30 * time.Second
+	minBackoff    = time.Second
+	maxBackoff    = time.Minute
+)
+
+type DialFunc func(ctx context.Context) (*grpc.ClientConn, error)
+
+// Edge 把本地设备、属性、告警写入outbox，连接中心后按游标续传
+type Edge struct {
+	cnf        *config.Config
+	reg        *iot.Registry
+	db         *gorm.DB
+	edgeID     string
+	batchSize  int
+	maxRecords int
+	dial       DialFunc
+
+	notify chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	sub    *iot.Subscription
+	wg     sync.WaitGroup
+
+	smutex    sync.Mutex
+	connected bool
+	lastErr   error
+}
+
+type EdgeOption func(e *Edge)
+
+// WithDialer 默认按配置Sync.Upstream使用lib/ssl双向认证连接
+func WithDialer(dial DialFunc) EdgeOption {
+	return func(e *Edge) {
+		e.dial = dial
+	}
+}
+
+func WithEdgeID(edgeID string) EdgeOption {
+	return func(e *Edge) {
+		e.edgeID = edgeID
+	}
+}
+
+func NewEdge(opts ...EdgeOption) *Edge {
+	e := &Edge{
+		cnf:    config.NewConfig(),
+		reg:    iot.NewRegistry(),
+		notify: make(chan struct{}, 1),
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+func (e *Edge) Name() string {
+	return "sync"
+}
+
+func (e *Edge) DependsOn() []string {
+	return []string{"config", "storage", "registry"}
+}
+
+// Policy 中心不可用不影响本地，数据库暂时不可用时重试
+func (e *Edge) Policy() cmp.Policy {
+	return cmp.Policy{Restart: cmp.RestartOnFailure, Optional: true}
+}
+
+func (e *Edge) Start(ctx context.Context) error {
+	conf := e.cnf.Conf().Sync
+	if e.dial == nil {
+		if conf.Upstream == "" {
+			return nil
+		}
+		e.dial = func(ctx context.Context) (*grpc.ClientConn, error) {
+			return grpc.DialContext(ctx, conf.Upstream, ssl.Client(), rpc.CallJSON())
+		}
+	}
+	if e.edgeID == "" {
+		e.edgeID = conf.EdgeID
+	}
+	if e.edgeID == "" {
+		return errors.New("sync: EdgeID is required")
+	}
+	e.batchSize = conf.BatchSize
+	if e.batchSize <= 0 {
+		e.batchSize = defaultBatchSize
+	}
+	e.maxRecords = conf.MaxRecords
+	if e.maxRecords <= 0 {
+		e.maxRecords = defaultMaxRecords
+	}
+
+	e.db = e.cnf.Db
+	if err := e.db.AutoMigrate(&model.SyncRecord{}, &model.SyncCursor{}); err != nil {
+		return err
+	}
+	// 重启时上一次的ctx已在Stop中取消
+	if e.ctx.Err() != nil {
+		e.ctx, e.cancel = context.WithCancel(context.Background())
+	}
+
+	// 数据库慢时不阻塞设备提交，缓冲满时丢弃事件，下次空闲时补发快照
+	e.sub = e.reg.Hub().Subscribe(4096, nil)
+	e.wg.Add(2)
+	go e.collect(e.sub)
+	go e.loop()
+
+	return nil
+}
+
+func (e *Edge) setConnected(connected bool, err error) {
+	e.smutex.Lock()
+	e.connected = connected
+	if err != nil {
+		e.lastErr = err
+	}
+	e.smutex.Unlock()
+}
+
+// Check 与中心断开时数据在outbox中排队，为降级
+func (e *Edge) Check(ctx context.Context) error {
+	if e.db == nil {
+		return nil
+	}
+
+	e.smutex.Lock()
+	defer e.smutex.Unlock()
+
+	if e.connected {
+		return nil
+	}
+	if e.lastErr != nil {
+		return cmp.Degraded(fmt.Errorf("upstream disconnected: %w", e.lastErr))
+	}
+	return cmp.Degraded(errors.New("upstream not connected"))
+}
+
+// Stop 断开连接，等待已收到的事件写入outbox
+func (e *Edge) Stop(ctx context.Context) error {
+	e.Close()
+	if e.sub != nil {
+		e.sub.Close()
+	}
+	e.wg.Wait()
+	return nil
+}
+
+func (e *Edge) enqueue(kind string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if err := sql.CreateModel(e.db, &model.SyncRecord{Kind: kind, Data: string(b)}); err != nil {
+		return err
+	}
+
+	select {
+	case e.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func deviceData(id uint, row *model.Device) syncv1.DeviceData {
+	if row == nil {
+		return syncv1.DeviceData{ID: id, Deleted: true}
+	}
+
+	return syncv1.DeviceData{
+		ID:     row.ID,
+		Name:   row.Name,
+		Model:  row.ModelName,
+		Config: json.RawMessage(row.Config),
+	}
+}
+
+// trim outbox超过maxRecords时删除最早的历史数据(point)，设备和告警保留，
+// 重连后的快照补齐最新属性
+func (e *Edge) trim() error {
+	var count int64
+	if err := e.db.Model(&model.SyncRecord{}).Count(&count).Error; err != nil {
+		return err
+	}
+	over := int(count) - e.maxRecords
+	if over <= 0 {
+		return nil
+	}
+
+	var seqs []uint64
+	err := e.db.Model(&model.SyncRecord{}).Where("kind = ?", syncv1.KindPoint).
+		Order("seq").Offset(over-1).Limit(1).Pluck("seq", &seqs).Error
+	if err != nil || len(seqs) == 0 {
+		return err
+	}
+	res := e.db.Where("kind = ? AND seq <= ?", syncv1.KindPoint, seqs[0]).Delete(&model.SyncRecord{})
+	if res.Error != nil {
+		return res.Error
+	}
+	logrus.WithField("dropped", res.RowsAffected).Warn("sync: outbox is full, dropped oldest points")
+	return nil
+}
+
+// collect 订阅设备事件写入outbox，断线期间数据留在数据库中
+func (e *Edge) collect(sub *iot.Subscription) {
+	defer e.wg.Done()
+
+	var n int
+	for ev := range sub.C {
+		var err error
+		switch ev.Kind {
+		case iot.EventDevice:
+			data := deviceData(ev.DeviceID, ev.Device)
+			data.Model = ev.Model
+			err = e.enqueue(syncv1.KindDevice, data)
+		case iot.EventProps:
+			err = e.enqueue(syncv1.KindPoint, syncv1.PropsData{DeviceID: ev.DeviceID, Model: ev.Model, Vals: ev.Vals})
+		case iot.EventAlarm:
+			err = e.enqueue(syncv1.KindAlarm, syncv1.AlarmData{
+				DeviceID: ev.DeviceID,
+				Model:    ev.Model,
+				Level:    ev.Alarm.Level,
+				Name:     ev.Alarm.Name,
+				Message:  ev.Alarm.Message,
+			})
+		}
+		if err != nil {
+			logrus.WithError(err).Error("sync: enqueue failed")
+		}
+
+		if n++; n%trimEvery == 0 {
+			if err := e.trim(); err != nil {
+				logrus.WithError(err).Error("sync: trim outbox failed")
+			}
+		}
+	}
+}
+
+// snapshot 补发全部设备和属性快照，保证中心与边缘一致。直接发送，不进入outbox，
+// 多次断线重连不会积压
+func (e *Edge) snapshot(send func(*syncv1.Upstream) error) error {
+	var batch []syncv1.Record
+	add := func(kind string, data interface{}) error {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, syncv1.Record{Kind: kind, Time: time.Now(), Data: b})
+		if len(batch) < e.batchSize {
+			return nil
+		}
+		err = send(&syncv1.Upstream{Snapshot: batch})
+		batch = nil
+		return err
+	}
+
+	for _, inst := range e.reg.List() {
+		row := inst.Row()
+		if err := add(syncv1.KindDevice, deviceData(row.ID, &row)); err != nil {
+			return err
+		}
+		if err := add(syncv1.KindSnapshot, syncv1.PropsData{
+			DeviceID: row.ID,
+			Model:    row.ModelName,
+			Vals:     inst.Vals(),
+		}); err != nil {
+			return err
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	return send(&syncv1.Upstream{Snapshot: batch})
+}
+
+func (e *Edge) cursor() (uint64, error) {
+	cursor, err := sql.GetModel[model.SyncCursor](e.db, func(q *gorm.DB) *gorm.DB {
+		return q.Where("name = ?", edgeCursor)
+	})
+	if err != nil || cursor == nil {
+		return 0, err
+	}
+
+	return cursor.Seq, nil
+}
+
+// ack 保存游标并清理已确认的记录。保留最后一条，否则表清空后sqlite和
+// 重启后的mysql会复用自增id，新记录的Seq将不大于游标
+func (e *Edge) ack(seq uint64) error {
+	return e.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&model.SyncCursor{Name: edgeCursor, Seq: seq}).Error; err != nil {
+			return err
+		}
+		return tx.Where("seq < ?", seq).Delete(&model.SyncRecord{}).Error
+	})
+}
+
+// Close 断开连接并停止重连，outbox中未确认的记录保留到下次启动
+func (e *Edge) Close() {
+	e.cancel()
+}
+
+func (e *Edge) loop() {
+	defer e.wg.Done()
+
+	backoff := minBackoff
+	for {
+		start := time.Now()
+		err := e.session()
+		if e.ctx.Err() != nil {
+			return
+		}
+		e.setConnected(false, err)
+		logrus.WithError(err).Warn("sync: disconnected from upstream")
+
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-e.ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (e *Edge) session() error {
+	ctx, cancel := context.WithCancel(e.ctx)
+	defer cancel()
+
+	conn, err := e.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stream, err := syncv1.NewSyncClient(conn).Stream(ctx)
+	if err != nil {
+		return err
+	}
+
+	var sendMutex sync.Mutex
+	send := func(m *syncv1.Upstream) error {
+		sendMutex.Lock()
+		defer sendMutex.Unlock()
+		return stream.Send(m)
+	}
+
+	cursor, err := e.cursor()
+	if err != nil {
+		return err
+	}
+	if err := send(&syncv1.Upstream{Hello: &syncv1.Hello{EdgeID: e.edgeID, Cursor: cursor}}); err != nil {
+		return err
+	}
+
+	var (
+		acks = make(chan uint64, 16)
+		errc = make(chan error, 1)
+	)
+	go func() {
+		for {
+			m, err := stream.Recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			if m.Ack != nil {
+				select {
+				case acks <- *m.Ack:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if m.Action != nil {
+				go e.action(m.Action, send)
+			}
+		}
+	}()
+
+	e.setConnected(true, nil)
+	logrus.WithField("cursor", cursor).Info("sync: connected to upstream")
+
+	// 快照在outbox中积压的记录发送完后补发，之后的记录晚于快照；订阅丢弃过事件时再次补发
+	var (
+		snapshotted bool
+		dropped     uint64
+	)
+	for {
+		records, err := sql.GetModels[model.SyncRecord](e.db, func(q *gorm.DB) *gorm.DB {
+			return q.Where("seq > ?", cursor).Order("seq").Limit(e.batchSize)
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(records) == 0 && (!snapshotted || e.sub.Dropped() != dropped) {
+			dropped = e.sub.Dropped()
+			if err := e.snapshot(send); err != nil {
+				return err
+			}
+			snapshotted = true
+			continue
+		}
+
+		if len(records) == 0 {
+			select {
+			case <-e.notify:
+			case ack := <-acks:
+				if ack > cursor {
+					cursor = ack
+					if err := e.ack(ack); err != nil {
+						return err
+					}
+				}
+			case err := <-errc:
+				return err
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		batch := make([]syncv1.Record, 0, len(records))
+		for _, r := range records {
+			batch = append(batch, syncv1.Record{Seq: r.Seq, Kind: r.Kind, Time: r.CreatedAt, Data: json.RawMessage(r.Data)})
+		}
+		if err := send(&syncv1.Upstream{Records: batch}); err != nil {
+			return err
+		}
+
+		last := batch[len(batch)-1].Seq
+		for cursor < last {
+			select {
+			case ack := <-acks:
+				if ack > cursor {
+					cursor = ack
+					if err := e.ack(ack); err != nil {
+						return err
+					}
+				}
+			case err := <-errc:
+				return err
+			case <-time.After(ackTimeout):
+				return errors.New("sync: wait ack timeout")
+			}
+		}
+	}
+}
+
+// action 执行中心下发的设备操作
+func (e *Edge) action(action *syncv1.Action, send func(*syncv1.Upstream) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+	defer cancel()
+
+	result := &syncv1.ActionResult{ID: action.ID}
+	rets, err := e.reg.Action(ctx, action.DeviceID, action.Name, action.Args)
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Rets = rets
+	}
+
+	if err := send(&syncv1.Upstream{ActionResult: result}); err != nil {
+		logrus.WithError(err).Warn("sync: send action result failed")
+	}
+}
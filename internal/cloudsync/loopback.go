@@ -0,0 +1,47 @@
+package cloudsync
+
+import (
+	"context"
+	"net"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"tmios/lib/rpc"
+	"tmios/lib/ssl"
+	"tmios/pkg/proto/syncv1"
+)
+
+// Loopback 在同一进程内通过内存连接把边缘端接到中心，连接同样走lib/ssl双向认证，
+// 用于联调和测试。返回的stop断开连接并停止边缘端
+func Loopback(c *Central, opts ...EdgeOption) (*Edge, func(), error) {
+	lis := bufconn.Listen(1 << 20)
+
+	srv := grpc.NewServer(ssl.Server(), rpc.ServerJSON(),
+		grpc.UnaryInterceptor(rpc.UnaryInterceptor()),
+		grpc.StreamInterceptor(rpc.StreamInterceptor()))
+	syncv1.RegisterSyncServer(srv, c)
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			logrus.WithError(err).Error("sync: loopback server stopped")
+		}
+	}()
+
+	dial := func(ctx context.Context) (*grpc.ClientConn, error) {
+		return grpc.DialContext(ctx, "bufconn", ssl.Client(), rpc.CallJSON(),
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return lis.DialContext(ctx)
+			}))
+	}
+
+	e := NewEdge(append([]EdgeOption{WithDialer(dial)}, opts...)...)
+	if err := e.Start(context.Background()); err != nil {
+		srv.Stop()
+		return nil, nil, err
+	}
+
+	return e, func() {
+		e.Close()
+		srv.Stop()
+	}, nil
+}
@@ -0,0 +1,279 @@
+package cloudsync
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"tmios/internal/config"
+	"tmios/internal/iot"
+	"tmios/lib/errors"
+	"tmios/lib/iot/device"
+	"tmios/pkg/model"
+	"tmios/pkg/proto/syncv1"
+)
+
+type alarmSink struct {
+	alarms chan syncv1.AlarmData
+}
+
+func (s *alarmSink) Device(edgeID string, data syncv1.DeviceData) error {
+	return nil
+}
+
+func (s *alarmSink) Props(edgeID string, snapshot bool, t time.Time, data syncv1.PropsData) error {
+	return nil
+}
+
+func (s *alarmSink) Alarm(edgeID string, t time.Time, data syncv1.AlarmData) error {
+	s.alarms <- data
+	return nil
+}
+
+// openDB 每个测试使用单独的数据库，config是单例，后开始的测试覆盖前一个的Db
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "sync.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnf := config.NewConfig()
+	cnf.Db = db
+	cnf.SetConf(config.Defaults())
+	return db
+}
+
+// newTestCentral NewCentral是单例，各测试使用自己的Sink
+func newTestCentral(sink Sink) *Central {
+	return &Central{cnf: config.NewConfig(), sink: sink, sessions: make(map[string]*session)}
+}
+
+func waitConnected(t *testing.T, c *Central, edgeID string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		for _, s := range c.Edges() {
+			if s.EdgeID == edgeID {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("edge %s not connected", edgeID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func publishAlarm(name string) {
+	iot.NewRegistry().Hub().Publish(iot.Event{
+		Kind:     iot.EventAlarm,
+		DeviceID: 1,
+		Model:    "meter",
+		Alarm:    &device.Alarm{Level: "warn", Name: name},
+		Time:     time.Now(),
+	})
+}
+
+func recvAlarm(t *testing.T, sink *alarmSink, name string) {
+	t.Helper()
+
+	select {
+	case alarm := <-sink.alarms:
+		if alarm.Name != name {
+			t.Fatalf("expect alarm %s, got %+v", name, alarm)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("alarm %s not synced", name)
+	}
+}
+
+// TestLoopback 边缘端的事件经outbox到达中心，中心下发的操作失败时返回带错误码的错误
+func TestLoopback(t *testing.T) {
+	openDB(t)
+
+	sink := &alarmSink{alarms: make(chan syncv1.AlarmData, 16)}
+	c := newTestCentral(sink)
+	e, stop, err := Loopback(c, WithEdgeID("edge1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		stop()
+		_ = e.Stop(context.Background())
+	}()
+
+	waitConnected(t, c, "edge1")
+
+	publishAlarm("over_voltage")
+	recvAlarm(t, sink, "over_voltage")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = c.Action(ctx, "edge1", 999, "reboot", nil)
+	e2, ok := err.(errors.Error)
+	if !ok || e2.Code == 0 {
+		t.Fatalf("action on unknown device: expect error with code, got %v", err)
+	}
+}
+
+// TestResume 断线期间的记录留在outbox，重连后从持久化的游标继续，已确认的不重复上送
+func TestResume(t *testing.T) {
+	db := openDB(t)
+
+	sink := &alarmSink{alarms: make(chan syncv1.AlarmData, 16)}
+	c := newTestCentral(sink)
+	e1, stop1, err := Loopback(c, WithEdgeID("edge-resume"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConnected(t, c, "edge-resume")
+
+	publishAlarm("before")
+	recvAlarm(t, sink, "before")
+	waitFor(t, "edge cursor not saved", func() bool {
+		cursor, err := e1.cursor()
+		return err == nil && cursor > 0
+	})
+
+	// 断开连接，edge仍在收集事件
+	stop1()
+	publishAlarm("offline")
+	waitFor(t, "offline alarm not in outbox", func() bool {
+		var count int64
+		db.Model(&model.SyncRecord{}).Where("kind = ? AND data LIKE ?", syncv1.KindAlarm, "%offline%").Count(&count)
+		return count == 1
+	})
+	if err := e1.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	e2, stop2, err := Loopback(c, WithEdgeID("edge-resume"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		stop2()
+		_ = e2.Stop(context.Background())
+	}()
+
+	recvAlarm(t, sink, "offline")
+	select {
+	case alarm := <-sink.alarms:
+		t.Fatalf("unexpected alarm %+v after resume", alarm)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+type echoConfig struct{}
+
+type echoProps struct{}
+
+type echoArgs struct {
+	Msg string `json:"msg"`
+}
+
+type echoRets struct {
+	Msg string `json:"msg"`
+}
+
+func echo(ctx context.Context, dv device.Device, args *echoArgs, rets *echoRets) error {
+	rets.Msg = args.Msg
+	return nil
+}
+
+// TestAction 中心下发的操作在边缘端的设备上执行，返回结果
+func TestAction(t *testing.T) {
+	openDB(t)
+
+	if device.GetMeta("sync-echo") == nil {
+		device.Register(&device.DeviceMeta{
+			Model:      "sync-echo",
+			Config:     device.GetPropsMeta(echoConfig{}),
+			Properties: device.GetPropsMeta(echoProps{}),
+			Actions:    device.ActionsMeta{device.ToActionMeta("echo", echo, "")},
+		})
+	}
+	reg := iot.NewRegistry()
+	if err := reg.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = reg.Stop(context.Background()) }()
+	row := &model.Device{Name: "echo", ModelName: "sync-echo", Config: "{}"}
+	if err := reg.Create(row); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCentral(&alarmSink{alarms: make(chan syncv1.AlarmData, 16)})
+	e, stop, err := Loopback(c, WithEdgeID("edge-action"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		stop()
+		_ = e.Stop(context.Background())
+	}()
+	waitConnected(t, c, "edge-action")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	raw, err := c.Action(ctx, "edge-action", row.ID, "echo", []byte(`{"msg":"hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rets echoRets
+	if err := json.Unmarshal(raw, &rets); err != nil || rets.Msg != "hi" {
+		t.Fatalf("unexpected rets %s", raw)
+	}
+}
+
+// TestTrim outbox超过上限时只丢弃最早的历史数据
+func TestTrim(t *testing.T) {
+	db := openDB(t)
+	if err := db.AutoMigrate(&model.SyncRecord{}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEdge()
+	e.db = db
+	e.maxRecords = 3
+	kinds := []string{syncv1.KindPoint, syncv1.KindDevice, syncv1.KindPoint, syncv1.KindAlarm, syncv1.KindPoint, syncv1.KindPoint}
+	for _, kind := range kinds {
+		if err := e.enqueue(kind, struct{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.trim(); err != nil {
+		t.Fatal(err)
+	}
+
+	var left []model.SyncRecord
+	db.Order("seq").Find(&left)
+	var got []string
+	for _, r := range left {
+		got = append(got, r.Kind)
+	}
+	want := []string{syncv1.KindDevice, syncv1.KindAlarm, syncv1.KindPoint}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expect %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Command 子命令，Name可以是多个单词，如"gen driver"
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+// Cmd 子命令集合
+type Cmd struct {
+	commands map[string]*Command
+}
+
+func NewCmd(commands ...*Command) *Cmd {
+	c := &Cmd{commands: make(map[string]*Command)}
+	for _, command := range commands {
+		c.commands[command.Name] = command
+	}
+	return c
+}
+
+// Match 判断args是否是子命令，以"-"开头的参数留给服务本身
+func (c *Cmd) Match(args []string) bool {
+	return len(args) > 0 && !strings.HasPrefix(args[0], "-")
+}
+
+// Run 按最长前缀匹配子命令并执行
+func (c *Cmd) Run(args []string) error {
+	for i := len(args); i > 0; i-- {
+		command, ok := c.commands[strings.Join(args[:i], " ")]
+		if ok {
+			return command.Run(args[i:])
+		}
+	}
+
+	c.usage()
+	return fmt.Errorf("unknown command: %s", strings.Join(args, " "))
+}
+
+func (c *Cmd) usage() {
+	var names []string
+	for name := range c.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: tmios [command] [flags]")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, c.commands[name].Usage)
+	}
+}
@@ -1,30 +1,276 @@
 package cmp
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
 	"github.com/sirupsen/logrus"
 )
 
-// Cmp 组件处理中心
+// StopTimeout 收到退出信号后停止全部组件的期限
+var StopTimeout = 30 * time.Second
+
+// Cmp 组件处理中心，按依赖顺序启动，逆序停止，按Policy重启失败的组件
 type Cmp struct {
-	srv []Srv
+	srv   []Srv
+	units map[string]*unit
+
+	mutex   sync.Mutex
+	state   string
+	started []*unit
+	errs    map[string]lastError
+
+	quit  chan struct{}
+	swg   sync.WaitGroup
+	fatal chan error
 }
 
-// NewCmp conf第一个，阻塞的http在最后
+// NewCmp 没有依赖关系的组件按传入顺序启动
 func NewCmp(srv ...Srv) *Cmp {
 	var arr []Srv
+	units := make(map[string]*unit)
 	for _, v := range srv {
 		arr = append(arr, v)
+		units[v.Name()] = newUnit(v)
+	}
+	return &Cmp{
+		srv:   arr,
+		units: units,
+		state: StateStopped,
+		errs:  make(map[string]lastError),
+		fatal: make(chan error, 1),
 	}
-	return &Cmp{arr}
 }
 
-func (cmp *Cmp) Run() error {
+// Policy 组件当前的重启策略
+func (cmp *Cmp) Policy(name string) (Policy, bool) {
+	u, ok := cmp.units[name]
+	if !ok {
+		return Policy{}, false
+	}
+	return u.policy, true
+}
+
+// SetPolicy 覆盖组件的默认重启策略，需要在Start之前调用
+func (cmp *Cmp) SetPolicy(name string, p Policy) error {
+	u, ok := cmp.units[name]
+	if !ok {
+		return fmt.Errorf("cmp: unknown component %s", name)
+	}
+	if err := p.check(); err != nil {
+		return err
+	}
+
+	u.policy = p.withDefaults()
+	return nil
+}
+
+// sort 拓扑排序，每次取传入顺序中第一个依赖都已排好的组件
+func (cmp *Cmp) sort() ([]Srv, error) {
+	names := make(map[string]bool)
+	for _, v := range cmp.srv {
+		if names[v.Name()] {
+			return nil, fmt.Errorf("cmp: duplicate component %s", v.Name())
+		}
+		names[v.Name()] = true
+	}
 	for _, v := range cmp.srv {
-		err := v.Run()
+		for _, dep := range dependsOn(v) {
+			if !names[dep] {
+				return nil, fmt.Errorf("cmp: %s depends on %s, which is not enabled", v.Name(), dep)
+			}
+		}
+	}
+
+	var (
+		sorted = make([]Srv, 0, len(cmp.srv))
+		placed = make(map[string]bool)
+	)
+	for len(sorted) < len(cmp.srv) {
+		found := false
+		for _, v := range cmp.srv {
+			if placed[v.Name()] || !ready(v, placed) {
+				continue
+			}
+			sorted = append(sorted, v)
+			placed[v.Name()] = true
+			found = true
+			break
+		}
+		if !found {
+			var rest []string
+			for _, v := range cmp.srv {
+				if !placed[v.Name()] {
+					rest = append(rest, v.Name())
+				}
+			}
+			return nil, fmt.Errorf("cmp: dependency cycle among %v", rest)
+		}
+	}
+
+	return sorted, nil
+}
+
+func dependsOn(v Srv) []string {
+	if d, ok := v.(Depender); ok {
+		return d.DependsOn()
+	}
+	return nil
+}
+
+func ready(v Srv, placed map[string]bool) bool {
+	for _, dep := range dependsOn(v) {
+		if !placed[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// Start 按依赖顺序启动。关键组件失败时逆序停止已启动的组件并返回错误，
+// 可选组件失败时按Policy在后台重试
+func (cmp *Cmp) Start(ctx context.Context) error {
+	sorted, err := cmp.sort()
+	if err != nil {
+		return err
+	}
+
+	cmp.quit = make(chan struct{})
+	cmp.setState(StateStarting)
+	for _, v := range sorted {
+		u := cmp.units[v.Name()]
+		cmp.mutex.Lock()
+		cmp.started = append(cmp.started, u)
+		cmp.mutex.Unlock()
+
+		start := time.Now()
+		err := v.Start(ctx)
+		u.setRunning(err == nil, err)
+		u.setActive(err == nil)
+		if err != nil && !u.policy.Optional {
+			stopCtx, cancel := context.WithTimeout(context.Background(), StopTimeout)
+			defer cancel()
+			_ = cmp.Stop(stopCtx)
+			return fmt.Errorf("cmp: start %s: %w", v.Name(), err)
+		}
+
 		if err != nil {
-			logrus.Fatal(err)
-			return err
+			u.log().WithError(err).Error("optional component start failed")
+			u.record(EventFailed, err)
+		} else {
+			u.log().WithField("cost", time.Since(start).String()).Info("component started")
 		}
+		cmp.swg.Add(1)
+		go cmp.supervise(u, err)
 	}
+	cmp.setState(StateRunning)
+
 	return nil
 }
+
+// Stop 逆序停止已启动的组件，ctx结束后不再等待剩余组件，返回第一个错误
+func (cmp *Cmp) Stop(ctx context.Context) error {
+	cmp.setState(StateStopping)
+
+	// 先结束监视，停止过程中不再重启
+	if cmp.quit != nil {
+		select {
+		case <-cmp.quit:
+		default:
+			close(cmp.quit)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		cmp.swg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	cmp.mutex.Lock()
+	started := cmp.started
+	cmp.mutex.Unlock()
+
+	var first error
+	for i := len(started) - 1; i >= 0; i-- {
+		u := started[i]
+		cmp.mutex.Lock()
+		cmp.started = started[:i]
+		cmp.mutex.Unlock()
+
+		u.mutex.Lock()
+		active := u.active
+		u.mutex.Unlock()
+		if !active {
+			continue
+		}
+
+		err := stop(ctx, u.srv)
+		u.setRunning(false, nil)
+		u.setActive(false)
+		if err != nil {
+			u.log().WithError(err).Error("component stop failed")
+			if first == nil {
+				first = fmt.Errorf("cmp: stop %s: %w", u.srv.Name(), err)
+			}
+			continue
+		}
+		u.log().Info("component stopped")
+	}
+	cmp.setState(StateStopped)
+
+	return first
+}
+
+func stop(ctx context.Context, v Srv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- v.Stop(ctx)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run 启动全部组件，收到SIGINT、SIGTERM或关键组件放弃重启后在StopTimeout内逆序停止
+func (cmp *Cmp) Run() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := cmp.Start(ctx); err != nil {
+		return err
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-cmp.fatal:
+	}
+	// 再次收到信号时直接退出
+	cancel()
+	logrus.Info("shutting down")
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), StopTimeout)
+	defer stopCancel()
+
+	if stopErr := cmp.Stop(stopCtx); err == nil {
+		err = stopErr
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package cmp
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Factory 创建组件，enabled为本次启用的全部组件，用来选择可选的依赖，例如http只注册已启用组件的接口
+type Factory func(enabled map[string]bool) (Srv, error)
+
+var (
+	fmutex    sync.Mutex
+	factories = make(map[string]Factory)
+	forder    []string
+)
+
+// Register 注册组件，名称与组件的Name相同，重复注册时panic
+func Register(name string, factory Factory) {
+	fmutex.Lock()
+	defer fmutex.Unlock()
+
+	if factory == nil {
+		panic("cmp: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("cmp: Register called twice for component " + name)
+	}
+	factories[name] = factory
+	forder = append(forder, name)
+}
+
+// Registered 已注册的组件名，按注册顺序
+func Registered() []string {
+	fmutex.Lock()
+	defer fmutex.Unlock()
+
+	return append([]string(nil), forder...)
+}
+
+// Build 创建启用的组件，没有依赖关系的组件按注册顺序启动
+func Build(names ...string) (*Cmp, error) {
+	fmutex.Lock()
+	defer fmutex.Unlock()
+
+	enabled := make(map[string]bool)
+	for _, name := range names {
+		if _, ok := factories[name]; !ok {
+			return nil, fmt.Errorf("cmp: unknown component %s", name)
+		}
+		enabled[name] = true
+	}
+
+	var srv []Srv
+	for _, name := range forder {
+		if !enabled[name] {
+			continue
+		}
+		v, err := factories[name](enabled)
+		if err != nil {
+			return nil, fmt.Errorf("cmp: create %s: %w", name, err)
+		}
+		if v.Name() != name {
+			return nil, fmt.Errorf("cmp: component %s registered as %s", v.Name(), name)
+		}
+		srv = append(srv, v)
+	}
+
+	cmp := NewCmp(srv...)
+	if _, err := cmp.sort(); err != nil {
+		return nil, err
+	}
+	return cmp, nil
+}
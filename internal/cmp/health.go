@@ -0,0 +1,204 @@
+package cmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 组件健康状态
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// Cmp的运行阶段
+const (
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateStopping = "stopping"
+	StateStopped  = "stopped"
+)
+
+// CheckTimeout 单个组件健康检查的期限
+var CheckTimeout = 5 * time.Second
+
+// Checker 可选的健康检查，返回Degraded包装的错误时为降级，其他错误为不可用
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+type degradedError struct {
+	err error
+}
+
+func (e degradedError) Error() string {
+	return e.err.Error()
+}
+
+func (e degradedError) Unwrap() error {
+	return e.err
+}
+
+// Degraded 非关键的故障，例如某个插件或上游连接断开，服务仍可用
+func Degraded(err error) error {
+	if err == nil {
+		return nil
+	}
+	return degradedError{err}
+}
+
+// ComponentHealth 最近一次错误在恢复后保留，History为最近的退出、重启记录
+type ComponentHealth struct {
+	Name        string         `json:"name"`
+	Status      string         `json:"status"`
+	Critical    bool           `json:"critical"`
+	Latency     float64        `json:"latency_ms"`
+	Error       string         `json:"error,omitempty"`
+	LastError   string         `json:"last_error,omitempty"`
+	LastErrorAt *time.Time     `json:"last_error_at,omitempty"`
+	Restart     string         `json:"restart"`
+	Restarts    int            `json:"restarts"`
+	History     []RestartEvent `json:"history,omitempty"`
+}
+
+// Report Status为各组件中最差的状态，可选组件不可用时为降级
+type Report struct {
+	State      string            `json:"state"`
+	Status     string            `json:"status"`
+	CheckedAt  time.Time         `json:"checked_at"`
+	Components []ComponentHealth `json:"components"`
+}
+
+// Ready 全部组件已启动且没有不可用的组件
+func (r *Report) Ready() bool {
+	return r.State == StateRunning && r.Status != HealthDown
+}
+
+// Live 进程仍在提供服务，依赖的故障不影响存活
+func (r *Report) Live() bool {
+	return r.State == StateStarting || r.State == StateRunning
+}
+
+type lastError struct {
+	err string
+	at  time.Time
+}
+
+var (
+	hmutex  sync.RWMutex
+	current *Cmp
+)
+
+// Health 当前运行的Cmp的健康状态
+func Health(ctx context.Context) *Report {
+	hmutex.RLock()
+	c := current
+	hmutex.RUnlock()
+
+	if c == nil {
+		return &Report{State: StateStopped, Status: HealthDown, CheckedAt: time.Now(), Components: []ComponentHealth{}}
+	}
+	return c.Health(ctx)
+}
+
+func (cmp *Cmp) setState(state string) {
+	cmp.mutex.Lock()
+	cmp.state = state
+	cmp.mutex.Unlock()
+
+	hmutex.Lock()
+	current = cmp
+	hmutex.Unlock()
+}
+
+// Health 并发执行各组件的检查，未运行的组件为不可用；可选组件不可用时整体为降级
+func (cmp *Cmp) Health(ctx context.Context) *Report {
+	cmp.mutex.Lock()
+	state := cmp.state
+	cmp.mutex.Unlock()
+
+	report := &Report{
+		State:      state,
+		Status:     HealthOK,
+		CheckedAt:  time.Now(),
+		Components: make([]ComponentHealth, len(cmp.srv)),
+	}
+
+	var wg sync.WaitGroup
+	for i, v := range cmp.srv {
+		wg.Add(1)
+		go func(i int, u *unit) {
+			defer wg.Done()
+			report.Components[i] = cmp.check(ctx, u)
+		}(i, cmp.units[v.Name()])
+	}
+	wg.Wait()
+
+	for _, h := range report.Components {
+		status := h.Status
+		if status == HealthDown && !h.Critical {
+			status = HealthDegraded
+		}
+		if status == HealthDown {
+			report.Status = HealthDown
+		} else if status == HealthDegraded && report.Status == HealthOK {
+			report.Status = HealthDegraded
+		}
+	}
+
+	return report
+}
+
+func (cmp *Cmp) check(ctx context.Context, u *unit) ComponentHealth {
+	u.mutex.Lock()
+	h := ComponentHealth{
+		Name:     u.srv.Name(),
+		Status:   HealthOK,
+		Critical: !u.policy.Optional,
+		Restart:  u.policy.Restart,
+		Restarts: u.total,
+		History:  append([]RestartEvent(nil), u.history...),
+	}
+	running, lastErr := u.running, u.err
+	u.mutex.Unlock()
+
+	var err error
+	if !running {
+		err = errors.New("not running")
+		if lastErr != nil {
+			err = fmt.Errorf("not running: %w", lastErr)
+		}
+	} else if c, ok := u.srv.(Checker); ok {
+		ctx, cancel := context.WithTimeout(ctx, CheckTimeout)
+		defer cancel()
+
+		start := time.Now()
+		err = c.Check(ctx)
+		h.Latency = float64(time.Since(start).Microseconds()) / 1000
+	}
+
+	cmp.mutex.Lock()
+	defer cmp.mutex.Unlock()
+
+	if err != nil {
+		h.Status = HealthDown
+		if errors.As(err, &degradedError{}) {
+			h.Status = HealthDegraded
+		}
+		h.Error = err.Error()
+		if running || lastErr != nil {
+			cmp.errs[h.Name] = lastError{err: h.Error, at: time.Now()}
+		}
+	}
+	if last, ok := cmp.errs[h.Name]; ok {
+		at := last.at
+		h.LastError = last.err
+		h.LastErrorAt = &at
+	}
+
+	return h
+}
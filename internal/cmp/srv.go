@@ -1,5 +1,16 @@
 package cmp
 
+import "context"
+
+// Srv 组件生命周期。Start的ctx只用于启动过程，组件的后台任务在Stop时结束；
+// Stop应在ctx结束前返回，超时后Cmp不再等待
 type Srv interface {
-	Run() error
+	Name() string
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// Depender 声明依赖的组件名，依赖先启动、后停止
+type Depender interface {
+	DependsOn() []string
 }
@@ -0,0 +1,286 @@
+package cmp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 重启策略
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+const (
+	defaultMaxRestarts   = 5
+	defaultRestartWindow = 10 * time.Minute
+	minBackoff           = time.Second
+	maxBackoff           = time.Minute
+	maxHistory           = 20
+)
+
+// Policy 组件的重启策略。Restart为空时不重启；Window内重启超过MaxRestarts次后放弃，
+// 关键组件放弃后整个进程退出，Optional的组件只影响自身
+type Policy struct {
+	Restart     string
+	Optional    bool
+	MaxRestarts int
+	Window      time.Duration
+}
+
+// Policer 组件的默认重启策略，可以用Cmp.SetPolicy覆盖
+type Policer interface {
+	Policy() Policy
+}
+
+// Failer 组件的后台任务(例如监听)退出时，从Failed返回的channel收到错误，正常结束时为nil；
+// 每次Start之后重新获取
+type Failer interface {
+	Failed() <-chan error
+}
+
+// RestartEvent 组件的退出、重启记录
+type RestartEvent struct {
+	At    time.Time `json:"at"`
+	Event string    `json:"event"`
+	Error string    `json:"error,omitempty"`
+}
+
+// 重启记录的事件
+const (
+	EventFailed    = "failed"
+	EventExited    = "exited"
+	EventRestarted = "restarted"
+	EventGaveUp    = "gave_up"
+)
+
+func (p Policy) restart(err error) bool {
+	switch p.Restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	}
+	return false
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.Restart == "" {
+		p.Restart = RestartNever
+	}
+	if p.MaxRestarts <= 0 {
+		p.MaxRestarts = defaultMaxRestarts
+	}
+	if p.Window <= 0 {
+		p.Window = defaultRestartWindow
+	}
+	return p
+}
+
+func (p Policy) check() error {
+	switch p.Restart {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+		return nil
+	}
+	return fmt.Errorf("cmp: unknown restart policy %q", p.Restart)
+}
+
+// unit 一个组件及其运行状态
+type unit struct {
+	srv    Srv
+	policy Policy
+
+	mutex   sync.Mutex
+	running bool
+	// active Start成功后到Stop之前，后台任务失败后仍需要Stop释放资源
+	active   bool
+	err      error
+	restarts []time.Time // Window内的重启时间
+	total    int
+	history  []RestartEvent
+}
+
+func newUnit(srv Srv) *unit {
+	u := &unit{srv: srv}
+	if p, ok := srv.(Policer); ok {
+		u.policy = p.Policy()
+	}
+	u.policy = u.policy.withDefaults()
+	return u
+}
+
+func (u *unit) log() *logrus.Entry {
+	return logrus.WithField("cmp", u.srv.Name())
+}
+
+func (u *unit) setRunning(running bool, err error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.running = running
+	if err != nil {
+		u.err = err
+	}
+}
+
+func (u *unit) setActive(active bool) {
+	u.mutex.Lock()
+	u.active = active
+	u.mutex.Unlock()
+}
+
+func (u *unit) record(event string, err error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	e := RestartEvent{At: time.Now(), Event: event}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	u.history = append(u.history, e)
+	if len(u.history) > maxHistory {
+		u.history = u.history[len(u.history)-maxHistory:]
+	}
+}
+
+// backoff 下一次重启前的等待时间，Window内重启次数达到上限时返回false
+func (u *unit) backoff() (time.Duration, bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	now := time.Now()
+	var recent []time.Time
+	for _, t := range u.restarts {
+		if now.Sub(t) < u.policy.Window {
+			recent = append(recent, t)
+		}
+	}
+	u.restarts = recent
+	if len(recent) >= u.policy.MaxRestarts {
+		return 0, false
+	}
+
+	delay := minBackoff << uint(len(recent))
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	u.restarts = append(u.restarts, now)
+	u.total++
+
+	return delay, true
+}
+
+// failedOf 组件不是Failer时返回nil，不需要监视
+func failedOf(srv Srv) <-chan error {
+	if f, ok := srv.(Failer); ok {
+		return f.Failed()
+	}
+	return nil
+}
+
+// supervise 监视组件的后台任务并按策略重启，err非nil表示组件启动失败
+func (cmp *Cmp) supervise(u *unit, err error) {
+	defer cmp.swg.Done()
+
+	for {
+		if err == nil {
+			failed := failedOf(u.srv)
+			if failed == nil {
+				return
+			}
+
+			select {
+			case err = <-failed:
+			case <-cmp.quit:
+				return
+			}
+			u.setRunning(false, err)
+			if err != nil {
+				u.log().WithError(err).Error("component failed")
+				u.record(EventFailed, err)
+			} else {
+				u.log().Warn("component exited")
+				u.record(EventExited, nil)
+			}
+		}
+
+		if !u.policy.restart(err) {
+			cmp.giveUp(u, err)
+			return
+		}
+		delay, ok := u.backoff()
+		if !ok {
+			cmp.giveUp(u, fmt.Errorf("restarted %d times in %s, last error: %v", u.policy.MaxRestarts, u.policy.Window, err))
+			return
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-cmp.quit:
+			return
+		}
+		err = cmp.restart(u)
+	}
+}
+
+// restart 先停止组件释放资源再启动，启动过程在Cmp停止时取消
+func (cmp *Cmp) restart(u *unit) error {
+	u.mutex.Lock()
+	active := u.active
+	u.mutex.Unlock()
+	if active {
+		stopCtx, cancel := context.WithTimeout(context.Background(), StopTimeout)
+		defer cancel()
+		if err := stop(stopCtx, u.srv); err != nil {
+			u.log().WithError(err).Warn("stop before restart failed")
+		}
+		u.setActive(false)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-cmp.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := u.srv.Start(ctx); err != nil {
+		u.log().WithError(err).Error("component restart failed")
+		u.setRunning(false, err)
+		u.record(EventFailed, err)
+		return err
+	}
+
+	u.setRunning(true, nil)
+	u.setActive(true)
+	u.record(EventRestarted, nil)
+	u.log().Info("component restarted")
+	return nil
+}
+
+// giveUp 不再重启，关键组件失败时通知Run退出
+func (cmp *Cmp) giveUp(u *unit, err error) {
+	if err == nil {
+		return
+	}
+
+	u.record(EventGaveUp, err)
+	if u.policy.Optional {
+		u.log().WithError(err).Error("optional component gave up")
+		return
+	}
+
+	u.log().WithError(err).Error("critical component gave up")
+	select {
+	case cmp.fatal <- fmt.Errorf("cmp: %s: %w", u.srv.Name(), err):
+	default:
+	}
+}
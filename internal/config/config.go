@@ -9,31 +9,109 @@ import (
 	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
+	"tmios/internal/cmp"
 	logutil "tmios/lib/log"
-	"tmios/lib/sql"
 )
 
 type Config struct {
 	context.Context
 	Db    *gorm.DB
-	Conf  *CnfFile
 	Rc    *resty.Client
 	Cache *cache.Cache
+
+	conf   atomic.Value // *CnfFile，重新加载时整体替换
+	loader *Loader
+	watch  bool
+
+	rmutex     sync.Mutex
+	sources    Sources
+	subs       map[string][]Subscriber
+	validators []Validator
+	reloadErr  error
+	cancel     context.CancelFunc
+	done       chan struct{}
 }
 
 type Option func(conf *Config)
 
 var (
-	cnf     *Config
-	once    sync.Once
-	options []Option
+	cnf  *Config
+	once sync.Once
 )
 
-func (s *Config) Run() error {
+// Conf 当前的配置，重新加载后返回新的配置；需要一致的多个字段时保存返回值，不要多次调用
+func (s *Config) Conf() *CnfFile {
+	file, _ := s.conf.Load().(*CnfFile)
+	return file
+}
+
+// SetConf 替换当前的配置，不通知Subscriber
+func (s *Config) SetConf(file *CnfFile) {
+	s.conf.Store(file)
+}
+
+func (s *Config) Name() string {
+	return "config"
+}
+
+// Start 校验当前配置，WithConf开启监听时在后台重新加载
+func (s *Config) Start(ctx context.Context) error {
+	if err := s.validate(s.Conf()); err != nil {
+		return err
+	}
+	if !s.watch {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.rmutex.Lock()
+	s.cancel, s.done = cancel, done
+	s.rmutex.Unlock()
+
+	go func() {
+		defer close(done)
+		if err := WatchConfigFile(ctx, s.loader.File(), s.reload); err != nil {
+			logrus.WithError(err).Error("watch config file failed")
+			s.setReloadErr(err)
+		}
+	}()
+	return nil
+}
+
+// Check 最近一次重新加载失败时为降级，仍在使用之前的配置
+func (s *Config) Check(ctx context.Context) error {
+	s.rmutex.Lock()
+	defer s.rmutex.Unlock()
+
+	if s.reloadErr != nil {
+		return cmp.Degraded(fmt.Errorf("config not reloaded: %w", s.reloadErr))
+	}
 	return nil
 }
+
+func (s *Config) Stop(ctx context.Context) error {
+	s.rmutex.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel = nil
+	s.rmutex.Unlock()
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func NewConfig(ops ...Option) *Config {
 	once.Do(func() {
 		cnf = &Config{
@@ -41,46 +119,45 @@ func NewConfig(ops ...Option) *Config {
 		}
 		for _, op := range ops {
 			op(cnf)
-			options = append(options, op)
 		}
 	})
 	return cnf
 }
 
-// WithConf 加载配置文件，排序第一
+// WithConf 加载配置文件，排序第一；watch为true时文件改变后重新加载，只通知配置改变的Subscriber
 func WithConf(path string, watch bool) Option {
-	return func(conf *Config) {
-		conf.Conf = LoadConfigFile(path)
-		if watch {
-			go WatchConfigFile(path, func() {
-				for _, op := range options {
-					op(cnf)
-				}
-			})
-		}
-	}
+	return WithLoader(NewLoader(path), watch)
 }
 
-// 在conf之后
-func WithLog() Option {
+// WithLoader 同WithConf，使用已注册命令行参数的Loader
+func WithLoader(loader *Loader, watch bool) Option {
 	return func(conf *Config) {
-		if err := logutil.Init(conf.Conf.Log.Level, conf.Conf.Log.Path); err != nil {
-			logrus.Fatal(err)
+		file, sources, err := loader.Load()
+		if err != nil {
+			// 问题列表分行打印，logrus会转义换行
+			fmt.Fprintf(os.Stderr, "%s: %v\n", loader.File(), err)
+			os.Exit(1)
 		}
+		conf.SetConf(file)
+		conf.sources = sources
+		conf.loader, conf.watch = loader, watch
 	}
 }
-func WithMysql() Option {
+
+// WithLog 在conf之后，[Log]改变后重新设置日志
+func WithLog() Option {
 	return func(conf *Config) {
-		//CreateDatabase(conf.Conf.MySQL.DatabaseName, conf.Conf.MySQL.Username, conf.Conf.MySQL.Password, conf.Conf.MySQL.Ip, conf.Conf.MySQL.Port)
-		db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=True&loc=Local",
-			conf.Conf.MySQL.Username, conf.Conf.MySQL.Password, conf.Conf.MySQL.Ip, conf.Conf.MySQL.Port, conf.Conf.MySQL.DatabaseName))
-		if err != nil {
+		log := conf.Conf().Log
+		if err := logutil.Init(log.Level, log.Path); err != nil {
 			logrus.Fatal(err)
 		}
-		conf.Db = db.DB
+		conf.Subscribe("Log", func(old, new *CnfFile) {
+			if err := logutil.Init(new.Log.Level, new.Log.Path); err != nil {
+				logrus.WithError(err).Error("reload log config failed")
+			}
+		})
 	}
 }
-
 func WithResty() Option {
 	return func(conf *Config) {
 		conf.Rc = resty.New().SetTLSClientConfig(&tls.Config{
@@ -1,118 +1,258 @@
 package config
 
 import (
-	"github.com/BurntSushi/toml"
+	"context"
 	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 	"os"
 	"path"
+	"path/filepath"
+	"time"
 )
 
+// API AllowOrigins为允许跨域访问的Origin，如"https://ui.example.com"，"*"为全部；
+// 为空时CORS不限制来源，WebSocket只允许同源和没有Origin的客户端
 type API struct {
-	ListenAddr     string
+	ListenAddr     string `validate:"required,hostport"`
 	PlannerFileDir string
+	AllowOrigins   []string
 }
 
 type Redis struct {
-	Addr     string
-	Password string
-	DB       int
+	Addr     string `validate:"omitempty,hostport"`
+	Password string `secret:"true"`
+	DB       int    `validate:"min=0"`
 }
 
 type MySQL struct {
 	Debug        bool
 	Username     string
-	Password     string
-	Ip           string
-	Port         int
+	Password     string `secret:"true"`
+	Ip           string `validate:"omitempty,hostname_rfc1123|ip"`
+	Port         int    `validate:"min=1,max=65535"`
 	DatabaseName string
 }
 
 type InfluxDB struct {
-	ServerURL string
-	AuthToken string
+	ServerURL string `validate:"omitempty,url"`
+	AuthToken string `secret:"true"`
 	Org       string
 	Bucket    string
 }
 
 type Log struct {
 	Path  string
-	Level string
+	Level string `validate:"omitempty,oneof=panic fatal error warn warning info debug trace"`
 }
 
+// App 接口应用。Role为角色名，没有登录用户的请求按该角色鉴权，不配置时不能访问需要权限的接口；
+// Department为department范围角色的部门
 type App struct {
-	AppID string
-	Token string
+	AppID      string `validate:"required"`
+	Token      string `secret:"true" validate:"required"`
+	Role       string
+	Department uint
 }
 
 type Ftp struct {
-	FtpUrl   string
+	FtpUrl   string `validate:"omitempty,hostport"`
 	Username string
-	Password string
-	Timeout  int32 // ftpclient连接超时时间, 单位是秒
+	Password string `secret:"true"`
+	Timeout  int32  `validate:"min=0"` // ftpclient连接超时时间, 单位是秒
 }
 
+// Account 用户登录，会话保存在SessionRedis，未配置时保存在内存，配置了但连不上时启动失败；SessionTTL默认24h，
+// 连续MaxFailures次密码错误后锁定LockDuration，默认5次、15m；
+// 没有用户时创建admin，密码为AdminPassword，为空时随机生成并写入AdminPasswordFile
+type Account struct {
+	SessionTTL    string `validate:"duration"`
+	MaxFailures   int    `validate:"min=0"`
+	LockDuration  string `validate:"duration"`
+	AdminPassword string `secret:"true"`
+	// AdminPasswordFile 没有配置AdminPassword时随机密码写入该文件(0600)，默认为工作目录下的admin.password
+	AdminPasswordFile string
+	// SecureCookie session Cookie只在HTTPS下发送，通过HTTPS或HTTPS反向代理访问时开启
+	SecureCookie bool
+}
+
+// Grpc gRPC服务监听地址，为空时不启动
+type Grpc struct {
+	ListenAddr string `validate:"omitempty,hostport"`
+}
+
+// Sync 边缘端配置Upstream(中心的gRPC地址)后向中心同步，MaxRecords为outbox最多保留的记录数
+type Sync struct {
+	EdgeID     string
+	Upstream   string `validate:"omitempty,hostport"`
+	BatchSize  int    `validate:"min=0"`
+	MaxRecords int    `validate:"min=0"` // 默认100000
+}
+
+// History 启用后按Retention定期降采样和清理历史数据。Backend为mysql时设备数据复制到MySQL；
+// 为influxdb时设备数据写入[IOTInfuxDB]，降采样和清理也在InfluxDB中进行
+type History struct {
+	Enable          bool
+	Backend         string `validate:"omitempty,oneof=mysql influxdb"` // 默认mysql
+	CompactInterval string `validate:"duration"`                       // 默认10m
+	ExportPath      string // 导出文件的目录，默认export
+	ExportChunk     string `validate:"duration"` // 导出时每次读取的时间范围，默认1h
+	ExportFtpDir    string // 导出文件推送到Ftp服务器的目录，默认history
+}
+
+// Rollup 一级汇总，Keep为空表示永久保留
+type Rollup struct {
+	Resolution string `validate:"required,duration"`
+	Keep       string `validate:"duration"`
+}
+
+// Retention 历史数据保留策略，Measurement为设备型号，"*"为默认策略；
+// 时长支持"7d"形式的天数，Raw为空表示原始数据永久保留
+type Retention struct {
+	Measurement string   `validate:"required"`
+	Raw         string   `validate:"duration"`
+	Rollups     []Rollup `validate:"dive"`
+}
+
+// Discovery 局域网设备发现，Subnets为空时扫描本机网卡所在网段，
+// Ports为额外探测的端口，驱动Probe声明的端口和502总会探测
+type Discovery struct {
+	Enable      bool
+	Interval    string   `validate:"duration"` // 扫描周期，默认1h
+	Subnets     []string `validate:"dive,cidr"`
+	Ports       []int    `validate:"dive,min=1,max=65535"`
+	MaxHosts    int      `validate:"min=0"`    // 每个网段最多扫描的主机数，默认1024
+	Concurrency int      `validate:"min=0"`    // 默认256
+	Timeout     string   `validate:"duration"` // 单个连接超时，默认500ms
+	Expire      string   `validate:"duration"` // 候选设备超过该时间没有再发现时删除，默认24h
+	MDNS        bool     // 监听mDNS
+	SSDP        bool     // 监听SSDP
+}
+
+// Upgrade 固件保存在UploadPath，BaseURL为设备下载固件时访问本服务的地址，
+// VerifyTimeout为升级后等待设备上报新版本的时间，默认10m
 type Upgrade struct {
-	UploadPath string
+	UploadPath    string
+	BaseURL       string `validate:"omitempty,url"`
+	VerifyTimeout string `validate:"duration"`
 }
+
+// Proxy TCP转发，ListenAddr收到的连接转发到Target
+type Proxy struct {
+	ListenAddr string `validate:"required,hostport"`
+	Target     string `validate:"required,hostport"`
+}
+
+// ComponentPolicy 覆盖组件默认的重启策略，为空的字段保持默认
+type ComponentPolicy struct {
+	Restart     string `validate:"omitempty,oneof=never on-failure always"`
+	Optional    *bool  // 可选组件放弃重启后不影响进程
+	MaxRestarts int    `validate:"min=0"`
+	Window      string `validate:"duration"`
+}
+
+// Components 运行的组件。Role为edge(默认)、center或proxy，Enable、Disable在角色的基础上增减，
+// Policies按组件名覆盖重启策略
+type Components struct {
+	Role     string
+	Enable   []string
+	Disable  []string
+	Policies map[string]ComponentPolicy `validate:"dive"`
+}
+
 type Tecs struct {
 	ListenAddr string
 	Url        string
 }
 
+// Plugin 驱动插件进程
+type Plugin struct {
+	Name string `validate:"required"`
+	Path string `validate:"required"`
+	Args []string
+}
+
 type CnfFile struct {
 	API          API
+	Grpc         Grpc
 	MySQL        MySQL
 	IOTInfuxDB   InfluxDB
 	IOTRedis     Redis
 	SessionRedis Redis
 	Log          Log
-	Apps         []App
+	Apps         []App `validate:"dive"`
+	Account      Account
 	Ftp          Ftp
 	Upgrade      Upgrade
 	Tecs         Tecs
+	Plugins      []Plugin `validate:"dive"`
+	Sync         Sync
+	History      History
+	Retention    []Retention `validate:"dive"`
+	Discovery    Discovery
+	Proxies      []Proxy `validate:"dive"`
+	Components   Components
 }
 
+// DefaultConfigFile 默认的配置文件，为空时为运行目录下的config/tmios.conf；
+// 可以在编译时用-ldflags "-X tmios/internal/config.DefaultConfigFile=..."设置
 var DefaultConfigFile string
 
+// LoadConfigFile 加载配置文件并应用环境变量，出错时退出
 func LoadConfigFile(configFile string) *CnfFile {
-	var conf CnfFile
-	if _, err := toml.DecodeFile(pathJoin(configFile), &conf); err != nil {
+	conf, _, err := NewLoader(configFile).Load()
+	if err != nil {
 		logrus.Fatal(err, "decode config failed")
 	}
-	return &conf
+	return conf
 }
 
-// WatchConfigFile 监听文件改变
-func WatchConfigFile(configFile string, fnc func()) {
-	// Create new watcher.
+// WatchDelay 文件连续改变时，最后一次改变之后等待的时间
+var WatchDelay = 500 * time.Millisecond
+
+// WatchConfigFile 监听文件改变直到ctx结束，WatchDelay内的多次改变只调用一次fnc。
+// 监听的是文件所在目录，编辑器和Kubernetes ConfigMap用rename替换文件后仍能收到事件
+func WatchConfigFile(ctx context.Context, configFile string, fnc func()) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		logrus.Fatal(err)
+		return err
 	}
 	defer watcher.Close()
-	// Add a path.
-	err = watcher.Add(pathJoin(configFile))
-	if err != nil {
-		logrus.Fatal(err)
+
+	configFile = filepath.Clean(pathJoin(configFile))
+	dir := filepath.Dir(configFile)
+	if err := watcher.Add(dir); err != nil {
+		return err
 	}
-	// Start listening for events.
+	// ConfigMap的文件是指向..data目录的链接，更新时替换的是..data
+	data := filepath.Join(dir, "..data")
+
+	timer := time.NewTimer(WatchDelay)
+	timer.Stop()
+	defer timer.Stop()
 	for {
 		select {
 		case event, ok := <-watcher.Events:
 			if !ok {
-				return
+				return nil
+			}
+			name := filepath.Clean(event.Name)
+			if name != configFile && name != data {
+				continue
 			}
-			logrus.Println("event:", event)
-			if event.Op == fsnotify.Write {
-				fnc()
-				return
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+				continue
 			}
+			logrus.WithField("event", event.String()).Debug("config file changed")
+			timer.Reset(WatchDelay)
+		case <-timer.C:
+			fnc()
 		case err, ok := <-watcher.Errors:
 			if !ok {
-				return
+				return nil
 			}
-			logrus.Println("error:", err)
+			logrus.WithError(err).Warn("watch config file")
+		case <-ctx.Done():
+			return nil
 		}
 	}
 }
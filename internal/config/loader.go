@@ -0,0 +1,347 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	logutil "tmios/lib/log"
+	"tmios/lib/secret"
+)
+
+// 配置项的来源，后面的覆盖前面的
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// EnvPrefix 环境变量前缀，MySQL.Password对应TMIOS_MYSQL_PASSWORD；
+// TMIOS_CONFIG为配置文件路径
+const EnvPrefix = "TMIOS_"
+
+const envConfigFile = EnvPrefix + "CONFIG"
+
+// 解密enc:v1:开头的值使用的密钥，见lib/secret；都没有设置时使用配置文件所在目录的secret.key
+const (
+	envSecretKeyFile = EnvPrefix + "SECRET_KEY_FILE"
+	envSecretKey     = EnvPrefix + "SECRET_KEY"
+	secretKeyFile    = "secret.key"
+)
+
+// Source 配置项的来源，Encrypted为值是解密得到的
+type Source struct {
+	From      string
+	Encrypted bool
+}
+
+// Sources 各配置项的来源，key为字段路径，例如"MySQL.Password"、"Apps[0].Token"
+type Sources map[string]Source
+
+// Defaults 配置文件、环境变量和命令行参数都没有设置时的值
+func Defaults() *CnfFile {
+	return &CnfFile{
+		API:   API{ListenAddr: "0.0.0.0:8888"},
+		MySQL: MySQL{Port: 3306},
+		Log:   Log{Level: "info"},
+	}
+}
+
+// Loader 依次加载默认值、配置文件、环境变量和命令行参数。
+// 只有不在数组和map中的字段可以用环境变量和命令行参数设置，数组用逗号分隔
+type Loader struct {
+	Path      string // 配置文件，-config和TMIOS_CONFIG优先
+	LookupEnv func(key string) (string, bool)
+
+	file    string
+	keyFile string
+	flags   map[string]string
+}
+
+func NewLoader(path string) *Loader {
+	return &Loader{
+		Path:      path,
+		LookupEnv: os.LookupEnv,
+		flags:     make(map[string]string),
+	}
+}
+
+// RegisterFlags 注册-config和每个字段的参数，参数名为小写的字段路径，例如-mysql.password
+func (l *Loader) RegisterFlags(fs *flag.FlagSet) {
+	l.registerKeyFlags(fs)
+	for _, f := range configFields(Defaults()) {
+		if !f.settable {
+			continue
+		}
+		f, key := f, f.key
+		fs.Func(strings.ToLower(key), key+" (env "+f.env()+")", func(value string) error {
+			if _, err := parseValue(f.value.Type(), value); err != nil {
+				return err
+			}
+			l.flags[key] = value
+			return nil
+		})
+	}
+}
+
+// File 实际使用的配置文件
+func (l *Loader) File() string {
+	if l.file != "" {
+		return pathJoin(l.file)
+	}
+	if l.LookupEnv != nil {
+		if file, ok := l.LookupEnv(envConfigFile); ok && file != "" {
+			return pathJoin(file)
+		}
+	}
+	return pathJoin(l.Path)
+}
+
+// Load 文件不存在或格式错误时只返回错误；其他问题全部收集到ConfigError，
+// 同时返回加载的配置，例如环境变量的值不能转换、未知的key和Validate的结果
+func (l *Loader) Load() (*CnfFile, Sources, error) {
+	conf := Defaults()
+	md, err := toml.DecodeFile(l.File(), conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var errs ConfigError
+	errs.Add(checkKeys(md))
+	// toml的key不区分大小写
+	inFile := make(map[string]bool)
+	for _, key := range md.Keys() {
+		inFile[strings.ToLower(key.String())] = true
+	}
+
+	sources := make(Sources)
+	for _, f := range configFields(conf) {
+		sources[f.path] = Source{From: SourceDefault}
+		if inFile[strings.ToLower(f.key)] {
+			sources[f.path] = Source{From: SourceFile}
+		}
+		if !f.settable {
+			continue
+		}
+
+		if l.LookupEnv != nil {
+			if value, ok := l.LookupEnv(f.env()); ok {
+				if err := f.set(value); err != nil {
+					errs.errorf("%s: %s: %v", f.path, f.env(), err)
+					continue
+				}
+				sources[f.path] = Source{From: SourceEnv}
+			}
+		}
+		if value, ok := l.flags[f.key]; ok {
+			if err := f.set(value); err != nil {
+				errs.errorf("%s: -%s: %v", f.path, strings.ToLower(f.key), err)
+				continue
+			}
+			sources[f.path] = Source{From: SourceFlag}
+		}
+	}
+	errs.Add(l.decrypt(conf, sources))
+	errs.Add(Validate(conf))
+
+	return conf, sources, errs.Err()
+}
+
+// KeyFile 密钥文件，使用TMIOS_SECRET_KEY或没有密钥时返回空
+func (l *Loader) KeyFile() string {
+	if l.keyFile != "" {
+		return l.keyFile
+	}
+	if l.LookupEnv != nil {
+		if file, ok := l.LookupEnv(envSecretKeyFile); ok && file != "" {
+			return file
+		}
+		if key, ok := l.LookupEnv(envSecretKey); ok && key != "" {
+			return ""
+		}
+	}
+	file := filepath.Join(filepath.Dir(l.File()), secretKeyFile)
+	if _, err := os.Stat(file); err != nil {
+		return ""
+	}
+	return file
+}
+
+// Keyring 依次使用-secret-key-file、TMIOS_SECRET_KEY_FILE、TMIOS_SECRET_KEY和配置文件所在目录的secret.key
+func (l *Loader) Keyring() (*secret.Keyring, error) {
+	if file := l.KeyFile(); file != "" {
+		return secret.LoadKeyring(file)
+	}
+	if l.LookupEnv != nil {
+		if key, ok := l.LookupEnv(envSecretKey); ok && key != "" {
+			return secret.ParseKeyring(key)
+		}
+	}
+	return nil, fmt.Errorf("no secret key, set -secret-key-file, %s or %s", envSecretKeyFile, envSecretKey)
+}
+
+// decrypt 解密enc:v1:开头的值，解密后的值和secret字段注册到lib/log，不会出现在日志中
+func (l *Loader) decrypt(conf *CnfFile, sources Sources) error {
+	var (
+		errs    ConfigError
+		keyring *secret.Keyring
+		keyErr  error
+		loaded  bool
+	)
+	for _, f := range configFields(conf) {
+		if f.value.Kind() != reflect.String {
+			continue
+		}
+		value := f.value.String()
+		if !secret.IsEncrypted(value) {
+			if f.secret {
+				logutil.Redact(value)
+			}
+			continue
+		}
+		if !f.value.CanSet() {
+			errs.errorf("%s: encrypted values are not supported here", f.path)
+			continue
+		}
+
+		if !loaded {
+			keyring, keyErr = l.Keyring()
+			loaded = true
+		}
+		if keyErr != nil {
+			errs.errorf("%s: %v", f.path, keyErr)
+			continue
+		}
+		plain, err := keyring.Decrypt(value)
+		if err != nil {
+			errs.errorf("%s: %v", f.path, err)
+			continue
+		}
+		logutil.Redact(plain)
+		f.value.SetString(plain)
+
+		source := sources[f.path]
+		source.Encrypted = true
+		sources[f.path] = source
+	}
+	return errs.Err()
+}
+
+// field 配置项。path包含数组下标，key不包含下标，与toml的key对应
+type field struct {
+	path     string
+	key      string
+	value    reflect.Value
+	secret   bool
+	settable bool
+}
+
+func (f field) env() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.key, ".", "_"))
+}
+
+func (f field) set(s string) error {
+	v, err := parseValue(f.value.Type(), s)
+	if err != nil {
+		return err
+	}
+	f.value.Set(v)
+	return nil
+}
+
+// configFields 按字段顺序展开配置，map按key排序
+func configFields(conf *CnfFile) []field {
+	var fields []field
+	walkFields(reflect.ValueOf(conf).Elem(), "", "", false, true, &fields)
+	return fields
+}
+
+func walkFields(v reflect.Value, path, key string, secret, settable bool, fields *[]field) {
+	join := func(prefix, name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + "." + name
+	}
+
+	switch {
+	case v.Kind() == reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			sf := v.Type().Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+			walkFields(v.Field(i), join(path, sf.Name), join(key, sf.Name), sf.Tag.Get("secret") == "true", settable, fields)
+		}
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		for i := 0; i < v.Len(); i++ {
+			walkFields(v.Index(i), fmt.Sprintf("%s[%d]", path, i), key, secret, false, fields)
+		}
+	case v.Kind() == reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			walkFields(v.MapIndex(k), join(path, k.String()), join(key, k.String()), secret, false, fields)
+		}
+	case v.Kind() == reflect.Ptr && !v.IsNil():
+		walkFields(v.Elem(), path, key, secret, false, fields)
+	default:
+		*fields = append(*fields, field{path: path, key: key, value: v, secret: secret, settable: settable && v.CanSet()})
+	}
+}
+
+func parseValue(t reflect.Type, s string) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return v, err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var parts []string
+		if s != "" {
+			parts = strings.Split(s, ",")
+		}
+		slice := reflect.MakeSlice(t, 0, len(parts))
+		for _, part := range parts {
+			elem, err := parseValue(t.Elem(), strings.TrimSpace(part))
+			if err != nil {
+				return v, err
+			}
+			slice = reflect.Append(slice, elem)
+		}
+		v.Set(slice)
+	default:
+		return v, fmt.Errorf("unsupported type %s", t)
+	}
+	return v, nil
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"text/tabwriter"
+)
+
+// redacted 密码等secret字段打印时的替换值
+const redacted = "******"
+
+// PrintCmd tmios config print，打印最终生效的配置及每一项的来源，参数与服务相同
+func PrintCmd(args []string) error {
+	var (
+		fs     = flag.NewFlagSet("config print", flag.ContinueOnError)
+		loader = NewLoader(DefaultConfigFile)
+	)
+	loader.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	conf, sources, err := loader.Load()
+	if conf == nil {
+		return err
+	}
+	if perr := Print(os.Stdout, loader.File(), conf, sources); perr != nil {
+		return perr
+	}
+	// 配置有问题时仍然打印，方便对照
+	return err
+}
+
+// Print 每行一个字段：路径、值和来源，secret字段和加密的值只显示是否设置
+func Print(w io.Writer, file string, conf *CnfFile, sources Sources) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "# %s\n", file)
+	for _, f := range configFields(conf) {
+		source := sources[f.path]
+		from := source.From
+		if from == SourceEnv {
+			from += " " + f.env()
+		}
+		value := formatValue(f)
+		if source.Encrypted {
+			from += ", encrypted"
+			value = redacted
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.path, value, from)
+	}
+	return tw.Flush()
+}
+
+func formatValue(f field) string {
+	v := f.value
+	if f.secret {
+		if v.IsZero() {
+			return `""`
+		}
+		return redacted
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("%q", v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			return fmt.Sprintf("%q", v.Interface())
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "-"
+		}
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
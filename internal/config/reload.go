@@ -0,0 +1,114 @@
+package config
+
+import (
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Subscriber 配置段改变后调用，old、new为重新加载前后的整个配置
+type Subscriber func(old, new *CnfFile)
+
+// Validator 重新加载前校验新的配置，返回错误时继续使用之前的配置
+type Validator func(file *CnfFile) error
+
+// Subscribe 订阅配置段，section为CnfFile的字段名，例如"Log"、"Apps"
+func (s *Config) Subscribe(section string, fn Subscriber) {
+	if _, ok := reflect.TypeOf(CnfFile{}).FieldByName(section); !ok {
+		panic("config: Subscribe unknown section " + section)
+	}
+
+	s.rmutex.Lock()
+	defer s.rmutex.Unlock()
+
+	if s.subs == nil {
+		s.subs = make(map[string][]Subscriber)
+	}
+	s.subs[section] = append(s.subs[section], fn)
+}
+
+// Sources 当前配置各项的来源
+func (s *Config) Sources() Sources {
+	s.rmutex.Lock()
+	defer s.rmutex.Unlock()
+
+	return s.sources
+}
+
+// AddValidator 启动和重新加载时校验配置
+func (s *Config) AddValidator(fn Validator) {
+	s.rmutex.Lock()
+	defer s.rmutex.Unlock()
+
+	s.validators = append(s.validators, fn)
+}
+
+func (s *Config) validate(file *CnfFile) error {
+	s.rmutex.Lock()
+	validators := s.validators
+	s.rmutex.Unlock()
+
+	for _, fn := range validators {
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Config) setReloadErr(err error) {
+	s.rmutex.Lock()
+	s.reloadErr = err
+	s.rmutex.Unlock()
+}
+
+// reload 读取并校验新的配置，替换后按改变的配置段通知Subscriber；
+// 文件不能解析或校验失败时保留之前的配置
+func (s *Config) reload() {
+	file, sources, err := s.loader.Load()
+	if err == nil {
+		err = s.validate(file)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("config file rejected, keep the previous config")
+		s.setReloadErr(err)
+		return
+	}
+	s.rmutex.Lock()
+	s.reloadErr, s.sources = nil, sources
+	s.rmutex.Unlock()
+
+	old := s.Conf()
+	changed := changedSections(old, file)
+	if len(changed) == 0 {
+		return
+	}
+	s.SetConf(file)
+	logrus.WithField("sections", changed).Info("config reloaded")
+
+	s.rmutex.Lock()
+	var subs []Subscriber
+	for _, section := range changed {
+		subs = append(subs, s.subs[section]...)
+	}
+	s.rmutex.Unlock()
+
+	for _, fn := range subs {
+		fn(old, file)
+	}
+}
+
+// changedSections 按字段比较，返回改变的配置段
+func changedSections(old, new *CnfFile) []string {
+	var (
+		changed []string
+		ov      = reflect.ValueOf(old).Elem()
+		nv      = reflect.ValueOf(new).Elem()
+	)
+	for i := 0; i < ov.NumField(); i++ {
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			changed = append(changed, ov.Type().Field(i).Name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tmios/lib/secret"
+)
+
+// registerKeyFlags 只注册-config和-secret-key-file，供secret命令使用
+func (l *Loader) registerKeyFlags(fs *flag.FlagSet) {
+	fs.StringVar(&l.file, "config", "", "config file (env "+envConfigFile+")")
+	fs.StringVar(&l.keyFile, "secret-key-file", "", "key file for enc:v1: values (env "+envSecretKeyFile+" or "+envSecretKey+")")
+}
+
+// SecretKeygenCmd tmios secret keygen，生成一行密钥，轮换时放在密钥文件的第一行
+func SecretKeygenCmd(args []string) error {
+	var (
+		fs = flag.NewFlagSet("secret keygen", flag.ContinueOnError)
+		id = fs.String("id", time.Now().Format("20060102"), "key id, written into every encrypted value")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	line, err := secret.GenerateKey(*id)
+	if err != nil {
+		return err
+	}
+	fmt.Println(line)
+	return nil
+}
+
+// SecretEncryptCmd tmios secret encrypt [value]，没有参数时从标准输入读取一行，避免明文留在shell历史中
+func SecretEncryptCmd(args []string) error {
+	var (
+		fs     = flag.NewFlagSet("secret encrypt", flag.ContinueOnError)
+		loader = NewLoader(DefaultConfigFile)
+	)
+	loader.registerKeyFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	keyring, err := loader.Keyring()
+	if err != nil {
+		return err
+	}
+
+	var plain string
+	switch fs.NArg() {
+	case 0:
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return errors.New("no value on stdin")
+		}
+		plain = strings.TrimRight(line, "\r\n")
+	case 1:
+		plain = fs.Arg(0)
+	default:
+		return errors.New("usage: tmios secret encrypt [flags] [value]")
+	}
+
+	value, err := keyring.Encrypt(plain)
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
+
+// SecretRotateCmd tmios secret rotate，把配置文件中的加密值改用密钥文件第一行的密钥重新加密；
+// 旧密钥在全部配置改完之前需要保留在密钥文件中
+func SecretRotateCmd(args []string) error {
+	var (
+		fs     = flag.NewFlagSet("secret rotate", flag.ContinueOnError)
+		loader = NewLoader(DefaultConfigFile)
+	)
+	loader.registerKeyFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	keyring, err := loader.Keyring()
+	if err != nil {
+		return err
+	}
+	file := loader.File()
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	text, n, err := keyring.Rotate(string(data))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		// 先写临时文件再rename，运行中的服务只会读到完整的文件
+		tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.WriteString(text); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), file); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%s: %d values re-encrypted with key %s\n", file, n, keyring.Primary())
+	return nil
+}
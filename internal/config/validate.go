@@ -0,0 +1,222 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	validator "github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+	"tmios/internal/utils"
+)
+
+// ConfigError 配置中的全部问题，每项以字段路径开头
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	return "invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+func (e *ConfigError) errorf(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+// Add 合并err，ConfigError按项展开
+func (e *ConfigError) Add(err error) {
+	if err == nil {
+		return
+	}
+	var ce ConfigError
+	if errors.As(err, &ce) {
+		*e = append(*e, ce...)
+		return
+	}
+	*e = append(*e, err.Error())
+}
+
+// Err 没有问题时返回nil
+func (e ConfigError) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+var (
+	validate     *validator.Validate
+	validateOnce sync.Once
+)
+
+// newValidate 除validator内置的tag外，hostport为host:port(host可以为空)，duration为utils.ParseDuration支持的时长
+func newValidate() *validator.Validate {
+	validateOnce.Do(func() {
+		validate = validator.New()
+		_ = validate.RegisterValidation("hostport", func(fl validator.FieldLevel) bool {
+			_, port, err := net.SplitHostPort(fl.Field().String())
+			if err != nil {
+				return false
+			}
+			n, err := strconv.Atoi(port)
+			return err == nil && n >= 0 && n <= 65535
+		})
+		_ = validate.RegisterValidation("duration", func(fl validator.FieldLevel) bool {
+			_, err := utils.ParseDuration(fl.Field().String())
+			return err == nil
+		})
+	})
+	return validate
+}
+
+// Validate 按validate tag和字段间的关系检查配置，返回ConfigError
+func Validate(conf *CnfFile) error {
+	var errs ConfigError
+
+	err := newValidate().Struct(conf)
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		for _, fe := range verrs {
+			errs.errorf("%s: %s", strings.TrimPrefix(fe.Namespace(), "CnfFile."), describe(fe))
+		}
+	} else if err != nil {
+		errs.Add(err)
+	}
+
+	if conf.Sync.Upstream != "" && conf.Sync.EdgeID == "" {
+		errs.errorf("Sync.EdgeID: is required when Sync.Upstream is set")
+	}
+	if conf.History.Enable && conf.History.Backend == "influxdb" {
+		for _, f := range []struct{ name, value string }{
+			{"ServerURL", conf.IOTInfuxDB.ServerURL}, {"Org", conf.IOTInfuxDB.Org}, {"Bucket", conf.IOTInfuxDB.Bucket},
+		} {
+			if f.value == "" {
+				errs.errorf("IOTInfuxDB.%s: is required when History.Backend is influxdb", f.name)
+			}
+		}
+	}
+	if conf.Grpc.ListenAddr != "" && conf.Grpc.ListenAddr == conf.API.ListenAddr {
+		errs.errorf("Grpc.ListenAddr: %q is already used by API.ListenAddr", conf.Grpc.ListenAddr)
+	}
+	unique(&errs, "Apps", "AppID", len(conf.Apps), func(i int) string { return conf.Apps[i].AppID })
+	unique(&errs, "Plugins", "Name", len(conf.Plugins), func(i int) string { return conf.Plugins[i].Name })
+	unique(&errs, "Proxies", "ListenAddr", len(conf.Proxies), func(i int) string { return conf.Proxies[i].ListenAddr })
+	unique(&errs, "Retention", "Measurement", len(conf.Retention), func(i int) string { return conf.Retention[i].Measurement })
+
+	return errs.Err()
+}
+
+func unique(errs *ConfigError, section, name string, n int, value func(i int) string) {
+	seen := make(map[string]int)
+	for i := 0; i < n; i++ {
+		v := value(i)
+		if v == "" {
+			continue
+		}
+		if j, ok := seen[v]; ok {
+			errs.errorf("%s[%d].%s: duplicate %q, already used by %s[%d]", section, i, name, v, section, j)
+			continue
+		}
+		seen[v] = i
+	}
+}
+
+func describe(fe validator.FieldError) string {
+	var msg string
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "hostport":
+		msg = "must be host:port, such as 0.0.0.0:8888"
+	case "duration":
+		msg = "must be a duration, such as 500ms, 10m or 7d"
+	case "url":
+		msg = "must be a URL, such as http://192.168.1.10:8888"
+	case "cidr":
+		msg = "must be a CIDR, such as 192.168.1.0/24"
+	case "oneof":
+		msg = "must be one of " + strings.Join(strings.Fields(fe.Param()), ", ")
+	case "min":
+		msg = "must be at least " + fe.Param()
+	case "max":
+		msg = "must be at most " + fe.Param()
+	case "hostname_rfc1123|ip":
+		msg = "must be a hostname or IP address"
+	default:
+		msg = "failed on " + fe.Tag()
+	}
+	return fmt.Sprintf("%s, got %v", msg, formatInvalid(fe))
+}
+
+func formatInvalid(fe validator.FieldError) string {
+	if s, ok := fe.Value().(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(fe.Value())
+}
+
+// checkKeys 配置文件中不属于CnfFile的key返回错误；大小写与字段名不一致的key仍然会解析到该字段，
+// 只给出警告，例如[LOG]应为[Log]
+func checkKeys(md toml.MetaData) error {
+	var (
+		errs     ConfigError
+		reported = make(map[string]bool)
+	)
+	for _, key := range md.Keys() {
+		path, canonical, ok := lookupKey(reflect.TypeOf(CnfFile{}), key)
+		if reported[path] {
+			continue
+		}
+		if !ok {
+			reported[path] = true
+			errs.errorf("%s: unknown key", path)
+		} else if !strings.HasSuffix(canonical, key[len(key)-1]) {
+			// 只在大小写不一致的那一级警告，下级的key不再重复
+			reported[path] = true
+			logrus.WithField("key", path).Warnf("config key case differs from the field, use %s", canonical)
+		}
+	}
+	return errs.Err()
+}
+
+// lookupKey 按字段名逐级查找key，字段名忽略大小写，与toml解码一致；map的key不检查。
+// 返回key的路径和按字段名的规范路径，ok为false时path为第一个未知的位置
+func lookupKey(t reflect.Type, key toml.Key) (path, canonical string, ok bool) {
+	var keys, names []string
+	for i := 0; i < len(key); i++ {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		name := key[i]
+		keys = append(keys, name)
+		switch t.Kind() {
+		case reflect.Map:
+			names = append(names, name)
+			t = t.Elem()
+			continue
+		case reflect.Struct:
+		default:
+			return strings.Join(keys, "."), "", false
+		}
+
+		f, found := t.FieldByName(name)
+		if !found || f.PkgPath != "" {
+			found = false
+			for j := 0; j < t.NumField(); j++ {
+				if f = t.Field(j); f.PkgPath == "" && strings.EqualFold(f.Name, name) {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return strings.Join(keys, "."), "", false
+		}
+		names = append(names, f.Name)
+		t = f.Type
+	}
+	return strings.Join(keys, "."), strings.Join(names, "."), true
+}
@@ -0,0 +1,459 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"tmios/internal/cmp"
+	"tmios/internal/config"
+	"tmios/internal/iot"
+	"tmios/internal/utils"
+	"tmios/lib/iot/device"
+	"tmios/lib/iot/discovery"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+const (
+	defaultInterval = time.Hour
+	defaultExpire   = 24 * time.Hour
+	// listenInterval mDNS、SSDP主动查询的周期
+	listenInterval = 5 * time.Minute
+	probeTimeout   = 5 * time.Second
+	retryInterval  = time.Minute
+)
+
+// Match 候选设备匹配到的型号，Config为Probe生成的设备配置
+type Match struct {
+	Model     string          `json:"model"`
+	Name      string          `json:"name"`
+	Config    json.RawMessage `json:"config"`
+	ForeignID string          `json:"foreign_id,omitempty"`
+}
+
+// Unclaimed 尚未配置为设备实例的候选设备
+type Unclaimed struct {
+	device.Candidate
+	Matches []Match `json:"matches"`
+}
+
+type entry struct {
+	candidate *device.Candidate
+	matches   []Match
+	seen      time.Time // 最近一次发现的时间
+	promoting bool      // 正在转为设备实例
+}
+
+// Discovery 扫描局域网并按驱动的Probe匹配型号，候选设备可以转为设备实例
+type Discovery struct {
+	cnf *config.Config
+	reg *iot.Registry
+
+	scanning sync.Mutex
+
+	// mutex 保护enabled、expire、entries和promoted
+	mutex    sync.RWMutex
+	enabled  bool
+	expire   time.Duration
+	entries  map[string]*entry
+	promoted map[string]uint // ip -> 设备ID
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var (
+	d     *Discovery
+	dOnce sync.Once
+)
+
+func NewDiscovery() *Discovery {
+	dOnce.Do(func() {
+		d = &Discovery{
+			cnf:      config.NewConfig(),
+			reg:      iot.NewRegistry(),
+			entries:  make(map[string]*entry),
+			promoted: make(map[string]uint),
+		}
+	})
+	return d
+}
+
+func (d *Discovery) Name() string {
+	return "discovery"
+}
+
+func (d *Discovery) DependsOn() []string {
+	return []string{"config", "registry"}
+}
+
+func (d *Discovery) Policy() cmp.Policy {
+	return cmp.Policy{Optional: true}
+}
+
+func (d *Discovery) Start(ctx context.Context) error {
+	conf := d.cnf.Conf().Discovery
+	if !conf.Enable {
+		return nil
+	}
+
+	interval, err := utils.ParseDuration(conf.Interval)
+	if err != nil {
+		return fmt.Errorf("discovery: interval: %w", err)
+	}
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	expire, err := utils.ParseDuration(conf.Expire)
+	if err != nil {
+		return fmt.Errorf("discovery: expire: %w", err)
+	}
+	if expire <= 0 {
+		expire = defaultExpire
+	}
+	if _, err := d.scanner(); err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	d.enabled, d.expire = true, expire
+	d.mutex.Unlock()
+
+	loopCtx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.wg.Add(1)
+	go d.loop(loopCtx, interval)
+	if conf.MDNS {
+		d.wg.Add(1)
+		go d.listen(loopCtx, "mdns", discovery.ListenMDNS)
+	}
+	if conf.SSDP {
+		d.wg.Add(1)
+		go d.listen(loopCtx, "ssdp", discovery.ListenSSDP)
+	}
+
+	return nil
+}
+
+// Stop 中止正在进行的扫描和监听
+func (d *Discovery) Stop(ctx context.Context) error {
+	if d.cancel == nil {
+		return nil
+	}
+	d.mutex.Lock()
+	d.enabled = false
+	d.mutex.Unlock()
+
+	d.cancel()
+	d.wg.Wait()
+	return nil
+}
+
+func (d *Discovery) isEnabled() bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.enabled
+}
+
+func (d *Discovery) loop(ctx context.Context, interval time.Duration) {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Scan(ctx); err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Warn("discovery: scan failed")
+		}
+		d.prune()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type listenFunc func(ctx context.Context, ifi *net.Interface, interval time.Duration, found discovery.FoundFunc) error
+
+// listen 监听出错时(例如网卡未就绪)稍后重试
+func (d *Discovery) listen(ctx context.Context, name string, fn listenFunc) {
+	defer d.wg.Done()
+
+	for {
+		err := fn(ctx, nil, listenInterval, d.found)
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.WithError(err).Warnf("discovery: %s listener stopped", name)
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scanner 按配置构造Scanner，探测配置的端口、502以及所有驱动Probe声明的端口
+func (d *Discovery) scanner() (*discovery.Scanner, error) {
+	conf := d.cnf.Conf().Discovery
+
+	timeout, err := utils.ParseDuration(conf.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: timeout: %w", err)
+	}
+
+	s := &discovery.Scanner{
+		MaxHosts:    conf.MaxHosts,
+		Concurrency: conf.Concurrency,
+		Timeout:     timeout,
+	}
+
+	for _, subnet := range conf.Subnets {
+		_, n, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, fmt.Errorf("discovery: subnet: %w", err)
+		}
+		s.Subnets = append(s.Subnets, n)
+	}
+	if len(conf.Subnets) == 0 {
+		for _, info := range utils.GetIpMac() {
+			if n := info.Subnet(); n != nil {
+				s.Subnets = append(s.Subnets, n)
+			}
+		}
+	}
+
+	ports := append([]int{discovery.ModbusPort}, conf.Ports...)
+	for _, meta := range device.Metas() {
+		if meta.Probe != nil {
+			ports = append(ports, meta.Probe.Ports...)
+		}
+	}
+	s.Ports = utils.Unique(ports)
+
+	return s, nil
+}
+
+// prune 删除超过expire没有再发现的候选设备，以及已被删除的设备的纳管记录
+func (d *Discovery) prune() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	for ip, e := range d.entries {
+		if !e.promoting && now.Sub(e.seen) > d.expire {
+			delete(d.entries, ip)
+		}
+	}
+	for ip, id := range d.promoted {
+		if _, err := d.reg.Get(id); err != nil {
+			delete(d.promoted, ip)
+		}
+	}
+}
+
+// Scan 立即扫描一次，正在扫描时返回ErrDiscoveryBusy
+func (d *Discovery) Scan(ctx context.Context) error {
+	if !d.isEnabled() {
+		return errm.ErrDiscoveryDisabled
+	}
+	if !d.scanning.TryLock() {
+		return errm.ErrDiscoveryBusy
+	}
+	defer d.scanning.Unlock()
+
+	s, err := d.scanner()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	candidates := s.Scan(ctx)
+	for _, c := range candidates {
+		d.found(c)
+	}
+	logrus.WithField("found", len(candidates)).WithField("cost", time.Since(start).String()).
+		Debug("discovery: scan done")
+
+	return nil
+}
+
+// found 合并同一IP的发现结果，有变化时重新匹配型号
+func (d *Discovery) found(c *device.Candidate) {
+	d.mutex.Lock()
+	e, ok := d.entries[c.IP]
+	if !ok {
+		e = &entry{candidate: &device.Candidate{IP: c.IP}}
+		d.entries[c.IP] = e
+	}
+	before := *e.candidate
+	before.Info = utils.CopyMap(e.candidate.Info)
+	e.candidate.Merge(c)
+	e.seen = time.Now()
+	changed := !ok || !sameCandidate(&before, e.candidate)
+	snapshot := *e.candidate
+	snapshot.Info = utils.CopyMap(e.candidate.Info)
+	d.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	matches := d.match(&snapshot)
+
+	d.mutex.Lock()
+	e.matches = matches
+	d.mutex.Unlock()
+}
+
+func sameCandidate(a, b *device.Candidate) bool {
+	return a.Mac == b.Mac &&
+		reflect.DeepEqual(a.Ports, b.Ports) &&
+		reflect.DeepEqual(a.Sources, b.Sources) &&
+		reflect.DeepEqual(a.Info, b.Info)
+}
+
+func (d *Discovery) match(c *device.Candidate) []Match {
+	var matches []Match
+	for _, meta := range device.Metas() {
+		if meta.Probe == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		config, ok, err := meta.Match(ctx, c)
+		cancel()
+		if err != nil {
+			logrus.WithField("ip", c.IP).WithField("model", meta.Model).WithError(err).Debug("discovery: probe failed")
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		normalized, err := meta.CheckConfig(config)
+		if err != nil {
+			logrus.WithField("ip", c.IP).WithField("model", meta.Model).WithError(err).
+				Warn("discovery: probe returned invalid config")
+			continue
+		}
+
+		m := Match{Model: meta.Model, Name: meta.Name, Config: normalized}
+		if m.ForeignID, err = meta.ForeignID(normalized); err != nil {
+			logrus.WithField("ip", c.IP).WithField("model", meta.Model).WithError(err).
+				Debug("discovery: foreign id unavailable")
+		}
+		matches = append(matches, m)
+	}
+
+	return matches
+}
+
+// claimed 已经转为设备实例，或匹配的外部ID已有设备
+func (d *Discovery) claimed(ip string, e *entry) bool {
+	if id, ok := d.promoted[ip]; ok {
+		if _, err := d.reg.Get(id); err == nil {
+			return true
+		}
+	}
+
+	for _, m := range e.matches {
+		if m.ForeignID == "" {
+			continue
+		}
+		if _, err := d.reg.ByForeignID(m.Model, m.ForeignID); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Unclaimed 尚未配置的候选设备，按IP排序
+func (d *Discovery) Unclaimed() ([]Unclaimed, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if !d.enabled {
+		return nil, errm.ErrDiscoveryDisabled
+	}
+
+	arr := make([]Unclaimed, 0, len(d.entries))
+	for ip, e := range d.entries {
+		if e.promoting || d.claimed(ip, e) {
+			continue
+		}
+
+		c := *e.candidate
+		c.Info = utils.CopyMap(e.candidate.Info)
+		arr = append(arr, Unclaimed{Candidate: c, Matches: append([]Match{}, e.matches...)})
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		a, b := net.ParseIP(arr[i].IP).To4(), net.ParseIP(arr[j].IP).To4()
+		if a == nil || b == nil {
+			return arr[i].IP < arr[j].IP
+		}
+		return string(a) < string(b)
+	})
+
+	return arr, nil
+}
+
+// Promote 把候选设备转为设备实例，config为空时使用匹配该型号时Probe生成的配置；
+// 已纳管或正在纳管的候选设备返回ErrDiscoveryClaimed，重复调用不会创建多个设备。
+// departmentID由调用方按数据范围校验
+func (d *Discovery) Promote(ip, modelName, name string, departmentID uint, config json.RawMessage) (*model.Device, error) {
+	d.mutex.Lock()
+	if !d.enabled {
+		d.mutex.Unlock()
+		return nil, errm.ErrDiscoveryDisabled
+	}
+	e, ok := d.entries[ip]
+	if !ok {
+		d.mutex.Unlock()
+		return nil, errm.ErrNotFound.SetDetail("candidate %s", ip)
+	}
+	if e.promoting || d.claimed(ip, e) {
+		d.mutex.Unlock()
+		return nil, errm.ErrDiscoveryClaimed.SetDetail("candidate %s", ip)
+	}
+	e.promoting = true
+	matches := append([]Match{}, e.matches...)
+	d.mutex.Unlock()
+
+	defer func() {
+		d.mutex.Lock()
+		e.promoting = false
+		d.mutex.Unlock()
+	}()
+
+	if len(config) == 0 {
+		for _, m := range matches {
+			if m.Model == modelName {
+				config = m.Config
+			}
+		}
+		if len(config) == 0 {
+			return nil, errm.ErrParam.SetDetail("candidate %s does not match model %s, config is required", ip, modelName)
+		}
+	}
+
+	row := &model.Device{Name: name, ModelName: modelName, Config: string(config), DepartmentID: departmentID}
+	if err := d.reg.Create(row); err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	d.promoted[ip] = row.ID
+	d.mutex.Unlock()
+
+	return row, nil
+}
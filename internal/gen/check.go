@@ -0,0 +1,265 @@
+package gen
+
+import (
+	"fmt"
+	"go/token"
+	"reflect"
+	"strings"
+	"unicode"
+
+	validator "github.com/go-playground/validator/v10"
+	"tmios/lib/iot/device"
+)
+
+// fieldTypes 生成代码允许的字段类型，需要满足GetPropsMeta按Type.Name()取类型名
+var fieldTypes = map[string]reflect.Type{
+	"bool":    reflect.TypeOf(false),
+	"string":  reflect.TypeOf(""),
+	"int":     reflect.TypeOf(int(0)),
+	"int8":    reflect.TypeOf(int8(0)),
+	"int16":   reflect.TypeOf(int16(0)),
+	"int32":   reflect.TypeOf(int32(0)),
+	"int64":   reflect.TypeOf(int64(0)),
+	"uint":    reflect.TypeOf(uint(0)),
+	"uint8":   reflect.TypeOf(uint8(0)),
+	"uint16":  reflect.TypeOf(uint16(0)),
+	"uint32":  reflect.TypeOf(uint32(0)),
+	"uint64":  reflect.TypeOf(uint64(0)),
+	"float32": reflect.TypeOf(float32(0)),
+	"float64": reflect.TypeOf(float64(0)),
+}
+
+// reservedNames 生成代码使用的标识符后缀，action和interval的Go名称不能与之相同，
+// 如action "config"生成的函数MeterConfig与配置结构体重名
+var reservedNames = map[string]bool{"Config": true, "Props": true, "Meta": true, "Init": true}
+
+// LayoutError 生成前发现的所有布局错误
+type LayoutError []string
+
+func (e LayoutError) Error() string {
+	return "driver spec layout error:\n  " + strings.Join(e, "\n  ")
+}
+
+type checker struct {
+	errs     LayoutError
+	validate *validator.Validate
+}
+
+func (c *checker) errorf(format string, args ...interface{}) {
+	c.errs = append(c.errs, fmt.Sprintf(format, args...))
+}
+
+// goName 把json名称转换为导出的Go标识符，如 "rated_power" -> "RatedPower"
+func goName(name string) string {
+	var (
+		b     strings.Builder
+		upper = true
+	)
+
+	for _, r := range name {
+		if r == '_' || r == '-' || r == '.' || r == ' ' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func isExported(name string) bool {
+	return token.IsIdentifier(name) && token.IsExported(name)
+}
+
+// checkValidateTag validator遇到未知tag会panic，这里提前触发
+func (c *checker) checkValidateTag(where string, typ reflect.Type, tag string) {
+	if tag == "" {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.errorf("%s: invalid validate tag %q: %v", where, tag, r)
+		}
+	}()
+
+	_ = c.validate.Var(reflect.Zero(typ).Interface(), tag)
+}
+
+func (c *checker) checkRateLimit(where string, limit *RateLimitSpec) {
+	if limit == nil {
+		return
+	}
+	if limit.Rate <= 0 {
+		c.errorf("%s: rate must be greater than 0", where)
+	}
+	if limit.Burst < 0 {
+		c.errorf("%s: burst must not be negative", where)
+	}
+}
+
+func (c *checker) checkFields(where string, fields []FieldSpec) {
+	var (
+		names   = make(map[string]bool)
+		goNames = make(map[string]bool)
+	)
+
+	for i, field := range fields {
+		fieldWhere := fmt.Sprintf("%s[%d]", where, i)
+		if field.Name == "" {
+			c.errorf("%s: name is required", fieldWhere)
+			continue
+		}
+		fieldWhere = fmt.Sprintf("%s.%s", where, field.Name)
+
+		name := goName(field.Name)
+		if names[field.Name] {
+			c.errorf("%s: duplicate name", fieldWhere)
+		} else if !isExported(name) {
+			c.errorf("%s: cannot convert to Go field name, got %q", fieldWhere, name)
+		} else if goNames[name] {
+			c.errorf("%s: Go field name %s collides with another field", fieldWhere, name)
+		}
+		names[field.Name] = true
+		goNames[name] = true
+
+		typ, ok := fieldTypes[field.Type]
+		if !ok {
+			c.errorf("%s: unsupported type %q", fieldWhere, field.Type)
+			continue
+		}
+
+		if strings.ContainsAny(field.Desc+field.Extras+field.Validate, "`\"") {
+			c.errorf("%s: desc/extras/validate cannot contain quotes", fieldWhere)
+			continue
+		}
+
+		c.checkValidateTag(fieldWhere, typ, field.Validate)
+	}
+}
+
+// Check 检查spec，覆盖ToActionMeta/GetPropsMeta在运行期才会panic的情况
+func Check(spec *DriverSpec) error {
+	c := &checker{validate: validator.New()}
+
+	if spec.Model == "" {
+		c.errorf("model is required")
+	}
+	if spec.Variable == "" {
+		spec.Variable = goName(spec.Model)
+	}
+	if !isExported(spec.Variable) {
+		c.errorf("variable %q is not an exported Go identifier", spec.Variable)
+	}
+	if spec.Package == "" {
+		c.errorf("package is required")
+	} else if !token.IsIdentifier(spec.Package) {
+		c.errorf("package %q is not a valid Go identifier", spec.Package)
+	}
+
+	c.checkFields("config", spec.Config)
+	c.checkFields("properties", spec.Properties)
+
+	if len(spec.Virtuals) > 0 {
+		var (
+			props    device.PropsMeta
+			virtuals []device.Virtual
+		)
+		for _, field := range spec.Properties {
+			props.Props = append(props.Props, &device.PropMeta{Name: field.Name})
+		}
+		for _, v := range spec.Virtuals {
+			virtuals = append(virtuals, device.Virtual{Name: v.Name, Expr: v.Expr, Desc: v.Desc})
+		}
+		if err := device.CheckVirtuals(props, virtuals); err != nil {
+			c.errorf("virtuals: %s", err.Error())
+		}
+	}
+
+	if spec.ForeignID != "" {
+		found := false
+		for _, field := range spec.Config {
+			if field.Name == spec.ForeignID {
+				found = true
+			}
+		}
+		if !found {
+			c.errorf("foreign_id: config field %q not found", spec.ForeignID)
+		}
+	}
+
+	c.checkRateLimit("rate_limit", spec.RateLimit)
+
+	var (
+		funcs   = make(map[string]bool)
+		structs = make(map[string]string)
+	)
+	for i, action := range spec.Actions {
+		if action.Name == "" {
+			c.errorf("actions[%d]: name is required", i)
+			continue
+		}
+		where := "actions." + action.Name
+		name := goName(action.Name)
+		if !isExported(name) {
+			c.errorf("%s: cannot convert to Go func name, got %q", where, name)
+		} else if funcs[name] {
+			c.errorf("%s: duplicate action or interval name", where)
+		} else if reservedNames[name] {
+			c.errorf("%s: Go name %s%s collides with the generated %s%s", where, spec.Variable, name, spec.Variable, name)
+		}
+		funcs[name] = true
+		structs[name+"Args"] = where
+		structs[name+"Rets"] = where
+
+		c.checkFields(where+".args", action.Args)
+		c.checkFields(where+".rets", action.Rets)
+		c.checkRateLimit(where+".rate_limit", action.RateLimit)
+	}
+
+	for i, interval := range spec.Intervals {
+		if interval.Name == "" {
+			c.errorf("intervals[%d]: name is required", i)
+			continue
+		}
+		where := "intervals." + interval.Name
+		name := goName(interval.Name)
+		if !isExported(name) {
+			c.errorf("%s: cannot convert to Go func name, got %q", where, name)
+		} else if funcs[name] {
+			c.errorf("%s: duplicate action or interval name", where)
+		} else if reservedNames[name] {
+			c.errorf("%s: Go name %s%s collides with the generated %s%s", where, spec.Variable, name, spec.Variable, name)
+		}
+		funcs[name] = true
+
+		if interval.Interval <= 0 {
+			c.errorf("%s: interval must be greater than 0", where)
+		}
+	}
+
+	// action的参数和返回值结构体为<Variable><Action>Args、<Variable><Action>Rets，如"set"和"set_args"
+	var names []string
+	for _, action := range spec.Actions {
+		names = append(names, "actions."+action.Name)
+	}
+	for _, interval := range spec.Intervals {
+		names = append(names, "intervals."+interval.Name)
+	}
+	for _, where := range names {
+		name := goName(where[strings.IndexByte(where, '.')+1:])
+		if other, ok := structs[name]; ok {
+			c.errorf("%s: Go name %s%s collides with the args/rets struct of %s", where, spec.Variable, name, other)
+		}
+	}
+
+	if len(c.errs) > 0 {
+		return c.errs
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package gen
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+var funcs = template.FuncMap{
+	"goName": goName,
+	"quote":  strconv.Quote,
+	"tag": func(field FieldSpec) string {
+		tags := []string{fmt.Sprintf(`json:"%s"`, field.Name)}
+		if field.Validate != "" {
+			tags = append(tags, fmt.Sprintf(`validate:"%s"`, field.Validate))
+		}
+		if field.Desc != "" {
+			tags = append(tags, fmt.Sprintf(`desc:"%s"`, field.Desc))
+		}
+		if field.Extras != "" {
+			tags = append(tags, fmt.Sprintf(`extras:"%s"`, field.Extras))
+		}
+		return "`" + strings.Join(tags, " ") + "`"
+	},
+}
+
+var metaTmpl = template.Must(template.New("meta").Funcs(funcs).Parse(`// Code generated by tmios gen driver. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .ForeignID}}
+	"encoding/json"
+	"fmt"
+{{end}}
+	"tmios/lib/iot/device"
+)
+
+{{define "fields"}}{{range .}}	{{goName .Name}} {{.Type}} {{tag .}}
+{{end}}{{end}}
+{{- $v := .Variable -}}
+// {{$v}}Config {{.Name}}配置
+type {{$v}}Config struct {
+{{template "fields" .Config}}}
+
+// {{$v}}Props {{.Name}}属性
+type {{$v}}Props struct {
+{{template "fields" .Properties}}}
+{{range .Actions}}
+type {{$v}}{{goName .Name}}Args struct {
+{{template "fields" .Args}}}
+
+type {{$v}}{{goName .Name}}Rets struct {
+{{template "fields" .Rets}}}
+{{end}}
+var {{$v}}Meta = &device.DeviceMeta{
+	Name:       {{quote .Name}},
+	Variable:   {{quote .Variable}},
+	Brand:      {{quote .Brand}},
+	Model:      {{quote .Model}},
+	Type:       {{quote .Type}},
+	Desc:       {{quote .Desc}},
+	Config:     device.GetPropsMeta({{$v}}Config{}),
+	Properties: device.GetPropsMeta({{$v}}Props{}),
+{{- if .Virtuals}}
+	Virtuals: []device.Virtual{
+{{- range .Virtuals}}
+		{Name: {{quote .Name}}, Expr: {{quote .Expr}}, Desc: {{quote .Desc}}},
+{{- end}}
+	},
+{{- end}}
+	Actions: device.ActionsMeta{
+{{- range .Actions}}
+		device.ToActionMeta({{quote .Name}}, {{$v}}{{goName .Name}}, {{quote .Desc}})
+{{- with .RateLimit}}.Limit({{.Rate}}, {{.Burst}}){{end}},
+{{- end}}
+	},
+	Intervals: device.Intervals{
+{{- range .Intervals}}
+		{Name: {{quote .Name}}, Interval: {{.Interval}}, Desc: {{quote .Desc}}, CtxFunc: {{$v}}{{goName .Name}}},
+{{- end}}
+	},
+{{- if .Concurrency}}
+	Concurrency: {{.Concurrency}},
+{{- end}}
+{{- with .RateLimit}}
+	RateLimit: &device.RateLimit{Rate: {{.Rate}}, Burst: {{.Burst}}},
+{{- end}}
+{{- if .ForeignID}}
+	ForeignIDFunc: func(config []byte) string {
+		var c {{$v}}Config
+		if err := json.Unmarshal(config, &c); err != nil {
+			return ""
+		}
+		return fmt.Sprint(c.{{goName .ForeignID}})
+	},
+{{- end}}
+{{- if .Init}}
+	InitFunc: {{$v}}Init,
+{{- end}}
+}
+
+func init() {
+	device.Register({{$v}}Meta)
+}
+`))
+
+var stubTmpl = template.Must(template.New("stub").Funcs(funcs).Parse(`package {{.Package}}
+
+{{if or .Actions .Intervals -}}
+import (
+	"context"
+	"errors"
+
+	"tmios/lib/iot/device"
+)
+{{end}}
+{{- $v := .Variable}}
+{{- if .Init}}
+func {{$v}}Init() error {
+	return nil
+}
+{{end}}
+{{- range .Actions}}
+// {{$v}}{{goName .Name}} {{or .Desc .Name}}
+func {{$v}}{{goName .Name}}(ctx context.Context, dv device.Device, args *{{$v}}{{goName .Name}}Args, rets *{{$v}}{{goName .Name}}Rets) error {
+	return errors.New("{{.Name}} not implemented")
+}
+{{end}}
+{{- range .Intervals}}
+// {{$v}}{{goName .Name}} {{or .Desc .Name}}
+func {{$v}}{{goName .Name}}(ctx context.Context, dv device.Device) error {
+	return errors.New("{{.Name}} not implemented")
+}
+{{end}}`))
+
+func render(tmpl *template.Template, spec *DriverSpec) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, spec); err != nil {
+		return nil, err
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w\n%s", err, buf.String())
+	}
+
+	return src, nil
+}
+
+func fileRune(r rune) rune {
+	if unicode.IsLetter(r) || unicode.IsDigit(r) {
+		return r
+	}
+	return '_'
+}
+
+// Driver 检查spec并在dir下生成 <model>_meta.go，stub文件已存在时不覆盖
+func Driver(spec *DriverSpec, dir string) ([]string, error) {
+	if err := Check(spec); err != nil {
+		return nil, err
+	}
+
+	meta, err := render(metaTmpl, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	stub, err := render(stubTmpl, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var (
+		base     = strings.ToLower(strings.Map(fileRune, spec.Model))
+		metaFile = filepath.Join(dir, base+"_meta.go")
+		stubFile = filepath.Join(dir, base+"_actions.go")
+		written  []string
+	)
+
+	if err := os.WriteFile(metaFile, meta, 0644); err != nil {
+		return nil, err
+	}
+	written = append(written, metaFile)
+
+	if _, err := os.Stat(stubFile); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(stubFile, stub, 0644); err != nil {
+			return nil, err
+		}
+		written = append(written, stubFile)
+	}
+
+	return written, nil
+}
+
+// DriverCmd tmios gen driver -spec meter.toml -out ./drivers/meter
+func DriverCmd(args []string) error {
+	var (
+		fs       = flag.NewFlagSet("gen driver", flag.ContinueOnError)
+		specFile = fs.String("spec", "", "driver spec file (.toml/.yaml)")
+		out      = fs.String("out", ".", "output directory")
+		check    = fs.Bool("check", false, "only check the spec")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *specFile == "" {
+		fs.Usage()
+		return errors.New("-spec is required")
+	}
+
+	spec, err := LoadSpec(*specFile)
+	if err != nil {
+		return err
+	}
+
+	if *check {
+		return Check(spec)
+	}
+
+	files, err := Driver(spec, *out)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		fmt.Println("generated", file)
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package gen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// FieldSpec config/properties/args/rets 中的一个字段
+type FieldSpec struct {
+	Name     string `toml:"name" yaml:"name"`
+	Type     string `toml:"type" yaml:"type"`
+	Validate string `toml:"validate" yaml:"validate"`
+	Desc     string `toml:"desc" yaml:"desc"`
+	Extras   string `toml:"extras" yaml:"extras"`
+}
+
+type ActionSpec struct {
+	Name string      `toml:"name" yaml:"name"`
+	Desc string      `toml:"desc" yaml:"desc"`
+	Args []FieldSpec `toml:"args" yaml:"args"`
+	Rets []FieldSpec `toml:"rets" yaml:"rets"`
+
+	RateLimit *RateLimitSpec `toml:"rate_limit" yaml:"rate_limit"`
+}
+
+// RateLimitSpec 令牌桶，rate为每秒次数
+type RateLimitSpec struct {
+	Rate  float64 `toml:"rate" yaml:"rate"`
+	Burst int     `toml:"burst" yaml:"burst"`
+}
+
+type IntervalSpec struct {
+	Name     string `toml:"name" yaml:"name"`
+	Interval int64  `toml:"interval" yaml:"interval"`
+	Desc     string `toml:"desc" yaml:"desc"`
+}
+
+// VirtualSpec 虚拟属性，expr语法见lib/iot/expr
+type VirtualSpec struct {
+	Name string `toml:"name" yaml:"name"`
+	Expr string `toml:"expr" yaml:"expr"`
+	Desc string `toml:"desc" yaml:"desc"`
+}
+
+// DriverSpec 设备驱动的声明式描述
+type DriverSpec struct {
+	Package  string `toml:"package" yaml:"package"`
+	Name     string `toml:"name" yaml:"name"`
+	Variable string `toml:"variable" yaml:"variable"`
+	Brand    string `toml:"brand" yaml:"brand"`
+	Model    string `toml:"model" yaml:"model"`
+	Type     string `toml:"type" yaml:"type"`
+	Desc     string `toml:"desc" yaml:"desc"`
+
+	ForeignID string `toml:"foreign_id" yaml:"foreign_id"` // 作为外部ID的config字段
+	Init      bool   `toml:"init" yaml:"init"`             // 是否生成InitFunc
+
+	Concurrency int            `toml:"concurrency" yaml:"concurrency"` // 0为串行，负数不限制
+	RateLimit   *RateLimitSpec `toml:"rate_limit" yaml:"rate_limit"`
+
+	Config     []FieldSpec    `toml:"config" yaml:"config"`
+	Properties []FieldSpec    `toml:"properties" yaml:"properties"`
+	Virtuals   []VirtualSpec  `toml:"virtuals" yaml:"virtuals"`
+	Actions    []ActionSpec   `toml:"actions" yaml:"actions"`
+	Intervals  []IntervalSpec `toml:"intervals" yaml:"intervals"`
+}
+
+// LoadSpec 按扩展名解析 .toml/.yaml/.yml
+func LoadSpec(file string) (*DriverSpec, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec DriverSpec
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".toml":
+		md, err := toml.Decode(string(data), &spec)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown keys in spec: %v", undecoded)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported spec file: %s", file)
+	}
+
+	return &spec, nil
+}
@@ -0,0 +1,153 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	gogrpc "google.golang.org/grpc"
+	"tmios/internal/cmp"
+	"tmios/internal/config"
+	"tmios/lib/rpc"
+	"tmios/lib/ssl"
+)
+
+// Server gRPC服务，使用lib/ssl双向认证，消息为JSON编码(lib/rpc.Codec)，不能用protobuf客户端调用
+type Server struct {
+	Grpc       *gogrpc.Server
+	cnf        *config.Config
+	listenAddr string
+	deps       []string
+	opts       []Option
+
+	mutex   sync.Mutex
+	serving bool
+	stopped bool
+	lis     net.Listener
+	failed  chan error
+}
+
+// DrainTimeout 停止时等待进行中调用的最长时间
+var DrainTimeout = 10 * time.Second
+
+type Option func(*Server)
+
+// WithListenAddr 默认使用配置文件中的Grpc.ListenAddr
+func WithListenAddr(addr string) Option {
+	return func(s *Server) {
+		s.listenAddr = addr
+	}
+}
+
+// WithDependsOn 服务用到的组件，在这些组件之后启动
+func WithDependsOn(names ...string) Option {
+	return func(s *Server) {
+		s.deps = names
+	}
+}
+
+func NewGrpc(opts ...Option) *Server {
+	s := &Server{
+		cnf:  config.NewConfig(),
+		opts: opts,
+	}
+	s.init()
+	return s
+}
+
+// init 停止后的gogrpc.Server不能再Serve，重启时重新创建并注册服务
+func (s *Server) init() {
+	s.Grpc = gogrpc.NewServer(
+		ssl.Server(),
+		rpc.ServerJSON(),
+		gogrpc.UnaryInterceptor(rpc.UnaryInterceptor()),
+		gogrpc.StreamInterceptor(rpc.StreamInterceptor()),
+	)
+	for _, opt := range s.opts {
+		opt(s)
+	}
+}
+
+func (s *Server) Name() string {
+	return "grpc"
+}
+
+func (s *Server) DependsOn() []string {
+	return append([]string{"config"}, s.deps...)
+}
+
+// Policy 监听出错时重新监听
+func (s *Server) Policy() cmp.Policy {
+	return cmp.Policy{Restart: cmp.RestartOnFailure}
+}
+
+func (s *Server) Failed() <-chan error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.failed
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	if s.listenAddr == "" {
+		s.listenAddr = s.cnf.Conf().Grpc.ListenAddr
+	}
+	if s.listenAddr == "" {
+		logrus.Info("grpc listen address not configured, skipped")
+		return nil
+	}
+
+	lis, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	if s.stopped {
+		s.init()
+		s.stopped = false
+	}
+	srv, failed := s.Grpc, make(chan error, 1)
+	s.serving, s.failed, s.lis = true, failed, lis
+	s.mutex.Unlock()
+
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			failed <- err
+		}
+	}()
+
+	logrus.WithField("addr", s.listenAddr).Info("grpc server started")
+	return nil
+}
+
+// Stop 等待进行中的调用结束，超过DrainTimeout或ctx结束时强制断开；
+// 同步流等长连接不会自行结束，留出时间给后面停止的组件
+func (s *Server) Stop(ctx context.Context) error {
+	s.mutex.Lock()
+	serving, lis := s.serving, s.lis
+	s.serving, s.stopped = false, true
+	s.mutex.Unlock()
+	if !serving {
+		return nil
+	}
+	// Serve还没有开始时GracefulStop不会关闭监听
+	defer lis.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, DrainTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.Grpc.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Grpc.Stop()
+	}
+	return nil
+}
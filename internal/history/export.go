@@ -0,0 +1,521 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"tmios/internal/utils"
+	"tmios/lib/ftp"
+	hist "tmios/lib/iot/history"
+	"tmios/lib/parquet"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+const (
+	ExportCSV     = "csv"
+	ExportParquet = "parquet"
+
+	defaultExportPath   = "export"
+	defaultExportChunk  = time.Hour
+	defaultExportFtpDir = "history"
+	defaultFtpTimeout   = 30 * time.Second
+
+	maxExports          = 2           // 同时执行的导出任务数，其余排队
+	progressInterval    = time.Second // 进度写入数据库的最小间隔
+	exportTimeFormat    = "20060102150405"
+	exportCSVTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// ExportReq Start、End为unix秒，End为0时为当前时间；Resolution为空时导出原始数据，
+// 否则为保留策略中的一级汇总，如"1h"；Ftp为true时导出完成后推送到配置的Ftp服务器
+type ExportReq struct {
+	Devices    []uint   `json:"devices" validate:"required,min=1,max=100"`
+	Fields     []string `json:"fields"`
+	Start      int64    `json:"start"`
+	End        int64    `json:"end"`
+	Format     string   `json:"format" validate:"required,oneof=csv parquet"`
+	Resolution string   `json:"resolution"`
+	Ftp        bool     `json:"ftp"`
+}
+
+// 导出文件的列，原始数据的value、min、max相同，count为1
+var exportColumns = []parquet.Column{
+	{Name: "time", Type: parquet.Timestamp},
+	{Name: "device_id", Type: parquet.Int64},
+	{Name: "device", Type: parquet.String},
+	{Name: "model", Type: parquet.String},
+	{Name: "property", Type: parquet.String},
+	{Name: "value", Type: parquet.Double},
+	{Name: "min", Type: parquet.Double},
+	{Name: "max", Type: parquet.Double},
+	{Name: "count", Type: parquet.Int64},
+}
+
+// initExport 建表，重启前未完成的导出任务标记为失败
+func (h *History) initExport() error {
+	h.db = h.cnf.Db
+	if h.db == nil {
+		return fmt.Errorf("history requires database")
+	}
+
+	chunk, err := utils.ParseDuration(h.cnf.Conf().History.ExportChunk)
+	if err != nil {
+		return fmt.Errorf("history: export chunk: %w", err)
+	}
+	if chunk <= 0 {
+		chunk = defaultExportChunk
+	}
+	h.exportChunk = chunk
+
+	if err := h.db.AutoMigrate(&model.HistoryExport{}); err != nil {
+		return err
+	}
+
+	return h.db.Model(&model.HistoryExport{}).
+		Where("status IN ?", []string{model.ExportPending, model.ExportRunning}).
+		Updates(map[string]interface{}{"status": model.ExportFailed, "message": "interrupted by restart"}).Error
+}
+
+func (h *History) exportPath() string {
+	if path := h.cnf.Conf().History.ExportPath; path != "" {
+		return path
+	}
+	return defaultExportPath
+}
+
+// exportStep 分块的时间范围，汇总数据按分辨率的整数倍对齐，避免一个周期被拆到两块中
+func (h *History) exportStep(res time.Duration) time.Duration {
+	if res <= 0 {
+		return h.exportChunk
+	}
+	if h.exportChunk <= res {
+		return res
+	}
+	return h.exportChunk.Truncate(res)
+}
+
+func chunkEnd(t, end time.Time, step time.Duration) time.Time {
+	next := t.Truncate(step).Add(step)
+	if next.After(end) {
+		return end
+	}
+	return next
+}
+
+// CreateExport 创建导出任务并在后台执行
+func (h *History) CreateExport(req *ExportReq) (*model.HistoryExport, error) {
+	if h.reader == nil {
+		return nil, errm.ErrHistoryDisabled
+	}
+
+	end := time.Now()
+	if req.End > 0 {
+		end = time.Unix(req.End, 0)
+	}
+	start := time.Unix(req.Start, 0)
+	if !start.Before(end) {
+		return nil, errm.ErrParam.SetDetail("start must be before end")
+	}
+
+	res, err := utils.ParseDuration(req.Resolution)
+	if err != nil {
+		return nil, errm.ErrParam.SetDetail("resolution: %s", err.Error())
+	}
+
+	devices := utils.Unique(req.Devices)
+	for _, id := range devices {
+		inst, err := h.reg.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if !h.reader.HasResolution(inst.Row().ModelName, res) {
+			return nil, errm.ErrParam.SetDetail("%s has no rollup of %s", inst.Row().ModelName, req.Resolution)
+		}
+	}
+
+	if req.Ftp && h.cnf.Conf().Ftp.FtpUrl == "" {
+		return nil, errm.ErrParam.SetDetail("ftp is not configured")
+	}
+
+	devicesJSON, _ := json.Marshal(devices)
+	fieldsJSON, _ := json.Marshal(req.Fields)
+	if req.Fields == nil {
+		fieldsJSON = []byte("[]")
+	}
+
+	step, perDevice := h.exportStep(res), 0
+	for t := start; t.Before(end); t = chunkEnd(t, end, step) {
+		perDevice++
+	}
+	job := &model.HistoryExport{
+		Format:     req.Format,
+		Devices:    string(devicesJSON),
+		Fields:     string(fieldsJSON),
+		Start:      start,
+		End:        end,
+		Resolution: req.Resolution,
+		Ftp:        req.Ftp,
+		Status:     model.ExportPending,
+		Chunks:     perDevice * len(devices),
+	}
+	if err := h.db.Create(job).Error; err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	h.startExport(job.ID)
+	return job, nil
+}
+
+func (h *History) Exports() ([]model.HistoryExport, error) {
+	if h.db == nil {
+		return nil, errm.ErrHistoryDisabled
+	}
+
+	arr := make([]model.HistoryExport, 0)
+	if err := h.db.Order("id DESC").Find(&arr).Error; err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return arr, nil
+}
+
+func (h *History) Export(id uint) (*model.HistoryExport, error) {
+	if h.db == nil {
+		return nil, errm.ErrHistoryDisabled
+	}
+
+	var job model.HistoryExport
+	err := h.db.First(&job, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errm.ErrNotFound.SetDetail("export %d", id)
+	}
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return &job, nil
+}
+
+// CancelExport 取消排队或正在执行的任务，已写入的部分文件会被删除
+func (h *History) CancelExport(id uint) error {
+	job, err := h.Export(id)
+	if err != nil {
+		return err
+	}
+
+	ok, err := h.transition(id, []string{model.ExportPending, model.ExportRunning},
+		map[string]interface{}{"status": model.ExportCanceled})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errm.ErrExportState.SetDetail("export %d is %s", id, job.Status)
+	}
+
+	h.mutex.Lock()
+	cancel := h.exports[id]
+	h.mutex.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+
+	return nil
+}
+
+// DeleteExport 删除已结束的任务和导出文件
+func (h *History) DeleteExport(id uint) error {
+	job, err := h.Export(id)
+	if err != nil {
+		return err
+	}
+	if job.Status == model.ExportPending || job.Status == model.ExportRunning {
+		return errm.ErrExportState.SetDetail("export %d is %s", id, job.Status)
+	}
+
+	if err := h.db.Unscoped().Delete(job).Error; err != nil {
+		return errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if job.Path != "" {
+		if err := os.Remove(job.Path); err != nil && !os.IsNotExist(err) {
+			logrus.WithField("path", job.Path).WithError(err).Warn("history: remove export file failed")
+		}
+	}
+
+	return nil
+}
+
+// transition 状态为from之一时更新，返回是否更新成功
+func (h *History) transition(id uint, from []string, updates map[string]interface{}) (bool, error) {
+	res := h.db.Model(&model.HistoryExport{}).Where("id = ? AND status IN ?", id, from).Updates(updates)
+	if res.Error != nil {
+		return false, errm.ErrDBCurd.SetDetail("%s", res.Error.Error())
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+func (h *History) startExport(id uint) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	h.mutex.Lock()
+	h.exports[id] = cancel
+	h.mutex.Unlock()
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		defer func() {
+			h.mutex.Lock()
+			delete(h.exports, id)
+			h.mutex.Unlock()
+			cancel()
+		}()
+
+		select {
+		case h.exportSem <- struct{}{}:
+			defer func() { <-h.exportSem }()
+		case <-ctx.Done():
+			return
+		}
+
+		if err := h.runExport(ctx, id); err != nil {
+			if ctx.Err() == nil {
+				logrus.WithField("export", id).WithError(err).Error("history: export failed")
+			}
+			_, _ = h.transition(id, []string{model.ExportRunning},
+				map[string]interface{}{"status": model.ExportFailed, "message": err.Error()})
+		}
+	}()
+}
+
+func (h *History) runExport(ctx context.Context, id uint) error {
+	ok, err := h.transition(id, []string{model.ExportPending}, map[string]interface{}{"status": model.ExportRunning})
+	if err != nil || !ok {
+		return err
+	}
+
+	job, err := h.Export(id)
+	if err != nil {
+		return err
+	}
+
+	var (
+		devices []uint
+		fields  []string
+	)
+	if err := json.Unmarshal([]byte(job.Devices), &devices); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(job.Fields), &fields); err != nil {
+		return err
+	}
+	res, err := utils.ParseDuration(job.Resolution)
+	if err != nil {
+		return err
+	}
+
+	step := h.exportStep(res)
+	dir := h.exportPath()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	buf := bufio.NewWriter(tmp)
+	w := newExportWriter(job.Format, buf)
+
+	var (
+		done     int
+		rows     int64
+		reported = time.Now()
+	)
+	for _, devID := range devices {
+		inst, err := h.reg.Get(devID)
+		if err != nil {
+			return err
+		}
+		row := inst.Row()
+
+		for start := job.Start; start.Before(job.End); start = chunkEnd(start, job.End, step) {
+			end := chunkEnd(start, job.End, step)
+
+			samples, err := h.reader.QueryAt(ctx, res, hist.Query{
+				Measurement: row.ModelName,
+				Tags:        map[string]string{"device_id": strconv.FormatUint(uint64(devID), 10)},
+				Fields:      fields,
+				Start:       start,
+				End:         end,
+			})
+			if err != nil {
+				return err
+			}
+			for i := range samples {
+				if err := w.write(&row, &samples[i]); err != nil {
+					return err
+				}
+			}
+			rows += int64(len(samples))
+			done++
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if time.Since(reported) >= progressInterval {
+				reported = time.Now()
+				h.db.Model(job).Updates(map[string]interface{}{"done_chunks": done, "rows": rows})
+			}
+		}
+	}
+
+	if err := w.close(); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("history-%d-%s.%s", job.ID, time.Now().Format(exportTimeFormat), job.Format)
+	path := filepath.Join(dir, filename)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"status":      model.ExportDone,
+		"done_chunks": done,
+		"rows":        rows,
+		"filename":    filename,
+		"path":        path,
+		"size":        info.Size(),
+	}
+	if job.Ftp {
+		// 推送失败不影响本地下载
+		if err := h.pushFtp(path, filename); err != nil {
+			logrus.WithField("export", job.ID).WithError(err).Warn("history: push export to ftp failed")
+			updates["message"] = "ftp: " + err.Error()
+		} else {
+			updates["pushed"] = true
+		}
+	}
+
+	ok, err = h.transition(job.ID, []string{model.ExportRunning}, updates)
+	if err == nil && !ok {
+		// 完成前被取消
+		os.Remove(path)
+	}
+	return err
+}
+
+func (h *History) pushFtp(path, filename string) error {
+	conf := h.cnf.Conf()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dir := conf.History.ExportFtpDir
+	if dir == "" {
+		dir = defaultExportFtpDir
+	}
+	timeout := time.Duration(conf.Ftp.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultFtpTimeout
+	}
+
+	return ftp.PutFiles(&ftp.Server{
+		FtpUrl:   conf.Ftp.FtpUrl,
+		Username: conf.Ftp.Username,
+		Password: conf.Ftp.Password,
+		Timeout:  timeout,
+	}, []*ftp.File{{RelativePath: dir, Filename: filename, Content: string(content)}})
+}
+
+type exportWriter interface {
+	write(row *model.Device, s *hist.Sample) error
+	close() error
+}
+
+func newExportWriter(format string, w *bufio.Writer) exportWriter {
+	if format == ExportParquet {
+		return &parquetWriter{w: parquet.NewWriter(w, exportColumns)}
+	}
+
+	header := make([]string, 0, len(exportColumns))
+	for _, col := range exportColumns {
+		header = append(header, col.Name)
+	}
+	c := &csvWriter{w: csv.NewWriter(w)}
+	// 写入缓冲区，错误在close时返回
+	_ = c.w.Write(header)
+	return c
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) write(row *model.Device, s *hist.Sample) error {
+	return c.w.Write([]string{
+		s.Time.Format(exportCSVTimeFormat),
+		strconv.FormatUint(uint64(row.ID), 10),
+		row.Name,
+		row.ModelName,
+		s.Field,
+		strconv.FormatFloat(s.Mean, 'g', -1, 64),
+		strconv.FormatFloat(s.Min, 'g', -1, 64),
+		strconv.FormatFloat(s.Max, 'g', -1, 64),
+		strconv.FormatInt(s.Count, 10),
+	})
+}
+
+func (c *csvWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type parquetWriter struct {
+	w *parquet.Writer
+}
+
+func (p *parquetWriter) write(row *model.Device, s *hist.Sample) error {
+	return p.w.Write([]interface{}{
+		s.Time,
+		int64(row.ID),
+		row.Name,
+		row.ModelName,
+		s.Field,
+		s.Mean,
+		s.Min,
+		s.Max,
+		s.Count,
+	})
+}
+
+func (p *parquetWriter) close() error {
+	return p.w.Close()
+}
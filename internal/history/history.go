@@ -0,0 +1,246 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"tmios/internal/cmp"
+	"tmios/internal/config"
+	"tmios/internal/iot"
+	"tmios/internal/utils"
+	"tmios/lib/influx"
+	"tmios/lib/iot/device"
+	hist "tmios/lib/iot/history"
+	"tmios/lib/iot/storage"
+	errm "tmios/pkg/model/errors"
+)
+
+const defaultCompactInterval = 10 * time.Minute
+
+// History 历史数据的存储、定期降采样和查询
+type History struct {
+	cnf *config.Config
+	reg *iot.Registry
+
+	backend   hist.Backend
+	reader    *hist.Reader
+	compactor *hist.Compactor
+
+	db          *gorm.DB
+	exportChunk time.Duration
+	exportSem   chan struct{}
+	mutex       sync.Mutex
+	exports     map[uint]context.CancelFunc
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// compactErr 最近一次降采样的错误，成功后清除
+	compactErr error
+	// wrapOnce 重启时不重复包装设备存储
+	wrapOnce sync.Once
+}
+
+var (
+	h     *History
+	hOnce sync.Once
+)
+
+// NewHistory 单例，在iot.NewRegistry之前Start，使设备写入的数据进入历史库
+func NewHistory() *History {
+	hOnce.Do(func() {
+		h = &History{
+			cnf:       config.NewConfig(),
+			reg:       iot.NewRegistry(),
+			exportSem: make(chan struct{}, maxExports),
+			exports:   make(map[uint]context.CancelFunc),
+		}
+	})
+	return h
+}
+
+// Policies 把配置中的Retention转为保留策略
+func Policies(retentions []config.Retention) (hist.Policies, error) {
+	var policies hist.Policies
+	for _, r := range retentions {
+		p := hist.Policy{Measurement: r.Measurement}
+		if p.Measurement == "" {
+			return nil, fmt.Errorf("retention: measurement is required")
+		}
+
+		var err error
+		if p.Raw, err = utils.ParseDuration(r.Raw); err != nil {
+			return nil, fmt.Errorf("retention %s: raw: %w", r.Measurement, err)
+		}
+		for _, rollup := range r.Rollups {
+			var level hist.Level
+			if level.Resolution, err = utils.ParseDuration(rollup.Resolution); err != nil {
+				return nil, fmt.Errorf("retention %s: resolution: %w", r.Measurement, err)
+			}
+			if level.Keep, err = utils.ParseDuration(rollup.Keep); err != nil {
+				return nil, fmt.Errorf("retention %s: keep: %w", r.Measurement, err)
+			}
+			p.Levels = append(p.Levels, level)
+		}
+
+		if err := p.Check(); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+
+	return policies, nil
+}
+
+// newBackend 返回存储和设备存储的包装：mysql把设备数据复制到history_samples，
+// influxdb让设备数据直接写入InfluxDB，降采样和清理都在InfluxDB中进行
+func (h *History) newBackend(name string) (hist.Backend, func(device.Storage) device.Storage, error) {
+	if name == "influxdb" {
+		conf := h.cnf.Conf().IOTInfuxDB
+		client := influx.NewClient(conf.ServerURL, conf.AuthToken, conf.Org, conf.Bucket)
+		return hist.NewInfluxBackend(client), func(s device.Storage) device.Storage {
+			return storage.NewInfluxStorage(s, client)
+		}, nil
+	}
+
+	backend, err := hist.NewSQLBackend(h.cnf.Db)
+	if err != nil {
+		return nil, nil, err
+	}
+	return backend, func(s device.Storage) device.Storage {
+		return hist.NewStorage(s, backend)
+	}, nil
+}
+
+func (h *History) Name() string {
+	return "history"
+}
+
+func (h *History) DependsOn() []string {
+	return []string{"config", "storage"}
+}
+
+func (h *History) Start(ctx context.Context) error {
+	conf := h.cnf.Conf().History
+	if !conf.Enable {
+		return nil
+	}
+
+	interval, err := utils.ParseDuration(conf.CompactInterval)
+	if err != nil {
+		return fmt.Errorf("history: compact interval: %w", err)
+	}
+	if interval <= 0 {
+		interval = defaultCompactInterval
+	}
+
+	policies, err := Policies(h.cnf.Conf().Retention)
+	if err != nil {
+		return err
+	}
+
+	backend, wrap, err := h.newBackend(conf.Backend)
+	if err != nil {
+		return err
+	}
+
+	if err := h.initExport(); err != nil {
+		return err
+	}
+
+	h.backend = backend
+	h.reader = hist.NewReader(backend, policies)
+	h.compactor = hist.NewCompactor(backend, policies)
+	h.wrapOnce.Do(func() {
+		h.reg.WrapStorage(wrap)
+	})
+
+	loopCtx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+	h.wg.Add(1)
+	go h.loop(loopCtx, interval)
+
+	return nil
+}
+
+// Check 降采样失败时查询和写入仍可用，为降级
+func (h *History) Check(ctx context.Context) error {
+	h.mutex.Lock()
+	err := h.compactErr
+	h.mutex.Unlock()
+
+	if err != nil {
+		return cmp.Degraded(fmt.Errorf("compact: %w", err))
+	}
+	return nil
+}
+
+// Stop 停止降采样并取消进行中的导出，导出任务标记为失败
+func (h *History) Stop(ctx context.Context) error {
+	if h.cancel == nil {
+		return nil
+	}
+	h.cancel()
+
+	h.mutex.Lock()
+	for _, cancel := range h.exports {
+		cancel()
+	}
+	h.mutex.Unlock()
+
+	h.wg.Wait()
+	return nil
+}
+
+func (h *History) loop(ctx context.Context, interval time.Duration) {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		err := h.compactor.Compact(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		h.mutex.Lock()
+		h.compactErr = err
+		h.mutex.Unlock()
+		if err != nil {
+			logrus.WithError(err).Error("history: compact failed")
+			continue
+		}
+		logrus.WithField("cost", time.Since(start).String()).Debug("history: compact done")
+	}
+}
+
+// WritePoint 不经过设备存储直接写入历史库，中心用来保存边缘同步的数据；未启用时忽略
+func (h *History) WritePoint(ctx context.Context, measurement string, tags map[string]string,
+	fields map[string]interface{}, t time.Time) error {
+	if h.backend == nil {
+		return nil
+	}
+
+	return hist.WriteRaw(ctx, h.backend, measurement, tags, fields, t)
+}
+
+// Query 按时间范围自动选择原始数据或汇总数据
+func (h *History) Query(ctx context.Context, q hist.Query) (*hist.Result, error) {
+	if h.reader == nil {
+		return nil, errm.ErrHistoryDisabled
+	}
+
+	return h.reader.Query(ctx, q)
+}
@@ -0,0 +1,198 @@
+package http
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+	"tmios/internal/config"
+	"tmios/internal/utils"
+	errm "tmios/pkg/model/errors"
+)
+
+// 鉴权使用的请求头
+const (
+	HeaderToken     = "X-TOKEN"
+	HeaderAppID     = "X-APP-ID"
+	HeaderTimestamp = "X-TIMESTAMP"
+	HeaderNonce     = "X-NONCE"
+	HeaderSignature = "X-SIGNATURE"
+)
+
+// signatureWindow 签名请求的时间戳与服务器时间允许的误差，nonce在该时间内不能重复
+const signatureWindow = 5 * time.Minute
+
+// maxSignedBody 签名请求需要在校验前读入整个body，超过时拒绝；上传固件等大文件使用X-TOKEN
+const maxSignedBody = 16 << 20
+
+// appAuth 按[[Apps]]校验请求，每次请求读取当前配置，配置重新加载后新的Token立即生效；没有配置Apps时不鉴权。
+// 支持两种方式:
+//   - X-TOKEN为应用的Token；SSE和WebSocket无法设置请求头，可以用token查询参数
+//   - X-APP-ID、X-TIMESTAMP(unix秒)、X-NONCE、X-SIGNATURE，签名为以Token为密钥的HMAC-SHA256，见Signature
+type appAuth struct {
+	cnf    *config.Config
+	nonces *cache.Cache
+	public sync.Map
+}
+
+func newAppAuth(cnf *config.Config) *appAuth {
+	cnf.Subscribe("Apps", func(old, new *config.CnfFile) {
+		if len(new.Apps) == 0 {
+			logrus.Warn("http: [[Apps]] removed, api authentication is disabled")
+			return
+		}
+		logrus.WithField("apps", len(new.Apps)).Info("http: [[Apps]] reloaded")
+	})
+
+	return &appAuth{
+		cnf:    cnf,
+		nonces: cache.New(2*signatureWindow, signatureWindow),
+	}
+}
+
+// Signature 签名内容为method、path、query、timestamp、nonce、body的SHA256(hex)，以\n连接
+func Signature(token, method, path, query, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(strings.Join([]string{method, path, query, timestamp, nonce, hex.EncodeToString(sum[:])}, "\n")))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *appAuth) handle(c *gin.Context) {
+	if _, ok := a.public.Load(c.Request.URL.Path); ok || c.Request.Method == "OPTIONS" {
+		c.Next()
+		return
+	}
+
+	apps := a.cnf.Conf().Apps
+	if len(apps) == 0 {
+		c.Next()
+		return
+	}
+
+	var (
+		app *config.App
+		err error
+	)
+	if c.GetHeader(HeaderSignature) != "" {
+		app, err = a.verifySignature(c, apps)
+	} else {
+		app, err = a.verifyToken(c, apps)
+	}
+	if err != nil {
+		utils.ApiErr(c, err)
+		c.Abort()
+		return
+	}
+
+	c.Set(utils.AppIDKey, app.AppID)
+	c.Next()
+}
+
+// verifyToken 与每个应用的Token都做一次定长比较，耗时与哪个应用匹配无关
+func (a *appAuth) verifyToken(c *gin.Context, apps []config.App) (*config.App, error) {
+	token := c.GetHeader(HeaderToken)
+	if token == "" && isStreamRequest(c) {
+		token = c.Query("token")
+	}
+	if token == "" {
+		return nil, errm.ErrNoTokenFound
+	}
+
+	var found *config.App
+	for i := range apps {
+		if apps[i].Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(apps[i].Token)) == 1 {
+			found = &apps[i]
+		}
+	}
+	if found == nil {
+		return nil, errm.ErrInvalidAppToken
+	}
+
+	return found, nil
+}
+
+func (a *appAuth) verifySignature(c *gin.Context, apps []config.App) (*config.App, error) {
+	var (
+		appID     = c.GetHeader(HeaderAppID)
+		timestamp = c.GetHeader(HeaderTimestamp)
+		nonce     = c.GetHeader(HeaderNonce)
+		signature = c.GetHeader(HeaderSignature)
+		log       = logrus.WithField("app", appID).WithField("path", c.Request.URL.Path)
+	)
+	if appID == "" || timestamp == "" || nonce == "" {
+		return nil, errm.ErrNoTokenFound
+	}
+
+	var app *config.App
+	for i := range apps {
+		if apps[i].AppID == appID && apps[i].Token != "" {
+			app = &apps[i]
+			break
+		}
+	}
+	if app == nil {
+		log.Debug("auth: unknown app")
+		return nil, errm.ErrInvalidAppToken
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errm.ErrInvalidAppToken
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > signatureWindow || d < -signatureWindow {
+		log.Debug("auth: timestamp out of window")
+		return nil, errm.ErrInvalidAppToken
+	}
+
+	body, err := io.ReadAll(nethttp.MaxBytesReader(c.Writer, c.Request.Body, maxSignedBody))
+	if err != nil {
+		return nil, errm.ErrInvalidRequest.SetDetail("signed body exceeds %d bytes", maxSignedBody)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	expect := Signature(app.Token, c.Request.Method, c.Request.URL.Path, c.Request.URL.RawQuery, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expect), []byte(strings.ToLower(signature))) {
+		log.Debug("auth: signature mismatch")
+		return nil, errm.ErrInvalidAppToken
+	}
+
+	// 签名通过后才记录nonce，窗口内重复的请求视为重放
+	if err := a.nonces.Add(appID+"\n"+nonce, nil, 2*signatureWindow); err != nil {
+		log.Warn("auth: nonce replayed")
+		return nil, errm.ErrInvalidAppToken
+	}
+
+	return app, nil
+}
+
+var tokenQuery = regexp.MustCompile(`(^|&)token=[^&]*`)
+
+// logFormatter 与gin默认的格式相同，token查询参数替换为******，访问日志不经过logrus的脱敏
+func logFormatter(p gin.LogFormatterParams) string {
+	path := p.Path
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i+1] + tokenQuery.ReplaceAllString(path[i+1:], "${1}token=******")
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		p.TimeStamp.Format("2006/01/02 - 15:04:05"), p.StatusCode, p.Latency, p.ClientIP, p.Method, path, p.ErrorMessage)
+}
+
+func isStreamRequest(c *gin.Context) bool {
+	return strings.EqualFold(c.GetHeader("Upgrade"), "websocket") ||
+		strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
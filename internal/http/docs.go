@@ -0,0 +1,10 @@
+package http
+
+import (
+	_ "embed"
+)
+
+// docsPage 读取/api/docs/openapi.json并展示的静态页面，不依赖外部资源
+//
+//go:embed docs/index.html
+var docsPage []byte
@@ -1,67 +1,158 @@
 package http
 
 import (
+	"context"
+	"errors"
+	"net"
+	nethttp "net/http"
+	"sync"
+
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"tmios/internal/cmp"
 	"tmios/internal/config"
 )
 
-var defaultListen string
-
 // Api api
 type Api struct {
 	Router     *gin.Engine
 	cnf        *config.Config
+	auth       *appAuth
 	listenAddr string
+	deps       []string
+
+	smutex sync.Mutex
+	server *nethttp.Server
+	lis    net.Listener
+	failed chan error
+	// closing 停止时关闭，通知SSE、WebSocket等长连接结束
+	closing chan struct{}
+
+	rmutex sync.Mutex
+	routes []route
 }
 type Option func(*Api)
 
+// WithDependsOn 接口用到的组件，在这些组件之后启动
+func WithDependsOn(names ...string) Option {
+	return func(a *Api) {
+		a.deps = names
+	}
+}
+
 func NewHttp(opts ...Option) *Api {
-	g := gin.Default()
+	cnf := config.NewConfig()
+	auth := newAppAuth(cnf)
+
+	g := gin.New()
 	g.Use(
 		gin.Recovery(),
-		gin.Logger(),
+		gin.LoggerWithConfig(gin.LoggerConfig{Formatter: logFormatter}),
 		cors.New(cors.Config{
 			AllowMethods: []string{"OPTIONS", "POST", "GET"},
 			AllowHeaders: []string{"Origin", "X-Requested-With",
-				"Content-Type", "Accept", "X-TOKEN"},
+				"Content-Type", "Accept", "Authorization", HeaderToken,
+				HeaderAppID, HeaderTimestamp, HeaderNonce, HeaderSignature},
 			AllowCredentials: true,
 			AllowOriginFunc: func(origin string) bool {
-				return true
+				origins := cnf.Conf().API.AllowOrigins
+				return len(origins) == 0 || allowOrigin(origins, origin)
 			},
-		}))
+		}),
+		auth.handle,
+	)
 
 	http := &Api{
-		Router:     g,
-		cnf:        config.NewConfig(),
-		listenAddr: defaultListen,
+		Router:  g,
+		cnf:     cnf,
+		auth:    auth,
+		closing: make(chan struct{}),
 	}
+	g.Use(func(c *gin.Context) {
+		http.smutex.Lock()
+		c.Set(closingKey, http.closing)
+		http.smutex.Unlock()
+	})
+	http.serveDocs()
 	for _, opt := range opts {
 		opt(http)
 	}
 	return http
 }
 
-func (a *Api) Run() error {
-	if a.listenAddr == defaultListen {
-		a.listenAddr = a.cnf.Conf.API.ListenAddr
-		err := a.Router.Run(a.listenAddr)
-		if err != nil {
-			return err
-		}
-	} else {
-		go func() {
-			err := a.Router.Run(a.listenAddr)
-			if err != nil {
-				logrus.Fatal(err)
-			}
-		}()
+// Public 不需要鉴权的路径，例如设备下载固件
+func (a *Api) Public(paths ...string) {
+	for _, path := range paths {
+		a.auth.public.Store(path, struct{}{})
+	}
+}
+
+func (a *Api) Name() string {
+	return "http"
+}
+
+func (a *Api) DependsOn() []string {
+	return append([]string{"config"}, a.deps...)
+}
+
+// Policy 监听出错时重新监听
+func (a *Api) Policy() cmp.Policy {
+	return cmp.Policy{Restart: cmp.RestartOnFailure}
+}
+
+func (a *Api) Failed() <-chan error {
+	a.smutex.Lock()
+	defer a.smutex.Unlock()
+	return a.failed
+}
+
+func (a *Api) Start(ctx context.Context) error {
+	if len(a.cnf.Conf().Apps) == 0 {
+		logrus.Warn("http: no [[Apps]] configured, api authentication is disabled")
+	}
+
+	if a.listenAddr == "" {
+		a.listenAddr = a.cnf.Conf().API.ListenAddr
+	}
+	lis, err := net.Listen("tcp", a.listenAddr)
+	if err != nil {
+		return err
 	}
 
+	server := &nethttp.Server{Handler: a.Router}
+	failed := make(chan error, 1)
+	a.smutex.Lock()
+	a.server, a.lis, a.failed, a.closing = server, lis, failed, make(chan struct{})
+	a.smutex.Unlock()
+
+	go func() {
+		if err := server.Serve(lis); err != nil && !errors.Is(err, nethttp.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	logrus.WithField("addr", lis.Addr().String()).Info("http server started")
 	return nil
 }
 
+// Stop 不再接受新连接，结束长连接后等待进行中的请求完成
+func (a *Api) Stop(ctx context.Context) error {
+	a.smutex.Lock()
+	server, lis, closing := a.server, a.lis, a.closing
+	a.server = nil
+	a.smutex.Unlock()
+	if server == nil {
+		return nil
+	}
+
+	close(closing)
+	err := server.Shutdown(ctx)
+	// Serve还没有开始时Shutdown不会关闭监听
+	_ = lis.Close()
+	return err
+}
+
 type PageReq struct {
 	PageIndex int                    `json:"page_index" validate:"required"`
 	PageSize  int                    `json:"page_size" validate:"required"`
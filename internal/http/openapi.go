@@ -0,0 +1,456 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"tmios/internal/utils"
+	"tmios/lib/errors"
+	errm "tmios/pkg/model/errors"
+)
+
+// route 通过GET、POST或Describe注册的接口，用于生成OpenAPI文档
+type route struct {
+	method string
+	path   string
+	req    reflect.Type
+	attr   *utils.HandlerAttr
+}
+
+// Group 注册到该分组的接口会记录到OpenAPI文档，直接调用RouterGroup的方法注册的不会
+type Group struct {
+	*gin.RouterGroup
+	api *Api
+}
+
+func (a *Api) Group(relativePath string) *Group {
+	return &Group{RouterGroup: a.Router.Group(relativePath), api: a}
+}
+
+func GET[T any](g *Group, relativePath string, handlerFunc func(*utils.ReqContext, *T) (interface{}, error), opts ...utils.HandlerOption) {
+	handle(g, "GET", relativePath, handlerFunc, opts...)
+}
+
+func POST[T any](g *Group, relativePath string, handlerFunc func(*utils.ReqContext, *T) (interface{}, error), opts ...utils.HandlerOption) {
+	handle(g, "POST", relativePath, handlerFunc, opts...)
+}
+
+func handle[T any](g *Group, method, relativePath string, handlerFunc func(*utils.ReqContext, *T) (interface{}, error), opts ...utils.HandlerOption) {
+	g.Handle(method, relativePath, utils.Handler(handlerFunc, opts...))
+	g.api.addRoute(method, path.Join(g.BasePath(), relativePath), reflect.TypeOf((*T)(nil)).Elem(), opts...)
+}
+
+// Describe 记录没有经过utils.Handler的接口，req为请求参数的示例值，可以为nil
+func (g *Group) Describe(method, relativePath string, req any, opts ...utils.HandlerOption) {
+	var t reflect.Type
+	if req != nil {
+		t = reflect.TypeOf(req)
+	}
+	g.api.addRoute(method, path.Join(g.BasePath(), relativePath), t, opts...)
+}
+
+func (a *Api) addRoute(method, fullPath string, req reflect.Type, opts ...utils.HandlerOption) {
+	a.rmutex.Lock()
+	defer a.rmutex.Unlock()
+
+	a.routes = append(a.routes, route{method: method, path: fullPath, req: req, attr: utils.NewHandlerAttr(opts...)})
+}
+
+// OpenAPI 按已注册的接口生成OpenAPI 3文档
+func (a *Api) OpenAPI() ([]byte, error) {
+	a.rmutex.Lock()
+	routes := append([]route(nil), a.routes...)
+	a.rmutex.Unlock()
+
+	gen := &schemaGen{components: make(map[string]interface{})}
+	paths := make(map[string]map[string]interface{})
+	for _, r := range routes {
+		_, public := a.auth.public.Load(r.path)
+		if paths[r.path] == nil {
+			paths[r.path] = make(map[string]interface{})
+		}
+		paths[r.path][strings.ToLower(r.method)] = gen.operation(r, public)
+	}
+
+	gen.components["Error"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"Code":    map[string]interface{}{"type": "integer"},
+			"Detail":  map[string]interface{}{"type": "string"},
+			"Content": map[string]interface{}{},
+		},
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "tmios",
+			"version":     "v1",
+			"description": "成功时HTTP状态为200，Code为0，结果在Content中；失败时HTTP状态和Code见各接口的错误列表(x-error-codes)",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": gen.components,
+			"securitySchemes": map[string]interface{}{
+				"appToken": map[string]interface{}{"type": "apiKey", "in": "header", "name": HeaderToken},
+				"session":  map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		// 配置了[[Apps]]时每个请求都需要应用Token，需要登录的接口同时需要会话
+		"security": []interface{}{
+			map[string]interface{}{"appToken": []string{}, "session": []string{}},
+		},
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+type schemaGen struct {
+	components map[string]interface{}
+}
+
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func operationID(method, p string) string {
+	return strings.ToLower(method) + "_" + strings.Trim(nonIdent.ReplaceAllString(strings.ReplaceAll(p, "/", "_"), "_"), "_")
+}
+
+// tag 按/api/v1/<tag>/...分组
+func tag(p string) string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) >= 3 && parts[0] == "api" {
+		return parts[2]
+	}
+	return parts[0]
+}
+
+func (g *schemaGen) operation(r route, public bool) map[string]interface{} {
+	doc := r.attr.Doc
+	op := map[string]interface{}{
+		"operationId": operationID(r.method, r.path),
+		"tags":        []string{tag(r.path)},
+	}
+	if doc.Summary != "" {
+		op["summary"] = doc.Summary
+	}
+	if public {
+		op["security"] = []interface{}{}
+	}
+
+	if r.req != nil {
+		switch {
+		case r.method == "GET":
+			if params := g.parameters(r.req); len(params) > 0 {
+				op["parameters"] = params
+			}
+		case doc.Upload != "":
+			form := g.object(r.req, "form")
+			props, _ := form["properties"].(map[string]interface{})
+			props[doc.Upload] = map[string]interface{}{"type": "string", "format": "binary"}
+			form["required"] = append(toStrings(form["required"]), doc.Upload)
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"multipart/form-data": map[string]interface{}{"schema": form}},
+			}
+		default:
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schema(r.req, "json")}},
+			}
+		}
+	}
+
+	responses := make(map[string]interface{})
+	if r.attr.ReturnType == utils.ReturnTypeNone {
+		responses["200"] = map[string]interface{}{"description": "文件或数据流，出错时为JSON"}
+	} else {
+		content := map[string]interface{}{}
+		if doc.Response != nil {
+			content = g.schema(reflect.TypeOf(doc.Response), "json")
+		}
+		responses["200"] = map[string]interface{}{
+			"description": "成功",
+			"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"Code":    map[string]interface{}{"type": "integer", "enum": []int{0}},
+					"Detail":  map[string]interface{}{"type": "string"},
+					"Content": content,
+				},
+			}}},
+		}
+	}
+
+	errs := append([]error{errm.ErrParam}, doc.Errors...)
+	if !public {
+		errs = append(errs, errm.ErrNoTokenFound, errm.ErrInvalidAppToken)
+	}
+	for status, list := range groupErrors(errs) {
+		lines := make([]string, 0, len(list))
+		codes := make([]interface{}, 0, len(list))
+		for _, e := range list {
+			lines = append(lines, fmt.Sprintf("%d %s", e.Code, e.Detail))
+			codes = append(codes, map[string]interface{}{"code": e.Code, "detail": e.Detail})
+		}
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description":   strings.Join(lines, "; "),
+			"x-error-codes": codes,
+			"content": map[string]interface{}{"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+			}},
+		}
+	}
+	responses["500"] = map[string]interface{}{"description": "内部错误，Code为-1"}
+	op["responses"] = responses
+
+	return op
+}
+
+// groupErrors 按HTTP状态分组，错误码以lib/errors.Errors中的定义为准，去掉SetDetail附加的内容
+func groupErrors(errs []error) map[int][]errors.Error {
+	seen := make(map[int]bool)
+	groups := make(map[int][]errors.Error)
+	for _, err := range errs {
+		e, ok := err.(errors.Error)
+		if !ok || seen[e.Code] {
+			continue
+		}
+		seen[e.Code] = true
+		if def, ok := errors.Errors[e.Code]; ok {
+			e = def
+		}
+		groups[e.Status] = append(groups[e.Status], e)
+	}
+	for _, list := range groups {
+		sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	}
+
+	return groups
+}
+
+func (g *schemaGen) parameters(t reflect.Type) []interface{} {
+	params := make([]interface{}, 0)
+	for _, f := range fields(t, "form") {
+		param := map[string]interface{}{
+			"name":   f.name,
+			"in":     "query",
+			"schema": g.field(f),
+		}
+		if f.required {
+			param["required"] = true
+		}
+		params = append(params, param)
+	}
+
+	return params
+}
+
+type structField struct {
+	name     string
+	typ      reflect.Type
+	validate string
+	required bool
+}
+
+// fields 按tag取字段名，展开匿名嵌入的结构体
+func fields(t reflect.Type, tagName string) []structField {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var out []structField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tagVal := sf.Tag.Get(tagName)
+		if tagVal == "-" {
+			continue
+		}
+		name := strings.Split(tagVal, ",")[0]
+		if sf.Anonymous && name == "" {
+			out = append(out, fields(sf.Type, tagName)...)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		validate := sf.Tag.Get("validate")
+		out = append(out, structField{
+			name:     name,
+			typ:      sf.Type,
+			validate: validate,
+			required: hasRule(validate, "required"),
+		})
+	}
+
+	return out
+}
+
+func hasRule(validate, rule string) bool {
+	for _, r := range strings.Split(validate, ",") {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func toStrings(v interface{}) []string {
+	s, _ := v.([]string)
+	return s
+}
+
+func (g *schemaGen) field(f structField) map[string]interface{} {
+	s := g.schema(f.typ, "json")
+	if _, ref := s["$ref"]; ref {
+		return s
+	}
+
+	kind := f.typ.Kind()
+	for _, rule := range strings.Split(f.validate, ",") {
+		if rule == "dive" {
+			break
+		}
+		key, val, _ := strings.Cut(rule, "=")
+		n, numErr := strconv.Atoi(val)
+		switch key {
+		case "min", "max", "len":
+			if numErr != nil {
+				continue
+			}
+			var prefix string
+			switch kind {
+			case reflect.String:
+				prefix = "Length"
+			case reflect.Slice, reflect.Array, reflect.Map:
+				prefix = "Items"
+			default:
+				if key == "min" {
+					s["minimum"] = n
+				} else if key == "max" {
+					s["maximum"] = n
+				}
+				continue
+			}
+			if key != "max" {
+				s["min"+prefix] = n
+			}
+			if key != "min" {
+				s["max"+prefix] = n
+			}
+		case "oneof":
+			s["enum"] = strings.Fields(val)
+		case "ip":
+			s["format"] = "ip"
+		case "hexadecimal":
+			s["pattern"] = "^[0-9a-fA-F]*$"
+		}
+	}
+
+	return s
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+	rawType       = reflect.TypeOf(json.RawMessage{})
+)
+
+func (g *schemaGen) schema(t reflect.Type, tagName string) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case deletedAtType:
+		return map[string]interface{}{"type": "string", "format": "date-time", "nullable": true}
+	case rawType:
+		return map[string]interface{}{"description": "JSON"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem(), tagName)
+		if _, ref := s["$ref"]; ref {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem(), tagName)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem(), tagName)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t, tagName)
+		}
+		name := nonIdent.ReplaceAllString(t.String(), "_")
+		if _, ok := g.components[name]; !ok {
+			// 先占位，自引用的结构体不会无限递归
+			g.components[name] = map[string]interface{}{}
+			g.components[name] = g.object(t, tagName)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+
+	return map[string]interface{}{}
+}
+
+func (g *schemaGen) object(t reflect.Type, tagName string) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	for _, f := range fields(t, tagName) {
+		props[f.name] = g.field(f)
+		if f.required {
+			required = append(required, f.name)
+		}
+	}
+
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// serveDocs /api/docs为文档页面，/api/docs/openapi.json为文档，不需要鉴权
+func (a *Api) serveDocs() {
+	a.Public("/api/docs", "/api/docs/openapi.json")
+	a.Router.GET("/api/docs", func(c *gin.Context) {
+		c.Data(200, "text/html; charset=utf-8", docsPage)
+	})
+	a.Router.GET("/api/docs/openapi.json", func(c *gin.Context) {
+		spec, err := a.OpenAPI()
+		if err != nil {
+			utils.ApiErr(c, err)
+			return
+		}
+		c.Data(200, "application/json; charset=utf-8", spec)
+	})
+}
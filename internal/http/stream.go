@@ -0,0 +1,177 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+	"tmios/internal/config"
+	"tmios/internal/utils"
+	errm "tmios/pkg/model/errors"
+)
+
+const (
+	StreamHeartbeat    = 15 * time.Second
+	streamWriteTimeout = 10 * time.Second
+)
+
+// 快照和增量之外的消息
+const (
+	StreamEventHeartbeat = "heartbeat"
+	StreamEventDropped   = "dropped" // Data为累计丢弃的消息数
+)
+
+// StreamMessage Event为SSE的event名，WebSocket中为JSON消息的event字段
+type StreamMessage struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// Stream 一个客户端的消息来源。连接建立后先发送Snapshot，再转发C中的增量消息，直到C关闭或客户端断开。
+// 来源在客户端消费过慢时应丢弃消息而不是阻塞，Dropped返回丢弃数，增加时通知客户端
+type Stream struct {
+	Snapshot []StreamMessage
+	C        <-chan StreamMessage
+	Dropped  func() uint64
+	Close    func()
+}
+
+// pump 发送快照、增量和心跳，send出错或ctx结束时返回
+func (s *Stream) pump(ctx context.Context, send func(StreamMessage) error) error {
+	for _, m := range s.Snapshot {
+		if err := send(m); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(StreamHeartbeat)
+	defer ticker.Stop()
+
+	var dropped uint64
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-s.C:
+			if !ok {
+				return nil
+			}
+			err = send(m)
+		case t := <-ticker.C:
+			err = send(StreamMessage{Event: StreamEventHeartbeat, Data: t.Unix()})
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.Dropped == nil {
+			continue
+		}
+		if n := s.Dropped(); n > dropped {
+			dropped = n
+			if err := send(StreamMessage{Event: StreamEventDropped, Data: n}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+const closingKey = "http.closing"
+
+// streamContext 客户端断开或服务停止时结束
+func streamContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	if closing, ok := c.Value(closingKey).(chan struct{}); ok {
+		go func() {
+			select {
+			case <-closing:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+// ServeSSE 以Server-Sent Events推送，Data为JSON
+func ServeSSE(c *gin.Context, s *Stream) {
+	defer s.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// 禁止nginx缓冲
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+	c.Writer.Flush()
+
+	ctx, cancel := streamContext(c)
+	defer cancel()
+
+	_ = s.pump(ctx, func(m StreamMessage) error {
+		c.SSEvent(m.Event, m.Data)
+		c.Writer.Flush()
+		return ctx.Err()
+	})
+}
+
+func allowOrigin(origins []string, origin string) bool {
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(strings.TrimRight(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin WebSocket不受CORS限制，浏览器会带上Cookie，只允许同源、API.AllowOrigins中的来源
+// 和没有Origin的非浏览器客户端
+func checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	if allowOrigin(config.NewConfig().Conf().API.AllowOrigins, origin) {
+		return nil
+	}
+
+	return errm.ErrOriginForbidden.SetDetail("%s", origin)
+}
+
+// ServeWebSocket 以WebSocket推送JSON消息，客户端发送的消息被忽略；
+// 写入超时的客户端会被断开
+func ServeWebSocket(c *gin.Context, s *Stream) {
+	defer s.Close()
+
+	if err := checkOrigin(c.Request); err != nil {
+		utils.ApiErr(c, err)
+		return
+	}
+
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		ctx, cancel := streamContext(c)
+		defer cancel()
+
+		// 读到EOF或出错表示客户端断开
+		go func() {
+			defer cancel()
+			_, _ = io.Copy(io.Discard, ws)
+		}()
+
+		_ = s.pump(ctx, func(m StreamMessage) error {
+			if err := ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+				return err
+			}
+			return websocket.JSON.Send(ws, m)
+		})
+	}}.ServeHTTP(c.Writer, c.Request)
+}
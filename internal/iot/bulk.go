@@ -0,0 +1,196 @@
+package iot
+
+import (
+	"gorm.io/gorm"
+	"tmios/lib/errors"
+	"tmios/lib/sql"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+const (
+	ImportCreate = "create"
+	ImportUpdate = "update"
+)
+
+// ImportRowResult Row从1开始，Action为create或update，Code、Error为该行的错误
+type ImportRowResult struct {
+	Row       int    `json:"row"`
+	Name      string `json:"name"`
+	Model     string `json:"model"`
+	ForeignID string `json:"foreign_id,omitempty"`
+	Action    string `json:"action,omitempty"`
+	DeviceID  uint   `json:"device_id,omitempty"`
+	Code      int    `json:"code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ImportResult 有任一行出错时全部不提交，Committed为false
+type ImportResult struct {
+	Total     int               `json:"total"`
+	Created   int               `json:"created"`
+	Updated   int               `json:"updated"`
+	Failed    int               `json:"failed"`
+	Committed bool              `json:"committed"`
+	Rows      []ImportRowResult `json:"rows"`
+}
+
+// Import 批量导入设备，每行按型号校验配置；upsert为true时外部ID已存在的设备更新为导入的数据，
+// 否则视为冲突。dryRun只校验不提交。所有行在一个事务中提交
+func (r *Registry) Import(rows []ImportRow, upsert, dryRun bool) (*ImportResult, error) {
+	r.wmutex.Lock()
+	defer r.wmutex.Unlock()
+
+	var (
+		result = &ImportResult{Total: len(rows), Rows: make([]ImportRowResult, len(rows))}
+		seen   = make(map[foreignKey]int)
+	)
+
+	for i := range rows {
+		row := &rows[i].Device
+		res := &result.Rows[i]
+		*res = ImportRowResult{Row: i + 1, Name: row.Name, Model: row.ModelName}
+
+		var err error
+		if rows[i].Err != nil {
+			err = errm.ErrParam.SetDetail("%s", rows[i].Err.Error())
+		} else {
+			err = r.checkImportRow(row, upsert, seen, i)
+		}
+		res.ForeignID = string(row.ForeignID)
+		if err != nil {
+			res.setError(err)
+			result.Failed++
+			continue
+		}
+
+		if row.ID == 0 {
+			res.Action = ImportCreate
+			result.Created++
+		} else {
+			res.Action = ImportUpdate
+			res.DeviceID = row.ID
+			result.Updated++
+		}
+	}
+
+	if dryRun || result.Failed > 0 {
+		return result, nil
+	}
+
+	var (
+		creates []*model.Device
+		updates = make(map[uint]*model.Device)
+		ids     []uint
+	)
+	for i := range rows {
+		row := &rows[i].Device
+		if row.ID == 0 {
+			creates = append(creates, row)
+		} else {
+			updates[row.ID] = row
+			ids = append(ids, row.ID)
+		}
+	}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range creates {
+			if err := sql.CreateModel(tx, row); err != nil {
+				return err
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		return sql.UpdateModelsInTx[model.Device](tx, func(q *gorm.DB) *gorm.DB {
+			return q.Where("id IN ?", ids)
+		}, func(models []*model.Device) error {
+			for _, m := range models {
+				u := updates[m.ID]
+				m.Name = u.Name
+				m.ModelName = u.ModelName
+				m.Config = u.Config
+				m.ForeignID = u.ForeignID
+				m.Virtuals = u.Virtuals
+				m.Debug = u.Debug
+				*u = *m
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	result.Committed = true
+
+	for i := range rows {
+		result.Rows[i].DeviceID = rows[i].Device.ID
+		if err := r.load(rows[i].Device); err != nil {
+			result.Rows[i].setError(err)
+		}
+	}
+
+	return result, nil
+}
+
+// checkImportRow 校验一行，需要更新已有设备时设置row.ID；seen记录文件中已出现的外部ID
+func (r *Registry) checkImportRow(row *model.Device, upsert bool, seen map[foreignKey]int, i int) error {
+	row.ID = 0
+	if row.Name == "" {
+		return errm.ErrParam.SetDetail("name is required")
+	}
+	if err := r.checkRow(row); err != nil {
+		return err
+	}
+	if row.ForeignID == "" {
+		return nil
+	}
+
+	key := foreignKey{row.ModelName, string(row.ForeignID)}
+	if j, ok := seen[key]; ok {
+		return errm.ErrDeviceForeignID.SetDetail("%s %s is duplicated with row %d", row.ModelName, row.ForeignID, j+1)
+	}
+	seen[key] = i
+
+	other, err := r.conflict(row)
+	if err != nil || other == nil {
+		return err
+	}
+	if !upsert {
+		return conflictErr(row, other)
+	}
+	row.ID = other.ID
+
+	return nil
+}
+
+// Export 按型号导出设备，modelName为空时导出全部
+func (r *Registry) Export(modelName string) ([]model.Device, error) {
+	rows, err := sql.GetModels[model.Device](r.db, func(q *gorm.DB) *gorm.DB {
+		if modelName != "" {
+			q = q.Where("model = ?", modelName)
+		}
+		return q.Order("id")
+	})
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	arr := make([]model.Device, 0, len(rows))
+	for _, row := range rows {
+		arr = append(arr, *row)
+	}
+
+	return arr, nil
+}
+
+// setError 不是errors.Error的错误按内部错误记录，Code不为0
+func (res *ImportRowResult) setError(err error) {
+	e := errors.Parse(err.Error())
+	if e.Code == 0 {
+		e = errors.InternalNew(err.Error(), err.Error())
+	}
+	res.Code = e.Code
+	res.Error = e.Detail
+}
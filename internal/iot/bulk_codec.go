@@ -0,0 +1,261 @@
+package iot
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"tmios/lib/iot/device"
+	"tmios/pkg/model"
+)
+
+// CSV的固定列，配置的每个字段一列，列名为config.<字段>；也可以用config列填写完整的JSON
+const (
+	colID        = "id"
+	colName      = "name"
+	colModel     = "model"
+	colForeignID = "foreign_id"
+	colDebug     = "debug"
+	colVirtuals  = "virtuals"
+	colConfig    = "config"
+	configPrefix = "config."
+)
+
+// ImportRow 解析后的一行，Err为解析错误，导入时作为该行的错误返回
+type ImportRow struct {
+	Device model.Device
+	Err    error
+}
+
+// BulkDevice JSON格式导入导出的一项，导入时忽略id和foreign_id
+type BulkDevice struct {
+	ID        uint            `json:"id,omitempty"`
+	Name      string          `json:"name"`
+	Model     string          `json:"model"`
+	ForeignID string          `json:"foreign_id,omitempty"`
+	Config    json.RawMessage `json:"config"`
+	Virtuals  json.RawMessage `json:"virtuals,omitempty"`
+	Debug     bool            `json:"debug"`
+}
+
+func DecodeJSON(r io.Reader) ([]ImportRow, error) {
+	var arr []BulkDevice
+	if err := json.NewDecoder(r).Decode(&arr); err != nil {
+		return nil, err
+	}
+
+	rows := make([]ImportRow, 0, len(arr))
+	for _, d := range arr {
+		row := model.Device{Name: d.Name, ModelName: d.Model, Config: string(d.Config), Debug: d.Debug}
+		if len(d.Virtuals) > 0 && string(d.Virtuals) != "null" {
+			row.Virtuals = string(d.Virtuals)
+		}
+		rows = append(rows, ImportRow{Device: row})
+	}
+
+	return rows, nil
+}
+
+func EncodeJSON(w io.Writer, devices []model.Device) error {
+	arr := make([]BulkDevice, 0, len(devices))
+	for _, row := range devices {
+		d := BulkDevice{
+			ID:        row.ID,
+			Name:      row.Name,
+			Model:     row.ModelName,
+			ForeignID: string(row.ForeignID),
+			Config:    json.RawMessage(row.Config),
+			Debug:     row.Debug,
+		}
+		if row.Config == "" {
+			d.Config = json.RawMessage("{}")
+		}
+		if row.Virtuals != "" {
+			d.Virtuals = json.RawMessage(row.Virtuals)
+		}
+		arr = append(arr, d)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(arr)
+}
+
+// DecodeCSV 第一行为表头，config.<字段>按型号的配置类型转换，字符串类型原样使用，其他类型按JSON解析
+func DecodeCSV(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		// Excel导出的UTF-8 BOM
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	index := make(map[string]int)
+	for i, col := range header {
+		index[strings.TrimSpace(col)] = i
+	}
+	if _, ok := index[colName]; !ok {
+		return nil, fmt.Errorf("csv: column %q is required", colName)
+	}
+	if _, ok := index[colModel]; !ok {
+		return nil, fmt.Errorf("csv: column %q is required", colModel)
+	}
+
+	var rows []ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		cell := func(col string) string {
+			if i, ok := index[col]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := model.Device{Name: cell(colName), ModelName: cell(colModel), Virtuals: cell(colVirtuals)}
+		row.Config, err = csvConfig(row.ModelName, header, record, cell(colConfig))
+		if err == nil && cell(colDebug) != "" {
+			row.Debug, err = strconv.ParseBool(cell(colDebug))
+		}
+		rows = append(rows, ImportRow{Device: row, Err: err})
+	}
+
+	return rows, nil
+}
+
+func csvConfig(modelName string, header, record []string, base string) (string, error) {
+	config := make(map[string]json.RawMessage)
+	if base != "" {
+		if err := json.Unmarshal([]byte(base), &config); err != nil {
+			return "", fmt.Errorf("config: %w", err)
+		}
+	}
+
+	meta := device.GetMeta(modelName)
+	for i, col := range header {
+		name := strings.TrimPrefix(strings.TrimSpace(col), configPrefix)
+		if name == strings.TrimSpace(col) || i >= len(record) || strings.TrimSpace(record[i]) == "" {
+			continue
+		}
+
+		var prop *device.PropMeta
+		if meta != nil {
+			for _, p := range meta.Config.Props {
+				if p.Name == name {
+					prop = p
+				}
+			}
+			if prop == nil {
+				return "", fmt.Errorf("%s: not a config field of %s", col, modelName)
+			}
+		}
+
+		value := strings.TrimSpace(record[i])
+		switch {
+		case prop != nil && prop.Type == "string":
+			config[name], _ = json.Marshal(value)
+		case json.Valid([]byte(value)):
+			config[name] = json.RawMessage(value)
+		case prop == nil:
+			// 型号不存在时按字符串处理，导入时会报型号错误
+			config[name], _ = json.Marshal(value)
+		default:
+			return "", fmt.Errorf("%s: invalid %s %q", col, prop.Type, value)
+		}
+	}
+
+	data, err := json.Marshal(config)
+	return string(data), err
+}
+
+// EncodeCSV 配置字段按型号声明的顺序展开为config.<字段>列
+func EncodeCSV(w io.Writer, devices []model.Device) error {
+	var (
+		fields  []string
+		known   = make(map[string]bool)
+		configs = make([]map[string]json.RawMessage, len(devices))
+	)
+	addField := func(name string) {
+		if !known[name] {
+			known[name] = true
+			fields = append(fields, name)
+		}
+	}
+
+	for i, row := range devices {
+		if row.Config != "" {
+			if err := json.Unmarshal([]byte(row.Config), &configs[i]); err != nil {
+				return fmt.Errorf("device %d: %w", row.ID, err)
+			}
+		}
+
+		if meta := device.GetMeta(row.ModelName); meta != nil {
+			for _, p := range meta.Config.Props {
+				addField(p.Name)
+			}
+		}
+		keys := make([]string, 0, len(configs[i]))
+		for k := range configs[i] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			addField(k)
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	header := []string{colID, colName, colModel, colForeignID, colDebug, colVirtuals}
+	for _, f := range fields {
+		header = append(header, configPrefix+f)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for i, row := range devices {
+		record := []string{
+			strconv.FormatUint(uint64(row.ID), 10),
+			row.Name,
+			row.ModelName,
+			string(row.ForeignID),
+			strconv.FormatBool(row.Debug),
+			row.Virtuals,
+		}
+		for _, f := range fields {
+			raw, ok := configs[i][f]
+			if !ok {
+				record = append(record, "")
+				continue
+			}
+			var s string
+			if json.Unmarshal(raw, &s) == nil {
+				record = append(record, s)
+				continue
+			}
+			record = append(record, string(bytes.TrimSpace(raw)))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,95 @@
+package iot
+
+import (
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"tmios/internal/utils"
+	"tmios/lib/iot/device"
+	"tmios/lib/sql"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+// foreignKey 同一型号下外部ID唯一
+type foreignKey struct {
+	model string
+	id    string
+}
+
+// foreignID 型号没有外部ID时为空；插件未运行等原因无法生成时返回ErrForeignIDUnavailable
+func foreignID(meta *device.DeviceMeta, config string) (utils.NullString, error) {
+	id, err := meta.ForeignID([]byte(config))
+	if err != nil {
+		return "", errm.ErrForeignIDUnavailable.SetDetail("%s: %s", meta.Model, err.Error())
+	}
+
+	return utils.NullString(id), nil
+}
+
+// indexForeign 需要持有r.mutex，外部ID已被其他设备占用时保留原来的设备
+func (r *Registry) indexForeign(inst *Instance) {
+	if inst.row.ForeignID == "" {
+		return
+	}
+
+	key := foreignKey{inst.row.ModelName, string(inst.row.ForeignID)}
+	if id, ok := r.foreign[key]; ok && id != inst.ID() {
+		logrus.WithField("device", inst.ID()).WithField("foreign_id", key.id).
+			Warnf("foreign id is used by device %d", id)
+		return
+	}
+	r.foreign[key] = inst.ID()
+}
+
+// unindexForeign 需要持有r.mutex
+func (r *Registry) unindexForeign(inst *Instance) {
+	key := foreignKey{inst.row.ModelName, string(inst.row.ForeignID)}
+	if id, ok := r.foreign[key]; ok && id == inst.ID() {
+		delete(r.foreign, key)
+	}
+}
+
+// ByForeignID 按型号和外部ID查找设备
+func (r *Registry) ByForeignID(model, foreignID string) (*Instance, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if foreignID != "" {
+		if id, ok := r.foreign[foreignKey{model, foreignID}]; ok {
+			return r.instances[id], nil
+		}
+	}
+
+	return nil, errm.ErrNotFound.SetDetail("device %s/%s", model, foreignID)
+}
+
+// conflict row为校验后的数据，返回外部ID相同的其他设备。查询数据库，
+// 型号或插件没有注册、没有加载到registry的设备同样检查
+func (r *Registry) conflict(row *model.Device) (*model.Device, error) {
+	if row.ForeignID == "" {
+		return nil, nil
+	}
+
+	other, err := sql.GetModel[model.Device](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Where("model = ? AND foreign_id = ? AND id <> ?", row.ModelName, row.ForeignID, row.ID)
+	})
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return other, nil
+}
+
+func conflictErr(row *model.Device, other *model.Device) error {
+	return errm.ErrDeviceForeignID.SetDetail("%s %s is used by device %d", row.ModelName, row.ForeignID, other.ID)
+}
+
+// Conflict 校验并规范化row，返回外部ID相同的其他设备，没有冲突时为nil；
+// 导入配置前可用来检查是否与已有设备重复，row.ID非0时表示更新该设备
+func (r *Registry) Conflict(row *model.Device) (*model.Device, error) {
+	if err := r.checkRow(row); err != nil {
+		return nil, err
+	}
+
+	return r.conflict(row)
+}
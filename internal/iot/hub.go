@@ -0,0 +1,134 @@
+package iot
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tmios/lib/iot/device"
+	"tmios/pkg/model"
+)
+
+const (
+	EventProps  = "props"
+	EventAlarm  = "alarm"
+	EventDevice = "device"
+)
+
+// recentAlarms Hub保留的最近告警数，新订阅者用作快照
+const recentAlarms = 100
+
+// Event 设备提交、告警以及设备增删改时发布
+type Event struct {
+	Kind     string                 `json:"kind"`
+	DeviceID uint                   `json:"device_id"`
+	Model    string                 `json:"model"`
+	Vals     map[string]interface{} `json:"vals,omitempty"`
+	Alarm    *device.Alarm          `json:"alarm,omitempty"`
+	Device   *model.Device          `json:"device,omitempty"` // 删除时为nil
+	Time     time.Time              `json:"time"`
+}
+
+// Subscription 订阅者，C满时丢弃事件并计数，不阻塞设备提交；SubscribeBlocking的订阅者不丢弃
+type Subscription struct {
+	C       chan Event
+	hub     *Hub
+	filter  func(Event) bool
+	block   bool
+	dropped uint64
+}
+
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// Hub 设备事件的发布订阅
+type Hub struct {
+	mutex sync.RWMutex
+	subs  map[*Subscription]struct{}
+
+	amutex sync.Mutex
+	alarms []Event
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe filter为nil时接收全部事件
+func (h *Hub) Subscribe(size int, filter func(Event) bool) *Subscription {
+	return h.subscribe(size, filter, false)
+}
+
+// SubscribeBlocking C满时Publish等待，用于不能丢事件的订阅者，例如同步的outbox；
+// 订阅者必须持续读取C直到Close，否则会阻塞设备提交
+func (h *Hub) SubscribeBlocking(size int, filter func(Event) bool) *Subscription {
+	return h.subscribe(size, filter, true)
+}
+
+func (h *Hub) subscribe(size int, filter func(Event) bool, block bool) *Subscription {
+	sub := &Subscription{
+		C:      make(chan Event, size),
+		hub:    h,
+		filter: filter,
+		block:  block,
+	}
+
+	h.mutex.Lock()
+	h.subs[sub] = struct{}{}
+	h.mutex.Unlock()
+
+	return sub
+}
+
+func (h *Hub) unsubscribe(sub *Subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.C)
+	}
+}
+
+// Alarms 最近的告警，按时间顺序
+func (h *Hub) Alarms() []Event {
+	h.amutex.Lock()
+	defer h.amutex.Unlock()
+
+	return append([]Event(nil), h.alarms...)
+}
+
+func (h *Hub) Publish(e Event) {
+	if e.Kind == EventAlarm {
+		h.amutex.Lock()
+		if len(h.alarms) >= recentAlarms {
+			h.alarms = append(h.alarms[:0], h.alarms[1:]...)
+		}
+		h.alarms = append(h.alarms, e)
+		h.amutex.Unlock()
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for sub := range h.subs {
+		if sub.filter != nil && !sub.filter(e) {
+			continue
+		}
+
+		if sub.block {
+			sub.C <- e
+			continue
+		}
+		select {
+		case sub.C <- e:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
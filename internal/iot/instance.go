@@ -0,0 +1,284 @@
+package iot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"tmios/lib/iot/device"
+	"tmios/pkg/model"
+)
+
+// Instance device.Device的实现，属性在内存中，Commit时写入Storage并发布事件
+type Instance struct {
+	row     model.Device
+	meta    *device.DeviceMeta
+	storage device.Storage
+	hub     *Hub
+
+	virtuals *virtuals
+	gate     *device.Gate
+
+	mutex    sync.RWMutex
+	vals     map[string]interface{}
+	dirty    map[string]*device.SetValOptions
+	updateAt int64
+}
+
+func newInstance(row model.Device, meta *device.DeviceMeta, storage device.Storage, hub *Hub) *Instance {
+	return &Instance{
+		row:      row,
+		meta:     meta,
+		storage:  storage,
+		hub:      hub,
+		virtuals: newVirtuals(meta),
+		gate:     device.NewGate(meta),
+		vals:     make(map[string]interface{}),
+		dirty:    make(map[string]*device.SetValOptions),
+	}
+}
+
+func (i *Instance) snapshotKey() string {
+	return fmt.Sprintf("tmios:device:%d", i.row.ID)
+}
+
+// restore 从Storage恢复上次提交的属性
+func (i *Instance) restore(ctx context.Context) error {
+	data, err := i.storage.Get(ctx, i.snapshotKey())
+	if err == device.ErrNil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var raws map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &raws); err != nil {
+		return err
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for name, raw := range raws {
+		prop := i.meta.GetProp(name)
+		if prop == nil {
+			continue
+		}
+		val, err := device.PropVal(raw).Cast(prop)
+		if err != nil {
+			continue
+		}
+		i.vals[name] = val
+	}
+
+	return nil
+}
+
+func (i *Instance) ID() uint {
+	return i.row.ID
+}
+
+func (i *Instance) Row() model.Device {
+	return i.row
+}
+
+func (i *Instance) UpdateAt() int64 {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	return i.updateAt
+}
+
+func (i *Instance) Meta() *device.DeviceMeta {
+	return i.meta
+}
+
+// Gate 设备的并发和频率控制，device.Action和周期任务共用
+func (i *Instance) Gate() *device.Gate {
+	return i.gate
+}
+
+func (i *Instance) Action(ctx context.Context, name string, args []byte) ([]byte, error) {
+	return device.Action(ctx, i, name, args)
+}
+
+func (i *Instance) GetConfig(config interface{}) error {
+	return device.GetConfig([]byte(i.row.Config), config)
+}
+
+func (i *Instance) GetStorage() device.Storage {
+	return i.storage
+}
+
+func (i *Instance) Tags() map[string]string {
+	return map[string]string{
+		"device_id": strconv.FormatUint(uint64(i.row.ID), 10),
+		"model":     i.row.ModelName,
+		"name":      i.row.Name,
+	}
+}
+
+func (i *Instance) DebugMode() bool {
+	return i.row.Debug
+}
+
+func (i *Instance) POM() device.POM {
+	return i
+}
+
+func (i *Instance) GetVal(name string) (interface{}, error) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	val, ok := i.vals[name]
+	if !ok {
+		return nil, device.ErrNil
+	}
+
+	return val, nil
+}
+
+// Vals 当前属性的拷贝
+func (i *Instance) Vals() map[string]interface{} {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	vals := make(map[string]interface{}, len(i.vals))
+	for name, val := range i.vals {
+		vals[name] = val
+	}
+
+	return vals
+}
+
+// copyVals 配置更新重建实例时沿用旧实例的属性值
+func (i *Instance) copyVals(old *Instance) {
+	vals := old.Vals()
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for name, val := range vals {
+		if prop := i.meta.GetProp(name); prop != nil && prop.Check(val) == nil {
+			i.vals[name] = val
+		}
+	}
+}
+
+func (i *Instance) GetPropVals(in interface{}) error {
+	data, err := json.Marshal(i.Vals())
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, in)
+}
+
+func (i *Instance) SetVal(name string, val interface{}, opts ...device.SetValOption) error {
+	prop := i.meta.GetProp(name)
+	if prop == nil {
+		return fmt.Errorf("property %s not found", name)
+	}
+	if prop.IsVirtual() {
+		return fmt.Errorf("property %s is virtual", name)
+	}
+	if err := prop.Check(val); err != nil {
+		return fmt.Errorf("property %s: %w", name, err)
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.vals[name] = val
+	i.dirty[name] = device.NewSetValOptions(opts...)
+
+	return nil
+}
+
+func (i *Instance) SetVals(vals map[string]interface{}, opts ...device.SetValOption) error {
+	for name, val := range vals {
+		if err := i.SetVal(name, val, opts...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *Instance) Commit(opts ...device.CommitOption) error {
+	attr := device.CommitAttr{UpdateAt: time.Now().Unix()}
+	for _, o := range opts {
+		o(&attr)
+	}
+
+	var (
+		ctx        = context.Background()
+		changed    = make(map[string]interface{})
+		fields     = make(map[string]interface{})
+		writeRedis = attr.KeepAlive
+		snapshot   []byte
+		err        error
+	)
+
+	i.mutex.Lock()
+	i.virtuals.compute(i.vals, i.dirty)
+	for name, o := range i.dirty {
+		changed[name] = i.vals[name]
+		if o.WriteInflux {
+			fields[name] = i.vals[name]
+		}
+		if o.WriteRedis {
+			writeRedis = true
+		}
+	}
+	i.dirty = make(map[string]*device.SetValOptions)
+	i.updateAt = attr.UpdateAt
+	if writeRedis {
+		snapshot, err = json.Marshal(i.vals)
+	}
+	i.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if writeRedis {
+		if err := i.storage.Set(ctx, i.snapshotKey(), string(snapshot), 0); err != nil {
+			return err
+		}
+	}
+
+	if len(fields) > 0 {
+		if err := i.storage.WritePoint(ctx, i.row.ModelName, i.Tags(), fields, time.Unix(attr.UpdateAt, 0)); err != nil {
+			return err
+		}
+	}
+
+	if len(changed) > 0 {
+		i.hub.Publish(Event{
+			Kind:     EventProps,
+			DeviceID: i.row.ID,
+			Model:    i.row.ModelName,
+			Vals:     changed,
+			Time:     time.Unix(attr.UpdateAt, 0),
+		})
+	}
+
+	return nil
+}
+
+func (i *Instance) Alarm(alarm device.Alarm) error {
+	i.hub.Publish(Event{
+		Kind:     EventAlarm,
+		DeviceID: i.row.ID,
+		Model:    i.row.ModelName,
+		Alarm:    &alarm,
+		Time:     time.Now(),
+	})
+
+	return nil
+}
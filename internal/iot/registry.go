@@ -0,0 +1,437 @@
+package iot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"tmios/internal/cmp"
+	"tmios/internal/config"
+	"tmios/lib/iot/device"
+	"tmios/lib/iot/storage"
+	"tmios/lib/sql"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+// Registry 设备实例的注册中心，负责持久化、实例化和周期任务调度
+type Registry struct {
+	cnf     *config.Config
+	db      *gorm.DB
+	storage device.Storage
+	hub     *Hub
+
+	mutex     sync.RWMutex
+	instances map[uint]*Instance
+	foreign   map[foreignKey]uint
+	scheduler *scheduler
+
+	// wmutex 串行化Create、Update、Delete，外部ID检查和加载之间不会插入其他写入
+	wmutex sync.Mutex
+}
+
+// maxStuckReport 健康检查中最多列出的卡住的周期任务
+const maxStuckReport = 5
+
+type Option func(r *Registry)
+
+var (
+	reg     *Registry
+	regOnce sync.Once
+)
+
+// WithStorage 默认使用内存存储
+func WithStorage(s device.Storage) Option {
+	return func(r *Registry) {
+		r.storage = s
+	}
+}
+
+// NewRegistry 与config.NewConfig一样是单例，只有第一次调用的opts生效
+func NewRegistry(opts ...Option) *Registry {
+	regOnce.Do(func() {
+		reg = &Registry{
+			cnf:       config.NewConfig(),
+			storage:   storage.NewMemStorage(),
+			hub:       NewHub(),
+			instances: make(map[uint]*Instance),
+			foreign:   make(map[foreignKey]uint),
+			scheduler: newScheduler(),
+		}
+		for _, opt := range opts {
+			opt(reg)
+		}
+	})
+	return reg
+}
+
+func (r *Registry) Name() string {
+	return "registry"
+}
+
+// DependsOn 插件注册型号、history包装存储之后才能加载设备
+func (r *Registry) DependsOn() []string {
+	return []string{"config", "storage", "plugin", "history"}
+}
+
+// migrate 同一型号的外部ID建唯一索引。之前的版本把空的外部ID存为空字符串，删除设备时也不清空，
+// 建索引前改为NULL；仍有重复时返回错误，需要手工处理
+func (r *Registry) migrate() error {
+	if r.db.Migrator().HasTable(&model.Device{}) {
+		err := r.db.Model(&model.Device{}).Unscoped().
+			Where("foreign_id = '' OR deleted_at IS NOT NULL").Update("foreign_id", nil).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := r.db.AutoMigrate(&model.Device{}); err != nil {
+		return fmt.Errorf("migrate devices, check duplicated (model, foreign_id): %w", err)
+	}
+	return nil
+}
+
+func (r *Registry) Start(ctx context.Context) error {
+	r.db = r.cnf.Db
+	if r.db == nil {
+		return errors.New("device registry requires database")
+	}
+
+	if err := r.migrate(); err != nil {
+		return err
+	}
+	r.scheduler.open()
+
+	for _, meta := range device.Metas() {
+		if meta.InitFunc == nil {
+			continue
+		}
+		if err := meta.InitFunc(); err != nil {
+			return err
+		}
+	}
+
+	rows, err := sql.GetModels[model.Device](r.db, func(q *gorm.DB) *gorm.DB {
+		return q
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if err := r.load(*row); err != nil {
+			logrus.WithField("device", row.ID).WithError(err).Error("load device failed")
+		}
+	}
+
+	return nil
+}
+
+// Check 个别设备的周期任务卡住时为降级
+func (r *Registry) Check(ctx context.Context) error {
+	stuck := r.scheduler.stuck()
+	if len(stuck) == 0 {
+		return nil
+	}
+
+	if len(stuck) > maxStuckReport {
+		stuck = append(stuck[:maxStuckReport], fmt.Sprintf("and %d more", len(stuck)-maxStuckReport))
+	}
+	return cmp.Degraded(fmt.Errorf("intervals stuck: %s", strings.Join(stuck, ", ")))
+}
+
+// Stop 停止周期任务，正在执行的一次会执行完
+func (r *Registry) Stop(ctx context.Context) error {
+	return r.scheduler.close(ctx)
+}
+
+func (r *Registry) Hub() *Hub {
+	return r.hub
+}
+
+// WrapStorage 装饰设备使用的存储，例如写入历史数据，需要在Start之前调用
+func (r *Registry) WrapStorage(wrap func(device.Storage) device.Storage) {
+	r.storage = wrap(r.storage)
+}
+
+func (r *Registry) Storage() device.Storage {
+	return r.storage
+}
+
+// instanceMeta 型号的DeviceMeta加上实例的虚拟属性
+func instanceMeta(row *model.Device) (*device.DeviceMeta, error) {
+	meta := device.GetMeta(row.ModelName)
+	if meta == nil {
+		return nil, errm.ErrDeviceModel.SetDetail("%s", row.ModelName)
+	}
+
+	if row.Virtuals == "" {
+		return meta, nil
+	}
+
+	var virtuals []device.Virtual
+	if err := json.Unmarshal([]byte(row.Virtuals), &virtuals); err != nil {
+		return nil, errm.ErrDeviceVirtual.SetDetail("%s", err.Error())
+	}
+
+	meta, err := meta.WithVirtuals(virtuals)
+	if err != nil {
+		return nil, errm.ErrDeviceVirtual.SetDetail("%s", err.Error())
+	}
+
+	return meta, nil
+}
+
+func (r *Registry) load(row model.Device) error {
+	meta, err := instanceMeta(&row)
+	if err != nil {
+		return err
+	}
+
+	// 型号的ForeignIDFunc可能变化，加载时重新生成；插件未运行等无法生成时保留原来的外部ID
+	if fid, err := foreignID(meta, row.Config); err == nil && fid != row.ForeignID {
+		row.ForeignID = fid
+		if err := r.db.Model(&model.Device{}).Where("id = ?", row.ID).Update("foreign_id", fid).Error; err != nil {
+			return errm.ErrDBCurd.SetDetail("%s", err.Error())
+		}
+	}
+
+	r.mutex.RLock()
+	old := r.instances[row.ID]
+	r.mutex.RUnlock()
+
+	inst := newInstance(row, meta, r.storage, r.hub)
+	if old != nil && old.row.ModelName == row.ModelName {
+		// 同一型号沿用原来的Gate，重新加载时正在执行的操作和新实例的操作不会交错
+		inst.gate = old.gate
+	}
+	if err := inst.restore(context.Background()); err != nil {
+		logrus.WithField("device", row.ID).WithError(err).Warn("restore device props failed")
+	}
+
+	r.mutex.Lock()
+	if old != nil {
+		r.unindexForeign(old)
+	}
+	r.instances[row.ID] = inst
+	r.indexForeign(inst)
+	r.mutex.Unlock()
+
+	if old != nil {
+		r.scheduler.stop(row.ID)
+		inst.copyVals(old)
+	}
+	r.scheduler.start(inst)
+
+	r.hub.Publish(Event{Kind: EventDevice, DeviceID: row.ID, Model: row.ModelName, Device: &row, Time: time.Now()})
+	return nil
+}
+
+func (r *Registry) unload(id uint) {
+	r.scheduler.stop(id)
+
+	r.mutex.Lock()
+	inst := r.instances[id]
+	if inst != nil {
+		r.unindexForeign(inst)
+	}
+	delete(r.instances, id)
+	r.mutex.Unlock()
+
+	if inst != nil {
+		r.hub.Publish(Event{Kind: EventDevice, DeviceID: id, Model: inst.row.ModelName, Time: time.Now()})
+	}
+}
+
+func (r *Registry) Get(id uint) (*Instance, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	inst, ok := r.instances[id]
+	if !ok {
+		return nil, errm.ErrNotFound.SetDetail("device %d", id)
+	}
+
+	return inst, nil
+}
+
+func (r *Registry) List() []*Instance {
+	r.mutex.RLock()
+	arr := make([]*Instance, 0, len(r.instances))
+	for _, inst := range r.instances {
+		arr = append(arr, inst)
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].ID() < arr[j].ID()
+	})
+
+	return arr
+}
+
+// checkRow 校验型号和配置，并把配置规范化、生成外部ID
+func (r *Registry) checkRow(row *model.Device) error {
+	meta, err := instanceMeta(row)
+	if err != nil {
+		return err
+	}
+
+	config, err := meta.CheckConfig([]byte(row.Config))
+	if err != nil {
+		return errm.ErrDeviceConfig.SetDetail("%s", err.Error())
+	}
+	row.Config = string(config)
+	// 无法生成时拒绝保存，不能用空的外部ID覆盖原来的并跳过重复检查
+	if row.ForeignID, err = foreignID(meta, row.Config); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Registry) Create(row *model.Device) error {
+	r.wmutex.Lock()
+	defer r.wmutex.Unlock()
+
+	if err := r.checkRow(row); err != nil {
+		return err
+	}
+	if other, err := r.conflict(row); err != nil {
+		return err
+	} else if other != nil {
+		return conflictErr(row, other)
+	}
+
+	if err := sql.CreateModel(r.db, row); err != nil {
+		return errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return r.load(*row)
+}
+
+func (r *Registry) Update(id uint, updateFunc func(row *model.Device) error) (*model.Device, error) {
+	r.wmutex.Lock()
+	defer r.wmutex.Unlock()
+
+	var updated model.Device
+
+	err := sql.UpdateModel[model.Device](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Where("id = ?", id)
+	}, func(row *model.Device) error {
+		if err := updateFunc(row); err != nil {
+			return err
+		}
+		row.ID = id
+		if err := r.checkRow(row); err != nil {
+			return err
+		}
+		if other, err := r.conflict(row); err != nil {
+			return err
+		} else if other != nil {
+			return conflictErr(row, other)
+		}
+		updated = *row
+		return nil
+	})
+	if err == gorm.ErrRecordNotFound {
+		return nil, errm.ErrNotFound.SetDetail("device %d", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, r.load(updated)
+}
+
+// Delete 软删除，同时清空外部ID，同一设备可以重新添加
+func (r *Registry) Delete(id uint) error {
+	r.wmutex.Lock()
+	defer r.wmutex.Unlock()
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Device{}).Where("id = ?", id).Update("foreign_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.Device{}, id).Error
+	})
+	if err != nil {
+		return errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	r.unload(id)
+	return nil
+}
+
+func (r *Registry) Action(ctx context.Context, id uint, name string, args []byte) ([]byte, error) {
+	inst, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rets, err := device.Action(ctx, inst, name, args)
+	if err == device.ErrInvalidAction {
+		return nil, errm.ErrDeviceAction.SetDetail("%s", name)
+	}
+
+	return rets, err
+}
+
+// SetProps 按属性类型转换后写入并提交
+func (r *Registry) SetProps(id uint, raws map[string]json.RawMessage) error {
+	inst, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+
+	vals := make(map[string]interface{}, len(raws))
+	for name, raw := range raws {
+		prop := inst.Meta().GetProp(name)
+		if prop == nil {
+			return errm.ErrDeviceProp.SetDetail("%s not found", name)
+		}
+		val, err := device.PropVal(raw).Cast(prop)
+		if err != nil {
+			return errm.ErrDeviceProp.SetDetail("%s: %s", name, err.Error())
+		}
+		vals[name] = val
+	}
+
+	if err := inst.SetVals(vals); err != nil {
+		return errm.ErrDeviceProp.SetDetail("%s", err.Error())
+	}
+
+	return inst.Commit()
+}
+
+// GetProps names为空时返回全部属性
+func (r *Registry) GetProps(id uint, names []string) (map[string]interface{}, error) {
+	inst, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	vals := inst.Vals()
+	if len(names) == 0 {
+		return vals, nil
+	}
+
+	ret := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		if inst.Meta().GetProp(name) == nil {
+			return nil, errm.ErrDeviceProp.SetDetail("%s not found", name)
+		}
+		if val, ok := vals[name]; ok {
+			ret[name] = val
+		}
+	}
+
+	return ret, nil
+}
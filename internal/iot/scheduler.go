@@ -0,0 +1,182 @@
+package iot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"tmios/lib/iot/device"
+)
+
+// scheduler 按DeviceMeta.Intervals周期执行，每个设备一组goroutine
+type scheduler struct {
+	mutex  sync.Mutex
+	stops  map[uint]chan struct{}
+	wg     sync.WaitGroup
+	closed bool
+
+	// ticks 正在执行的周期任务及开始时间
+	tmutex sync.Mutex
+	ticks  map[tickKey]tickState
+}
+
+type tickKey struct {
+	device   uint
+	interval string
+}
+
+type tickState struct {
+	start time.Time
+	limit time.Duration
+}
+
+// minStuck 周期任务执行超过3个周期且不少于minStuck时认为卡住
+const minStuck = time.Minute
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		stops: make(map[uint]chan struct{}),
+		ticks: make(map[tickKey]tickState),
+	}
+}
+
+func runInterval(inst *Instance, interval device.Interval) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.WithField("device", inst.ID()).WithField("interval", interval.Name).
+				Error(fmt.Sprintf("interval panic: %v", r))
+		}
+	}()
+
+	// 周期任务和action共用Gate，等不到时跳过本次，避免周期任务堆积
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(interval.Interval)*time.Second)
+	defer cancel()
+	ctx, release, err := inst.Gate().Acquire(ctx, "")
+	if err != nil {
+		logrus.WithField("device", inst.ID()).WithField("interval", interval.Name).
+			Debug("interval skipped, device busy")
+		return
+	}
+	defer release()
+
+	if err := interval.Run(ctx, inst); err != nil {
+		logrus.WithField("device", inst.ID()).WithField("interval", interval.Name).
+			WithError(err).Warn("interval failed")
+	}
+}
+
+func (s *scheduler) start(inst *Instance) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.stops[inst.ID()]; ok || s.closed {
+		return
+	}
+
+	stop := make(chan struct{})
+	s.stops[inst.ID()] = stop
+
+	for _, interval := range inst.Meta().Intervals {
+		if !interval.HasFunc() || interval.Interval <= 0 {
+			continue
+		}
+
+		s.wg.Add(1)
+		go func(interval device.Interval) {
+			defer s.wg.Done()
+
+			ticker := time.NewTicker(time.Duration(interval.Interval) * time.Second)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					s.tick(inst, interval)
+				}
+			}
+		}(interval)
+	}
+}
+
+func (s *scheduler) tick(inst *Instance, interval device.Interval) {
+	key := tickKey{device: inst.ID(), interval: interval.Name}
+	state := tickState{start: time.Now(), limit: 3 * time.Duration(interval.Interval) * time.Second}
+	if state.limit < minStuck {
+		state.limit = minStuck
+	}
+
+	s.tmutex.Lock()
+	s.ticks[key] = state
+	s.tmutex.Unlock()
+
+	defer func() {
+		s.tmutex.Lock()
+		delete(s.ticks, key)
+		s.tmutex.Unlock()
+	}()
+
+	runInterval(inst, interval)
+}
+
+// stuck 执行时间过长的周期任务，例如设备通讯没有超时
+func (s *scheduler) stuck() []string {
+	s.tmutex.Lock()
+	defer s.tmutex.Unlock()
+
+	var arr []string
+	for key, state := range s.ticks {
+		if cost := time.Since(state.start); cost > state.limit {
+			arr = append(arr, fmt.Sprintf("device %d %s %s", key.device, key.interval, cost.Truncate(time.Second)))
+		}
+	}
+	sort.Strings(arr)
+
+	return arr
+}
+
+// stop 停止设备的周期任务，正在执行的一次会继续执行完
+func (s *scheduler) stop(id uint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stop, ok := s.stops[id]; ok {
+		close(stop)
+		delete(s.stops, id)
+	}
+}
+
+// open 重新启动后允许调度
+func (s *scheduler) open() {
+	s.mutex.Lock()
+	s.closed = false
+	s.mutex.Unlock()
+}
+
+// close 停止全部周期任务，等待正在执行的一次完成或ctx结束
+func (s *scheduler) close(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closed = true
+	for id, stop := range s.stops {
+		close(stop)
+		delete(s.stops, id)
+	}
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,112 @@
+package iot
+
+import (
+	"tmios/lib/iot/device"
+	"tmios/lib/iot/expr"
+	"tmios/lib/iot/history"
+)
+
+type virtual struct {
+	name string
+	expr *expr.Expr
+}
+
+// virtuals 实例的虚拟属性及窗口聚合需要的历史值，历史值只在内存中，重启后重新累积
+type virtuals struct {
+	list    []virtual
+	windows map[string]*expr.Window
+}
+
+func newVirtuals(meta *device.DeviceMeta) *virtuals {
+	v := &virtuals{windows: make(map[string]*expr.Window)}
+
+	for _, prop := range meta.Properties.Props {
+		if !prop.IsVirtual() {
+			continue
+		}
+		// 注册型号和加载实例时已校验过
+		e, err := expr.Parse(prop.Virtual)
+		if err != nil {
+			continue
+		}
+		v.list = append(v.list, virtual{name: prop.Name, expr: e})
+
+		for name, size := range e.Windows() {
+			if w, ok := v.windows[name]; !ok || w.Size() < size {
+				v.windows[name] = expr.NewWindow(size)
+			}
+		}
+	}
+
+	return v
+}
+
+type env struct {
+	vals    map[string]interface{}
+	windows map[string]*expr.Window
+}
+
+func (e env) Var(name string) (float64, bool) {
+	val, ok := e.vals[name]
+	if !ok {
+		return 0, false
+	}
+	return history.ToFloat(val)
+}
+
+func (e env) Window(name string, n int) []float64 {
+	w, ok := e.windows[name]
+	if !ok {
+		return nil
+	}
+	return w.Last(n)
+}
+
+func (v *virtuals) push(name string, val interface{}) {
+	w, ok := v.windows[name]
+	if !ok {
+		return
+	}
+	if f, ok := history.ToFloat(val); ok {
+		w.Push(f)
+	}
+}
+
+// compute 在Commit时调用，dirty为本次提交变化的属性。依赖的属性变化时按声明顺序重新计算，
+// 计算结果写入vals和dirty，写入选项取依赖属性的合集。缺少依赖值或计算失败时保留上次的值
+func (v *virtuals) compute(vals map[string]interface{}, dirty map[string]*device.SetValOptions) {
+	if len(v.list) == 0 {
+		return
+	}
+
+	for name := range dirty {
+		v.push(name, vals[name])
+	}
+
+	e := env{vals: vals, windows: v.windows}
+	for _, vt := range v.list {
+		var opts *device.SetValOptions
+		for _, name := range vt.expr.Vars() {
+			o, ok := dirty[name]
+			if !ok {
+				continue
+			}
+			if opts == nil {
+				opts = &device.SetValOptions{}
+			}
+			opts.WriteRedis = opts.WriteRedis || o.WriteRedis
+			opts.WriteInflux = opts.WriteInflux || o.WriteInflux
+		}
+		if opts == nil {
+			continue
+		}
+
+		val, err := vt.expr.Eval(e)
+		if err != nil {
+			continue
+		}
+		vals[vt.name] = val
+		dirty[vt.name] = opts
+		v.push(vt.name, val)
+	}
+}
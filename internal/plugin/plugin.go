@@ -0,0 +1,434 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"tmios/internal/cmp"
+	"tmios/internal/config"
+	"tmios/lib/iot/device"
+	"tmios/lib/iot/plugin"
+	"tmios/lib/rpc"
+	"tmios/lib/ssl"
+	errm "tmios/pkg/model/errors"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	minBackoff       = time.Second
+	maxBackoff       = time.Minute
+)
+
+// Status 插件进程状态
+type Status struct {
+	Name      string   `json:"name"`
+	Running   bool     `json:"running"`
+	Pid       int      `json:"pid"`
+	Restarts  int      `json:"restarts"`
+	LastError string   `json:"last_error"`
+	Models    []string `json:"models"`
+}
+
+type process struct {
+	conf config.Plugin
+
+	ready     chan struct{}
+	readyOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+
+	mutex   sync.RWMutex
+	proc    *os.Process
+	client  *plugin.DriverClient
+	status  Status
+	models  map[string]bool
+	schemas map[string]map[string]interface{}
+}
+
+// Manager 启动并守护插件进程，插件崩溃只影响其注册的型号
+type Manager struct {
+	cnf *config.Config
+
+	mutex     sync.RWMutex
+	processes []*process
+}
+
+var (
+	m     *Manager
+	mOnce sync.Once
+)
+
+func NewManager() *Manager {
+	mOnce.Do(func() {
+		m = &Manager{
+			cnf: config.NewConfig(),
+		}
+	})
+	return m
+}
+
+func (m *Manager) Name() string {
+	return "plugin"
+}
+
+func (m *Manager) DependsOn() []string {
+	return []string{"config"}
+}
+
+// Policy 插件进程自行重启，插件不可用只影响其注册的型号
+func (m *Manager) Policy() cmp.Policy {
+	return cmp.Policy{Optional: true}
+}
+
+// Start 等待每个插件第一次启动完成(成功或失败)，保证设备实例加载前型号已注册
+func (m *Manager) Start(ctx context.Context) error {
+	var processes []*process
+	for _, conf := range m.cnf.Conf().Plugins {
+		p := &process{
+			conf:    conf,
+			ready:   make(chan struct{}),
+			stop:    make(chan struct{}),
+			done:    make(chan struct{}),
+			status:  Status{Name: conf.Name},
+			models:  make(map[string]bool),
+			schemas: make(map[string]map[string]interface{}),
+		}
+		processes = append(processes, p)
+		go p.supervise()
+	}
+	m.mutex.Lock()
+	m.processes = processes
+	m.mutex.Unlock()
+
+	for _, p := range processes {
+		select {
+		case <-p.ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Stop 关闭插件的stdin使其退出，ctx结束时仍未退出的插件被kill
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mutex.Lock()
+	processes := m.processes
+	m.processes = nil
+	m.mutex.Unlock()
+
+	for _, p := range processes {
+		close(p.stop)
+	}
+
+	for _, p := range processes {
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			p.mutex.RLock()
+			if p.proc != nil {
+				_ = p.proc.Kill()
+			}
+			p.mutex.RUnlock()
+			<-p.done
+		}
+	}
+
+	return nil
+}
+
+// Check 插件崩溃只影响其注册的型号，为降级
+func (m *Manager) Check(ctx context.Context) error {
+	var down []string
+	for _, s := range m.Status() {
+		if !s.Running {
+			down = append(down, s.Name)
+		}
+	}
+
+	if len(down) > 0 {
+		return cmp.Degraded(fmt.Errorf("plugins not running: %s", strings.Join(down, ", ")))
+	}
+	return nil
+}
+
+// Status 各插件进程的状态，按配置顺序
+func (m *Manager) Status() []Status {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	arr := make([]Status, 0, len(m.processes))
+	for _, p := range m.processes {
+		p.mutex.RLock()
+		s := p.status
+		s.Models = append([]string(nil), p.status.Models...)
+		p.mutex.RUnlock()
+		arr = append(arr, s)
+	}
+
+	return arr
+}
+
+// Schema 插件上报的型号JSON Schema
+func (m *Manager) Schema(model string) map[string]interface{} {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, p := range m.processes {
+		p.mutex.RLock()
+		schema, ok := p.schemas[model]
+		p.mutex.RUnlock()
+		if ok {
+			return schema
+		}
+	}
+
+	return nil
+}
+
+func (p *process) Client() (*plugin.DriverClient, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.client == nil {
+		return nil, errm.ErrPluginUnavailable.SetDetail("%s", p.conf.Name)
+	}
+
+	return p.client, nil
+}
+
+func (p *process) setReady() {
+	p.readyOnce.Do(func() {
+		close(p.ready)
+	})
+}
+
+func (p *process) log() *logrus.Entry {
+	return logrus.WithField("plugin", p.conf.Name)
+}
+
+// supervise 插件退出后按指数退避重启，稳定运行超过maxBackoff后重置退避
+func (p *process) supervise() {
+	defer close(p.done)
+
+	backoff := minBackoff
+	for {
+		start := time.Now()
+		err := p.runOnce()
+		p.setReady()
+
+		p.mutex.Lock()
+		p.client = nil
+		p.status.Running = false
+		p.status.Pid = 0
+		if err != nil {
+			p.status.LastError = err.Error()
+		}
+		p.mutex.Unlock()
+
+		select {
+		case <-p.stop:
+			p.log().Info("plugin stopped")
+			return
+		default:
+		}
+		p.log().WithError(err).Warn("plugin exited")
+
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-p.stop:
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		p.mutex.Lock()
+		p.status.Restarts++
+		p.mutex.Unlock()
+	}
+}
+
+func readHandshake(r *bufio.Reader) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		line, err := r.ReadString('\n')
+		ch <- result{line, err}
+	}()
+
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return "", res.err
+		}
+		parts := strings.Split(strings.TrimSpace(res.line), "|")
+		if len(parts) != 3 || parts[0] != plugin.HandshakePrefix {
+			return "", fmt.Errorf("invalid handshake: %q", res.line)
+		}
+		if version, _ := strconv.Atoi(parts[1]); version != plugin.ProtocolVersion {
+			return "", fmt.Errorf("unsupported protocol version %s", parts[1])
+		}
+		return parts[2], nil
+	case <-time.After(handshakeTimeout):
+		return "", fmt.Errorf("handshake timeout")
+	}
+}
+
+func (p *process) runOnce() error {
+	cmd := exec.Command(p.conf.Path, p.conf.Args...)
+	cmd.Env = append(os.Environ(), plugin.EnvWatchStdin+"=1")
+	cmd.Stderr = p.log().WriterLevel(logrus.InfoLevel)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	defer stdin.Close()
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	p.proc = cmd.Process
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		p.proc = nil
+		p.mutex.Unlock()
+	}()
+
+	// 插件在stdin关闭后退出
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-p.stop:
+			_ = stdin.Close()
+		case <-exited:
+		}
+	}()
+
+	// cmd.Wait会关闭stdout，要在读完stdout之后调用
+	var copying sync.WaitGroup
+	conn, err := p.connect(bufio.NewReader(stdout), cmd.Process.Pid, &copying)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		copying.Wait()
+		_ = cmd.Wait()
+		return err
+	}
+	defer conn.Close()
+
+	copying.Wait()
+	return cmd.Wait()
+}
+
+// connect 握手后把stdout的剩余输出转到日志，直到插件退出，copying在转发结束时Done
+func (p *process) connect(stdout *bufio.Reader, pid int, copying *sync.WaitGroup) (*grpc.ClientConn, error) {
+	addr, err := readHandshake(stdout)
+	if err != nil {
+		return nil, err
+	}
+	copying.Add(1)
+	go func() {
+		defer copying.Done()
+		_, _ = io.Copy(p.log().WriterLevel(logrus.InfoLevel), stdout)
+	}()
+
+	conn, err := grpc.Dial(addr, ssl.Client(), rpc.CallJSON())
+	if err != nil {
+		return nil, err
+	}
+
+	client := plugin.NewDriverClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+
+	desc, err := client.Describe(ctx)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if err := p.register(desc); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	p.client = client
+	p.status.Running = true
+	p.status.Pid = pid
+	p.mutex.Unlock()
+
+	p.setReady()
+
+	p.log().WithField("addr", addr).Info("plugin started")
+	return conn, nil
+}
+
+// register 首次启动时注册型号，重启后沿用已注册的DeviceMeta，其转发函数会取新连接
+func (p *process) register(desc *plugin.DescribeResp) error {
+	for _, metaDesc := range desc.Metas {
+		var head struct {
+			Model string `json:"model"`
+		}
+		if err := json.Unmarshal(metaDesc.Meta, &head); err != nil {
+			return err
+		}
+
+		p.mutex.Lock()
+		p.schemas[head.Model] = metaDesc.Schema
+		registered := p.models[head.Model]
+		p.mutex.Unlock()
+
+		if registered {
+			continue
+		}
+
+		if device.GetMeta(head.Model) != nil {
+			p.log().WithField("model", head.Model).Error("device model already registered, ignored")
+			continue
+		}
+
+		meta, err := plugin.RemoteMeta(metaDesc, p.Client)
+		if err != nil {
+			return err
+		}
+		device.Register(meta)
+
+		p.mutex.Lock()
+		p.models[head.Model] = true
+		p.status.Models = append(p.status.Models, head.Model)
+		p.mutex.Unlock()
+	}
+
+	return nil
+}
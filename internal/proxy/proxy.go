@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"tmios/internal/cmp"
+	"tmios/internal/config"
+	"tmios/internal/utils"
+)
+
+// Proxy TCP转发，按配置文件的[[Proxies]]监听并转发到Target
+type Proxy struct {
+	cnf *config.Config
+
+	mutex  sync.Mutex
+	proxy  *utils.Proxy
+	failed chan error
+}
+
+var (
+	p     *Proxy
+	pOnce sync.Once
+)
+
+func NewProxy() *Proxy {
+	pOnce.Do(func() {
+		p = &Proxy{
+			cnf: config.NewConfig(),
+		}
+	})
+	return p
+}
+
+func (p *Proxy) Name() string {
+	return "proxy"
+}
+
+func (p *Proxy) DependsOn() []string {
+	return []string{"config"}
+}
+
+// Policy 监听出错时重新监听
+func (p *Proxy) Policy() cmp.Policy {
+	return cmp.Policy{Restart: cmp.RestartOnFailure}
+}
+
+func (p *Proxy) Failed() <-chan error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.failed
+}
+
+func (p *Proxy) Start(ctx context.Context) error {
+	routes := p.cnf.Conf().Proxies
+	if len(routes) == 0 {
+		logrus.Info("proxy: no [[Proxies]] configured, skipped")
+		return nil
+	}
+
+	proxy := &utils.Proxy{}
+	for _, r := range routes {
+		if r.ListenAddr == "" || r.Target == "" {
+			return fmt.Errorf("proxy: ListenAddr and Target are required, got %q -> %q", r.ListenAddr, r.Target)
+		}
+		proxy.AddRoute(r.ListenAddr, utils.To(r.Target))
+	}
+	if err := proxy.Start(); err != nil {
+		return err
+	}
+
+	failed := make(chan error, 1)
+	p.mutex.Lock()
+	p.proxy, p.failed = proxy, failed
+	p.mutex.Unlock()
+
+	go func() {
+		err := proxy.Wait()
+		p.mutex.Lock()
+		stopped := p.proxy != proxy
+		p.mutex.Unlock()
+		// Stop关闭监听时Wait同样返回错误
+		if !stopped {
+			failed <- err
+		}
+	}()
+
+	for _, r := range routes {
+		logrus.WithField("addr", r.ListenAddr).WithField("target", r.Target).Info("proxy started")
+	}
+	return nil
+}
+
+// Stop 关闭监听，已建立的连接在任意一端关闭后结束
+func (p *Proxy) Stop(ctx context.Context) error {
+	p.mutex.Lock()
+	proxy := p.proxy
+	p.proxy = nil
+	p.mutex.Unlock()
+	if proxy == nil {
+		return nil
+	}
+	return proxy.Close()
+}
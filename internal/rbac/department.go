@@ -0,0 +1,170 @@
+package rbac
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"tmios/lib/sql"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+func (r *RBAC) Departments() ([]*model.Department, error) {
+	depts, err := sql.GetModels[model.Department](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Order("path")
+	})
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return depts, nil
+}
+
+func (r *RBAC) Department(id uint) (*model.Department, error) {
+	dept, err := sql.GetModel[model.Department](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Where("id = ?", id)
+	})
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if dept == nil {
+		return nil, errm.ErrNotFound.SetDetail("department %d", id)
+	}
+
+	return dept, nil
+}
+
+// Subtree 部门及所有下级部门的ID
+func (r *RBAC) Subtree(id uint) ([]uint, error) {
+	dept, err := r.Department(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	err = r.db.Model(&model.Department{}).Where("path LIKE ?", dept.Path+"%").Pluck("id", &ids).Error
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return ids, nil
+}
+
+func (r *RBAC) parentPath(tx *gorm.DB, parentID uint) (string, error) {
+	if parentID == 0 {
+		return "/", nil
+	}
+
+	parent, err := sql.GetModel[model.Department](tx, func(q *gorm.DB) *gorm.DB {
+		return q.Where("id = ?", parentID)
+	})
+	if err != nil {
+		return "", errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if parent == nil {
+		return "", errm.ErrNotFound.SetDetail("department %d", parentID)
+	}
+
+	return parent.Path, nil
+}
+
+func (r *RBAC) CreateDepartment(name string, parentID uint) (*model.Department, error) {
+	dept := &model.Department{Name: name, ParentID: parentID}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		path, err := r.parentPath(tx, parentID)
+		if err != nil {
+			return err
+		}
+
+		// Path包含自身ID，创建后才能确定
+		if err := sql.CreateModel(tx, dept); err != nil {
+			return errm.ErrDBCurd.SetDetail("%s", err.Error())
+		}
+		dept.Path = fmt.Sprintf("%s%d/", path, dept.ID)
+		if err := tx.Model(dept).Update("path", dept.Path).Error; err != nil {
+			return errm.ErrDBCurd.SetDetail("%s", err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dept, nil
+}
+
+// UpdateDepartment 修改上级部门时同时更新所有下级部门的Path，不能移动到自身或下级部门之下
+func (r *RBAC) UpdateDepartment(id uint, name string, parentID uint) (*model.Department, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		dept, err := sql.GetModel[model.Department](tx, func(q *gorm.DB) *gorm.DB {
+			return q.Where("id = ?", id)
+		})
+		if err != nil {
+			return errm.ErrDBCurd.SetDetail("%s", err.Error())
+		}
+		if dept == nil {
+			return errm.ErrNotFound.SetDetail("department %d", id)
+		}
+
+		parentPath, err := r.parentPath(tx, parentID)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(parentPath, dept.Path) {
+			return errm.ErrParam.SetDetail("department %d cannot move under itself", id)
+		}
+
+		oldPath, newPath := dept.Path, fmt.Sprintf("%s%d/", parentPath, id)
+		if err := tx.Model(dept).Updates(map[string]interface{}{"name": name, "parent_id": parentID}).Error; err != nil {
+			return errm.ErrDBCurd.SetDetail("%s", err.Error())
+		}
+		if oldPath == newPath {
+			return nil
+		}
+
+		return sql.UpdateModelsInTx[model.Department](tx, func(q *gorm.DB) *gorm.DB {
+			return q.Where("path LIKE ?", oldPath+"%")
+		}, func(depts []*model.Department) error {
+			for _, d := range depts {
+				d.Path = newPath + strings.TrimPrefix(d.Path, oldPath)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Department(id)
+}
+
+// DeleteDepartment 有下级部门、用户或设备的部门不能删除
+func (r *RBAC) DeleteDepartment(id uint) error {
+	if _, err := r.Department(id); err != nil {
+		return err
+	}
+
+	checks := []error{
+		sql.ExistCheck[model.Department](r.db, func(q *gorm.DB) *gorm.DB {
+			return q.Where("parent_id = ?", id)
+		}, errm.ErrDeleteDepartment.SetDetail("has sub departments")),
+		sql.ExistCheck[model.User](r.db, func(q *gorm.DB) *gorm.DB {
+			return q.Where("department_id = ?", id)
+		}, errm.ErrDeleteDepartment.SetDetail("has users")),
+		sql.ExistCheck[model.Device](r.db, func(q *gorm.DB) *gorm.DB {
+			return q.Where("department_id = ?", id)
+		}, errm.ErrDeleteDepartment.SetDetail("has devices")),
+	}
+	for _, err := range checks {
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := r.db.Unscoped().Delete(&model.Department{}, id).Error; err != nil {
+		return errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return nil
+}
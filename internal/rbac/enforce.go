@@ -0,0 +1,174 @@
+package rbac
+
+import (
+	"crypto/subtle"
+
+	"tmios/internal/config"
+	"tmios/internal/user"
+	"tmios/internal/utils"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+// scopeKey 校验通过后的数据范围在ReqContext.Data中的键
+const scopeKey = "rbac_scope"
+
+// Scope 设备的数据范围，All为false时只能访问Departments中的设备
+type Scope struct {
+	All         bool
+	Department  uint
+	Departments map[uint]bool
+}
+
+var scopeAll = &Scope{All: true}
+
+func (s *Scope) Contains(departmentID uint) bool {
+	return s.All || s.Departments[departmentID]
+}
+
+// Device 设备是否在数据范围内
+func (s *Scope) Device(row model.Device) bool {
+	return s.Contains(row.DepartmentID)
+}
+
+// ScopeFrom 没有经过Require的接口不限制数据范围
+func ScopeFrom(ctx *utils.ReqContext) *Scope {
+	if s, ok := ctx.Data[scopeKey].(*Scope); ok {
+		return s
+	}
+	return scopeAll
+}
+
+// Require 声明接口需要的权限，如utils.Handler(f, rbac.Require(model.ResourceDevice, model.VerbWrite))。
+// 需要登录；只通过应用Token或签名访问、没有登录用户的请求使用[[Apps]]中配置的Role和Department
+func Require(resource, verb string) utils.HandlerOption {
+	return func(attr *utils.HandlerAttr) {
+		utils.WithPreHooks(func(ctx *utils.ReqContext) error {
+			return NewRBAC().check(ctx, resource, verb)
+		})(attr)
+		utils.WithErrors(errm.ErrNoAuth, errm.ErrNoPermission)(attr)
+	}
+}
+
+func (r *RBAC) check(ctx *utils.ReqContext, resource, verb string) error {
+	usr := user.FromContext(ctx)
+	if usr == nil {
+		appID, ok := ctx.Data[utils.AppIDKey].(string)
+		if !ok {
+			return errm.ErrNoAuth
+		}
+		return r.checkApp(ctx, appID, resource, verb)
+	}
+
+	if usr.RoleID == 0 {
+		return errm.ErrNoPermission.SetDetail("%s:%s", resource, verb)
+	}
+	role, err := r.Role(usr.RoleID)
+	if err != nil {
+		return err
+	}
+	if err := hasRight(role, resource, verb); err != nil {
+		return err
+	}
+
+	scope, err := r.scope(role, usr.DepartmentID)
+	if err != nil {
+		return err
+	}
+	ctx.Data[scopeKey] = scope
+
+	return nil
+}
+
+// checkApp 应用按配置的角色鉴权，没有配置角色的应用只能访问不需要权限的接口
+func (r *RBAC) checkApp(ctx *utils.ReqContext, appID, resource, verb string) error {
+	scope, err := r.appScope(appID, resource, verb)
+	if err != nil {
+		return err
+	}
+	ctx.Data[scopeKey] = scope
+
+	return nil
+}
+
+// TokenScope gRPC等不经过HTTP鉴权的调用按[[Apps]]的Token和角色鉴权，返回数据范围；
+// 没有配置[[Apps]]时与HTTP一样不校验Token
+func (r *RBAC) TokenScope(token, resource, verb string) (*Scope, error) {
+	apps := r.cnf.Conf().Apps
+	if len(apps) == 0 {
+		return scopeAll, nil
+	}
+	if token == "" {
+		return nil, errm.ErrNoTokenFound
+	}
+
+	var found *config.App
+	for i := range apps {
+		if apps[i].Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(apps[i].Token)) == 1 {
+			found = &apps[i]
+		}
+	}
+	if found == nil {
+		return nil, errm.ErrInvalidAppToken
+	}
+
+	return r.appScope(found.AppID, resource, verb)
+}
+
+func (r *RBAC) appScope(appID, resource, verb string) (*Scope, error) {
+	var app *config.App
+	apps := r.cnf.Conf().Apps
+	for i := range apps {
+		if apps[i].AppID == appID {
+			app = &apps[i]
+			break
+		}
+	}
+	if app == nil || app.Role == "" {
+		return nil, errm.ErrNoPermission.SetDetail("app %s has no role, %s:%s", appID, resource, verb)
+	}
+
+	role, err := r.RoleByName(app.Role)
+	if err != nil {
+		return nil, err
+	}
+	if err := hasRight(role, resource, verb); err != nil {
+		return nil, err
+	}
+
+	return r.scope(role, app.Department)
+}
+
+func hasRight(role *model.Role, resource, verb string) error {
+	if role.Builtin {
+		return nil
+	}
+	for _, right := range role.Rights {
+		if right.Resource == resource && right.Verb == verb {
+			return nil
+		}
+	}
+	return errm.ErrNoPermission.SetDetail("%s:%s", resource, verb)
+}
+
+// scope 没有部门的用户或应用在department范围下看不到任何设备
+func (r *RBAC) scope(role *model.Role, departmentID uint) (*Scope, error) {
+	if role.Builtin || role.Scope == model.ScopeAll {
+		return scopeAll, nil
+	}
+
+	s := &Scope{Department: departmentID, Departments: make(map[uint]bool)}
+	if departmentID == 0 {
+		return s, nil
+	}
+
+	ids, err := r.Subtree(departmentID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		s.Departments[id] = true
+	}
+
+	return s, nil
+}
@@ -0,0 +1,204 @@
+package rbac
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"tmios/internal/config"
+	"tmios/internal/utils"
+	"tmios/lib/errors"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+// newTestRBAC NewRBAC是单例，每个测试使用单独的数据库和[[Apps]]
+func newTestRBAC(t *testing.T, apps ...config.App) *RBAC {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "rbac.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.User{}); err != nil {
+		t.Fatal(err)
+	}
+	cnf := config.NewConfig()
+	cnf.Db = db
+	conf := config.Defaults()
+	conf.Apps = apps
+	cnf.SetConf(conf)
+
+	r := &RBAC{cnf: cnf}
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func newRole(t *testing.T, r *RBAC, name, scope string, verbs ...string) *model.Role {
+	t.Helper()
+
+	role, err := r.CreateRole(name, "", scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, verb := range verbs {
+		if role, err = r.AddRoleRight(role.ID, model.ResourceDevice, verb); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return role
+}
+
+// newTree 建立 1 -> 2 -> 3 和 1 -> 4
+func newTree(t *testing.T, r *RBAC) []uint {
+	t.Helper()
+
+	var ids []uint
+	for _, parent := range []int{-1, 0, 1, 0} {
+		var parentID uint
+		if parent >= 0 {
+			parentID = ids[parent]
+		}
+		dept, err := r.CreateDepartment("d", parentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, dept.ID)
+	}
+	return ids
+}
+
+func userCtx(usr *model.User) *utils.ReqContext {
+	return &utils.ReqContext{Data: map[string]interface{}{utils.UserKey: usr}}
+}
+
+func appCtx(appID string) *utils.ReqContext {
+	return &utils.ReqContext{Data: map[string]interface{}{utils.AppIDKey: appID}}
+}
+
+func expectCode(t *testing.T, name string, err error, want errors.Error) {
+	t.Helper()
+
+	e, ok := err.(errors.Error)
+	if !ok || e.Code != want.Code {
+		t.Errorf("%s: expect %d, got %v", name, want.Code, err)
+	}
+}
+
+func TestCheckAllScope(t *testing.T) {
+	r := newTestRBAC(t)
+	role := newRole(t, r, "ops", model.ScopeAll, model.VerbRead)
+
+	ctx := userCtx(&model.User{RoleID: role.ID, DepartmentID: 0})
+	if err := r.check(ctx, model.ResourceDevice, model.VerbRead); err != nil {
+		t.Fatal(err)
+	}
+	if s := ScopeFrom(ctx); !s.All || !s.Device(model.Device{DepartmentID: 42}) {
+		t.Errorf("expect all scope, got %+v", s)
+	}
+
+	// 内置admin角色拥有全部权限
+	admin, err := r.RoleByName(adminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = userCtx(&model.User{RoleID: admin.ID})
+	if err := r.check(ctx, model.ResourceUser, model.VerbWrite); err != nil || !ScopeFrom(ctx).All {
+		t.Errorf("admin: %v", err)
+	}
+}
+
+func TestCheckDepartmentScope(t *testing.T) {
+	r := newTestRBAC(t)
+	ids := newTree(t, r)
+	role := newRole(t, r, "dep", model.ScopeDepartment, model.VerbRead)
+
+	ctx := userCtx(&model.User{RoleID: role.ID, DepartmentID: ids[1]})
+	if err := r.check(ctx, model.ResourceDevice, model.VerbRead); err != nil {
+		t.Fatal(err)
+	}
+	s := ScopeFrom(ctx)
+	if s.All || s.Department != ids[1] {
+		t.Fatalf("unexpected scope %+v", s)
+	}
+	for i, want := range []bool{false, true, true, false} {
+		if got := s.Device(model.Device{DepartmentID: ids[i]}); got != want {
+			t.Errorf("department %d: expect %v", ids[i], want)
+		}
+	}
+	if s.Device(model.Device{}) {
+		t.Error("device without department is out of scope")
+	}
+
+	// 没有部门的用户看不到任何设备
+	ctx = userCtx(&model.User{RoleID: role.ID})
+	if err := r.check(ctx, model.ResourceDevice, model.VerbRead); err != nil {
+		t.Fatal(err)
+	}
+	if s := ScopeFrom(ctx); s.All || len(s.Departments) != 0 {
+		t.Errorf("expect empty scope, got %+v", s)
+	}
+}
+
+func TestCheckDenied(t *testing.T) {
+	r := newTestRBAC(t)
+	role := newRole(t, r, "viewer", model.ScopeAll, model.VerbRead)
+
+	expectCode(t, "no right", r.check(userCtx(&model.User{RoleID: role.ID}), model.ResourceDevice, model.VerbWrite), errm.ErrNoPermission)
+	expectCode(t, "other resource", r.check(userCtx(&model.User{RoleID: role.ID}), model.ResourceUser, model.VerbRead), errm.ErrNoPermission)
+	expectCode(t, "no role", r.check(userCtx(&model.User{}), model.ResourceDevice, model.VerbRead), errm.ErrNoPermission)
+	expectCode(t, "no login", r.check(&utils.ReqContext{Data: map[string]interface{}{}}, model.ResourceDevice, model.VerbRead), errm.ErrNoAuth)
+
+	// 没有经过Require的接口不限制数据范围
+	if !ScopeFrom(&utils.ReqContext{Data: map[string]interface{}{}}).All {
+		t.Error("expect all scope without Require")
+	}
+}
+
+func TestCheckApp(t *testing.T) {
+	r := newTestRBAC(t)
+	ids := newTree(t, r)
+	newRole(t, r, "dep", model.ScopeDepartment, model.VerbRead)
+	conf := r.cnf.Conf()
+	conf.Apps = []config.App{
+		{AppID: "app1", Token: "token1", Role: "dep", Department: ids[1]},
+		{AppID: "app2", Token: "token2"},
+		{AppID: "app3", Token: "token3", Role: "missing"},
+	}
+	r.cnf.SetConf(conf)
+
+	ctx := appCtx("app1")
+	if err := r.check(ctx, model.ResourceDevice, model.VerbRead); err != nil {
+		t.Fatal(err)
+	}
+	if s := ScopeFrom(ctx); s.All || !s.Contains(ids[2]) || s.Contains(ids[3]) {
+		t.Errorf("unexpected app scope %+v", s)
+	}
+	expectCode(t, "app write", r.check(appCtx("app1"), model.ResourceDevice, model.VerbWrite), errm.ErrNoPermission)
+	expectCode(t, "app without role", r.check(appCtx("app2"), model.ResourceDevice, model.VerbRead), errm.ErrNoPermission)
+	expectCode(t, "unknown app", r.check(appCtx("app9"), model.ResourceDevice, model.VerbRead), errm.ErrNoPermission)
+	expectCode(t, "unknown role", r.check(appCtx("app3"), model.ResourceDevice, model.VerbRead), errm.ErrInvalidRole)
+
+	// gRPC按Token找到应用
+	if s, err := r.TokenScope("token1", model.ResourceDevice, model.VerbRead); err != nil || !s.Contains(ids[1]) || s.Contains(ids[0]) {
+		t.Errorf("token scope %+v %v", s, err)
+	}
+	_, err := r.TokenScope("token1", model.ResourceDevice, model.VerbWrite)
+	expectCode(t, "token write", err, errm.ErrNoPermission)
+	_, err = r.TokenScope("token9", model.ResourceDevice, model.VerbRead)
+	expectCode(t, "wrong token", err, errm.ErrInvalidAppToken)
+	_, err = r.TokenScope("", model.ResourceDevice, model.VerbRead)
+	expectCode(t, "no token", err, errm.ErrNoTokenFound)
+
+	// 没有配置[[Apps]]时不校验Token
+	conf.Apps = nil
+	r.cnf.SetConf(conf)
+	if s, err := r.TokenScope("", model.ResourceDevice, model.VerbWrite); err != nil || !s.All {
+		t.Errorf("no apps: %+v %v", s, err)
+	}
+}
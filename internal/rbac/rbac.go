@@ -0,0 +1,130 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"gorm.io/gorm"
+	"tmios/internal/config"
+	"tmios/lib/sql"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+const adminRole = "admin"
+
+// RBAC 角色、权限和部门
+type RBAC struct {
+	cnf *config.Config
+	db  *gorm.DB
+}
+
+var (
+	r     *RBAC
+	rOnce sync.Once
+)
+
+func NewRBAC() *RBAC {
+	rOnce.Do(func() {
+		r = &RBAC{
+			cnf: config.NewConfig(),
+		}
+	})
+	return r
+}
+
+func (r *RBAC) Name() string {
+	return "rbac"
+}
+
+// DependsOn 内置角色分配给user创建的admin用户
+func (r *RBAC) DependsOn() []string {
+	return []string{"config", "storage", "user"}
+}
+
+// Start 建表，按model.Resources创建权限；第一次启动时创建内置的admin角色并分配给admin用户
+func (r *RBAC) Start(ctx context.Context) error {
+	r.db = r.cnf.Db
+	if r.db == nil {
+		return errors.New("rbac requires database")
+	}
+
+	if err := r.db.AutoMigrate(&model.Right{}, &model.Role{}, &model.Department{}); err != nil {
+		return err
+	}
+
+	for _, def := range model.Resources {
+		for _, verb := range []string{model.VerbRead, model.VerbWrite} {
+			right := model.Right{Resource: def.Resource, Verb: verb}
+			err := r.db.Where(right).
+				Assign(model.Right{Desc: def.Desc, Global: def.Global}).
+				FirstOrCreate(&right).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	role, err := sql.GetModel[model.Role](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Where("builtin = ?", true)
+	})
+	if err != nil || role != nil {
+		return err
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		role := &model.Role{Name: adminRole, Desc: "内置管理员", Scope: model.ScopeAll, Builtin: true}
+		if err := sql.CreateModel(tx, role); err != nil {
+			return err
+		}
+		return tx.Model(&model.User{}).
+			Where("username = ? AND role_id = 0", adminRole).
+			Update("role_id", role.ID).Error
+	})
+}
+
+func (r *RBAC) Stop(ctx context.Context) error {
+	return nil
+}
+
+func (r *RBAC) Rights() ([]*model.Right, error) {
+	rights, err := sql.GetModels[model.Right](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Order("id")
+	})
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return rights, nil
+}
+
+// AssignUser 设置用户的角色和部门，roleID、departmentID为0时清除
+func (r *RBAC) AssignUser(userID, roleID, departmentID uint) error {
+	if roleID != 0 {
+		if _, err := r.Role(roleID); err != nil {
+			return err
+		}
+	}
+	if departmentID != 0 {
+		if _, err := r.Department(departmentID); err != nil {
+			return err
+		}
+	}
+
+	err := sql.UpdateModel[model.User](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Where("id = ?", userID)
+	}, func(user *model.User) error {
+		user.RoleID = roleID
+		user.DepartmentID = departmentID
+		return nil
+	})
+	if err == gorm.ErrRecordNotFound {
+		return errm.ErrNotFound.SetDetail("user %d", userID)
+	}
+	if err != nil {
+		return errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return nil
+}
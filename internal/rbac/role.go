@@ -0,0 +1,195 @@
+package rbac
+
+import (
+	"gorm.io/gorm"
+	"tmios/lib/sql"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+func checkScope(scope string) error {
+	switch scope {
+	case model.ScopeAll, model.ScopeDepartment:
+		return nil
+	}
+	return errm.ErrRoleScope.SetDetail("%q", scope)
+}
+
+func (r *RBAC) Roles() ([]*model.Role, error) {
+	roles, err := sql.GetModels[model.Role](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Preload("Rights").Order("id")
+	})
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return roles, nil
+}
+
+func (r *RBAC) Role(id uint) (*model.Role, error) {
+	role, err := sql.GetModel[model.Role](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Preload("Rights").Where("id = ?", id)
+	})
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if role == nil {
+		return nil, errm.ErrInvalidRole.SetDetail("%d", id)
+	}
+
+	return role, nil
+}
+
+// RoleByName [[Apps]]按角色名配置角色
+func (r *RBAC) RoleByName(name string) (*model.Role, error) {
+	role, err := sql.GetModel[model.Role](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Preload("Rights").Where("name = ?", name)
+	})
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if role == nil {
+		return nil, errm.ErrInvalidRole.SetDetail("%s", name)
+	}
+
+	return role, nil
+}
+
+func (r *RBAC) CreateRole(name, desc, scope string) (*model.Role, error) {
+	if err := checkScope(scope); err != nil {
+		return nil, err
+	}
+
+	err := sql.ExistCheck[model.Role](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Unscoped().Where("name = ?", name)
+	}, errm.ErrDuplicateEntry.SetDetail("role %s", name))
+	if err != nil {
+		return nil, err
+	}
+
+	role := &model.Role{Name: name, Desc: desc, Scope: scope, Rights: make([]model.Right, 0)}
+	if err := sql.CreateModel(r.db, role); err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return role, nil
+}
+
+// UpdateRole 改为department范围时，已有的全局权限会被拒绝
+func (r *RBAC) UpdateRole(id uint, desc, scope string) (*model.Role, error) {
+	if err := checkScope(scope); err != nil {
+		return nil, err
+	}
+
+	role, err := r.Role(id)
+	if err != nil {
+		return nil, err
+	}
+	if role.Builtin {
+		return nil, errm.ErrInvalidRole.SetDetail("builtin role %s", role.Name)
+	}
+	if scope != model.ScopeAll {
+		for _, right := range role.Rights {
+			if right.Global {
+				return nil, errm.ErrRoleScope.SetDetail("role has global right %s:%s", right.Resource, right.Verb)
+			}
+		}
+	}
+
+	err = r.db.Model(role).Updates(map[string]interface{}{"desc": desc, "scope": scope}).Error
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return r.Role(id)
+}
+
+// DeleteRole 内置角色和仍有用户的角色不能删除
+func (r *RBAC) DeleteRole(id uint) error {
+	role, err := r.Role(id)
+	if err != nil {
+		return err
+	}
+	if role.Builtin {
+		return errm.ErrDeleteRole.SetDetail("builtin role %s", role.Name)
+	}
+
+	err = sql.ExistCheck[model.User](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Where("role_id = ?", id)
+	}, errm.ErrDeleteRole.SetDetail("role %s has users", role.Name))
+	if err != nil {
+		return err
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(role).Association("Rights").Clear(); err != nil {
+			return errm.ErrDBCurd.SetDetail("%s", err.Error())
+		}
+		if err := tx.Unscoped().Delete(role).Error; err != nil {
+			return errm.ErrDBCurd.SetDetail("%s", err.Error())
+		}
+		return nil
+	})
+}
+
+func (r *RBAC) right(resource, verb string) (*model.Right, error) {
+	right, err := sql.GetModel[model.Right](r.db, func(q *gorm.DB) *gorm.DB {
+		return q.Where("resource = ? AND verb = ?", resource, verb)
+	})
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if right == nil {
+		return nil, errm.ErrAddRoleRight.SetDetail("unknown right %s:%s", resource, verb)
+	}
+
+	return right, nil
+}
+
+// AddRoleRight department范围的角色不能授予全局资源的权限
+func (r *RBAC) AddRoleRight(roleID uint, resource, verb string) (*model.Role, error) {
+	role, err := r.Role(roleID)
+	if err != nil {
+		return nil, err
+	}
+	if role.Builtin {
+		return nil, errm.ErrAddRoleRight.SetDetail("builtin role %s", role.Name)
+	}
+
+	right, err := r.right(resource, verb)
+	if err != nil {
+		return nil, err
+	}
+	if right.Global && role.Scope != model.ScopeAll {
+		return nil, errm.ErrAddRoleRight.SetDetail("%s:%s requires scope %s", resource, verb, model.ScopeAll)
+	}
+
+	if err := r.db.Model(role).Association("Rights").Append(right); err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return r.Role(roleID)
+}
+
+func (r *RBAC) RemoveRoleRight(roleID uint, resource, verb string) (*model.Role, error) {
+	role, err := r.Role(roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	var right *model.Right
+	for i := range role.Rights {
+		if role.Rights[i].Resource == resource && role.Rights[i].Verb == verb {
+			right = &role.Rights[i]
+		}
+	}
+	if right == nil {
+		return nil, errm.ErrRightNotInRole.SetDetail("%s:%s", resource, verb)
+	}
+
+	if err := r.db.Model(role).Association("Rights").Delete(right); err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return r.Role(roleID)
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"tmios/internal/config"
+	"tmios/lib/sql"
+)
+
+// Storage MySQL连接，启动后写入config.Config.Db，使用数据库的组件依赖storage
+type Storage struct {
+	cnf *config.Config
+}
+
+var (
+	s     *Storage
+	sOnce sync.Once
+)
+
+func NewStorage() *Storage {
+	sOnce.Do(func() {
+		s = &Storage{
+			cnf: config.NewConfig(),
+		}
+	})
+	return s
+}
+
+func (s *Storage) Name() string {
+	return "storage"
+}
+
+func (s *Storage) DependsOn() []string {
+	return []string{"config"}
+}
+
+// Start 按配置文件的[MySQL]连接数据库
+func (s *Storage) Start(ctx context.Context) error {
+	conf := s.cnf.Conf().MySQL
+	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=True&loc=Local",
+		conf.Username, conf.Password, conf.Ip, conf.Port, conf.DatabaseName))
+	if err != nil {
+		return err
+	}
+	s.cnf.Db = db.DB
+	return nil
+}
+
+// Check ping数据库
+func (s *Storage) Check(ctx context.Context) error {
+	if s.cnf.Db == nil {
+		return nil
+	}
+
+	db, err := s.cnf.Db.DB()
+	if err != nil {
+		return err
+	}
+	return db.PingContext(ctx)
+}
+
+// Stop 关闭数据库连接，在使用数据库的组件之后停止
+func (s *Storage) Stop(ctx context.Context) error {
+	if s.cnf.Db == nil {
+		return nil
+	}
+
+	db, err := s.cnf.Db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
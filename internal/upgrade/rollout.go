@@ -0,0 +1,466 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"tmios/internal/rbac"
+	"tmios/lib/iot/device"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+const defaultParallel = 4
+
+const (
+	MethodAction = "action"
+	MethodFTP    = "ftp"
+)
+
+// RolloutReq DeviceIDs为空时升级该型号的全部设备；Stages为各阶段累计覆盖设备的百分比，
+// 最后一阶段必须为100，为空时一次升级全部设备
+type RolloutReq struct {
+	Name        string `json:"name" validate:"required,max=64"`
+	FirmwareID  uint   `json:"firmware_id" validate:"required"`
+	DeviceIDs   []uint `json:"device_ids"`
+	Stages      []int  `json:"stages"`
+	MaxFailures int    `json:"max_failures" validate:"min=0"`
+	Parallel    int    `json:"parallel" validate:"min=0"`
+	AutoAdvance bool   `json:"auto_advance"`
+}
+
+// RolloutDetail Summary为各状态的设备数
+type RolloutDetail struct {
+	model.Rollout
+	Firmware *model.Firmware       `json:"firmware"`
+	Devices  []model.RolloutDevice `json:"devices"`
+	Summary  map[string]int        `json:"summary"`
+}
+
+func checkStages(stages []int) ([]int, error) {
+	if len(stages) == 0 {
+		return []int{100}, nil
+	}
+
+	for i, pct := range stages {
+		if pct <= 0 || pct > 100 || (i > 0 && pct <= stages[i-1]) {
+			return nil, errm.ErrParam.SetDetail("stages must be increasing percentages in (0, 100]")
+		}
+	}
+	if stages[len(stages)-1] != 100 {
+		return nil, errm.ErrParam.SetDetail("last stage must be 100")
+	}
+
+	return stages, nil
+}
+
+// stageOf 第i个设备(从0开始)所在的阶段
+func stageOf(i, total int, stages []int) int {
+	for s, pct := range stages {
+		if i < (total*pct+99)/100 {
+			return s
+		}
+	}
+	return len(stages) - 1
+}
+
+// method 有upgrade action时优先使用action
+func method(meta *device.DeviceMeta) string {
+	if meta.GetAction(device.UpgradeAction) != nil {
+		return MethodAction
+	}
+	if meta.FTPTargetFunc != nil {
+		return MethodFTP
+	}
+	return ""
+}
+
+// CreateRollout scope为调用者的数据范围，只能升级范围内的设备，升级型号的全部设备需要全部数据范围
+func (u *Upgrader) CreateRollout(scope *rbac.Scope, req *RolloutReq) (*model.Rollout, error) {
+	if len(req.DeviceIDs) == 0 && !scope.All {
+		return nil, errm.ErrNoPermission.SetDetail("device_ids is required for department scope")
+	}
+
+	fw, err := u.Firmware(req.FirmwareID)
+	if err != nil {
+		return nil, err
+	}
+
+	stages, err := checkStages(req.Stages)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	if len(req.DeviceIDs) == 0 {
+		for _, inst := range u.reg.List() {
+			if inst.Row().ModelName == fw.ModelName {
+				ids = append(ids, inst.ID())
+			}
+		}
+	} else {
+		ids = append(ids, req.DeviceIDs...)
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	if len(ids) == 0 {
+		return nil, errm.ErrParam.SetDetail("no device of model %s", fw.ModelName)
+	}
+
+	var devices []model.RolloutDevice
+	for i, id := range ids {
+		if i > 0 && id == ids[i-1] {
+			return nil, errm.ErrParam.SetDetail("duplicate device %d", id)
+		}
+		inst, err := u.reg.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if !scope.Device(inst.Row()) {
+			return nil, errm.ErrNoPermission.SetDetail("device %d", id)
+		}
+		if inst.Row().ModelName != fw.ModelName {
+			return nil, errm.ErrParam.SetDetail("device %d is not model %s", id, fw.ModelName)
+		}
+		m := method(inst.Meta())
+		if m == "" {
+			return nil, errm.ErrUpgradeUnsupported.SetDetail("device %d", id)
+		}
+
+		devices = append(devices, model.RolloutDevice{
+			DeviceID: id,
+			Stage:    stageOf(i, len(ids), stages),
+			State:    model.UpgradePending,
+			Method:   m,
+		})
+	}
+
+	data, _ := json.Marshal(stages)
+	r := &model.Rollout{
+		Name:        req.Name,
+		FirmwareID:  fw.ID,
+		Stages:      string(data),
+		MaxFailures: req.MaxFailures,
+		Parallel:    req.Parallel,
+		AutoAdvance: req.AutoAdvance,
+		Status:      model.RolloutPending,
+	}
+
+	err = u.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(r).Error; err != nil {
+			return err
+		}
+		for i := range devices {
+			devices[i].RolloutID = r.ID
+		}
+		return tx.Create(&devices).Error
+	})
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return r, nil
+}
+
+func (u *Upgrader) rollout(id uint) (*model.Rollout, error) {
+	var r model.Rollout
+	err := u.db.First(&r, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errm.ErrNotFound.SetDetail("rollout %d", id)
+	}
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return &r, nil
+}
+
+func (u *Upgrader) Rollouts() ([]model.Rollout, error) {
+	arr := make([]model.Rollout, 0)
+	if err := u.db.Order("id DESC").Find(&arr).Error; err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return arr, nil
+}
+
+func (u *Upgrader) Rollout(id uint) (*RolloutDetail, error) {
+	r, err := u.rollout(id)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &RolloutDetail{Rollout: *r, Summary: make(map[string]int)}
+	if fw, err := u.Firmware(r.FirmwareID); err == nil {
+		detail.Firmware = fw
+	}
+	if err := u.db.Where("rollout_id = ?", id).Order("stage, device_id").Find(&detail.Devices).Error; err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	for _, d := range detail.Devices {
+		detail.Summary[d.State]++
+	}
+
+	return detail, nil
+}
+
+// transition 状态为from之一时改为to，返回是否修改成功
+func (u *Upgrader) transition(id uint, from []string, updates map[string]interface{}) (bool, error) {
+	res := u.db.Model(&model.Rollout{}).Where("id = ? AND status IN ?", id, from).Updates(updates)
+	if res.Error != nil {
+		return false, errm.ErrDBCurd.SetDetail("%s", res.Error.Error())
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+// StartRollout 开始升级；停止(halted)的任务重新开始时，当前阶段失败的设备会重试
+func (u *Upgrader) StartRollout(id uint) error {
+	r, err := u.rollout(id)
+	if err != nil {
+		return err
+	}
+
+	switch r.Status {
+	case model.RolloutPending:
+	case model.RolloutHalted:
+		err := u.db.Model(&model.RolloutDevice{}).
+			Where("rollout_id = ? AND stage <= ? AND state = ?", id, r.Stage, model.UpgradeFailed).
+			Updates(map[string]interface{}{"state": model.UpgradePending, "error": ""}).Error
+		if err != nil {
+			return errm.ErrDBCurd.SetDetail("%s", err.Error())
+		}
+	default:
+		return errm.ErrRolloutState.SetDetail("rollout %d is %s", id, r.Status)
+	}
+
+	ok, err := u.transition(id, []string{r.Status}, map[string]interface{}{"status": model.RolloutRunning, "message": ""})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errm.ErrRolloutState.SetDetail("rollout %d status changed", id)
+	}
+
+	u.start(id)
+	return nil
+}
+
+// Advance 当前阶段完成后进入下一阶段
+func (u *Upgrader) Advance(id uint) error {
+	r, err := u.rollout(id)
+	if err != nil {
+		return err
+	}
+	if r.Status != model.RolloutPaused {
+		return errm.ErrRolloutState.SetDetail("rollout %d is %s", id, r.Status)
+	}
+
+	ok, err := u.transition(id, []string{model.RolloutPaused},
+		map[string]interface{}{"status": model.RolloutRunning, "stage": r.Stage + 1, "message": ""})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errm.ErrRolloutState.SetDetail("rollout %d status changed", id)
+	}
+
+	u.start(id)
+	return nil
+}
+
+// Cancel 取消任务，正在传输的设备会被中断并标记为失败
+func (u *Upgrader) Cancel(id uint) error {
+	r, err := u.rollout(id)
+	if err != nil {
+		return err
+	}
+
+	ok, err := u.transition(id,
+		[]string{model.RolloutPending, model.RolloutRunning, model.RolloutPaused, model.RolloutHalted},
+		map[string]interface{}{"status": model.RolloutCanceled})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errm.ErrRolloutState.SetDetail("rollout %d is %s", id, r.Status)
+	}
+
+	u.mutex.Lock()
+	cancel := u.running[id]
+	u.mutex.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+
+	return nil
+}
+
+func (u *Upgrader) start(id uint) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if _, ok := u.running[id]; ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(u.ctx)
+	u.running[id] = cancel
+
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		defer func() {
+			u.mutex.Lock()
+			delete(u.running, id)
+			u.mutex.Unlock()
+			cancel()
+		}()
+
+		if err := u.run(ctx, id); err != nil {
+			if u.ctx.Err() != nil {
+				logrus.WithField("rollout", id).Info("upgrade: rollout interrupted by shutdown")
+				return
+			}
+			logrus.WithField("rollout", id).WithError(err).Error("upgrade: rollout failed")
+			_, _ = u.transition(id, []string{model.RolloutRunning},
+				map[string]interface{}{"status": model.RolloutHalted, "message": err.Error()})
+		}
+	}()
+}
+
+// run 逐阶段升级，阶段完成后自动进入下一阶段或暂停等待Advance
+func (u *Upgrader) run(ctx context.Context, id uint) error {
+	for {
+		r, err := u.rollout(id)
+		if err != nil {
+			return err
+		}
+		if r.Status != model.RolloutRunning {
+			return nil
+		}
+
+		fw, err := u.Firmware(r.FirmwareID)
+		if err != nil {
+			return err
+		}
+
+		var stages []int
+		if err := json.Unmarshal([]byte(r.Stages), &stages); err != nil {
+			return err
+		}
+
+		halted, err := u.runStage(ctx, r, fw)
+		if err != nil || halted || ctx.Err() != nil {
+			return err
+		}
+
+		if r.Stage >= len(stages)-1 {
+			_, err := u.transition(id, []string{model.RolloutRunning}, map[string]interface{}{"status": model.RolloutDone})
+			return err
+		}
+		if !r.AutoAdvance {
+			_, err := u.transition(id, []string{model.RolloutRunning}, map[string]interface{}{"status": model.RolloutPaused})
+			return err
+		}
+		if _, err := u.transition(id, []string{model.RolloutRunning}, map[string]interface{}{"stage": r.Stage + 1}); err != nil {
+			return err
+		}
+	}
+}
+
+// runStage 并发升级当前阶段待升级的设备，失败数超过阈值时停止派发并返回halted
+func (u *Upgrader) runStage(ctx context.Context, r *model.Rollout, fw *model.Firmware) (bool, error) {
+	var devices []model.RolloutDevice
+	err := u.db.Where("rollout_id = ? AND stage <= ? AND state = ?", r.ID, r.Stage, model.UpgradePending).
+		Order("stage, device_id").Find(&devices).Error
+	if err != nil {
+		return false, err
+	}
+
+	parallel := r.Parallel
+	if parallel <= 0 {
+		parallel = defaultParallel
+	}
+
+	var (
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, parallel)
+		halt   = make(chan struct{})
+		once   sync.Once
+		halted bool
+	)
+
+loop:
+	for i := range devices {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-halt:
+			break loop
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(rd *model.RolloutDevice) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := u.upgrade(ctx, fw, rd); err == nil {
+				return
+			}
+
+			failed, err := u.failures(r.ID)
+			if err != nil || failed <= int64(r.MaxFailures) {
+				return
+			}
+			once.Do(func() {
+				halted = true
+				close(halt)
+				_, _ = u.transition(r.ID, []string{model.RolloutRunning}, map[string]interface{}{
+					"status":  model.RolloutHalted,
+					"message": fmt.Sprintf("%d devices failed, exceeds max failures %d", failed, r.MaxFailures),
+				})
+			})
+		}(&devices[i])
+	}
+	wg.Wait()
+
+	return halted, nil
+}
+
+func (u *Upgrader) failures(id uint) (int64, error) {
+	var count int64
+	err := u.db.Model(&model.RolloutDevice{}).
+		Where("rollout_id = ? AND state = ?", id, model.UpgradeFailed).Count(&count).Error
+
+	return count, err
+}
+
+func (u *Upgrader) setState(rd *model.RolloutDevice, state string, err error) {
+	updates := map[string]interface{}{"state": state}
+	now := time.Now()
+	switch state {
+	case model.UpgradeTransferring:
+		updates["started_at"] = &now
+		updates["error"] = ""
+	case model.UpgradeDone, model.UpgradeFailed:
+		updates["finished_at"] = &now
+	}
+	if err != nil {
+		updates["error"] = err.Error()
+	}
+
+	if err := u.db.Model(rd).Updates(updates).Error; err != nil {
+		logrus.WithField("rollout", rd.RolloutID).WithField("device", rd.DeviceID).WithError(err).
+			Error("upgrade: update device state failed")
+	}
+	rd.State = state
+}
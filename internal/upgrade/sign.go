@@ -0,0 +1,76 @@
+package upgrade
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+// DownloadPath 设备下载固件的地址，不需要鉴权，由签名限制固件、升级设备和有效期
+const DownloadPath = "/api/v1/upgrade/firmwares/fetch"
+
+// DownloadReq UpgradeArgs.URL中的参数，Device为RolloutDevice的ID
+type DownloadReq struct {
+	ID      uint   `form:"id" validate:"required"`
+	Device  uint   `form:"device" validate:"required"`
+	Expires int64  `form:"expires" validate:"required"`
+	Sig     string `form:"sig" validate:"required,hexadecimal"`
+}
+
+// newSignKey 每个进程随机生成，链接只在传输超时内有效，重启后未完成的传输会标记为失败
+func newSignKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func (u *Upgrader) sign(fwID, rdID uint, expires int64) string {
+	mac := hmac.New(sha256.New, u.signKey)
+	_, _ = fmt.Fprintf(mac, "%d\n%d\n%d", fwID, rdID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// downloadURL 设备通过UpgradeArgs.URL下载固件，签名包含固件、升级设备和过期时间
+func (u *Upgrader) downloadURL(base string, fw *model.Firmware, rd *model.RolloutDevice) string {
+	expires := time.Now().Add(transferTimeout).Unix()
+	q := url.Values{}
+	q.Set("id", strconv.FormatUint(uint64(fw.ID), 10))
+	q.Set("device", strconv.FormatUint(uint64(rd.ID), 10))
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", u.sign(fw.ID, rd.ID, expires))
+	return strings.TrimRight(base, "/") + DownloadPath + "?" + q.Encode()
+}
+
+// Download 校验签名和有效期，且该设备仍在传输这个固件
+func (u *Upgrader) Download(req *DownloadReq) (*model.Firmware, error) {
+	sig, err := hex.DecodeString(req.Sig)
+	if err != nil || time.Now().Unix() > req.Expires {
+		return nil, errm.ErrDownloadToken
+	}
+	expect, _ := hex.DecodeString(u.sign(req.ID, req.Device, req.Expires))
+	if !hmac.Equal(sig, expect) {
+		return nil, errm.ErrDownloadToken
+	}
+
+	var rd model.RolloutDevice
+	if err := u.db.First(&rd, req.Device).Error; err != nil || rd.State != model.UpgradeTransferring {
+		return nil, errm.ErrDownloadToken
+	}
+	var ro model.Rollout
+	if err := u.db.First(&ro, rd.RolloutID).Error; err != nil || ro.FirmwareID != req.ID {
+		return nil, errm.ErrDownloadToken
+	}
+
+	return u.Firmware(req.ID)
+}
@@ -0,0 +1,89 @@
+package upgrade
+
+import (
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"tmios/pkg/model"
+)
+
+func newTestUpgrader(t *testing.T) *Upgrader {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "upgrade.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.Firmware{}, &model.Rollout{}, &model.RolloutDevice{}); err != nil {
+		t.Fatal(err)
+	}
+	return &Upgrader{db: db, signKey: newSignKey()}
+}
+
+func parseDownload(t *testing.T, raw string) *DownloadReq {
+	t.Helper()
+
+	if !strings.HasPrefix(raw, "http://edge:8888"+DownloadPath+"?") {
+		t.Fatalf("unexpected url %s", raw)
+	}
+	q, err := url.ParseQuery(raw[strings.Index(raw, "?")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	num := func(k string) int64 {
+		v, err := strconv.ParseInt(q.Get(k), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	return &DownloadReq{ID: uint(num("id")), Device: uint(num("device")), Expires: num("expires"), Sig: q.Get("sig")}
+}
+
+func TestDownload(t *testing.T) {
+	u := newTestUpgrader(t)
+	fw := model.Firmware{ModelName: "m", Version: "1.0", Filename: "fw.bin"}
+	other := model.Firmware{ModelName: "m", Version: "2.0", Filename: "fw2.bin"}
+	u.db.Create(&fw)
+	u.db.Create(&other)
+	ro := model.Rollout{FirmwareID: fw.ID, Status: model.RolloutRunning}
+	u.db.Create(&ro)
+	rd := model.RolloutDevice{RolloutID: ro.ID, DeviceID: 7, State: model.UpgradeTransferring}
+	u.db.Create(&rd)
+
+	req := parseDownload(t, u.downloadURL("http://edge:8888/", &fw, &rd))
+	if got, err := u.Download(req); err != nil || got.ID != fw.ID {
+		t.Fatalf("expect firmware %d, got %v %v", fw.ID, got, err)
+	}
+
+	cases := map[string]func(r *DownloadReq){
+		"other firmware": func(r *DownloadReq) { r.ID = other.ID },
+		"other device":   func(r *DownloadReq) { r.Device++ },
+		"extend expires": func(r *DownloadReq) { r.Expires += 3600 },
+		"tampered sig":   func(r *DownloadReq) { r.Sig = strings.Repeat("0", len(r.Sig)) },
+		"expired":        func(r *DownloadReq) { r.Expires = 1; r.Sig = u.sign(r.ID, r.Device, 1) },
+		"not hex":        func(r *DownloadReq) { r.Sig = "zz" },
+	}
+	for name, change := range cases {
+		r := *req
+		change(&r)
+		if _, err := u.Download(&r); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+
+	// 其他进程的签名无效
+	if _, err := newTestUpgrader(t).Download(req); err == nil {
+		t.Error("expect error with another key")
+	}
+
+	// 传输结束后链接失效
+	u.db.Model(&rd).Update("state", model.UpgradeVerifying)
+	if _, err := u.Download(req); err == nil {
+		t.Error("expect error after transfer")
+	}
+}
@@ -0,0 +1,158 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"tmios/lib/ftp"
+	"tmios/lib/iot/device"
+	"tmios/pkg/model"
+)
+
+const (
+	transferTimeout = 30 * time.Minute
+	ftpTimeout      = 30 * time.Second
+	verifyInterval  = 5 * time.Second
+)
+
+// upgrade 升级单个设备: 传输固件，再校验设备报告的版本
+func (u *Upgrader) upgrade(ctx context.Context, fw *model.Firmware, rd *model.RolloutDevice) error {
+	u.setState(rd, model.UpgradeTransferring, nil)
+
+	version, err := u.transfer(ctx, fw, rd)
+	if err != nil {
+		u.setState(rd, model.UpgradeFailed, err)
+		return err
+	}
+
+	u.setState(rd, model.UpgradeVerifying, nil)
+	if err := u.verify(ctx, fw, rd.DeviceID, version); err != nil {
+		u.setState(rd, model.UpgradeFailed, err)
+		return err
+	}
+
+	u.setState(rd, model.UpgradeDone, nil)
+	return nil
+}
+
+// transfer 返回upgrade action报告的版本，FTP推送时为空
+func (u *Upgrader) transfer(ctx context.Context, fw *model.Firmware, rd *model.RolloutDevice) (string, error) {
+	inst, err := u.reg.Get(rd.DeviceID)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, transferTimeout)
+	defer cancel()
+
+	switch rd.Method {
+	case MethodAction:
+		args := device.UpgradeArgs{
+			Version:  fw.Version,
+			Filename: fw.Filename,
+			Size:     fw.Size,
+			SHA256:   fw.SHA256,
+			Path:     fw.Path,
+		}
+		if base := u.cnf.Conf().Upgrade.BaseURL; base != "" {
+			args.URL = u.downloadURL(base, fw, rd)
+		}
+		data, err := json.Marshal(args)
+		if err != nil {
+			return "", err
+		}
+
+		raw, err := u.reg.Action(ctx, rd.DeviceID, device.UpgradeAction, data)
+		if err != nil {
+			return "", err
+		}
+
+		var rets device.UpgradeRets
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &rets); err != nil {
+				return "", fmt.Errorf("invalid upgrade rets: %w", err)
+			}
+		}
+		return rets.Version, nil
+
+	case MethodFTP:
+		if inst.Meta().FTPTargetFunc == nil {
+			return "", fmt.Errorf("model %s has no ftp target", inst.Row().ModelName)
+		}
+		target := inst.Meta().FTPTargetFunc([]byte(inst.Row().Config))
+		if target == nil {
+			return "", fmt.Errorf("device has no ftp target")
+		}
+
+		content, err := os.ReadFile(fw.Path)
+		if err != nil {
+			return "", err
+		}
+
+		// lib/ftp不支持ctx，取消时等待本次上传结束
+		errc := make(chan error, 1)
+		go func() {
+			errc <- ftp.PutFiles(&ftp.Server{
+				FtpUrl:   target.URL,
+				Username: target.Username,
+				Password: target.Password,
+				Timeout:  ftpTimeout,
+			}, []*ftp.File{{RelativePath: target.Dir, Filename: fw.Filename, Content: string(content)}})
+		}()
+		select {
+		case err := <-errc:
+			return "", err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	return "", fmt.Errorf("unknown upgrade method %q", rd.Method)
+}
+
+// verify upgrade action报告了版本时直接比较，否则等待设备的VersionProp变为新版本；
+// 型号没有VersionProp时无法校验，视为成功
+func (u *Upgrader) verify(ctx context.Context, fw *model.Firmware, id uint, reported string) error {
+	if reported != "" {
+		if reported != fw.Version {
+			return fmt.Errorf("device reports version %s, expect %s", reported, fw.Version)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, u.verifyTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(verifyInterval)
+	defer ticker.Stop()
+
+	var current string
+	for {
+		// 设备可能因重新加载被替换，每次重新获取
+		inst, err := u.reg.Get(id)
+		if err != nil {
+			return err
+		}
+		if inst.Meta().GetProp(device.VersionProp) == nil {
+			return nil
+		}
+		if val, err := inst.GetVal(device.VersionProp); err == nil && val != nil {
+			current = fmt.Sprint(val)
+			if current == fw.Version {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.Canceled {
+				return ctx.Err()
+			}
+			return fmt.Errorf("verify timeout, device version %q, expect %s", current, fw.Version)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package upgrade
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"tmios/internal/config"
+	"tmios/internal/iot"
+	"tmios/internal/utils"
+	"tmios/lib/iot/device"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+const (
+	defaultUploadPath    = "upload"
+	defaultVerifyTimeout = 10 * time.Minute
+)
+
+// Upgrader 固件管理和分阶段升级
+type Upgrader struct {
+	cnf *config.Config
+	reg *iot.Registry
+	db  *gorm.DB
+
+	verifyTimeout time.Duration
+	signKey       []byte
+
+	mutex   sync.Mutex
+	running map[uint]context.CancelFunc
+
+	// ctx 在Stop时取消，进行中的升级保持running状态，下次启动时继续
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var (
+	u     *Upgrader
+	uOnce sync.Once
+)
+
+func NewUpgrader() *Upgrader {
+	uOnce.Do(func() {
+		u = &Upgrader{
+			cnf:     config.NewConfig(),
+			reg:     iot.NewRegistry(),
+			running: make(map[uint]context.CancelFunc),
+			signKey: newSignKey(),
+		}
+		u.ctx, u.cancel = context.WithCancel(context.Background())
+	})
+	return u
+}
+
+func (u *Upgrader) Name() string {
+	return "upgrade"
+}
+
+func (u *Upgrader) DependsOn() []string {
+	return []string{"config", "storage", "registry"}
+}
+
+// Start 建表，重启前正在传输的设备标记为失败，继续执行进行中的升级任务
+func (u *Upgrader) Start(ctx context.Context) error {
+	u.db = u.cnf.Db
+	if u.db == nil {
+		return errors.New("upgrade requires database")
+	}
+	if u.ctx.Err() != nil {
+		u.ctx, u.cancel = context.WithCancel(context.Background())
+	}
+
+	timeout, err := utils.ParseDuration(u.cnf.Conf().Upgrade.VerifyTimeout)
+	if err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		timeout = defaultVerifyTimeout
+	}
+	u.verifyTimeout = timeout
+
+	if err := u.db.AutoMigrate(&model.Firmware{}, &model.Rollout{}, &model.RolloutDevice{}); err != nil {
+		return err
+	}
+
+	err = u.db.Model(&model.RolloutDevice{}).
+		Where("state IN ?", []string{model.UpgradeTransferring, model.UpgradeVerifying}).
+		Updates(map[string]interface{}{"state": model.UpgradeFailed, "error": "interrupted by restart"}).Error
+	if err != nil {
+		return err
+	}
+
+	var rollouts []model.Rollout
+	if err := u.db.Where("status = ?", model.RolloutRunning).Find(&rollouts).Error; err != nil {
+		return err
+	}
+	for _, r := range rollouts {
+		u.start(r.ID)
+	}
+
+	return nil
+}
+
+// Stop 中断进行中的升级任务
+func (u *Upgrader) Stop(ctx context.Context) error {
+	u.cancel()
+	u.wg.Wait()
+	return nil
+}
+
+func (u *Upgrader) uploadPath() string {
+	if path := u.cnf.Conf().Upgrade.UploadPath; path != "" {
+		return path
+	}
+	return defaultUploadPath
+}
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// safeName 型号和版本用作上传目录的路径，不能包含路径分隔符和..
+func safeName(s string) bool {
+	return namePattern.MatchString(s) && !strings.Contains(s, "..")
+}
+
+// Upload 保存固件并计算SHA256，checksum非空时必须与计算结果一致
+func (u *Upgrader) Upload(r io.Reader, filename, modelName, version, checksum, desc string) (*model.Firmware, error) {
+	meta := device.GetMeta(modelName)
+	if meta == nil {
+		return nil, errm.ErrDeviceModel.SetDetail("%s", modelName)
+	}
+	if !meta.CanUpgrade() {
+		return nil, errm.ErrUpgradeUnsupported.SetDetail("%s", modelName)
+	}
+
+	filename = filepath.Base(filepath.Clean("/" + filename))
+	if filename == "/" || filename == "." {
+		return nil, errm.ErrParam.SetDetail("invalid filename")
+	}
+	if !safeName(modelName) || !safeName(version) {
+		return nil, errm.ErrParam.SetDetail("model and version may only contain letters, digits, '.', '_' and '-'")
+	}
+
+	var count int64
+	if err := u.db.Model(&model.Firmware{}).Where("model = ? AND version = ?", modelName, version).Count(&count).Error; err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if count > 0 {
+		return nil, errm.ErrDuplicateEntry.SetDetail("firmware %s %s", modelName, version)
+	}
+
+	root := filepath.Join(u.uploadPath(), "firmware")
+	dir := filepath.Join(root, modelName, version)
+	if !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return nil, errm.ErrParam.SetDetail("invalid model or version")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != "" && !strings.EqualFold(checksum, sum) {
+		return nil, errm.ErrFirmwareChecksum.SetDetail("expect %s, got %s", checksum, sum)
+	}
+
+	// 先写入记录再把临时文件改名，同一型号和版本同时上传时唯一索引只让一个成功，失败的只删除自己的临时文件
+	path := filepath.Join(dir, filename)
+	fw := &model.Firmware{
+		ModelName: modelName,
+		Version:   version,
+		Filename:  filename,
+		Path:      path,
+		Size:      size,
+		SHA256:    sum,
+		Desc:      desc,
+	}
+	if err := u.db.Create(fw).Error; err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		u.db.Unscoped().Delete(fw)
+		return nil, err
+	}
+
+	return fw, nil
+}
+
+func (u *Upgrader) Firmwares(modelName string) ([]model.Firmware, error) {
+	q := u.db.Order("id DESC")
+	if modelName != "" {
+		q = q.Where("model = ?", modelName)
+	}
+
+	arr := make([]model.Firmware, 0)
+	if err := q.Find(&arr).Error; err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return arr, nil
+}
+
+func (u *Upgrader) Firmware(id uint) (*model.Firmware, error) {
+	var fw model.Firmware
+	err := u.db.First(&fw, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errm.ErrNotFound.SetDetail("firmware %d", id)
+	}
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return &fw, nil
+}
+
+// DeleteFirmware 有未结束的升级任务使用该固件时不能删除
+func (u *Upgrader) DeleteFirmware(id uint) error {
+	fw, err := u.Firmware(id)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	err = u.db.Model(&model.Rollout{}).
+		Where("firmware_id = ? AND status NOT IN ?", id, []string{model.RolloutDone, model.RolloutCanceled}).
+		Count(&count).Error
+	if err != nil {
+		return errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if count > 0 {
+		return errm.ErrRolloutState.SetDetail("firmware %d is used by unfinished rollout", id)
+	}
+
+	// 物理删除，之后可以重新上传同一版本
+	if err := u.db.Unscoped().Delete(fw).Error; err != nil {
+		return errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if err := os.Remove(fw.Path); err != nil && !os.IsNotExist(err) {
+		logrus.WithField("path", fw.Path).WithError(err).Warn("upgrade: remove firmware file failed")
+	}
+
+	return nil
+}
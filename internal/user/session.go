@@ -0,0 +1,95 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"tmios/lib/redis"
+)
+
+const sessionPrefix = "tmios:session:"
+
+// sessionStore 会话的键值存储，键不存在时返回redis.ErrNil
+type sessionStore interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, val string, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) error
+}
+
+// memStore 没有配置SessionRedis时使用，重启后会话失效
+type memStore struct {
+	c *cache.Cache
+}
+
+func newMemStore() *memStore {
+	return &memStore{c: cache.New(cache.NoExpiration, 10*time.Minute)}
+}
+
+func (m *memStore) Get(ctx context.Context, key string) (string, error) {
+	val, ok := m.c.Get(key)
+	if !ok {
+		return "", redis.ErrNil
+	}
+	return val.(string), nil
+}
+
+func (m *memStore) Set(ctx context.Context, key, val string, ttl time.Duration) error {
+	m.c.Set(key, val, ttl)
+	return nil
+}
+
+func (m *memStore) Del(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		m.c.Delete(key)
+	}
+	return nil
+}
+
+// session 存储的会话，Version与用户不一致时失效
+type session struct {
+	UserID    uint      `json:"user_id"`
+	Version   int       `json:"version"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (u *Users) saveSession(ctx context.Context, token string, s *session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return u.store.Set(ctx, sessionPrefix+token, string(data), time.Until(s.ExpiresAt))
+}
+
+// loadSession 剩余时间不到一半时延长，活跃的会话不会过期
+func (u *Users) loadSession(ctx context.Context, token string) (*session, error) {
+	data, err := u.store.Get(ctx, sessionPrefix+token)
+	if err != nil {
+		return nil, err
+	}
+
+	var s session
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return nil, err
+	}
+
+	if time.Until(s.ExpiresAt) < u.sessionTTL/2 {
+		s.ExpiresAt = time.Now().Add(u.sessionTTL)
+		if err := u.saveSession(ctx, token, &s); err != nil {
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
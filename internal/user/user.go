@@ -0,0 +1,531 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"tmios/internal/cmp"
+	"tmios/internal/config"
+	"tmios/internal/utils"
+	liberrors "tmios/lib/errors"
+	"tmios/lib/redis"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+const (
+	defaultSessionTTL   = 24 * time.Hour
+	defaultMaxFailures  = 5
+	defaultLockDuration = 15 * time.Minute
+
+	adminUsername = "admin"
+
+	defaultAdminPasswordFile = "admin.password"
+
+	// SessionCookie 登录后设置的Cookie，也可以使用Authorization: Bearer <token>
+	SessionCookie = "session"
+)
+
+// dummyHash 用户不存在时也做一次比较，避免按耗时区分用户是否存在
+var dummyHash, _ = utils.GenPassword("tmios-dummy-password")
+
+// Users 用户管理和登录会话
+type Users struct {
+	cnf   *config.Config
+	db    *gorm.DB
+	store sessionStore
+
+	sessionTTL   time.Duration
+	maxFailures  int
+	lockDuration time.Duration
+
+	// mutex 保护logins，同一用户名的登录串行，不同用户名互不影响
+	mutex  sync.Mutex
+	logins map[string]*loginLock
+	// hookOnce 重启时不重复注册PreHook
+	hookOnce sync.Once
+}
+
+var (
+	us     *Users
+	usOnce sync.Once
+)
+
+// loginLock 用户名的登录锁，refs为0时从logins中删除，不随尝试过的用户名增长
+type loginLock struct {
+	mutex sync.Mutex
+	refs  int
+}
+
+func NewUsers() *Users {
+	usOnce.Do(func() {
+		us = &Users{
+			cnf:    config.NewConfig(),
+			logins: make(map[string]*loginLock),
+		}
+	})
+	return us
+}
+
+func (u *Users) Name() string {
+	return "user"
+}
+
+func (u *Users) DependsOn() []string {
+	return []string{"config", "storage"}
+}
+
+// Policy 会话Redis暂时连不上时重试启动
+func (u *Users) Policy() cmp.Policy {
+	return cmp.Policy{Restart: cmp.RestartOnFailure}
+}
+
+// Start 建表，选择会话存储，没有用户时创建admin，注册加载当前用户的PreHook
+func (u *Users) Start(ctx context.Context) error {
+	u.db = u.cnf.Db
+	if u.db == nil {
+		return errors.New("user requires database")
+	}
+
+	if err := u.loadConf(u.cnf.Conf().Account); err != nil {
+		return err
+	}
+
+	if err := u.db.AutoMigrate(&model.User{}); err != nil {
+		return err
+	}
+
+	store, err := u.newStore(u.cnf.Conf().SessionRedis)
+	if err != nil {
+		return err
+	}
+	u.store = store
+
+	if err := u.bootstrap(u.cnf.Conf().Account); err != nil {
+		return err
+	}
+
+	u.hookOnce.Do(func() {
+		utils.AddPreHook(u.loadUser)
+	})
+	return nil
+}
+
+// Check 会话Redis不可用时无法登录，应用Token仍可使用，为降级
+func (u *Users) Check(ctx context.Context) error {
+	client, ok := u.store.(*redis.Client)
+	if !ok {
+		return nil
+	}
+
+	if _, err := client.Do(ctx, "PING"); err != nil {
+		return cmp.Degraded(fmt.Errorf("session redis: %w", err))
+	}
+	return nil
+}
+
+// Stop 关闭Redis连接
+func (u *Users) Stop(ctx context.Context) error {
+	if c, ok := u.store.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (u *Users) loadConf(conf config.Account) error {
+	ttl, err := utils.ParseDuration(conf.SessionTTL)
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+
+	lock, err := utils.ParseDuration(conf.LockDuration)
+	if err != nil {
+		return err
+	}
+	if lock <= 0 {
+		lock = defaultLockDuration
+	}
+
+	u.sessionTTL = ttl
+	u.lockDuration = lock
+	u.maxFailures = conf.MaxFailures
+	if u.maxFailures <= 0 {
+		u.maxFailures = defaultMaxFailures
+	}
+
+	return nil
+}
+
+// newStore 没有配置Redis时使用内存，会话在重启后失效；配置了但连不上时返回错误，由组件重启重试，
+// 避免多实例部署时静默退化为各自的内存会话
+func (u *Users) newStore(conf config.Redis) (sessionStore, error) {
+	if conf.Addr == "" {
+		return newMemStore(), nil
+	}
+
+	client := redis.NewClient(conf.Addr, conf.Password, conf.DB)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Do(ctx, "PING"); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("user: session redis %s: %w", conf.Addr, err)
+	}
+
+	return client, nil
+}
+
+// bootstrap 没有用户时创建admin，未配置密码时随机生成并只写入AdminPasswordFile，不写日志
+func (u *Users) bootstrap(conf config.Account) error {
+	var count int64
+	if err := u.db.Model(&model.User{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	password := conf.AdminPassword
+	if password == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		password = hex.EncodeToString(b)
+
+		file := conf.AdminPasswordFile
+		if file == "" {
+			file = defaultAdminPasswordFile
+		}
+		if err := os.WriteFile(file, []byte(password+"\n"), 0600); err != nil {
+			return fmt.Errorf("user: write admin password: %w", err)
+		}
+		// WriteFile不修改已存在文件的权限
+		if err := os.Chmod(file, 0600); err != nil {
+			return fmt.Errorf("user: write admin password: %w", err)
+		}
+		logrus.WithField("file", file).Warn("user: created admin with random password, please change it and remove the file")
+	}
+
+	_, err := u.CreateUser(adminUsername, password, "")
+	return err
+}
+
+// lockLogin 占用用户名的登录锁，返回释放函数。MySQL默认的排序规则不区分大小写，锁也不区分
+func (u *Users) lockLogin(username string) func() {
+	username = strings.ToLower(username)
+	u.mutex.Lock()
+	l, ok := u.logins[username]
+	if !ok {
+		l = &loginLock{}
+		u.logins[username] = l
+	}
+	l.refs++
+	u.mutex.Unlock()
+
+	l.mutex.Lock()
+	return func() {
+		l.mutex.Unlock()
+
+		u.mutex.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(u.logins, username)
+		}
+		u.mutex.Unlock()
+	}
+}
+
+// Login 密码错误累计达到上限后锁定，锁定期间即使密码正确也不能登录；登录成功后失败次数清零。
+// 同一用户名的登录串行，失败计数不会丢失更新
+func (u *Users) Login(ctx context.Context, username, password string) (string, *model.User, error) {
+	defer u.lockLogin(username)()
+
+	var user model.User
+	err := u.db.Where("username = ?", username).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		utils.ComparePassword(dummyHash, password)
+		return "", nil, errm.ErrInvalidUserOrPassword
+	}
+	if err != nil {
+		return "", nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	if user.Disabled {
+		return "", nil, errm.ErrUserDisabled
+	}
+	now := time.Now()
+	if user.LockedUntil != nil && user.LockedUntil.After(now) {
+		return "", nil, errm.ErrLocked.SetDetail("until %s", user.LockedUntil.Format(time.RFC3339))
+	}
+
+	if !utils.ComparePassword(user.Password, password) {
+		updates := map[string]interface{}{"failures": user.Failures + 1}
+		if user.Failures+1 >= u.maxFailures {
+			until := now.Add(u.lockDuration)
+			updates["failures"] = 0
+			updates["locked_until"] = &until
+			logrus.WithField("user", username).Warn("user: locked after too many failures")
+		}
+		if err := u.db.Model(&user).Updates(updates).Error; err != nil {
+			return "", nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+		}
+		return "", nil, errm.ErrInvalidUserOrPassword
+	}
+
+	err = u.db.Model(&user).Updates(map[string]interface{}{
+		"failures": 0, "locked_until": nil, "last_login": &now,
+	}).Error
+	if err != nil {
+		return "", nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+	s := &session{UserID: user.ID, Version: user.Version, ExpiresAt: now.Add(u.sessionTTL)}
+	if err := u.saveSession(ctx, token, s); err != nil {
+		return "", nil, err
+	}
+
+	return token, &user, nil
+}
+
+func (u *Users) Logout(ctx context.Context, token string) error {
+	return u.store.Del(ctx, sessionPrefix+token)
+}
+
+// Current 会话不存在、过期、用户被删除或禁用、修改过密码时返回ErrNoAuth
+func (u *Users) Current(ctx context.Context, token string) (*model.User, error) {
+	if token == "" {
+		return nil, errm.ErrNoAuth
+	}
+
+	s, err := u.loadSession(ctx, token)
+	if err == redis.ErrNil {
+		return nil, errm.ErrNoAuth
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var user model.User
+	err = u.db.First(&user, s.UserID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errm.ErrNoAuth
+	}
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if user.Disabled || user.Version != s.Version {
+		return nil, errm.ErrNoAuth
+	}
+
+	return &user, nil
+}
+
+// ChangePassword 修改后该用户的所有会话失效，包括当前会话
+func (u *Users) ChangePassword(id uint, oriPassword, password string) error {
+	user, err := u.User(id)
+	if err != nil {
+		return err
+	}
+	if !utils.ComparePassword(user.Password, oriPassword) {
+		return errm.ErrInvalidOriPassword
+	}
+
+	return u.setPassword(user, password)
+}
+
+func (u *Users) setPassword(user *model.User, password string) error {
+	hash, err := utils.GenPassword(password)
+	if err != nil {
+		return errm.ErrParam.SetDetail("%s", err.Error())
+	}
+
+	err = u.db.Model(user).Updates(map[string]interface{}{
+		"password": hash, "version": gorm.Expr("version + 1"),
+	}).Error
+	if err != nil {
+		return errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return nil
+}
+
+func (u *Users) Users() ([]model.User, error) {
+	arr := make([]model.User, 0)
+	if err := u.db.Order("id").Find(&arr).Error; err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return arr, nil
+}
+
+func (u *Users) User(id uint) (*model.User, error) {
+	var user model.User
+	err := u.db.First(&user, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errm.ErrNotFound.SetDetail("user %d", id)
+	}
+	if err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return &user, nil
+}
+
+func (u *Users) CreateUser(username, password, name string) (*model.User, error) {
+	var count int64
+	if err := u.db.Unscoped().Model(&model.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if count > 0 {
+		return nil, errm.ErrUserExisted.SetDetail("%s", username)
+	}
+
+	hash, err := utils.GenPassword(password)
+	if err != nil {
+		return nil, errm.ErrParam.SetDetail("%s", err.Error())
+	}
+
+	user := model.User{Username: username, Password: hash, Name: name}
+	if err := u.db.Create(&user).Error; err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return &user, nil
+}
+
+// UpdateUser password非空时重置密码，禁用或重置密码后该用户的会话失效
+func (u *Users) UpdateUser(id uint, name string, disabled bool, password string) (*model.User, error) {
+	user, err := u.User(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.db.Model(user).Updates(map[string]interface{}{"name": name, "disabled": disabled}).Error; err != nil {
+		return nil, errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+	if password != "" {
+		if err := u.setPassword(user, password); err != nil {
+			return nil, err
+		}
+	}
+
+	return u.User(id)
+}
+
+// DeleteUser 不能删除当前登录的用户，已登录的会话在下次请求时失效
+func (u *Users) DeleteUser(id, current uint) error {
+	if id == current {
+		return errm.ErrParam.SetDetail("cannot delete current user")
+	}
+	if _, err := u.User(id); err != nil {
+		return err
+	}
+
+	if err := u.db.Unscoped().Delete(&model.User{}, id).Error; err != nil {
+		return errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return nil
+}
+
+// Unlock 提前解除锁定
+func (u *Users) Unlock(id uint) error {
+	user, err := u.User(id)
+	if err != nil {
+		return err
+	}
+
+	err = u.db.Model(user).Updates(map[string]interface{}{"failures": 0, "locked_until": nil}).Error
+	if err != nil {
+		return errm.ErrDBCurd.SetDetail("%s", err.Error())
+	}
+
+	return nil
+}
+
+// Token 从Authorization: Bearer或session Cookie中取会话
+func Token(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// SetCookie 写入session Cookie，token为空时删除。HttpOnly、SameSite=Lax，
+// Account.SecureCookie为true时只在HTTPS下发送
+func (u *Users) SetCookie(w http.ResponseWriter, token string) {
+	cookie := &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   u.cnf.Conf().Account.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// loadUser 全局PreHook，有效会话的用户放入ReqContext.Data[utils.UserKey]，没有登录时不报错
+func (u *Users) loadUser(ctx *utils.ReqContext) error {
+	token := Token(ctx.Gin.Request)
+	if token == "" {
+		return nil
+	}
+
+	user, err := u.Current(ctx.Gin.Request.Context(), token)
+	if err != nil {
+		if e, ok := err.(liberrors.Error); ok && e.Code == errm.ErrNoAuth.Code {
+			return nil
+		}
+		return err
+	}
+
+	ctx.Data[utils.UserKey] = user
+	return nil
+}
+
+// Required 没有登录时返回ErrNoAuth
+func Required(ctx *utils.ReqContext) error {
+	if FromContext(ctx) == nil {
+		return errm.ErrNoAuth
+	}
+	return nil
+}
+
+// RequireLogin 需要登录的接口使用
+func RequireLogin() utils.HandlerOption {
+	return func(attr *utils.HandlerAttr) {
+		utils.WithPreHooks(Required)(attr)
+		utils.WithErrors(errm.ErrNoAuth)(attr)
+	}
+}
+
+// FromContext 当前登录用户，没有登录时为nil
+func FromContext(ctx *utils.ReqContext) *model.User {
+	user, _ := ctx.Data[utils.UserKey].(*model.User)
+	return user
+}
@@ -109,3 +109,30 @@ func OneOf[T comparable](e T, l []T) bool {
 
 	return false
 }
+
+// Unique 去重并保持原有顺序
+func Unique[T comparable](l []T) []T {
+	seen := make(map[T]bool, len(l))
+	arr := make([]T, 0, len(l))
+	for _, i := range l {
+		if !seen[i] {
+			seen[i] = true
+			arr = append(arr, i)
+		}
+	}
+
+	return arr
+}
+
+func CopyMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return nil
+	}
+
+	ret := make(map[K]V, len(m))
+	for k, v := range m {
+		ret[k] = v
+	}
+
+	return ret
+}
@@ -1,8 +1,11 @@
 package utils
 
 import (
-	"gorm.io/gorm"
+	"database/sql/driver"
+	"fmt"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Model struct {
@@ -11,3 +14,27 @@ type Model struct {
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 }
+
+// NullString 空字符串在数据库中存为NULL，唯一索引中多个空值不冲突
+type NullString string
+
+func (s NullString) Value() (driver.Value, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return string(s), nil
+}
+
+func (s *NullString) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = ""
+	case string:
+		*s = NullString(v)
+	case []byte:
+		*s = NullString(v)
+	default:
+		return fmt.Errorf("unsupported type %T for NullString", value)
+	}
+	return nil
+}
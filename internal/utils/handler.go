@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"sync"
+
 	errm "tmios/pkg/model/errors"
 
 	"github.com/gin-gonic/gin"
@@ -26,11 +28,59 @@ type HandlerAttr struct {
 	ReturnType ReturnType
 	PreHooks   []PreHook
 	PostHooks  []PostHook
+	Doc        HandlerDoc
+}
+
+// HandlerDoc 只用于生成接口文档。Response为Content的示例值，如model.Device{}；
+// Errors为可能返回的lib/errors错误；Upload为multipart上传时文件的字段名
+type HandlerDoc struct {
+	Summary  string
+	Response any
+	Errors   []error
+	Upload   string
 }
 
 type HandlerOption func(*HandlerAttr)
 
-func newHandlerAttr(opts ...HandlerOption) *HandlerAttr {
+// WithPreHooks 在全局PreHook之后执行
+func WithPreHooks(hooks ...PreHook) HandlerOption {
+	return func(attr *HandlerAttr) {
+		attr.PreHooks = append(attr.PreHooks, hooks...)
+	}
+}
+
+// WithReturnType 处理函数自己写响应时使用ReturnTypeNone
+func WithReturnType(t ReturnType) HandlerOption {
+	return func(attr *HandlerAttr) {
+		attr.ReturnType = t
+	}
+}
+
+func WithSummary(summary string) HandlerOption {
+	return func(attr *HandlerAttr) {
+		attr.Doc.Summary = summary
+	}
+}
+
+func WithResponse(rsp any) HandlerOption {
+	return func(attr *HandlerAttr) {
+		attr.Doc.Response = rsp
+	}
+}
+
+func WithErrors(errs ...error) HandlerOption {
+	return func(attr *HandlerAttr) {
+		attr.Doc.Errors = append(attr.Doc.Errors, errs...)
+	}
+}
+
+func WithUpload(field string) HandlerOption {
+	return func(attr *HandlerAttr) {
+		attr.Doc.Upload = field
+	}
+}
+
+func NewHandlerAttr(opts ...HandlerOption) *HandlerAttr {
 	attr := HandlerAttr{}
 	for _, o := range opts {
 		o(&attr)
@@ -39,6 +89,32 @@ func newHandlerAttr(opts ...HandlerOption) *HandlerAttr {
 	return &attr
 }
 
+const (
+	// AppIDKey 鉴权通过的应用ID在gin.Context和ReqContext.Data中的键
+	AppIDKey = "app_id"
+	// UserKey 当前登录用户在ReqContext.Data中的键
+	UserKey = "user"
+)
+
+var (
+	hooksMutex  sync.RWMutex
+	globalHooks []PreHook
+)
+
+// AddPreHook 所有Handler在自身的PreHooks之前执行，例如加载当前用户
+func AddPreHook(hook PreHook) {
+	hooksMutex.Lock()
+	globalHooks = append(globalHooks, hook)
+	hooksMutex.Unlock()
+}
+
+func preHooks(attr *HandlerAttr) []PreHook {
+	hooksMutex.RLock()
+	defer hooksMutex.RUnlock()
+
+	return append(append([]PreHook(nil), globalHooks...), attr.PreHooks...)
+}
+
 type ReqContext struct {
 	Gin  *gin.Context
 	Data map[string]interface{}
@@ -58,7 +134,7 @@ func Handler[T any](
 ) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var (
-			attr   = newHandlerAttr(opts...)
+			attr   = NewHandlerAttr(opts...)
 			reqArg T
 		)
 		switch c.Request.Method {
@@ -78,6 +154,9 @@ func Handler[T any](
 			Gin:  c,
 			Data: make(map[string]interface{}),
 		}
+		if appID, ok := c.Get(AppIDKey); ok {
+			ctxt.Data[AppIDKey] = appID
+		}
 
 		// Check request arguments
 		if c.Request.Method == "POST" {
@@ -87,7 +166,7 @@ func Handler[T any](
 			}
 		}
 
-		for _, hookFunc := range attr.PreHooks {
+		for _, hookFunc := range preHooks(attr) {
 			if err := hookFunc(ctxt); err != nil {
 				ApiErr(c, err)
 				return
@@ -8,8 +8,9 @@ import (
 )
 
 type MachineInfo struct {
-	Ip  net.IP
-	Mac net.HardwareAddr
+	Ip   net.IP
+	Mask net.IPMask
+	Mac  net.HardwareAddr
 }
 
 func GetIpMac() []MachineInfo {
@@ -38,12 +39,27 @@ func GetIpMac() []MachineInfo {
 				continue
 			}
 			info.Ip = ip
+			if v, ok := addr.(*net.IPNet); ok {
+				info.Mask = v.Mask
+				if len(info.Mask) == net.IPv6len {
+					info.Mask = info.Mask[12:]
+				}
+			}
 		}
 		arr = append(arr, info)
 	}
 	return arr
 }
 
+// Subnet 网卡所在网段，没有IPv4地址或掩码时为nil
+func (m MachineInfo) Subnet() *net.IPNet {
+	if m.Ip == nil || len(m.Mask) != net.IPv4len {
+		return nil
+	}
+
+	return &net.IPNet{IP: m.Ip.Mask(m.Mask), Mask: m.Mask}
+}
+
 //获取ip
 func getIpFromAddr(addr net.Addr) net.IP {
 	var ip net.IP
@@ -30,3 +30,8 @@ func EncryptPassword(password string) string {
 	)
 	return fmt.Sprintf("%x", hash)
 }
+
+// ComparePassword hash为GenPassword的结果
+func ComparePassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
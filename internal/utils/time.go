@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"strconv"
 	"strings"
@@ -51,3 +52,20 @@ func ParseTimeToCTS(clientTime string, nextTime string) string {
 	}
 	return nextTime
 }
+
+// ParseDuration 在time.ParseDuration基础上支持天，例如"7d"、"90d"；空字符串为0
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
@@ -87,6 +87,22 @@ func NotFound(code int, detail string) Error {
 	})
 }
 
+func Locked(code int, detail string) Error {
+	return addError(Error{
+		Code:   code,
+		Status: 423,
+		Detail: detail,
+	})
+}
+
+func ServiceUnavailable(code int, detail string) Error {
+	return addError(Error{
+		Code:   code,
+		Status: 503,
+		Detail: detail,
+	})
+}
+
 func Internal(detail string, err error) Error {
 	e, ok := err.(Error)
 	if ok {
@@ -0,0 +1,256 @@
+// Package influx 最小的InfluxDB v2 HTTP客户端，只实现写入、Flux查询和按条件删除
+package influx
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// Error 服务端返回的错误
+type Error struct {
+	Status  int
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("influx: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+type Client struct {
+	url    string
+	token  string
+	org    string
+	bucket string
+	http   *http.Client
+}
+
+func NewClient(serverURL, token, org, bucket string) *Client {
+	return &Client{
+		url:    strings.TrimRight(serverURL, "/"),
+		token:  token,
+		org:    org,
+		bucket: bucket,
+		http:   &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+func (c *Client) Bucket() string {
+	return c.bucket
+}
+
+func (c *Client) do(ctx context.Context, path string, query url.Values, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path+"?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Token "+c.token)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		e := &Error{Status: resp.StatusCode}
+		if json.Unmarshal(data, e) != nil || e.Message == "" {
+			e.Message = strings.TrimSpace(string(data))
+		}
+		return nil, e
+	}
+
+	return data, nil
+}
+
+// Write 写入行协议数据，时间精度为纳秒
+func (c *Client) Write(ctx context.Context, lines []byte) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	q := url.Values{"org": {c.org}, "bucket": {c.bucket}, "precision": {"ns"}}
+	_, err := c.do(ctx, "/api/v2/write", q, "text/plain; charset=utf-8", lines)
+	return err
+}
+
+// Query 执行Flux查询，每行为列名到值的映射，空值的列不出现
+func (c *Client) Query(ctx context.Context, flux string) ([]map[string]string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":   flux,
+		"type":    "flux",
+		"dialect": map[string]interface{}{"header": true, "annotations": []string{}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.do(ctx, "/api/v2/query", url.Values{"org": {c.org}}, "application/json", body)
+	if err != nil {
+		return nil, err
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = false
+
+	var (
+		rows   []map[string]string
+		header []string
+	)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 每个表以列名开头，第二列为result
+		if len(record) > 1 && record[1] == "result" {
+			header = record
+			continue
+		}
+		if header == nil {
+			continue
+		}
+
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			if i < len(record) && name != "" && record[i] != "" {
+				row[name] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// Delete 删除[start, stop)内满足predicate的数据，predicate为delete API的语法，如_measurement="m"
+func (c *Client) Delete(ctx context.Context, start, stop time.Time, predicate string) error {
+	body, err := json.Marshal(map[string]string{
+		"start":     start.UTC().Format(time.RFC3339Nano),
+		"stop":      stop.UTC().Format(time.RFC3339Nano),
+		"predicate": predicate,
+	})
+	if err != nil {
+		return err
+	}
+
+	q := url.Values{"org": {c.org}, "bucket": {c.bucket}}
+	_, err = c.do(ctx, "/api/v2/delete", q, "application/json", body)
+	return err
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// AppendLine 按行协议追加一个点，tag按名称排序，不支持的字段类型和空字段名忽略，没有字段时不追加
+func AppendLine(b []byte, measurement string, tags map[string]string, fields map[string]interface{}, ts time.Time) []byte {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var fb []byte
+	for _, name := range names {
+		v, ok := formatField(fields[name])
+		if !ok {
+			continue
+		}
+		if len(fb) > 0 {
+			fb = append(fb, ',')
+		}
+		fb = append(fb, keyEscaper.Replace(name)...)
+		fb = append(fb, '=')
+		fb = append(fb, v...)
+	}
+	if len(fb) == 0 {
+		return b
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if k != "" && v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	b = append(b, measurementEscaper.Replace(measurement)...)
+	for _, k := range keys {
+		b = append(b, ',')
+		b = append(b, keyEscaper.Replace(k)...)
+		b = append(b, '=')
+		b = append(b, keyEscaper.Replace(tags[k])...)
+	}
+	b = append(b, ' ')
+	b = append(b, fb...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, ts.UnixNano(), 10)
+	return append(b, '\n')
+}
+
+func formatField(val interface{}) (string, bool) {
+	switch v := val.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), true
+	case int:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int8:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int16:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int32:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int64:
+		return strconv.FormatInt(v, 10) + "i", true
+	case uint:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint64:
+		return strconv.FormatUint(v, 10) + "u", true
+	case bool:
+		return strconv.FormatBool(v), true
+	case string:
+		return `"` + stringEscaper.Replace(v) + `"`, true
+	}
+
+	return "", false
+}
+
+// String Flux字符串字面量
+func String(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`).Replace(s) + `"`
+}
@@ -0,0 +1,34 @@
+package device
+
+import (
+	"errors"
+)
+
+var ErrAlarmUnsupported = errors.New("device does not support alarm")
+
+const (
+	AlarmInfo     = "info"
+	AlarmWarning  = "warning"
+	AlarmCritical = "critical"
+)
+
+type Alarm struct {
+	Level   string `json:"level"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// Alarmer Device的可选实现
+type Alarmer interface {
+	Alarm(alarm Alarm) error
+}
+
+// RaiseAlarm 驱动上报告警
+func RaiseAlarm(dv Device, alarm Alarm) error {
+	a, ok := dv.(Alarmer)
+	if !ok {
+		return ErrAlarmUnsupported
+	}
+
+	return a.Alarm(alarm)
+}
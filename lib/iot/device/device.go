@@ -35,6 +35,7 @@ type PropMeta struct {
 	Validate string `json:"validate"`
 	Desc     string `json:"desc"`
 	Extras   string `json:"extras"`
+	Virtual  string `json:"virtual,omitempty"` // 虚拟属性的表达式
 
 	propType reflect.Type
 }
@@ -48,7 +49,11 @@ func (p PropsMeta) MarshalJSON() ([]byte, error) {
 	return json.Marshal(p.Props)
 }
 
+// Check 检查值的类型。从JSON还原的PropMeta中非基本类型为interface{}，不检查
 func (p *PropMeta) Check(val interface{}) error {
+	if p.propType == typeInterface {
+		return nil
+	}
 	if p.propType != reflect.TypeOf(val) {
 		return fmt.Errorf("value is not %s type", p.Type)
 	}
@@ -83,7 +88,10 @@ type ActionMeta struct {
 	Args PropsMeta `json:"args"`
 	Rets PropsMeta `json:"rets"`
 
+	RateLimit *RateLimit `json:"rate_limit,omitempty"` // 在设备的RateLimit之外单独限制该action
+
 	fun      reflect.Value
+	raw      RawActionFunc
 	argsType reflect.Type
 	retsType reflect.Type
 }
@@ -105,6 +113,15 @@ func (meta ActionMeta) Action(ctx context.Context, dv Device, args []byte) ([]by
 		return nil, err
 	}
 
+	if meta.raw != nil {
+		data, err := json.Marshal(argsVal.Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		return meta.raw(ctx, dv, data)
+	}
+
 	retVals := meta.fun.Call([]reflect.Value{
 		reflect.ValueOf(ctx), reflect.ValueOf(dv),
 		reflect.ValueOf(argsVal.Interface()),
@@ -123,17 +140,40 @@ type ActionsMeta []ActionMeta
 
 type IntervalFunc func(Device) error
 
+// IntervalCtxFunc ctx已持有设备的Gate，其中调用同一设备的Action不会重复占用(action自己的频率限制仍然生效，见Gate.Acquire)；
+// IntervalFunc中调用同一设备的Action需要再次占用Gate，设备串行时会等到超时
+type IntervalCtxFunc func(ctx context.Context, dv Device) error
+
 type Interval struct {
-	Name     string       `json:"name"`
-	Interval int64        `json:"interval"`
-	Desc     string       `json:"desc"`
-	Func     IntervalFunc `json:"-"`
+	Name     string          `json:"name"`
+	Interval int64           `json:"interval"`
+	Desc     string          `json:"desc"`
+	Func     IntervalFunc    `json:"-"`
+	CtxFunc  IntervalCtxFunc `json:"-"` // 设置后代替Func
+}
+
+func (i *Interval) HasFunc() bool {
+	return i.CtxFunc != nil || i.Func != nil
+}
+
+// Run 执行周期任务，CtxFunc优先
+func (i *Interval) Run(ctx context.Context, dv Device) error {
+	if i.CtxFunc != nil {
+		return i.CtxFunc(ctx, dv)
+	}
+	if i.Func != nil {
+		return i.Func(dv)
+	}
+	return nil
 }
 
 type Intervals []Interval
 type InitFunc func() error
 type ForeignIDFunc func(config []byte) string
 
+// ForeignIDErrFunc 可能失败的ForeignIDFunc，例如插件未运行时无法生成
+type ForeignIDErrFunc func(config []byte) (string, error)
+
 type DeviceMeta struct {
 	Name     string    `json:"name"`     // 设备名称
 	Variable string    `json:"variable"` // 变量名
@@ -144,11 +184,23 @@ type DeviceMeta struct {
 	Config   PropsMeta `json:"config"`
 
 	Properties PropsMeta   `json:"properties"`
+	Virtuals   []Virtual   `json:"virtuals"` // 注册时追加到Properties
 	Actions    ActionsMeta `json:"actions"`
 
 	ForeignIDFunc ForeignIDFunc `json:"-"`
-	InitFunc      InitFunc      `json:"-"`
-	Intervals     Intervals     `json:"intervals"`
+	// ForeignIDErrFunc 设置后代替ForeignIDFunc，出错时不能确定外部ID
+	ForeignIDErrFunc ForeignIDErrFunc `json:"-"`
+	InitFunc         InitFunc         `json:"-"`
+	Intervals        Intervals        `json:"intervals"`
+
+	// Concurrency 同时访问设备的action和IntervalFunc个数，0为1(串行)，负数不限制
+	Concurrency int `json:"concurrency"`
+	// RateLimit 设备所有action和IntervalFunc共用的频率限制，nil不限制
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+	// Probe 局域网发现时用来识别该型号的设备，nil表示不参与发现
+	Probe *Probe `json:"probe,omitempty"`
+	// FTPTargetFunc 没有upgrade action时通过FTP向设备推送固件
+	FTPTargetFunc FTPTargetFunc `json:"-"`
 }
 
 func (meta *DeviceMeta) GetAction(name string) *ActionMeta {
@@ -287,6 +339,15 @@ func Action(ctx context.Context, dv Device, name string, args []byte) ([]byte, e
 		return nil, ErrInvalidAction
 	}
 
+	if gated, ok := dv.(Gated); ok {
+		c, release, err := gated.Gate().Acquire(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		ctx = c
+	}
+
 	return actionMeta.Action(ctx, dv, args)
 }
 
@@ -362,3 +423,19 @@ type Device interface {
 	SetVals(vals map[string]interface{}, opts ...SetValOption) error
 	Commit(opts ...CommitOption) error
 }
+
+// HasForeignID 型号按配置生成外部ID
+func (meta *DeviceMeta) HasForeignID() bool {
+	return meta.ForeignIDErrFunc != nil || meta.ForeignIDFunc != nil
+}
+
+// ForeignID 按配置生成外部ID，型号没有外部ID时为空
+func (meta *DeviceMeta) ForeignID(config []byte) (string, error) {
+	if meta.ForeignIDErrFunc != nil {
+		return meta.ForeignIDErrFunc(config)
+	}
+	if meta.ForeignIDFunc != nil {
+		return meta.ForeignIDFunc(config), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,148 @@
+package device
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit 令牌桶，Rate为每秒令牌数，Burst为桶容量(至少为1)
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+type bucket struct {
+	rate  float64
+	burst float64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(limit *RateLimit) *bucket {
+	if limit == nil || limit.Rate <= 0 {
+		return nil
+	}
+
+	burst := math.Max(1, float64(limit.Burst))
+	return &bucket{rate: limit.Rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait 取一个令牌，没有时等待补充，ctx结束时返回错误
+func (b *bucket) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	for {
+		b.mutex.Lock()
+		now := time.Now()
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mutex.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mutex.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+type gateKey struct{}
+
+// Gate 设备的并发和频率控制，action和IntervalFunc都经过Gate，
+// 默认同一时间只有一个操作访问设备
+type Gate struct {
+	sem     chan struct{}
+	device  *bucket
+	actions map[string]*bucket
+}
+
+// NewGate 按DeviceMeta.Concurrency、RateLimit和ActionMeta.RateLimit构造
+func NewGate(meta *DeviceMeta) *Gate {
+	g := &Gate{
+		device:  newBucket(meta.RateLimit),
+		actions: make(map[string]*bucket),
+	}
+
+	concurrency := meta.Concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	if concurrency > 0 {
+		g.sem = make(chan struct{}, concurrency)
+	}
+
+	for _, action := range meta.Actions {
+		if b := newBucket(action.RateLimit); b != nil {
+			g.actions[action.Name] = b
+		}
+	}
+
+	return g
+}
+
+// Acquire 占用设备并等待令牌，action为空表示IntervalFunc。返回的ctx标记已持有Gate，
+// action和IntervalFunc中用这个ctx再调用同一设备的action(嵌套调用)时不重复占用并发，
+// 也不再消耗设备的令牌，设备的RateLimit按最外层的一次操作计算；
+// 嵌套调用的action仍然消耗该action自己的令牌，action的频率限制不因调用方而失效
+func (g *Gate) Acquire(ctx context.Context, action string) (context.Context, func(), error) {
+	if ctx.Value(gateKey{}) == g {
+		if action != "" {
+			if err := g.actions[action].wait(ctx); err != nil {
+				return ctx, nil, err
+			}
+		}
+		return ctx, func() {}, nil
+	}
+
+	// action的令牌在占用设备前等待，限频的action不会阻塞设备上的其他操作
+	if action != "" {
+		if err := g.actions[action].wait(ctx); err != nil {
+			return ctx, nil, err
+		}
+	}
+
+	release := func() {}
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+			release = func() { <-g.sem }
+		case <-ctx.Done():
+			return ctx, nil, ctx.Err()
+		}
+	}
+
+	// 持有设备后再等待设备的令牌，保证发到设备上的命令间隔
+	if err := g.device.wait(ctx); err != nil {
+		release()
+		return ctx, nil, err
+	}
+
+	return context.WithValue(ctx, gateKey{}, g), release, nil
+}
+
+// Gated Device的可选实现，实现后device.Action按Gate控制并发和频率
+type Gated interface {
+	Gate() *Gate
+}
+
+// Limit 设置action的频率限制，例如:
+//
+//	device.ToActionMeta("reset", Reset, "复位").Limit(0.2, 1)
+func (meta ActionMeta) Limit(rate float64, burst int) ActionMeta {
+	meta.RateLimit = &RateLimit{Rate: rate, Burst: burst}
+	return meta
+}
@@ -0,0 +1,135 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func acquire(t *testing.T, ctx context.Context, g *Gate, action string) (context.Context, func()) {
+	t.Helper()
+
+	c, release, err := g.Acquire(ctx, action)
+	if err != nil {
+		t.Fatalf("acquire %q: %v", action, err)
+	}
+	return c, release
+}
+
+// tryAcquire 在timeout内取不到时返回错误
+func tryAcquire(ctx context.Context, g *Gate, action string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, release, err := g.Acquire(ctx, action)
+	if err == nil {
+		release()
+	}
+	return err
+}
+
+func TestGateRate(t *testing.T) {
+	g := NewGate(&DeviceMeta{Concurrency: -1, RateLimit: &RateLimit{Rate: 20, Burst: 1}})
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		_, release := acquire(t, context.Background(), g, "")
+		release()
+	}
+	// 第一个令牌在桶中，其余3个每个等待50ms
+	if d := time.Since(start); d < 140*time.Millisecond || d > time.Second {
+		t.Errorf("4 acquisitions at 20/s took %s", d)
+	}
+}
+
+func TestGateBurst(t *testing.T) {
+	g := NewGate(&DeviceMeta{Concurrency: -1, RateLimit: &RateLimit{Rate: 1, Burst: 3}})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, release := acquire(t, context.Background(), g, "")
+		release()
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("burst took %s", d)
+	}
+	if err := tryAcquire(context.Background(), g, "", 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect deadline exceeded after burst, got %v", err)
+	}
+}
+
+func TestGateConcurrency(t *testing.T) {
+	g := NewGate(&DeviceMeta{})
+
+	_, release := acquire(t, context.Background(), g, "")
+	if err := tryAcquire(context.Background(), g, "", 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect serial access, got %v", err)
+	}
+	release()
+	if err := tryAcquire(context.Background(), g, "", 50*time.Millisecond); err != nil {
+		t.Errorf("acquire after release: %v", err)
+	}
+}
+
+// TestGateActionLimit 限频的action在占用设备前等待，不阻塞设备上的其他操作
+func TestGateActionLimit(t *testing.T) {
+	g := NewGate(&DeviceMeta{Actions: []ActionMeta{
+		{Name: "reset", RateLimit: &RateLimit{Rate: 1, Burst: 1}},
+		{Name: "read"},
+	}})
+
+	_, release := acquire(t, context.Background(), g, "reset")
+	release()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tryAcquire(context.Background(), g, "reset", 100*time.Millisecond)
+	}()
+	if err := tryAcquire(context.Background(), g, "read", 50*time.Millisecond); err != nil {
+		t.Errorf("read blocked by limited reset: %v", err)
+	}
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect reset to be limited, got %v", err)
+	}
+}
+
+// TestGateReentrant 嵌套调用不占用并发和设备令牌，但消耗action自己的令牌
+func TestGateReentrant(t *testing.T) {
+	g := NewGate(&DeviceMeta{
+		RateLimit: &RateLimit{Rate: 1, Burst: 1},
+		Actions: []ActionMeta{
+			{Name: "reset", RateLimit: &RateLimit{Rate: 1, Burst: 1}},
+			{Name: "read"},
+		},
+	})
+
+	ctx, release := acquire(t, context.Background(), g, "")
+	defer release()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		c, inner := acquire(t, ctx, g, "read")
+		if c != ctx {
+			t.Error("nested acquire should keep the ctx")
+		}
+		inner()
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("nested acquisitions took %s", d)
+	}
+
+	_, inner := acquire(t, ctx, g, "reset")
+	inner()
+	if err := tryAcquire(ctx, g, "reset", 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect nested reset to consume its bucket, got %v", err)
+	}
+
+	// 其他Gate的ctx不算嵌套
+	other := NewGate(&DeviceMeta{})
+	_, otherRelease := acquire(t, ctx, other, "")
+	otherRelease()
+	if err := tryAcquire(context.Background(), g, "read", 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect device to be held by the outer call, got %v", err)
+	}
+}
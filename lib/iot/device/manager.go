@@ -46,6 +46,11 @@ func Register(meta *DeviceMeta) {
 		panic(fmt.Sprintf("device model '%s' register twice.", meta.Model))
 	}
 
+	if err := CheckVirtuals(meta.Properties, meta.Virtuals); err != nil {
+		panic(fmt.Sprintf("device model '%s': %s", meta.Model, err.Error()))
+	}
+	meta.Properties = withVirtuals(meta.Properties, meta.Virtuals)
+
 	m.metas[meta.Model] = meta
 }
 
@@ -0,0 +1,93 @@
+package device
+
+import (
+	"context"
+	"sort"
+)
+
+// 候选设备的发现方式
+const (
+	SourceTCP    = "tcp"
+	SourceModbus = "modbus"
+	SourceMDNS   = "mdns"
+	SourceSSDP   = "ssdp"
+)
+
+// Candidate 局域网中发现的设备，Ports为开放的TCP端口，
+// Info为Modbus设备标识、mDNS服务名、SSDP头等附加信息
+type Candidate struct {
+	IP      string            `json:"ip"`
+	Mac     string            `json:"mac,omitempty"`
+	Ports   []int             `json:"ports"`
+	Sources []string          `json:"sources"`
+	Info    map[string]string `json:"info"`
+	SeenAt  int64             `json:"seen_at"`
+}
+
+func (c *Candidate) HasPort(port int) bool {
+	for _, p := range c.Ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge 合并同一IP的另一次发现结果
+func (c *Candidate) Merge(other *Candidate) {
+	if other.Mac != "" {
+		c.Mac = other.Mac
+	}
+	for _, port := range other.Ports {
+		if !c.HasPort(port) {
+			c.Ports = append(c.Ports, port)
+		}
+	}
+	sort.Ints(c.Ports)
+
+	for _, source := range other.Sources {
+		found := false
+		for _, s := range c.Sources {
+			found = found || s == source
+		}
+		if !found {
+			c.Sources = append(c.Sources, source)
+		}
+	}
+
+	if c.Info == nil {
+		c.Info = make(map[string]string)
+	}
+	for k, v := range other.Info {
+		c.Info[k] = v
+	}
+
+	if other.SeenAt > c.SeenAt {
+		c.SeenAt = other.SeenAt
+	}
+}
+
+// ProbeFunc 判断候选设备是否为该型号，是则返回该型号的设备配置(JSON)，
+// 可以连接设备进一步确认，ctx带有超时
+type ProbeFunc func(ctx context.Context, c *Candidate) (config []byte, ok bool, err error)
+
+// Probe 型号的发现规则，Ports为该型号必须开放的端口，扫描时总会探测这些端口
+type Probe struct {
+	Ports []int     `json:"ports"`
+	Func  ProbeFunc `json:"-"`
+}
+
+// Match 候选设备开放了Probe.Ports且Probe.Func确认时返回设备配置
+func (meta *DeviceMeta) Match(ctx context.Context, c *Candidate) ([]byte, bool, error) {
+	if meta.Probe == nil || meta.Probe.Func == nil {
+		return nil, false, nil
+	}
+
+	for _, port := range meta.Probe.Ports {
+		if !c.HasPort(port) {
+			return nil, false, nil
+		}
+	}
+
+	return meta.Probe.Func(ctx, c)
+}
@@ -0,0 +1,181 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+var (
+	typeInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+
+	// basicTypes PropMeta.Type 到反射类型，用于从JSON还原PropsMeta
+	basicTypes = map[string]reflect.Type{
+		"bool":    reflect.TypeOf(false),
+		"string":  reflect.TypeOf(""),
+		"int":     reflect.TypeOf(int(0)),
+		"int8":    reflect.TypeOf(int8(0)),
+		"int16":   reflect.TypeOf(int16(0)),
+		"int32":   reflect.TypeOf(int32(0)),
+		"int64":   reflect.TypeOf(int64(0)),
+		"uint":    reflect.TypeOf(uint(0)),
+		"uint8":   reflect.TypeOf(uint8(0)),
+		"uint16":  reflect.TypeOf(uint16(0)),
+		"uint32":  reflect.TypeOf(uint32(0)),
+		"uint64":  reflect.TypeOf(uint64(0)),
+		"float32": reflect.TypeOf(float32(0)),
+		"float64": reflect.TypeOf(float64(0)),
+	}
+)
+
+// RawActionFunc 不经过反射的action实现，args已经过校验
+type RawActionFunc func(ctx context.Context, dv Device, args []byte) ([]byte, error)
+
+func jsonSchemaType(typ string) string {
+	switch typ {
+	case "bool":
+		return "boolean"
+	case "string":
+		return "string"
+	case "int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64":
+		return "integer"
+	case "float32", "float64":
+		return "number"
+	}
+
+	return ""
+}
+
+// JSONSchema 生成 draft-07 JSON Schema
+func (meta PropsMeta) JSONSchema() map[string]interface{} {
+	var (
+		properties = make(map[string]interface{})
+		required   []string
+	)
+
+	for _, prop := range meta.Props {
+		schema := map[string]interface{}{}
+		if typ := jsonSchemaType(prop.Type); typ != "" {
+			schema["type"] = typ
+		}
+		if prop.Desc != "" {
+			schema["description"] = prop.Desc
+		}
+		properties[prop.Name] = schema
+
+		for _, rule := range strings.Split(prop.Validate, ",") {
+			if rule == "required" {
+				required = append(required, prop.Name)
+			}
+		}
+	}
+
+	schema := map[string]interface{}{
+		"$schema":    "http://json-schema.org/draft-07/schema#",
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+// JSONSchema config、properties以及每个action的args/rets的JSON Schema
+func (meta *DeviceMeta) JSONSchema() map[string]interface{} {
+	actions := make(map[string]interface{})
+	for _, action := range meta.Actions {
+		actions[action.Name] = map[string]interface{}{
+			"args": action.Args.JSONSchema(),
+			"rets": action.Rets.JSONSchema(),
+		}
+	}
+
+	return map[string]interface{}{
+		"config":     meta.Config.JSONSchema(),
+		"properties": meta.Properties.JSONSchema(),
+		"actions":    actions,
+	}
+}
+
+func exportName(name string, idx int) string {
+	r := []rune(name)
+	if len(r) > 0 {
+		r[0] = unicode.ToUpper(r[0])
+	}
+	if s := string(r); token.IsIdentifier(s) && token.IsExported(s) {
+		return s
+	}
+
+	return fmt.Sprintf("F%d", idx)
+}
+
+// propsStruct 按PropMeta构造结构体类型，字段带json和validate tag
+func propsStruct(props []*PropMeta) reflect.Type {
+	var (
+		fields []reflect.StructField
+		names  = make(map[string]bool)
+	)
+
+	for i, prop := range props {
+		name := exportName(prop.Name, i)
+		if names[name] {
+			name = fmt.Sprintf("F%d", i)
+		}
+		names[name] = true
+
+		tag := fmt.Sprintf(`json:"%s"`, prop.Name)
+		if prop.Validate != "" {
+			tag += fmt.Sprintf(` validate:"%s"`, prop.Validate)
+		}
+		fields = append(fields, reflect.StructField{
+			Name: name,
+			Type: prop.propType,
+			Tag:  reflect.StructTag(tag),
+		})
+	}
+
+	return reflect.StructOf(fields)
+}
+
+// UnmarshalJSON 从JSON还原PropsMeta，并用reflect.StructOf重建结构体类型，
+// 使远程注册的DeviceMeta与本地一样可以CheckConfig、Cast和validate
+func (meta *PropsMeta) UnmarshalJSON(data []byte) error {
+	var props []*PropMeta
+	if err := json.Unmarshal(data, &props); err != nil {
+		return err
+	}
+
+	for _, prop := range props {
+		typ, ok := basicTypes[prop.Type]
+		if !ok {
+			typ = typeInterface
+		}
+		prop.propType = typ
+	}
+
+	meta.Props = props
+	meta.Type = propsStruct(props)
+
+	return nil
+}
+
+// NewRawActionMeta 创建由fn实现的action，用于插件等非反射的实现
+func NewRawActionMeta(name, desc string, args, rets PropsMeta, fn RawActionFunc) ActionMeta {
+	return ActionMeta{
+		Name: name,
+		Desc: desc,
+		Args: args,
+		Rets: rets,
+
+		raw:      fn,
+		argsType: args.Type,
+		retsType: rets.Type,
+	}
+}
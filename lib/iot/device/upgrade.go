@@ -0,0 +1,39 @@
+package device
+
+const (
+	// UpgradeAction 驱动声明该action时通过它升级设备，参数为UpgradeArgs，返回UpgradeRets
+	UpgradeAction = "upgrade"
+	// VersionProp 设备上报当前固件版本的属性，升级后用来校验
+	VersionProp = "version"
+)
+
+// UpgradeArgs 固件信息，Path为本机文件路径，URL为设备可下载的签名地址，只在本次传输中有效(未配置Upgrade.BaseURL时为空)
+type UpgradeArgs struct {
+	Version  string `json:"version"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Path     string `json:"path"`
+	URL      string `json:"url"`
+}
+
+// UpgradeRets Version为升级后设备报告的版本，为空时按VersionProp校验
+type UpgradeRets struct {
+	Version string `json:"version"`
+}
+
+// FTPTarget 设备自带的FTP服务，固件上传到Dir目录
+type FTPTarget struct {
+	URL      string
+	Username string
+	Password string
+	Dir      string
+}
+
+// FTPTargetFunc 按设备配置返回设备的FTP服务，没有upgrade action的型号通过FTP推送固件
+type FTPTargetFunc func(config []byte) *FTPTarget
+
+// CanUpgrade 型号声明了upgrade action或FTPTargetFunc
+func (meta *DeviceMeta) CanUpgrade() bool {
+	return meta.GetAction(UpgradeAction) != nil || meta.FTPTargetFunc != nil
+}
@@ -0,0 +1,104 @@
+package device
+
+import (
+	"fmt"
+	"reflect"
+
+	"tmios/lib/iot/expr"
+)
+
+var typeFloat64 = reflect.TypeOf(float64(0))
+
+// Virtual 虚拟属性，由表达式根据其他属性计算，例如:
+//
+//	{Name: "power", Expr: "voltage * current"}
+//	{Name: "temp_avg", Expr: "avg(temp, 10)"}
+type Virtual struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+	Desc string `json:"desc"`
+}
+
+// IsVirtual 虚拟属性只能由表达式计算，不能SetVal
+func (p *PropMeta) IsVirtual() bool {
+	return p.Virtual != ""
+}
+
+// CheckVirtuals 校验表达式，只能引用已有属性或排在前面的虚拟属性
+func CheckVirtuals(props PropsMeta, virtuals []Virtual) error {
+	names := make(map[string]bool)
+	for _, prop := range props.Props {
+		names[prop.Name] = true
+	}
+
+	for _, v := range virtuals {
+		// 已追加过，例如插件型号的Properties中已包含虚拟属性
+		if prop := props.Get(v.Name); prop != nil && prop.Virtual == v.Expr {
+			continue
+		}
+		if v.Name == "" {
+			return fmt.Errorf("virtual property name is empty")
+		}
+		if names[v.Name] {
+			return fmt.Errorf("virtual property %s: name already exists", v.Name)
+		}
+
+		e, err := expr.Parse(v.Expr)
+		if err != nil {
+			return fmt.Errorf("virtual property %s: %w", v.Name, err)
+		}
+		for _, name := range e.Vars() {
+			if !names[name] {
+				return fmt.Errorf("virtual property %s: unknown property %s", v.Name, name)
+			}
+		}
+
+		names[v.Name] = true
+	}
+
+	return nil
+}
+
+// withVirtuals 虚拟属性追加到Properties，和真实属性一样存储和展示
+func withVirtuals(props PropsMeta, virtuals []Virtual) PropsMeta {
+	var (
+		arr   = append([]*PropMeta{}, props.Props...)
+		added bool
+	)
+
+	for _, v := range virtuals {
+		if prop := props.Get(v.Name); prop != nil && prop.IsVirtual() {
+			continue
+		}
+		arr = append(arr, &PropMeta{
+			Name:     v.Name,
+			Type:     "float64",
+			Desc:     v.Desc,
+			Virtual:  v.Expr,
+			propType: typeFloat64,
+		})
+		added = true
+	}
+
+	if !added {
+		return props
+	}
+
+	return PropsMeta{Props: arr, Type: propsStruct(arr)}
+}
+
+// WithVirtuals 按实例追加虚拟属性，返回新的DeviceMeta，不影响已注册的型号
+func (meta *DeviceMeta) WithVirtuals(virtuals []Virtual) (*DeviceMeta, error) {
+	if len(virtuals) == 0 {
+		return meta, nil
+	}
+
+	if err := CheckVirtuals(meta.Properties, virtuals); err != nil {
+		return nil, err
+	}
+
+	m := *meta
+	m.Properties = withVirtuals(meta.Properties, virtuals)
+
+	return &m, nil
+}
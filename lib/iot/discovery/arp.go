@@ -0,0 +1,33 @@
+package discovery
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+const arpFile = "/proc/net/arp"
+
+// ARP 读取系统ARP表 ip -> mac，扫描之后才会有同网段主机的记录；非linux系统返回空
+func ARP() map[string]string {
+	table := make(map[string]string)
+
+	f, err := os.Open(arpFile)
+	if err != nil {
+		return table
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // 表头
+	for scanner.Scan() {
+		// IP address  HW type  Flags  HW address  Mask  Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] == "00:00:00:00:00:00" {
+			continue
+		}
+		table[fields[0]] = fields[3]
+	}
+
+	return table
+}
@@ -0,0 +1,166 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"tmios/lib/iot/device"
+)
+
+const (
+	mdnsAddr = "224.0.0.251:5353"
+	// mdnsServices DNS-SD服务类型枚举
+	mdnsServices = "_services._dns-sd._udp.local."
+)
+
+// FoundFunc 发现候选设备时回调，同一设备会多次回调
+type FoundFunc func(c *device.Candidate)
+
+// ListenMDNS 监听mDNS响应，每隔interval查询一次服务类型和各类型的实例，ctx结束时返回；
+// ifi为nil时使用系统默认网卡
+func ListenMDNS(ctx context.Context, ifi *net.Interface, interval time.Duration, found FoundFunc) error {
+	group, err := net.ResolveUDPAddr("udp4", mdnsAddr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", ifi, group)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	services := make(chan string, 64)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		known := make(map[string]bool)
+		query := func(name string) {
+			if msg, err := mdnsQuery(name); err == nil {
+				_, _ = conn.WriteToUDP(msg, group)
+			}
+		}
+
+		query(mdnsServices)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case name := <-services:
+				if !known[name] {
+					known[name] = true
+					query(name)
+				}
+			case <-ticker.C:
+				query(mdnsServices)
+				for name := range known {
+					query(name)
+				}
+			}
+		}
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		c, types := parseMDNS(buf[:n], src.IP)
+		for _, name := range types {
+			select {
+			case services <- name:
+			default:
+			}
+		}
+		if c != nil {
+			found(c)
+		}
+	}
+}
+
+func mdnsQuery(name string) ([]byte, error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: n, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	return msg.Pack()
+}
+
+// parseMDNS 解析一个响应包，一个包按一个设备处理，IP取A记录，没有时取源地址；
+// types为服务类型枚举返回的类型，需要继续查询实例
+func parseMDNS(data []byte, src net.IP) (*device.Candidate, []string) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(data); err != nil || !msg.Header.Response {
+		return nil, nil
+	}
+
+	var (
+		c = &device.Candidate{
+			IP:      src.String(),
+			Sources: []string{device.SourceMDNS},
+			Info:    make(map[string]string),
+			SeenAt:  time.Now().Unix(),
+		}
+		types     []string
+		instances []string
+		hasA      bool
+	)
+
+	records := append(append([]dnsmessage.Resource{}, msg.Answers...), msg.Additionals...)
+	for _, r := range records {
+		name := r.Header.Name.String()
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			target := body.PTR.String()
+			if name == mdnsServices {
+				types = append(types, target)
+				continue
+			}
+			c.Info["mdns.service"] = name
+			instances = append(instances, target)
+		case *dnsmessage.SRVResource:
+			c.Info["mdns.host"] = body.Target.String()
+			if port := int(body.Port); port > 0 && !c.HasPort(port) {
+				c.Ports = append(c.Ports, port)
+			}
+		case *dnsmessage.AResource:
+			if !hasA {
+				c.IP = net.IP(body.A[:]).String()
+				hasA = true
+			}
+		case *dnsmessage.TXTResource:
+			for _, txt := range body.TXT {
+				if k, v, ok := strings.Cut(txt, "="); ok && k != "" {
+					c.Info["mdns.txt."+strings.ToLower(k)] = v
+				}
+			}
+		}
+	}
+
+	if len(instances) > 0 {
+		c.Info["mdns.name"] = strings.Join(instances, ",")
+	}
+	if len(c.Info) == 0 && len(c.Ports) == 0 {
+		return nil, types
+	}
+
+	return c, types
+}
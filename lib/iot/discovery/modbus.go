@@ -0,0 +1,150 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// ModbusPort Modbus TCP默认端口
+const ModbusPort = 502
+
+const (
+	funcEncapsulated = 0x2B
+	meiDeviceID      = 0x0E
+
+	readBasic   = 0x01
+	readRegular = 0x02
+
+	maxObjectsRounds = 8
+)
+
+// modbusObjects Read Device Identification的对象ID
+var modbusObjects = map[byte]string{
+	0x00: "modbus.vendor",
+	0x01: "modbus.product_code",
+	0x02: "modbus.revision",
+	0x03: "modbus.vendor_url",
+	0x04: "modbus.product_name",
+	0x05: "modbus.model_name",
+	0x06: "modbus.application",
+}
+
+// ModbusException 设备返回的异常码
+type ModbusException byte
+
+func (e ModbusException) Error() string {
+	return fmt.Sprintf("modbus exception %d", byte(e))
+}
+
+// ModbusIdentify 通过功能码43/14读取设备标识，先读regular，设备不支持时读basic
+func ModbusIdentify(ctx context.Context, addr string, unit byte, timeout time.Duration) (map[string]string, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	info, err := readDeviceID(conn, unit, readRegular, timeout)
+	var exception ModbusException
+	if errors.As(err, &exception) {
+		info, err = readDeviceID(conn, unit, readBasic, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info["modbus.unit"] = fmt.Sprint(unit)
+	return info, nil
+}
+
+func readDeviceID(conn net.Conn, unit byte, code byte, timeout time.Duration) (map[string]string, error) {
+	var (
+		info   = make(map[string]string)
+		object byte
+	)
+
+	for round := 0; round < maxObjectsRounds; round++ {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+
+		pdu := []byte{funcEncapsulated, meiDeviceID, code, object}
+		resp, err := modbusCall(conn, uint16(round+1), unit, pdu)
+		if err != nil {
+			return nil, err
+		}
+
+		// 2B 0E code conformity more next count [id len value]...
+		if len(resp) < 7 || resp[1] != meiDeviceID {
+			return nil, fmt.Errorf("modbus: invalid device id response")
+		}
+		more, next, count := resp[4], resp[5], int(resp[6])
+
+		data := resp[7:]
+		for i := 0; i < count; i++ {
+			if len(data) < 2 || len(data) < 2+int(data[1]) {
+				return nil, fmt.Errorf("modbus: truncated device id object")
+			}
+			id, value := data[0], string(data[2:2+int(data[1])])
+			if name, ok := modbusObjects[id]; ok {
+				info[name] = value
+			}
+			data = data[2+int(data[1]):]
+		}
+
+		if more != 0xFF {
+			break
+		}
+		object = next
+	}
+
+	return info, nil
+}
+
+// modbusCall 发送一帧MBAP请求，返回响应的PDU
+func modbusCall(conn net.Conn, tid uint16, unit byte, pdu []byte) ([]byte, error) {
+	req := make([]byte, 7+len(pdu))
+	binary.BigEndian.PutUint16(req[0:], tid)
+	binary.BigEndian.PutUint16(req[2:], 0)
+	binary.BigEndian.PutUint16(req[4:], uint16(len(pdu)+1))
+	req[6] = unit
+	copy(req[7:], pdu)
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(header[0:]) != tid || binary.BigEndian.Uint16(header[2:]) != 0 {
+		return nil, fmt.Errorf("modbus: unexpected response header")
+	}
+
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if length < 2 || length > 254 {
+		return nil, fmt.Errorf("modbus: invalid response length %d", length)
+	}
+	resp := make([]byte, length-1)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+
+	if resp[0] == pdu[0]|0x80 {
+		if len(resp) < 2 {
+			return nil, fmt.Errorf("modbus: truncated exception")
+		}
+		return nil, ModbusException(resp[1])
+	}
+	if resp[0] != pdu[0] {
+		return nil, fmt.Errorf("modbus: unexpected function %d", resp[0])
+	}
+
+	return resp, nil
+}
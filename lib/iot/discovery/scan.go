@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Hosts 网段内的主机地址，去掉网络地址和广播地址，最多limit个，limit<=0不限制
+func Hosts(n *net.IPNet, limit int) []net.IP {
+	ip := n.IP.To4()
+	if ip == nil {
+		return nil
+	}
+
+	ones, bits := n.Mask.Size()
+	if bits != 32 {
+		return nil
+	}
+
+	var (
+		start = binary.BigEndian.Uint32(ip.Mask(n.Mask))
+		size  = uint64(1) << uint(32-ones)
+		hosts []net.IP
+	)
+	first, last := uint64(0), size-1
+	if size > 2 {
+		first, last = 1, size-2
+	}
+
+	for i := first; i <= last; i++ {
+		if limit > 0 && len(hosts) >= limit {
+			break
+		}
+		host := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(host, start+uint32(i))
+		hosts = append(hosts, host)
+	}
+
+	return hosts
+}
+
+// ScanTCP 并发探测主机的TCP端口，返回 ip -> 开放的端口
+func ScanTCP(ctx context.Context, hosts []net.IP, ports []int, concurrency int, timeout time.Duration) map[string][]int {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	type target struct {
+		ip   string
+		port int
+	}
+
+	var (
+		targets = make(chan target)
+		mutex   sync.Mutex
+		open    = make(map[string][]int)
+		wg      sync.WaitGroup
+		dialer  = net.Dialer{Timeout: timeout}
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range targets {
+				conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(t.ip, strconv.Itoa(t.port)))
+				if err != nil {
+					continue
+				}
+				conn.Close()
+
+				mutex.Lock()
+				open[t.ip] = append(open[t.ip], t.port)
+				mutex.Unlock()
+			}
+		}()
+	}
+
+loop:
+	for _, host := range hosts {
+		for _, port := range ports {
+			select {
+			case <-ctx.Done():
+				break loop
+			case targets <- target{host.String(), port}:
+			}
+		}
+	}
+	close(targets)
+	wg.Wait()
+
+	for _, ports := range open {
+		sort.Ints(ports)
+	}
+
+	return open
+}
@@ -0,0 +1,104 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"tmios/lib/iot/device"
+)
+
+const (
+	DefaultMaxHosts    = 1024
+	DefaultConcurrency = 256
+	DefaultTimeout     = 500 * time.Millisecond
+)
+
+// modbusUnits 依次尝试的单元号，直连设备一般是1，网关常用255
+var modbusUnits = []byte{1, 255}
+
+// Scanner 主动扫描网段的TCP端口，开放502端口的主机再读取Modbus设备标识
+type Scanner struct {
+	Subnets     []*net.IPNet
+	Ports       []int
+	MaxHosts    int // 每个网段
+	Concurrency int
+	Timeout     time.Duration // 单个连接
+}
+
+func (s *Scanner) Scan(ctx context.Context) []*device.Candidate {
+	var (
+		maxHosts    = s.MaxHosts
+		concurrency = s.Concurrency
+		timeout     = s.Timeout
+	)
+	if maxHosts <= 0 {
+		maxHosts = DefaultMaxHosts
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	var hosts []net.IP
+	for _, subnet := range s.Subnets {
+		hosts = append(hosts, Hosts(subnet, maxHosts)...)
+	}
+
+	open := ScanTCP(ctx, hosts, s.Ports, concurrency, timeout)
+
+	var (
+		arp        = ARP()
+		now        = time.Now().Unix()
+		candidates = make([]*device.Candidate, 0, len(open))
+	)
+	for ip, ports := range open {
+		c := &device.Candidate{
+			IP:      ip,
+			Mac:     arp[ip],
+			Ports:   ports,
+			Sources: []string{device.SourceTCP},
+			Info:    make(map[string]string),
+			SeenAt:  now,
+		}
+
+		if c.HasPort(ModbusPort) {
+			for _, unit := range modbusUnits {
+				info, err := ModbusIdentify(ctx, net.JoinHostPort(ip, strconv.Itoa(ModbusPort)), unit, timeout)
+				if err != nil {
+					continue
+				}
+				for k, v := range info {
+					c.Info[k] = v
+				}
+				c.Sources = append(c.Sources, device.SourceModbus)
+				break
+			}
+		}
+
+		candidates = append(candidates, c)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return ipLess(candidates[i].IP, candidates[j].IP)
+	})
+
+	return candidates
+}
+
+func ipLess(a, b string) bool {
+	ipa, ipb := net.ParseIP(a).To4(), net.ParseIP(b).To4()
+	if ipa == nil || ipb == nil {
+		return a < b
+	}
+	for i := range ipa {
+		if ipa[i] != ipb[i] {
+			return ipa[i] < ipb[i]
+		}
+	}
+	return false
+}
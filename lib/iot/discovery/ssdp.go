@@ -0,0 +1,139 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"tmios/lib/iot/device"
+)
+
+const ssdpAddr = "239.255.255.250:1900"
+
+var ssdpSearch = []byte("M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: ssdp:all\r\n\r\n")
+
+// ListenSSDP 监听SSDP的NOTIFY广播，并每隔interval发送一次M-SEARCH，ctx结束时返回；
+// ifi为nil时使用系统默认网卡
+func ListenSSDP(ctx context.Context, ifi *net.Interface, interval time.Duration, found FoundFunc) error {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return err
+	}
+
+	notify, err := net.ListenMulticastUDP("udp4", ifi, group)
+	if err != nil {
+		return err
+	}
+	defer notify.Close()
+
+	// M-SEARCH的响应单播回发送端口
+	search, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return err
+	}
+	defer search.Close()
+
+	go func() {
+		<-ctx.Done()
+		notify.Close()
+		search.Close()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			_, _ = search.WriteToUDP(ssdpSearch, group)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	errc := make(chan error, 2)
+	read := func(conn *net.UDPConn) {
+		buf := make([]byte, 4096)
+		for {
+			n, src, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				errc <- err
+				return
+			}
+			if c := parseSSDP(buf[:n], src.IP); c != nil {
+				found(c)
+			}
+		}
+	}
+	go read(notify)
+	go read(search)
+
+	err = <-errc
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// parseSSDP 解析NOTIFY和M-SEARCH响应，忽略其他设备发出的M-SEARCH和ssdp:byebye
+func parseSSDP(data []byte, src net.IP) *device.Candidate {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	line, err := reader.ReadLine()
+	if err != nil {
+		return nil
+	}
+	if !strings.HasPrefix(line, "NOTIFY ") && !strings.HasPrefix(line, "HTTP/") {
+		return nil
+	}
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return nil
+	}
+	if header.Get("NTS") == "ssdp:byebye" {
+		return nil
+	}
+
+	c := &device.Candidate{
+		IP:      src.String(),
+		Sources: []string{device.SourceSSDP},
+		Info:    make(map[string]string),
+		SeenAt:  time.Now().Unix(),
+	}
+
+	target := header.Get("ST")
+	if target == "" {
+		target = header.Get("NT")
+	}
+	for k, v := range map[string]string{
+		"ssdp.server":   header.Get("SERVER"),
+		"ssdp.location": header.Get("LOCATION"),
+		"ssdp.usn":      header.Get("USN"),
+		"ssdp.st":       target,
+	} {
+		if v != "" {
+			c.Info[k] = v
+		}
+	}
+
+	// 描述文件在设备自己的HTTP端口上
+	if u, err := url.Parse(header.Get("LOCATION")); err == nil && u.Hostname() == c.IP {
+		if port, err := strconv.Atoi(u.Port()); err == nil {
+			c.Ports = append(c.Ports, port)
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,282 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+)
+
+type node interface {
+	eval(env Env) (float64, error)
+}
+
+type numNode float64
+
+func (n numNode) eval(Env) (float64, error) {
+	return float64(n), nil
+}
+
+type varNode string
+
+func (n varNode) eval(env Env) (float64, error) {
+	v, ok := env.Var(string(n))
+	if !ok {
+		return 0, fmt.Errorf("%s has no value", string(n))
+	}
+	return v, nil
+}
+
+func boolVal(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+func (n *unaryNode) eval(env Env) (float64, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "-":
+		return -x, nil
+	case "!":
+		return boolVal(x == 0), nil
+	default:
+		return x, nil
+	}
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(env Env) (float64, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	// 短路求值，if(x > 0 && y / x > 1, ...)不会除零
+	switch n.op {
+	case "&&":
+		if l == 0 {
+			return 0, nil
+		}
+	case "||":
+		if l != 0 {
+			return 1, nil
+		}
+	}
+
+	r, err := n.right.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return math.Mod(l, r), nil
+	case "^":
+		return math.Pow(l, r), nil
+	case "==":
+		return boolVal(l == r), nil
+	case "!=":
+		return boolVal(l != r), nil
+	case "<":
+		return boolVal(l < r), nil
+	case "<=":
+		return boolVal(l <= r), nil
+	case ">":
+		return boolVal(l > r), nil
+	case ">=":
+		return boolVal(l >= r), nil
+	case "&&", "||":
+		return boolVal(r != 0), nil
+	}
+
+	return 0, fmt.Errorf("unknown operator %s", n.op)
+}
+
+type callNode struct {
+	name string
+	fn   func(args []float64) (float64, error)
+	args []node
+}
+
+func (n *callNode) eval(env Env) (float64, error) {
+	// if只计算选中的分支
+	if n.name == "if" {
+		cond, err := n.args[0].eval(env)
+		if err != nil {
+			return 0, err
+		}
+		if cond != 0 {
+			return n.args[1].eval(env)
+		}
+		return n.args[2].eval(env)
+	}
+
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+
+	return n.fn(args)
+}
+
+type windowNode struct {
+	fn   string
+	name string
+	size int
+}
+
+func newWindowNode(fn string, args []node) (node, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%s(prop, n) expects 2 arguments", fn)
+	}
+	name, ok := args[0].(varNode)
+	if !ok {
+		return nil, fmt.Errorf("%s: first argument must be a property", fn)
+	}
+	size, ok := args[1].(numNode)
+	if !ok || float64(size) != math.Trunc(float64(size)) || size < 1 || size > MaxWindow {
+		return nil, fmt.Errorf("%s: window size must be an integer in [1, %d]", fn, MaxWindow)
+	}
+
+	return &windowNode{fn: fn, name: string(name), size: int(size)}, nil
+}
+
+func (n *windowNode) eval(env Env) (float64, error) {
+	vals := env.Window(n.name, n.size)
+	if len(vals) == 0 {
+		return 0, fmt.Errorf("%s has no value", n.name)
+	}
+
+	return aggregates[n.fn](vals), nil
+}
+
+var aggregates = map[string]func(vals []float64) float64{
+	"avg": func(vals []float64) float64 {
+		var sum float64
+		for _, v := range vals {
+			sum += v
+		}
+		return sum / float64(len(vals))
+	},
+	"sum": func(vals []float64) float64 {
+		var sum float64
+		for _, v := range vals {
+			sum += v
+		}
+		return sum
+	},
+	"wmin": func(vals []float64) float64 {
+		ret := vals[0]
+		for _, v := range vals[1:] {
+			ret = math.Min(ret, v)
+		}
+		return ret
+	},
+	"wmax": func(vals []float64) float64 {
+		ret := vals[0]
+		for _, v := range vals[1:] {
+			ret = math.Max(ret, v)
+		}
+		return ret
+	},
+	// delta 窗口内最新值与最旧值之差，常用于累计量
+	"delta": func(vals []float64) float64 {
+		return vals[len(vals)-1] - vals[0]
+	},
+}
+
+type function struct {
+	min, max int // max为-1表示不限
+	call     func(args []float64) (float64, error)
+}
+
+func math1(f func(float64) float64) function {
+	return function{min: 1, max: 1, call: func(args []float64) (float64, error) {
+		return f(args[0]), nil
+	}}
+}
+
+var funcs = map[string]function{
+	"abs":   math1(math.Abs),
+	"ceil":  math1(math.Ceil),
+	"floor": math1(math.Floor),
+	"round": {min: 1, max: 2, call: func(args []float64) (float64, error) {
+		if len(args) == 1 {
+			return math.Round(args[0]), nil
+		}
+		p := math.Pow(10, math.Trunc(args[1]))
+		return math.Round(args[0]*p) / p, nil
+	}},
+	"sqrt":  math1(math.Sqrt),
+	"exp":   math1(math.Exp),
+	"ln":    math1(math.Log),
+	"log10": math1(math.Log10),
+	"sin":   math1(math.Sin),
+	"cos":   math1(math.Cos),
+	"tan":   math1(math.Tan),
+	"atan":  math1(math.Atan),
+	"pow": {min: 2, max: 2, call: func(args []float64) (float64, error) {
+		return math.Pow(args[0], args[1]), nil
+	}},
+	"min": {min: 1, max: -1, call: func(args []float64) (float64, error) {
+		return aggregates["wmin"](args), nil
+	}},
+	"max": {min: 1, max: -1, call: func(args []float64) (float64, error) {
+		return aggregates["wmax"](args), nil
+	}},
+	"clamp": {min: 3, max: 3, call: func(args []float64) (float64, error) {
+		return math.Max(args[1], math.Min(args[2], args[0])), nil
+	}},
+	"if": {min: 3, max: 3},
+}
+
+var constants = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+func walk(n node, fn func(node)) {
+	fn(n)
+	switch n := n.(type) {
+	case *unaryNode:
+		walk(n.x, fn)
+	case *binaryNode:
+		walk(n.left, fn)
+		walk(n.right, fn)
+	case *callNode:
+		for _, arg := range n.args {
+			walk(arg, fn)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// Package expr 虚拟属性使用的表达式，只支持数值运算、数学函数和窗口聚合，
+// 没有赋值、循环和外部调用，可以安全地执行用户输入的表达式
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	MaxLen    = 1024
+	MaxDepth  = 64
+	MaxWindow = 1000
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNum
+	tokIdent
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+var ops = []string{"&&", "||", "==", "!=", "<=", ">=", "+", "-", "*", "/", "%", "^", "<", ">", "!"}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", pos: i})
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			// 科学计数法 1e-3
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				k := j + 1
+				if k < len(src) && (src[k] == '+' || src[k] == '-') {
+					k++
+				}
+				if k < len(src) && unicode.IsDigit(rune(src[k])) {
+					for k < len(src) && unicode.IsDigit(rune(src[k])) {
+						k++
+					}
+					j = k
+				}
+			}
+			num, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", src[i:j], i)
+			}
+			tokens = append(tokens, token{kind: tokNum, text: src[i:j], num: num, pos: i})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+		default:
+			matched := false
+			for _, op := range ops {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// 二元运算符优先级，^右结合
+var binaryPrec = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+	"^": 8,
+}
+
+const unaryPrec = 7
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	t := p.next()
+	if t.kind != kind {
+		return fmt.Errorf("expected %q at %d", text, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) expr(prec int) (node, error) {
+	if p.depth++; p.depth > MaxDepth {
+		return nil, fmt.Errorf("expression too deep")
+	}
+	defer func() { p.depth-- }()
+
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != tokOp {
+			return left, nil
+		}
+		opPrec, ok := binaryPrec[t.text]
+		if !ok || opPrec < prec {
+			return left, nil
+		}
+		p.next()
+
+		nextPrec := opPrec + 1
+		if t.text == "^" {
+			nextPrec = opPrec
+		}
+		right, err := p.expr(nextPrec)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	t := p.peek()
+	if t.kind == tokOp && (t.text == "-" || t.text == "+" || t.text == "!") {
+		p.next()
+		x, err := p.expr(unaryPrec)
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: t.text, x: x}, nil
+	}
+
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNum:
+		return numNode(t.num), nil
+	case tokLParen:
+		x, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(tokRParen, ")")
+	case tokIdent:
+		if p.peek().kind != tokLParen {
+			if c, ok := constants[t.text]; ok {
+				return numNode(c), nil
+			}
+			return varNode(t.text), nil
+		}
+		p.next()
+		return p.call(t)
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+}
+
+func (p *parser) call(name token) (node, error) {
+	var args []node
+	if p.peek().kind == tokRParen {
+		p.next()
+	} else {
+		for {
+			arg, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			t := p.next()
+			if t.kind == tokRParen {
+				break
+			}
+			if t.kind != tokComma {
+				return nil, fmt.Errorf("expected ',' or ')' at %d", t.pos)
+			}
+		}
+	}
+
+	if _, ok := aggregates[name.text]; ok {
+		return newWindowNode(name.text, args)
+	}
+
+	fn, ok := funcs[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name.text)
+	}
+	if fn.min > len(args) || (fn.max >= 0 && fn.max < len(args)) {
+		return nil, fmt.Errorf("wrong number of arguments for %s", name.text)
+	}
+
+	return &callNode{name: name.text, fn: fn.call, args: args}, nil
+}
+
+// Expr 编译后的表达式，可并发求值
+type Expr struct {
+	src     string
+	root    node
+	vars    []string
+	windows map[string]int
+}
+
+// Parse 编译表达式，例如 "voltage * current"、"avg(temp, 10)"
+func Parse(src string) (*Expr, error) {
+	if len(src) > MaxLen {
+		return nil, fmt.Errorf("expression longer than %d", MaxLen)
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+
+	e := &Expr{src: src, root: root, windows: make(map[string]int)}
+	seen := make(map[string]bool)
+	walk(root, func(n node) {
+		switch n := n.(type) {
+		case varNode:
+			if !seen[string(n)] {
+				seen[string(n)] = true
+				e.vars = append(e.vars, string(n))
+			}
+		case *windowNode:
+			if !seen[n.name] {
+				seen[n.name] = true
+				e.vars = append(e.vars, n.name)
+			}
+			if n.size > e.windows[n.name] {
+				e.windows[n.name] = n.size
+			}
+		}
+	})
+
+	return e, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Vars 表达式引用的属性
+func (e *Expr) Vars() []string {
+	return e.vars
+}
+
+// Windows 窗口聚合引用的属性及需要保留的最大个数
+func (e *Expr) Windows() map[string]int {
+	return e.windows
+}
+
+// Env 求值时的属性值，Window返回最近n个值(旧的在前)
+type Env interface {
+	Var(name string) (float64, bool)
+	Window(name string, n int) []float64
+}
+
+// Eval 结果为NaN或Inf时返回错误，例如sqrt(-1)
+func (e *Expr) Eval(env Env) (float64, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("%s is not a finite number", e.src)
+	}
+
+	return v, nil
+}
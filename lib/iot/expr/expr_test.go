@@ -0,0 +1,199 @@
+package expr
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testEnv struct {
+	vars    map[string]float64
+	windows map[string][]float64
+}
+
+func (e testEnv) Var(name string) (float64, bool) {
+	v, ok := e.vars[name]
+	return v, ok
+}
+
+func (e testEnv) Window(name string, n int) []float64 {
+	vals := e.windows[name]
+	if n < len(vals) {
+		vals = vals[len(vals)-n:]
+	}
+	return vals
+}
+
+func TestParseError(t *testing.T) {
+	cases := []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 + 2)",
+		"1 2",
+		"a $ b",
+		"1..2",
+		"foo(1)",
+		"abs()",
+		"abs(1, 2)",
+		"pow(1)",
+		"if(1, 2)",
+		"min(1,)",
+		"avg(temp)",
+		"avg(1, 10)",
+		"avg(temp, 0)",
+		"avg(temp, 1.5)",
+		"avg(temp, 1001)",
+		"avg(temp, n)",
+		strings.Repeat("(", MaxDepth+1) + "1" + strings.Repeat(")", MaxDepth+1),
+		strings.Repeat("1+", MaxLen/2) + "1",
+	}
+	for _, src := range cases {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%q: expect parse error", src)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	env := testEnv{vars: map[string]float64{"a": 2, "b": 3, "zero": 0}}
+	cases := map[string]float64{
+		"1 + 2 * 3":            7,
+		"(1 + 2) * 3":          9,
+		"10 - 4 - 3":           3,
+		"12 / 3 / 2":           2,
+		"7 % 4":                3,
+		"2 ^ 3 ^ 2":            512,
+		"-2 ^ 2":               -4,
+		"(-2) ^ 2":             4,
+		"-a * b":               -6,
+		"!0 + 1":               2,
+		"1 + 2 < 4":            1,
+		"1 < 2 == 2 < 3":       1,
+		"0 || 1 && 0":          0,
+		"1 || 0 && 0":          1,
+		"a == 2 && b != 2":     1,
+		"1e3 + .5":             1000.5,
+		"2 * pi":               2 * math.Pi,
+		"round(3.14159, 2)":    3.14,
+		"clamp(a * 10, 0, 15)": 15,
+		"max(a, b, 1)":         3,
+		"min(a, b, 1)":         1,
+		// 短路和if不计算未选中的分支
+		"zero != 0 && a / zero > 1":  0,
+		"zero == 0 || a / zero > 1":  1,
+		"if(zero == 0, a, a / zero)": 2,
+		"if(zero, missing, b)":       3,
+	}
+	for src, want := range cases {
+		e, err := Parse(src)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		got, err := e.Eval(env)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("%q = %v, want %v", src, got, want)
+		}
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	env := testEnv{vars: map[string]float64{"a": 2, "zero": 0, "inf": math.Inf(1), "nan": math.NaN()}}
+	cases := []string{
+		"missing + 1",
+		"a / zero",
+		"a % zero",
+		"sqrt(-1)",
+		"ln(zero)",
+		"10 ^ 400",
+		"inf - 1",
+		"nan * 0",
+		"avg(missing, 3)",
+	}
+	for _, src := range cases {
+		e, err := Parse(src)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		if v, err := e.Eval(env); err == nil {
+			t.Errorf("%q: expect error, got %v", src, v)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	env := testEnv{windows: map[string][]float64{
+		"temp":  {1, 5, 3, 7},
+		"count": {100, 110, 125},
+		"nan":   {1, math.NaN()},
+		"inf":   {1, math.Inf(-1)},
+	}}
+	cases := map[string]float64{
+		"avg(temp, 4)":    4,
+		"avg(temp, 2)":    5,
+		"avg(temp, 10)":   4,
+		"sum(temp, 3)":    15,
+		"wmin(temp, 3)":   3,
+		"wmax(temp, 4)":   7,
+		"delta(count, 3)": 25,
+		"delta(count, 1)": 0,
+	}
+	for src, want := range cases {
+		e, err := Parse(src)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		got, err := e.Eval(env)
+		if err != nil || got != want {
+			t.Errorf("%q = %v %v, want %v", src, got, err, want)
+		}
+	}
+
+	// 窗口中的NaN和Inf使结果不是有限数，求值失败
+	for _, src := range []string{"avg(nan, 2)", "sum(inf, 2)", "delta(inf, 2)"} {
+		e, err := Parse(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := e.Eval(env); err == nil {
+			t.Errorf("%q: expect error, got %v", src, v)
+		}
+	}
+}
+
+func TestVarsWindows(t *testing.T) {
+	e, err := Parse("avg(temp, 10) + wmax(temp, 20) - humidity * temp + sum(power, 5)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"temp", "humidity", "power"}; !reflect.DeepEqual(e.Vars(), want) {
+		t.Errorf("vars %v, want %v", e.Vars(), want)
+	}
+	if want := map[string]int{"temp": 20, "power": 5}; !reflect.DeepEqual(e.Windows(), want) {
+		t.Errorf("windows %v, want %v", e.Windows(), want)
+	}
+}
+
+func TestRingWindow(t *testing.T) {
+	w := NewWindow(3)
+	if got := w.Last(3); len(got) != 0 {
+		t.Errorf("empty window %v", got)
+	}
+	for i := 1; i <= 5; i++ {
+		w.Push(float64(i))
+	}
+	if got, want := w.Last(5), []float64{3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("last %v, want %v", got, want)
+	}
+	if got, want := w.Last(2), []float64{4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("last %v, want %v", got, want)
+	}
+}
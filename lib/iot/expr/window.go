@@ -0,0 +1,43 @@
+package expr
+
+// Window 保留最近size个值的环形缓冲区，非并发安全
+type Window struct {
+	vals  []float64
+	start int
+	count int
+}
+
+func NewWindow(size int) *Window {
+	return &Window{vals: make([]float64, size)}
+}
+
+func (w *Window) Size() int {
+	return len(w.vals)
+}
+
+func (w *Window) Push(v float64) {
+	if len(w.vals) == 0 {
+		return
+	}
+
+	w.vals[(w.start+w.count)%len(w.vals)] = v
+	if w.count < len(w.vals) {
+		w.count++
+	} else {
+		w.start = (w.start + 1) % len(w.vals)
+	}
+}
+
+// Last 最近n个值，旧的在前
+func (w *Window) Last(n int) []float64 {
+	if n > w.count {
+		n = w.count
+	}
+
+	ret := make([]float64, n)
+	for i := 0; i < n; i++ {
+		ret[i] = w.vals[(w.start+w.count-n+i)%len(w.vals)]
+	}
+
+	return ret
+}
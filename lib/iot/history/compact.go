@@ -0,0 +1,188 @@
+package history
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// chunkBuckets 每次读取源数据的时间跨度为chunkBuckets个汇总周期，限制内存占用
+const chunkBuckets = 60
+
+type aggKey struct {
+	series string
+	field  string
+	time   int64
+}
+
+// Aggregate 把样本按序列、字段和res对齐的时间汇总，源样本可以是原始数据或更细的汇总
+func Aggregate(samples []Sample, res time.Duration) []Sample {
+	var (
+		keys   []aggKey
+		groups = make(map[aggKey]*Sample)
+		sums   = make(map[aggKey]float64)
+	)
+
+	for _, s := range samples {
+		t := s.Time.Truncate(res)
+		key := aggKey{series: SeriesKey(s.Tags), field: s.Field, time: t.UnixNano()}
+
+		g, ok := groups[key]
+		if !ok {
+			g = &Sample{
+				Measurement: s.Measurement,
+				Tags:        s.Tags,
+				Field:       s.Field,
+				Time:        t,
+				Min:         math.Inf(1),
+				Max:         math.Inf(-1),
+			}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+
+		g.Min = math.Min(g.Min, s.Min)
+		g.Max = math.Max(g.Max, s.Max)
+		g.Count += s.Count
+		sums[key] += s.Mean * float64(s.Count)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.series != b.series {
+			return a.series < b.series
+		}
+		if a.field != b.field {
+			return a.field < b.field
+		}
+		return a.time < b.time
+	})
+
+	ret := make([]Sample, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		if g.Count > 0 {
+			g.Mean = sums[key] / float64(g.Count)
+		}
+		ret = append(ret, *g)
+	}
+
+	return ret
+}
+
+// Compactor 按保留策略逐级生成汇总数据，并删除过期数据。
+// 每级汇总只处理已结束的周期，进度记录在Backend的Watermark中；
+// 晚于Watermark到达的原始数据不会再进入汇总
+type Compactor struct {
+	backend  Backend
+	policies Policies
+}
+
+func NewCompactor(backend Backend, policies Policies) *Compactor {
+	return &Compactor{backend: backend, policies: policies}
+}
+
+func (c *Compactor) Compact(ctx context.Context) error {
+	measurements, err := c.backend.Measurements(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, m := range measurements {
+		p := c.policies.For(m)
+		if p == nil {
+			continue
+		}
+		if err := c.compact(ctx, m, p, now); err != nil {
+			logrus.WithField("measurement", m).WithError(err).Error("history: compact failed")
+		}
+	}
+
+	return nil
+}
+
+func (c *Compactor) compact(ctx context.Context, m string, p *Policy, now time.Time) error {
+	src := Raw
+	for _, level := range p.Levels {
+		if err := c.rollup(ctx, m, src, level.Resolution, now); err != nil {
+			return err
+		}
+		src = level.Resolution
+	}
+
+	// 每级数据只删除已汇总到下一级的部分，避免未汇总的数据过期丢失
+	type tier struct {
+		res, keep time.Duration
+	}
+	tiers := []tier{{Raw, p.Raw}}
+	for _, level := range p.Levels {
+		tiers = append(tiers, tier{level.Resolution, level.Keep})
+	}
+
+	for i, t := range tiers {
+		if t.keep == 0 {
+			continue
+		}
+		before := now.Add(-t.keep)
+		if i+1 < len(tiers) {
+			wm, err := c.backend.Watermark(ctx, m, tiers[i+1].res)
+			if err != nil {
+				return err
+			}
+			if wm.Before(before) {
+				before = wm
+			}
+		}
+		if before.IsZero() {
+			continue
+		}
+		if err := c.backend.Delete(ctx, t.res, m, before); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Compactor) rollup(ctx context.Context, m string, src, res time.Duration, now time.Time) error {
+	start, err := c.backend.Watermark(ctx, m, res)
+	if err != nil {
+		return err
+	}
+	if start.IsZero() {
+		first, ok, err := c.backend.First(ctx, src, m)
+		if err != nil || !ok {
+			return err
+		}
+		start = first.Truncate(res)
+	}
+
+	end := now.Truncate(res)
+	for from := start; from.Before(end); {
+		to := from.Add(res * chunkBuckets)
+		if to.After(end) {
+			to = end
+		}
+
+		samples, err := c.backend.Query(ctx, src, Query{Measurement: m, Start: from, End: to})
+		if err != nil {
+			return err
+		}
+		if len(samples) > 0 {
+			if err := c.backend.Write(ctx, res, Aggregate(samples, res)); err != nil {
+				return err
+			}
+		}
+		if err := c.backend.SetWatermark(ctx, m, res, to); err != nil {
+			return err
+		}
+
+		from = to
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+// Package history 设备历史数据的存储、降采样和查询。
+// 原始数据和各级汇总数据统一用Sample表示，原始数据的Resolution为0，
+// Min=Max=Mean=值，Count=1。Backend只需要按分辨率读写删除，汇总计算在Compactor中完成。
+// SQLBackend的原始数据由Storage从设备数据复制；InfluxBackend直接使用设备写入InfluxDB的数据
+package history
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"tmios/lib/iot/device"
+)
+
+// Raw 原始数据的分辨率
+const Raw time.Duration = 0
+
+type Sample struct {
+	Measurement string            `json:"measurement"`
+	Tags        map[string]string `json:"tags"`
+	Field       string            `json:"field"`
+	Time        time.Time         `json:"time"`
+	Min         float64           `json:"min"`
+	Max         float64           `json:"max"`
+	Mean        float64           `json:"mean"`
+	Count       int64             `json:"count"`
+}
+
+// Query Tags为过滤条件，Sample的Tags包含全部键值才匹配；Fields为空时返回全部字段
+type Query struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []string
+	Start, End  time.Time // [Start, End)
+	MaxPoints   int       // 每个序列期望的最大点数，Reader据此选择分辨率
+}
+
+// Backend 历史数据存储，按分辨率读写删除样本
+type Backend interface {
+	Write(ctx context.Context, res time.Duration, samples []Sample) error
+	// Query 返回的样本按序列、字段、时间排序
+	Query(ctx context.Context, res time.Duration, q Query) ([]Sample, error)
+	Delete(ctx context.Context, res time.Duration, measurement string, before time.Time) error
+	// First 该分辨率最早的样本时间，ok为false表示没有数据
+	First(ctx context.Context, res time.Duration, measurement string) (t time.Time, ok bool, err error)
+	Measurements(ctx context.Context) ([]string, error)
+
+	// Watermark 该分辨率已汇总到的时间，之前的周期不再计算，没有时为零值
+	Watermark(ctx context.Context, measurement string, res time.Duration) (time.Time, error)
+	SetWatermark(ctx context.Context, measurement string, res time.Duration, t time.Time) error
+}
+
+var seriesEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`)
+
+// SeriesKey 序列的规范化表示"k1=v1,k2=v2,"，按tag名排序，键值中的,=\会转义
+func SeriesKey(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s,", seriesEscaper.Replace(k), seriesEscaper.Replace(tags[k]))
+	}
+
+	return b.String()
+}
+
+// sortSamples 按序列、字段、时间排序
+func sortSamples(samples []Sample) {
+	keys := make([]string, len(samples))
+	for i := range samples {
+		keys[i] = SeriesKey(samples[i].Tags)
+	}
+
+	sort.Sort(bySeries{samples, keys})
+}
+
+type bySeries struct {
+	samples []Sample
+	keys    []string
+}
+
+func (s bySeries) Len() int {
+	return len(s.samples)
+}
+
+func (s bySeries) Swap(i, j int) {
+	s.samples[i], s.samples[j] = s.samples[j], s.samples[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+func (s bySeries) Less(i, j int) bool {
+	a, b := s.samples[i], s.samples[j]
+	if s.keys[i] != s.keys[j] {
+		return s.keys[i] < s.keys[j]
+	}
+	if a.Field != b.Field {
+		return a.Field < b.Field
+	}
+	return a.Time.Before(b.Time)
+}
+
+// Match tags是否包含filter的全部键值
+func Match(tags, filter map[string]string) bool {
+	for k, v := range filter {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ToFloat 数值和bool类型转为float64，用于降采样和虚拟属性计算，其他类型返回false
+func ToFloat(val interface{}) (float64, bool) {
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// WriteRaw 把一个数据点的数值字段作为原始样本写入Backend
+func WriteRaw(ctx context.Context, backend Backend, measurement string, tags map[string]string,
+	fields map[string]interface{}, ts time.Time) error {
+	samples := make([]Sample, 0, len(fields))
+	for name, val := range fields {
+		v, ok := ToFloat(val)
+		if !ok {
+			continue
+		}
+		samples = append(samples, Sample{
+			Measurement: measurement,
+			Tags:        tags,
+			Field:       name,
+			Time:        ts,
+			Min:         v,
+			Max:         v,
+			Mean:        v,
+			Count:       1,
+		})
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+
+	return backend.Write(ctx, Raw, samples)
+}
+
+// Storage 在device.Storage的WritePoint上同时写入Backend，其他方法透传。
+// 设备数据已经写入InfluxDB时使用InfluxBackend，不需要再复制
+type Storage struct {
+	device.Storage
+	backend Backend
+}
+
+func NewStorage(inner device.Storage, backend Backend) *Storage {
+	return &Storage{Storage: inner, backend: backend}
+}
+
+func (s *Storage) WritePoint(ctx context.Context, measurement string, tags map[string]string,
+	fields map[string]interface{}, ts time.Time) error {
+	if err := WriteRaw(ctx, s.backend, measurement, tags, fields, ts); err != nil {
+		return err
+	}
+
+	return s.Storage.WritePoint(ctx, measurement, tags, fields, ts)
+}
@@ -0,0 +1,254 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"tmios/lib/influx"
+)
+
+const (
+	// watermarkMeasurement 保存汇总进度，每次更新写入一个点，读取最新的点
+	watermarkMeasurement = "tmios_history_watermark"
+	// rollupSep 汇总数据的measurement为"<measurement>@<分辨率秒数>s"，字段为"<字段>.min"等
+	rollupSep = "@"
+)
+
+var epoch = time.Unix(0, 0)
+
+// rollupFields 汇总数据的字段后缀
+var rollupFields = []string{"min", "max", "mean", "count"}
+
+// InfluxBackend 原始数据为device.Storage写入bucket的点，汇总数据写入同一bucket的
+// "<measurement>@<秒数>s"，过期数据用delete API按measurement删除。
+// 原始数据已经由设备存储写入InfluxDB，不需要用Storage再复制一份
+type InfluxBackend struct {
+	client *influx.Client
+}
+
+func NewInfluxBackend(client *influx.Client) *InfluxBackend {
+	return &InfluxBackend{client: client}
+}
+
+func rollupMeasurement(measurement string, res time.Duration) string {
+	if res == Raw {
+		return measurement
+	}
+	return fmt.Sprintf("%s%s%ds", measurement, rollupSep, seconds(res))
+}
+
+func fluxTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func (b *InfluxBackend) from(start, end time.Time) string {
+	if start.IsZero() {
+		start = epoch
+	}
+	stop := "now()"
+	if !end.IsZero() {
+		stop = fluxTime(end)
+	}
+	return fmt.Sprintf("from(bucket: %s)\n  |> range(start: %s, stop: %s)\n",
+		influx.String(b.client.Bucket()), fluxTime(start), stop)
+}
+
+func orFilter(column string, values []string) string {
+	conds := make([]string, 0, len(values))
+	for _, v := range values {
+		conds = append(conds, fmt.Sprintf("r[%s] == %s", influx.String(column), influx.String(v)))
+	}
+	return "  |> filter(fn: (r) => " + strings.Join(conds, " or ") + ")\n"
+}
+
+func (b *InfluxBackend) Write(ctx context.Context, res time.Duration, samples []Sample) error {
+	var lines []byte
+	for _, s := range samples {
+		var fields map[string]interface{}
+		if res == Raw {
+			fields = map[string]interface{}{s.Field: s.Mean}
+		} else {
+			fields = map[string]interface{}{
+				s.Field + ".min":   s.Min,
+				s.Field + ".max":   s.Max,
+				s.Field + ".mean":  s.Mean,
+				s.Field + ".count": s.Count,
+			}
+		}
+		lines = influx.AppendLine(lines, rollupMeasurement(s.Measurement, res), s.Tags, fields, s.Time)
+	}
+
+	return b.client.Write(ctx, lines)
+}
+
+// reserved Flux结果中不是tag的列
+var reserved = map[string]bool{
+	"result": true, "table": true, "_start": true, "_stop": true,
+	"_time": true, "_value": true, "_field": true, "_measurement": true,
+}
+
+func parseValue(s string) (float64, bool) {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, true
+	}
+	if v, err := strconv.ParseBool(s); err == nil {
+		return ToFloat(v)
+	}
+	return 0, false
+}
+
+func (b *InfluxBackend) Query(ctx context.Context, res time.Duration, q Query) ([]Sample, error) {
+	flux := b.from(q.Start, q.End) + orFilter("_measurement", []string{rollupMeasurement(q.Measurement, res)})
+	for k, v := range q.Tags {
+		flux += orFilter(k, []string{v})
+	}
+	if len(q.Fields) > 0 {
+		fields := q.Fields
+		if res != Raw {
+			fields = nil
+			for _, f := range q.Fields {
+				for _, suffix := range rollupFields {
+					fields = append(fields, f+"."+suffix)
+				}
+			}
+		}
+		flux += orFilter("_field", fields)
+	}
+
+	rows, err := b.client.Query(ctx, flux)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		samples []Sample
+		index   = make(map[aggKey]int)
+	)
+	for _, row := range rows {
+		t, err := time.Parse(time.RFC3339Nano, row["_time"])
+		if err != nil {
+			return nil, fmt.Errorf("influx: invalid _time %q", row["_time"])
+		}
+		v, ok := parseValue(row["_value"])
+		if !ok {
+			continue
+		}
+		tags := make(map[string]string)
+		for k, val := range row {
+			if !reserved[k] && k != "" {
+				tags[k] = val
+			}
+		}
+
+		field := row["_field"]
+		if res == Raw {
+			samples = append(samples, Sample{
+				Measurement: q.Measurement, Tags: tags, Field: field, Time: t,
+				Min: v, Max: v, Mean: v, Count: 1,
+			})
+			continue
+		}
+
+		i := strings.LastIndexByte(field, '.')
+		if i < 0 {
+			continue
+		}
+		key := aggKey{series: SeriesKey(tags), field: field[:i], time: t.UnixNano()}
+		n, ok := index[key]
+		if !ok {
+			n = len(samples)
+			index[key] = n
+			samples = append(samples, Sample{Measurement: q.Measurement, Tags: tags, Field: field[:i], Time: t})
+		}
+		switch s := &samples[n]; field[i+1:] {
+		case "min":
+			s.Min = v
+		case "max":
+			s.Max = v
+		case "mean":
+			s.Mean = v
+		case "count":
+			s.Count = int64(v)
+		}
+	}
+
+	sortSamples(samples)
+	return samples, nil
+}
+
+func (b *InfluxBackend) Delete(ctx context.Context, res time.Duration, measurement string, before time.Time) error {
+	name := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(rollupMeasurement(measurement, res))
+	return b.client.Delete(ctx, epoch, before, `_measurement="`+name+`"`)
+}
+
+func (b *InfluxBackend) First(ctx context.Context, res time.Duration, measurement string) (time.Time, bool, error) {
+	flux := b.from(time.Time{}, time.Time{}) +
+		orFilter("_measurement", []string{rollupMeasurement(measurement, res)}) +
+		"  |> first()\n  |> group()\n  |> sort(columns: [\"_time\"])\n  |> limit(n: 1)\n"
+
+	rows, err := b.client.Query(ctx, flux)
+	if err != nil || len(rows) == 0 {
+		return time.Time{}, false, err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, rows[0]["_time"])
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("influx: invalid _time %q", rows[0]["_time"])
+	}
+	return t, true, nil
+}
+
+// Measurements 不包含汇总数据和Watermark
+func (b *InfluxBackend) Measurements(ctx context.Context) ([]string, error) {
+	flux := fmt.Sprintf("import \"influxdata/influxdb/schema\"\nschema.measurements(bucket: %s)\n",
+		influx.String(b.client.Bucket()))
+
+	rows, err := b.client.Query(ctx, flux)
+	if err != nil {
+		return nil, err
+	}
+
+	var measurements []string
+	for _, row := range rows {
+		m := row["_value"]
+		if m == "" || m == watermarkMeasurement || strings.Contains(m, rollupSep) {
+			continue
+		}
+		measurements = append(measurements, m)
+	}
+
+	return measurements, nil
+}
+
+func watermarkTags(measurement string, res time.Duration) map[string]string {
+	return map[string]string{"measurement": measurement, "resolution": strconv.FormatInt(seconds(res), 10)}
+}
+
+func (b *InfluxBackend) Watermark(ctx context.Context, measurement string, res time.Duration) (time.Time, error) {
+	flux := b.from(time.Time{}, time.Time{}) + orFilter("_measurement", []string{watermarkMeasurement})
+	for k, v := range watermarkTags(measurement, res) {
+		flux += orFilter(k, []string{v})
+	}
+	flux += "  |> last()\n"
+
+	rows, err := b.client.Query(ctx, flux)
+	if err != nil || len(rows) == 0 {
+		return time.Time{}, err
+	}
+
+	ns, err := strconv.ParseInt(rows[0]["_value"], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("influx: invalid watermark %q", rows[0]["_value"])
+	}
+	return time.Unix(0, ns), nil
+}
+
+// SetWatermark 点的时间为当前时间，不会因为bucket的保留策略被丢弃
+func (b *InfluxBackend) SetWatermark(ctx context.Context, measurement string, res time.Duration, t time.Time) error {
+	line := influx.AppendLine(nil, watermarkMeasurement, watermarkTags(measurement, res),
+		map[string]interface{}{"time": t.UnixNano()}, time.Now())
+	return b.client.Write(ctx, line)
+}
@@ -0,0 +1,62 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Level 一级汇总，Keep为0表示永久保留
+type Level struct {
+	Resolution time.Duration
+	Keep       time.Duration
+}
+
+// Policy 一个measurement(即设备型号)的保留策略，Measurement为"*"表示默认策略
+type Policy struct {
+	Measurement string
+	Raw         time.Duration // 原始数据保留时间，0表示永久保留
+	Levels      []Level       // 按分辨率从细到粗
+}
+
+// Check 分辨率从细到粗且后一级是前一级的整数倍，保证汇总可以逐级计算
+func (p *Policy) Check() error {
+	sort.Slice(p.Levels, func(i, j int) bool {
+		return p.Levels[i].Resolution < p.Levels[j].Resolution
+	})
+
+	for i, level := range p.Levels {
+		if level.Resolution < time.Second {
+			return fmt.Errorf("retention %s: resolution must be at least 1s", p.Measurement)
+		}
+		if level.Keep < 0 || p.Raw < 0 {
+			return fmt.Errorf("retention %s: keep must not be negative", p.Measurement)
+		}
+		if i > 0 {
+			prev := p.Levels[i-1].Resolution
+			if level.Resolution == prev || level.Resolution%prev != 0 {
+				return fmt.Errorf("retention %s: resolution %s is not a multiple of %s",
+					p.Measurement, level.Resolution, prev)
+			}
+		}
+	}
+
+	return nil
+}
+
+type Policies []Policy
+
+// For measurement的策略，没有单独配置时使用"*"，都没有时返回nil
+func (ps Policies) For(measurement string) *Policy {
+	var def *Policy
+	for i := range ps {
+		switch ps[i].Measurement {
+		case measurement:
+			return &ps[i]
+		case "*":
+			def = &ps[i]
+		}
+	}
+
+	return def
+}
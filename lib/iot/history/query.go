@@ -0,0 +1,161 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultMaxPoints Query.MaxPoints为0时使用
+const DefaultMaxPoints = 1000
+
+// Result Resolution为实际使用的分辨率，Raw表示原始数据
+type Result struct {
+	Resolution time.Duration `json:"resolution"`
+	Samples    []Sample      `json:"samples"`
+}
+
+// Reader 按查询的时间范围自动选择分辨率
+type Reader struct {
+	backend  Backend
+	policies Policies
+}
+
+func NewReader(backend Backend, policies Policies) *Reader {
+	return &Reader{backend: backend, policies: policies}
+}
+
+// Resolution 选择保留范围覆盖Start、且点数不超过MaxPoints的最细分辨率，原始数据的点数按最细一级汇总估算；
+// 都超出时使用覆盖Start的最粗一级，都不覆盖时使用保留时间最长的一级
+func (r *Reader) Resolution(q Query, now time.Time) time.Duration {
+	p := r.policies.For(q.Measurement)
+	if p == nil || len(p.Levels) == 0 {
+		return Raw
+	}
+
+	maxPoints := q.MaxPoints
+	if maxPoints <= 0 {
+		maxPoints = DefaultMaxPoints
+	}
+
+	type tier struct {
+		res, keep, step time.Duration
+	}
+	tiers := []tier{{Raw, p.Raw, p.Levels[0].Resolution}}
+	for _, level := range p.Levels {
+		tiers = append(tiers, tier{level.Resolution, level.Keep, level.Resolution})
+	}
+
+	var (
+		span   = q.End.Sub(q.Start)
+		covers = func(t tier) bool {
+			return t.keep == 0 || !q.Start.Before(now.Add(-t.keep))
+		}
+	)
+
+	for _, t := range tiers {
+		if covers(t) && span <= t.step*time.Duration(maxPoints) {
+			return t.res
+		}
+	}
+
+	for i := len(tiers) - 1; i >= 0; i-- {
+		if covers(tiers[i]) {
+			return tiers[i].res
+		}
+	}
+
+	longest := tiers[0]
+	for _, t := range tiers[1:] {
+		if t.keep == 0 || (longest.keep != 0 && t.keep > longest.keep) {
+			longest = t
+		}
+	}
+
+	return longest.res
+}
+
+func (r *Reader) Query(ctx context.Context, q Query) (*Result, error) {
+	res := r.Resolution(q, time.Now())
+
+	levels, _ := r.levels(q.Measurement, res)
+	samples, err := r.read(ctx, levels, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Resolution: res, Samples: samples}, nil
+}
+
+// QueryAt 读取指定分辨率的数据，res须为Raw或保留策略中的一级
+func (r *Reader) QueryAt(ctx context.Context, res time.Duration, q Query) ([]Sample, error) {
+	levels, ok := r.levels(q.Measurement, res)
+	if !ok {
+		return nil, fmt.Errorf("%s has no rollup of %s", q.Measurement, res)
+	}
+
+	return r.read(ctx, levels, q)
+}
+
+// HasResolution res是否为Raw或保留策略中的一级
+func (r *Reader) HasResolution(measurement string, res time.Duration) bool {
+	_, ok := r.levels(measurement, res)
+	return ok
+}
+
+// levels 不超过res的各级汇总，ok表示res为Raw或其中一级
+func (r *Reader) levels(measurement string, res time.Duration) (levels []time.Duration, ok bool) {
+	p := r.policies.For(measurement)
+	if p == nil {
+		return nil, res == Raw
+	}
+
+	for _, level := range p.Levels {
+		if level.Resolution > res {
+			break
+		}
+		levels = append(levels, level.Resolution)
+	}
+
+	return levels, res == Raw || (len(levels) > 0 && levels[len(levels)-1] == res)
+}
+
+// read 读取levels最后一级，Watermark之后尚未汇总的部分由上一级实时汇总补齐
+func (r *Reader) read(ctx context.Context, levels []time.Duration, q Query) ([]Sample, error) {
+	if len(levels) == 0 {
+		return r.backend.Query(ctx, Raw, q)
+	}
+
+	res := levels[len(levels)-1]
+	wm, err := r.backend.Watermark(ctx, q.Measurement, res)
+	if err != nil {
+		return nil, err
+	}
+
+	head := q
+	if head.End.After(wm) {
+		head.End = wm
+	}
+
+	var samples []Sample
+	if head.Start.Before(head.End) {
+		if samples, err = r.backend.Query(ctx, res, head); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.End.After(wm) {
+		tail := q
+		if tail.Start.Before(wm) {
+			tail.Start = wm
+		}
+		finer, err := r.read(ctx, levels[:len(levels)-1], tail)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, Aggregate(finer, res)...)
+		sortSamples(samples)
+	}
+
+	return samples, nil
+}
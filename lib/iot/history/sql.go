@@ -0,0 +1,179 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const writeBatchSize = 500
+
+type sampleRow struct {
+	ID          uint64    `gorm:"primaryKey;autoIncrement"`
+	Measurement string    `gorm:"size:64;uniqueIndex:idx_history_sample,priority:1"`
+	Resolution  int64     `gorm:"uniqueIndex:idx_history_sample,priority:2"` // 秒
+	Time        time.Time `gorm:"uniqueIndex:idx_history_sample,priority:3"`
+	Series      string    `gorm:"size:255;uniqueIndex:idx_history_sample,priority:4"`
+	Field       string    `gorm:"size:64;uniqueIndex:idx_history_sample,priority:5"`
+	Tags        string    `gorm:"type:text"`
+	Min         float64
+	Max         float64
+	Mean        float64
+	Count       int64
+}
+
+func (sampleRow) TableName() string {
+	return "history_samples"
+}
+
+type watermarkRow struct {
+	Measurement string `gorm:"primaryKey;size:64"`
+	Resolution  int64  `gorm:"primaryKey;autoIncrement:false"`
+	Time        time.Time
+}
+
+func (watermarkRow) TableName() string {
+	return "history_watermarks"
+}
+
+// likeEscaper LIKE使用!作为转义字符，mysql和sqlite都支持
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// SQLBackend 使用gorm存储历史数据，原始数据和汇总数据在同一张表中按Resolution区分
+type SQLBackend struct {
+	db *gorm.DB
+}
+
+func NewSQLBackend(db *gorm.DB) (*SQLBackend, error) {
+	if err := db.AutoMigrate(&sampleRow{}, &watermarkRow{}); err != nil {
+		return nil, err
+	}
+
+	return &SQLBackend{db: db}, nil
+}
+
+func seconds(res time.Duration) int64 {
+	return int64(res / time.Second)
+}
+
+func (b *SQLBackend) Write(ctx context.Context, res time.Duration, samples []Sample) error {
+	rows := make([]sampleRow, 0, len(samples))
+	for _, s := range samples {
+		tags, err := json.Marshal(s.Tags)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, sampleRow{
+			Measurement: s.Measurement,
+			Resolution:  seconds(res),
+			Time:        s.Time.UTC(),
+			Series:      "," + SeriesKey(s.Tags),
+			Field:       s.Field,
+			Tags:        string(tags),
+			Min:         s.Min,
+			Max:         s.Max,
+			Mean:        s.Mean,
+			Count:       s.Count,
+		})
+	}
+
+	// 同一序列同一时间重复写入时覆盖，汇总中断后重新计算不会产生重复数据
+	return b.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{
+			{Name: "measurement"}, {Name: "resolution"}, {Name: "time"}, {Name: "series"}, {Name: "field"},
+		},
+		DoUpdates: clause.AssignmentColumns([]string{"tags", "min", "max", "mean", "count"}),
+	}).CreateInBatches(rows, writeBatchSize).Error
+}
+
+func (b *SQLBackend) Query(ctx context.Context, res time.Duration, q Query) ([]Sample, error) {
+	query := b.db.WithContext(ctx).Model(&sampleRow{}).
+		Where("measurement = ? AND resolution = ?", q.Measurement, seconds(res))
+	if !q.Start.IsZero() {
+		query = query.Where("time >= ?", q.Start.UTC())
+	}
+	if !q.End.IsZero() {
+		query = query.Where("time < ?", q.End.UTC())
+	}
+	if len(q.Fields) > 0 {
+		query = query.Where("field IN ?", q.Fields)
+	}
+	for k, v := range q.Tags {
+		pattern := "%," + likeEscaper.Replace(SeriesKey(map[string]string{k: v})) + "%"
+		query = query.Where("series LIKE ? ESCAPE '!'", pattern)
+	}
+
+	var rows []sampleRow
+	if err := query.Order("series, field, time").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	samples := make([]Sample, 0, len(rows))
+	for _, row := range rows {
+		var tags map[string]string
+		if err := json.Unmarshal([]byte(row.Tags), &tags); err != nil {
+			return nil, err
+		}
+		samples = append(samples, Sample{
+			Measurement: row.Measurement,
+			Tags:        tags,
+			Field:       row.Field,
+			Time:        row.Time,
+			Min:         row.Min,
+			Max:         row.Max,
+			Mean:        row.Mean,
+			Count:       row.Count,
+		})
+	}
+
+	return samples, nil
+}
+
+func (b *SQLBackend) Delete(ctx context.Context, res time.Duration, measurement string, before time.Time) error {
+	return b.db.WithContext(ctx).
+		Where("measurement = ? AND resolution = ? AND time < ?", measurement, seconds(res), before.UTC()).
+		Delete(&sampleRow{}).Error
+}
+
+func (b *SQLBackend) First(ctx context.Context, res time.Duration, measurement string) (time.Time, bool, error) {
+	var rows []sampleRow
+	err := b.db.WithContext(ctx).
+		Where("measurement = ? AND resolution = ?", measurement, seconds(res)).
+		Order("time").Limit(1).Find(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return time.Time{}, false, err
+	}
+
+	return rows[0].Time, true, nil
+}
+
+func (b *SQLBackend) Measurements(ctx context.Context) ([]string, error) {
+	var measurements []string
+	err := b.db.WithContext(ctx).Model(&sampleRow{}).Distinct().Pluck("measurement", &measurements).Error
+
+	return measurements, err
+}
+
+func (b *SQLBackend) Watermark(ctx context.Context, measurement string, res time.Duration) (time.Time, error) {
+	var rows []watermarkRow
+	err := b.db.WithContext(ctx).
+		Where("measurement = ? AND resolution = ?", measurement, seconds(res)).
+		Limit(1).Find(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return time.Time{}, err
+	}
+
+	return rows[0].Time, nil
+}
+
+func (b *SQLBackend) SetWatermark(ctx context.Context, measurement string, res time.Duration, t time.Time) error {
+	return b.db.WithContext(ctx).Save(&watermarkRow{
+		Measurement: measurement,
+		Resolution:  seconds(res),
+		Time:        t.UTC(),
+	}).Error
+}
@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"tmios/lib/iot/device"
+)
+
+var ErrStorage = errors.New("storage is not available in plugin")
+
+type pom uint
+
+func (p pom) ID() uint {
+	return uint(p)
+}
+
+// nopStorage 插件不直接访问redis/influxdb，数据通过Changes交给host提交
+type nopStorage struct{}
+
+func (nopStorage) LRange(ctx context.Context, key string, start, end int) ([]string, error) {
+	return nil, ErrStorage
+}
+
+func (nopStorage) LPush(ctx context.Context, key string, values ...string) error {
+	return ErrStorage
+}
+
+func (nopStorage) RPop(ctx context.Context, key string, c int) error {
+	return ErrStorage
+}
+
+func (nopStorage) Get(ctx context.Context, key string) (string, error) {
+	return "", ErrStorage
+}
+
+func (nopStorage) Set(ctx context.Context, key string, value string, expiration time.Duration) error {
+	return ErrStorage
+}
+
+func (nopStorage) WritePoint(ctx context.Context, measurement string, tags map[string]string,
+	fields map[string]interface{}, ts time.Time) error {
+	return ErrStorage
+}
+
+// stubDevice 插件侧按DeviceState构造的Device，驱动代码无需修改即可运行在插件中
+type stubDevice struct {
+	meta    *device.DeviceMeta
+	state   DeviceState
+	vals    map[string]interface{}
+	changed map[string]bool
+	commit  bool
+}
+
+func newStubDevice(meta *device.DeviceMeta, state DeviceState) (*stubDevice, error) {
+	d := &stubDevice{
+		meta:    meta,
+		state:   state,
+		vals:    make(map[string]interface{}),
+		changed: make(map[string]bool),
+	}
+
+	for name, raw := range state.Vals {
+		prop := meta.GetProp(name)
+		if prop == nil {
+			continue
+		}
+		val, err := device.PropVal(raw).Cast(prop)
+		if err != nil {
+			return nil, err
+		}
+		d.vals[name] = val
+	}
+
+	return d, nil
+}
+
+func (d *stubDevice) Meta() *device.DeviceMeta {
+	return d.meta
+}
+
+func (d *stubDevice) Action(ctx context.Context, name string, args []byte) ([]byte, error) {
+	return device.Action(ctx, d, name, args)
+}
+
+func (d *stubDevice) GetConfig(config interface{}) error {
+	return device.GetConfig(d.state.Config, config)
+}
+
+func (d *stubDevice) GetStorage() device.Storage {
+	return nopStorage{}
+}
+
+func (d *stubDevice) Tags() map[string]string {
+	return d.state.Tags
+}
+
+func (d *stubDevice) DebugMode() bool {
+	return d.state.Debug
+}
+
+func (d *stubDevice) POM() device.POM {
+	return pom(d.state.ID)
+}
+
+func (d *stubDevice) GetVal(name string) (interface{}, error) {
+	val, ok := d.vals[name]
+	if !ok {
+		return nil, device.ErrNil
+	}
+
+	return val, nil
+}
+
+func (d *stubDevice) GetPropVals(in interface{}) error {
+	data, err := json.Marshal(d.vals)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, in)
+}
+
+func (d *stubDevice) SetVal(name string, val interface{}, opts ...device.SetValOption) error {
+	prop := d.meta.GetProp(name)
+	if prop == nil {
+		return fmt.Errorf("property %s not found", name)
+	}
+	if err := prop.Check(val); err != nil {
+		return err
+	}
+
+	d.vals[name] = val
+	d.changed[name] = true
+
+	return nil
+}
+
+func (d *stubDevice) SetVals(vals map[string]interface{}, opts ...device.SetValOption) error {
+	for name, val := range vals {
+		if err := d.SetVal(name, val, opts...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *stubDevice) Commit(opts ...device.CommitOption) error {
+	d.commit = true
+	return nil
+}
+
+func (d *stubDevice) changes() (Changes, error) {
+	changes := Changes{
+		Vals:   make(map[string]json.RawMessage),
+		Commit: d.commit,
+	}
+
+	for name := range d.changed {
+		data, err := json.Marshal(d.vals[name])
+		if err != nil {
+			return changes, err
+		}
+		changes.Vals[name] = data
+	}
+
+	return changes, nil
+}
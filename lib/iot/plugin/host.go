@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"tmios/lib/iot/device"
+	"tmios/lib/rpc"
+)
+
+// CallTimeout ForeignID等同步调用的超时时间
+var CallTimeout = 5 * time.Second
+
+// ClientFunc 返回插件当前的连接，插件重启期间返回错误
+type ClientFunc func() (*DriverClient, error)
+
+func stateOf(dv device.Device) (DeviceState, error) {
+	var (
+		meta  = dv.Meta()
+		state = DeviceState{
+			Vals:  make(map[string]json.RawMessage),
+			Tags:  dv.Tags(),
+			Debug: dv.DebugMode(),
+		}
+	)
+
+	if pom := dv.POM(); pom != nil {
+		state.ID = pom.ID()
+	}
+
+	config := reflect.New(meta.Config.Type)
+	if err := dv.GetConfig(config.Interface()); err != nil {
+		return state, err
+	}
+	data, err := json.Marshal(config.Interface())
+	if err != nil {
+		return state, err
+	}
+	state.Config = data
+
+	for _, prop := range meta.Properties.Props {
+		val, err := dv.GetVal(prop.Name)
+		if err != nil || val == nil {
+			continue
+		}
+		data, err := json.Marshal(val)
+		if err != nil {
+			return state, err
+		}
+		state.Vals[prop.Name] = data
+	}
+
+	return state, nil
+}
+
+func apply(dv device.Device, changes Changes) error {
+	var (
+		meta = dv.Meta()
+		vals = make(map[string]interface{})
+	)
+
+	for name, raw := range changes.Vals {
+		prop := meta.GetProp(name)
+		if prop == nil {
+			continue
+		}
+		val, err := device.PropVal(raw).Cast(prop)
+		if err != nil {
+			return err
+		}
+		vals[name] = val
+	}
+
+	if len(vals) > 0 {
+		if err := dv.SetVals(vals); err != nil {
+			return err
+		}
+	}
+
+	if changes.Commit {
+		return dv.Commit()
+	}
+
+	return nil
+}
+
+// RemoteMeta 把插件注册的型号还原为DeviceMeta，action、interval、ForeignIDFunc和ProbeFunc
+// 转发给插件执行，对调用方来说和本地驱动没有区别
+func RemoteMeta(desc MetaDesc, client ClientFunc) (*device.DeviceMeta, error) {
+	var meta device.DeviceMeta
+	if err := json.Unmarshal(desc.Meta, &meta); err != nil {
+		return nil, err
+	}
+	model := meta.Model
+
+	for i, action := range meta.Actions {
+		var (
+			name  = action.Name
+			limit = action.RateLimit
+		)
+		meta.Actions[i] = device.NewRawActionMeta(action.Name, action.Desc, action.Args, action.Rets,
+			func(ctx context.Context, dv device.Device, args []byte) ([]byte, error) {
+				c, err := client()
+				if err != nil {
+					return nil, err
+				}
+
+				state, err := stateOf(dv)
+				if err != nil {
+					return nil, err
+				}
+
+				resp, err := c.Action(ctx, &ActionReq{Model: model, Device: state, Name: name, Args: args})
+				if err != nil {
+					return nil, rpc.FromStatus(err)
+				}
+
+				if err := apply(dv, resp.Changes); err != nil {
+					return nil, err
+				}
+
+				return resp.Rets, nil
+			})
+		meta.Actions[i].RateLimit = limit
+	}
+
+	for i, interval := range meta.Intervals {
+		var (
+			name    = interval.Name
+			timeout = time.Duration(interval.Interval) * time.Second
+		)
+		if timeout <= 0 {
+			timeout = CallTimeout
+		}
+
+		meta.Intervals[i].CtxFunc = func(ctx context.Context, dv device.Device) error {
+			c, err := client()
+			if err != nil {
+				return err
+			}
+
+			state, err := stateOf(dv)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			resp, err := c.Interval(ctx, &IntervalReq{Model: model, Device: state, Name: name})
+			if err != nil {
+				return rpc.FromStatus(err)
+			}
+
+			return apply(dv, resp.Changes)
+		}
+	}
+
+	if desc.HasForeign {
+		// 插件重启期间返回错误，不能当作没有外部ID
+		meta.ForeignIDErrFunc = func(config []byte) (string, error) {
+			c, err := client()
+			if err != nil {
+				return "", err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), CallTimeout)
+			defer cancel()
+
+			resp, err := c.ForeignID(ctx, &ForeignIDReq{Model: model, Config: config})
+			if err != nil {
+				return "", rpc.FromStatus(err)
+			}
+
+			return resp.ForeignID, nil
+		}
+	}
+
+	if desc.HasProbe && meta.Probe != nil {
+		meta.Probe.Func = func(ctx context.Context, candidate *device.Candidate) ([]byte, bool, error) {
+			c, err := client()
+			if err != nil {
+				return nil, false, err
+			}
+
+			resp, err := c.Probe(ctx, &ProbeReq{Model: model, Candidate: *candidate})
+			if err != nil {
+				return nil, false, rpc.FromStatus(err)
+			}
+
+			return resp.Config, resp.OK, nil
+		}
+	}
+
+	return &meta, nil
+}
@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"tmios/lib/iot/device"
+	"tmios/lib/rpc"
+)
+
+const (
+	// ProtocolVersion 握手协议版本，host与插件不一致时拒绝加载
+	ProtocolVersion = 1
+
+	// HandshakePrefix 插件启动后在stdout输出的第一行: TMIOS_PLUGIN|1|127.0.0.1:port
+	HandshakePrefix = "TMIOS_PLUGIN"
+
+	// EnvAddr 插件监听地址，默认127.0.0.1:0
+	EnvAddr = "TMIOS_PLUGIN_ADDR"
+
+	serviceName = "tmios.plugin.v1.Driver"
+)
+
+// MetaDesc 插件注册的设备型号，Meta为DeviceMeta的JSON
+type MetaDesc struct {
+	Meta       json.RawMessage        `json:"meta"`
+	Schema     map[string]interface{} `json:"schema"`
+	HasForeign bool                   `json:"has_foreign"`
+	HasProbe   bool                   `json:"has_probe"`
+}
+
+type DescribeResp struct {
+	Metas []MetaDesc `json:"metas"`
+}
+
+// DeviceState host侧设备的快照，插件按此构造Device
+type DeviceState struct {
+	ID     uint                       `json:"id"`
+	Config json.RawMessage            `json:"config"`
+	Vals   map[string]json.RawMessage `json:"vals"`
+	Tags   map[string]string          `json:"tags"`
+	Debug  bool                       `json:"debug"`
+}
+
+// Changes 插件执行过程中修改的属性，host收到后SetVals并按需Commit
+type Changes struct {
+	Vals   map[string]json.RawMessage `json:"vals"`
+	Commit bool                       `json:"commit"`
+}
+
+type ActionReq struct {
+	Model  string      `json:"model"`
+	Device DeviceState `json:"device"`
+	Name   string      `json:"name"`
+	Args   []byte      `json:"args"`
+}
+
+type ActionResp struct {
+	Rets    []byte  `json:"rets"`
+	Changes Changes `json:"changes"`
+}
+
+type IntervalReq struct {
+	Model  string      `json:"model"`
+	Device DeviceState `json:"device"`
+	Name   string      `json:"name"`
+}
+
+type IntervalResp struct {
+	Changes Changes `json:"changes"`
+}
+
+type ForeignIDReq struct {
+	Model  string `json:"model"`
+	Config []byte `json:"config"`
+}
+
+type ForeignIDResp struct {
+	ForeignID string `json:"foreign_id"`
+}
+
+type ProbeReq struct {
+	Model     string           `json:"model"`
+	Candidate device.Candidate `json:"candidate"`
+}
+
+type ProbeResp struct {
+	Config []byte `json:"config"`
+	OK     bool   `json:"ok"`
+}
+
+// DriverServer 插件侧实现
+type DriverServer interface {
+	Describe(context.Context, *rpc.Empty) (*DescribeResp, error)
+	Action(context.Context, *ActionReq) (*ActionResp, error)
+	Interval(context.Context, *IntervalReq) (*IntervalResp, error)
+	ForeignID(context.Context, *ForeignIDReq) (*ForeignIDResp, error)
+	Probe(context.Context, *ProbeReq) (*ProbeResp, error)
+}
+
+var driverServiceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*DriverServer)(nil),
+	Methods: []grpc.MethodDesc{
+		rpc.Unary(serviceName, "Describe", DriverServer.Describe),
+		rpc.Unary(serviceName, "Action", DriverServer.Action),
+		rpc.Unary(serviceName, "Interval", DriverServer.Interval),
+		rpc.Unary(serviceName, "ForeignID", DriverServer.ForeignID),
+		rpc.Unary(serviceName, "Probe", DriverServer.Probe),
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "tmios/plugin/v1",
+}
+
+// DriverClient host侧调用
+type DriverClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDriverClient(cc grpc.ClientConnInterface) *DriverClient {
+	return &DriverClient{cc}
+}
+
+func (c *DriverClient) Describe(ctx context.Context) (*DescribeResp, error) {
+	return rpc.Invoke[DescribeResp](ctx, c.cc, serviceName, "Describe", &rpc.Empty{})
+}
+
+func (c *DriverClient) Action(ctx context.Context, in *ActionReq) (*ActionResp, error) {
+	return rpc.Invoke[ActionResp](ctx, c.cc, serviceName, "Action", in)
+}
+
+func (c *DriverClient) Interval(ctx context.Context, in *IntervalReq) (*IntervalResp, error) {
+	return rpc.Invoke[IntervalResp](ctx, c.cc, serviceName, "Interval", in)
+}
+
+func (c *DriverClient) ForeignID(ctx context.Context, in *ForeignIDReq) (*ForeignIDResp, error) {
+	return rpc.Invoke[ForeignIDResp](ctx, c.cc, serviceName, "ForeignID", in)
+}
+
+func (c *DriverClient) Probe(ctx context.Context, in *ProbeReq) (*ProbeResp, error) {
+	return rpc.Invoke[ProbeResp](ctx, c.cc, serviceName, "Probe", in)
+}
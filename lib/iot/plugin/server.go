@@ -0,0 +1,181 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"tmios/lib/iot/device"
+	"tmios/lib/rpc"
+	"tmios/lib/ssl"
+)
+
+// EnvWatchStdin host设置该变量后，插件在stdin关闭(host退出)时自动退出
+const EnvWatchStdin = "TMIOS_PLUGIN_WATCH_STDIN"
+
+type server struct {
+	metas map[string]*device.DeviceMeta
+}
+
+func (s *server) meta(model string) (*device.DeviceMeta, error) {
+	meta, ok := s.metas[model]
+	if !ok {
+		return nil, fmt.Errorf("device model '%s' not found in plugin", model)
+	}
+
+	return meta, nil
+}
+
+func (s *server) Describe(ctx context.Context, _ *rpc.Empty) (*DescribeResp, error) {
+	var resp DescribeResp
+	for _, meta := range s.metas {
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Metas = append(resp.Metas, MetaDesc{
+			Meta:       data,
+			Schema:     meta.JSONSchema(),
+			HasForeign: meta.HasForeignID(),
+			HasProbe:   meta.Probe != nil && meta.Probe.Func != nil,
+		})
+	}
+
+	return &resp, nil
+}
+
+func (s *server) Action(ctx context.Context, req *ActionReq) (*ActionResp, error) {
+	meta, err := s.meta(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	dv, err := newStubDevice(meta, req.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	rets, err := device.Action(ctx, dv, req.Name, req.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := dv.changes()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ActionResp{Rets: rets, Changes: changes}, nil
+}
+
+func (s *server) Interval(ctx context.Context, req *IntervalReq) (*IntervalResp, error) {
+	meta, err := s.meta(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	var interval *device.Interval
+	for i := range meta.Intervals {
+		if meta.Intervals[i].Name == req.Name {
+			interval = &meta.Intervals[i]
+		}
+	}
+	if interval == nil || !interval.HasFunc() {
+		return nil, fmt.Errorf("interval '%s' not found", req.Name)
+	}
+
+	dv, err := newStubDevice(meta, req.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := interval.Run(ctx, dv); err != nil {
+		return nil, err
+	}
+
+	changes, err := dv.changes()
+	if err != nil {
+		return nil, err
+	}
+
+	return &IntervalResp{Changes: changes}, nil
+}
+
+func (s *server) ForeignID(ctx context.Context, req *ForeignIDReq) (*ForeignIDResp, error) {
+	meta, err := s.meta(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := meta.ForeignID(req.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ForeignIDResp{ForeignID: id}, nil
+}
+
+func (s *server) Probe(ctx context.Context, req *ProbeReq) (*ProbeResp, error) {
+	meta, err := s.meta(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	config, ok, err := meta.Match(ctx, &req.Candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProbeResp{Config: config, OK: ok}, nil
+}
+
+// Serve 插件进程的入口，在main中调用:
+//
+//	func main() {
+//		if err := plugin.Serve(meter.AcmeM100Meta); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+func Serve(metas ...*device.DeviceMeta) error {
+	s := &server{metas: make(map[string]*device.DeviceMeta)}
+	for _, meta := range metas {
+		if meta.InitFunc != nil {
+			if err := meta.InitFunc(); err != nil {
+				return err
+			}
+		}
+		s.metas[meta.Model] = meta
+	}
+
+	addr := os.Getenv(EnvAddr)
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := grpc.NewServer(ssl.Server(), rpc.ServerJSON(), grpc.UnaryInterceptor(rpc.UnaryInterceptor()))
+	srv.RegisterService(&driverServiceDesc, s)
+
+	// 日志只能写stderr，stdout第一行是握手信息
+	logrus.SetOutput(os.Stderr)
+	fmt.Printf("%s|%d|%s\n", HandshakePrefix, ProtocolVersion, lis.Addr().String())
+
+	if os.Getenv(EnvWatchStdin) != "" {
+		go func() {
+			_, _ = io.Copy(io.Discard, os.Stdin)
+			srv.Stop()
+		}()
+	}
+
+	return srv.Serve(lis)
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"tmios/lib/influx"
+	"tmios/lib/iot/device"
+)
+
+// InfluxStorage WritePoint写入InfluxDB，其他方法使用inner
+type InfluxStorage struct {
+	device.Storage
+	client *influx.Client
+}
+
+func NewInfluxStorage(inner device.Storage, client *influx.Client) *InfluxStorage {
+	return &InfluxStorage{Storage: inner, client: client}
+}
+
+func (s *InfluxStorage) WritePoint(ctx context.Context, measurement string, tags map[string]string,
+	fields map[string]interface{}, ts time.Time) error {
+	return s.client.Write(ctx, influx.AppendLine(nil, measurement, tags, fields, ts))
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"tmios/lib/iot/device"
+)
+
+type item struct {
+	value    string
+	expireAt time.Time
+}
+
+// MemStorage 内存实现的device.Storage，没有配置redis/influxdb时使用，
+// WritePoint直接丢弃
+type MemStorage struct {
+	mutex sync.Mutex
+	kv    map[string]item
+	lists map[string][]string
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		kv:    make(map[string]item),
+		lists: make(map[string][]string),
+	}
+}
+
+func (s *MemStorage) LRange(ctx context.Context, key string, start, end int) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := s.lists[key]
+	if start < 0 {
+		start += len(list)
+	}
+	if end < 0 {
+		end += len(list)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= len(list) {
+		end = len(list) - 1
+	}
+	if start > end {
+		return nil, nil
+	}
+
+	return append([]string(nil), list[start:end+1]...), nil
+}
+
+func (s *MemStorage) LPush(ctx context.Context, key string, values ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, v := range values {
+		s.lists[key] = append([]string{v}, s.lists[key]...)
+	}
+
+	return nil
+}
+
+func (s *MemStorage) RPop(ctx context.Context, key string, c int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := s.lists[key]
+	if c > len(list) {
+		c = len(list)
+	}
+	s.lists[key] = list[:len(list)-c]
+
+	return nil
+}
+
+func (s *MemStorage) Get(ctx context.Context, key string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	it, ok := s.kv[key]
+	if !ok || (!it.expireAt.IsZero() && time.Now().After(it.expireAt)) {
+		delete(s.kv, key)
+		return "", device.ErrNil
+	}
+
+	return it.value, nil
+}
+
+func (s *MemStorage) Set(ctx context.Context, key string, value string, expiration time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	it := item{value: value}
+	if expiration > 0 {
+		it.expireAt = time.Now().Add(expiration)
+	}
+	s.kv[key] = it
+
+	return nil
+}
+
+func (s *MemStorage) WritePoint(ctx context.Context, measurement string, tags map[string]string,
+	fields map[string]interface{}, ts time.Time) error {
+	return nil
+}
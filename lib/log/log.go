@@ -1,11 +1,102 @@
 package log
 
 import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+var (
+	mutex sync.Mutex
+	file  *lumberjack.Logger
+)
+
+// minSecret 过短的值替换后会误伤普通日志，不做替换
+const minSecret = 4
+
+const redacted = "******"
+
+var (
+	smutex  sync.RWMutex
+	secrets = make(map[string]struct{})
+)
+
+func init() {
+	log.AddHook(redactHook{})
+}
+
+// Redact 注册不能出现在日志中的值，例如解密后的密码，日志的消息和字段中出现时替换为******
+func Redact(values ...string) {
+	smutex.Lock()
+	defer smutex.Unlock()
+
+	for _, v := range values {
+		if len(v) >= minSecret {
+			secrets[v] = struct{}{}
+		}
+	}
+}
+
+func redact(s string) string {
+	smutex.RLock()
+	defer smutex.RUnlock()
+
+	for secret := range secrets {
+		if strings.Contains(s, secret) {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+	return s
+}
+
+// redactHook 在格式化之前替换消息和字段，error等字段包含注册的值时替换为字符串
+type redactHook struct{}
+
+func (redactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (redactHook) Fire(entry *log.Entry) error {
+	smutex.RLock()
+	empty := len(secrets) == 0
+	smutex.RUnlock()
+	if empty {
+		return nil
+	}
+
+	entry.Message = redact(entry.Message)
+	data := make(log.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		data[k] = v
+		var str string
+		switch v := v.(type) {
+		case string:
+			str = v
+		case error:
+			str = v.Error()
+		case fmt.Stringer:
+			str = v.String()
+		default:
+			continue
+		}
+		if r := redact(str); r != str {
+			data[k] = r
+		}
+	}
+	// Data可能与其他Entry共享，替换而不是修改
+	entry.Data = data
+	return nil
+}
+
+// Init 可以重复调用，重新设置时关闭之前的日志文件；level为空时为info，filePath为空时输出到stderr
 func Init(level string, filePath string) error {
+	if level == "" {
+		level = "info"
+	}
 	lvl, err := log.ParseLevel(level)
 	if err != nil {
 		return err
@@ -13,15 +104,27 @@ func Init(level string, filePath string) error {
 
 	log.SetLevel(lvl)
 	log.SetReportCaller(true)
-	if filePath != "" {
-		log.SetOutput(&lumberjack.Logger{
-			Filename:   filePath,
-			MaxSize:    20, // megabytes
-			MaxBackups: 10,
-			MaxAge:     30,   //days
-			Compress:   true, // disabled by default
-		})
 
+	mutex.Lock()
+	defer mutex.Unlock()
+	if file == nil || file.Filename != filePath {
+		old := file
+		file = nil
+		if filePath != "" {
+			file = &lumberjack.Logger{
+				Filename:   filePath,
+				MaxSize:    20, // megabytes
+				MaxBackups: 10,
+				MaxAge:     30,   //days
+				Compress:   true, // disabled by default
+			}
+			log.SetOutput(file)
+		} else {
+			log.SetOutput(os.Stderr)
+		}
+		if old != nil {
+			_ = old.Close()
+		}
 	}
 
 	log.WithField("LogLevel", level).Warn("log inited")
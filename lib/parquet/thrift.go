@@ -0,0 +1,107 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// thrift compact协议的类型，只实现写入footer和页头需要的部分
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+type thriftWriter struct {
+	buf   []byte
+	last  []int16 // 嵌套结构体的上一个字段ID
+	field int16
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	t.buf = append(t.buf, b[:n]...)
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.field; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.zigzag(int64(id))
+	}
+	t.field = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.fieldHeader(id, thriftBinary)
+	t.varint(uint64(len(v)))
+	t.buf = append(t.buf, v...)
+}
+
+func (t *thriftWriter) listHeader(id int16, typ byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf = append(t.buf, byte(size)<<4|typ)
+	} else {
+		t.buf = append(t.buf, 0xf0|typ)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) i32List(id int16, vals []int32) {
+	t.listHeader(id, thriftI32, len(vals))
+	for _, v := range vals {
+		t.zigzag(int64(v))
+	}
+}
+
+func (t *thriftWriter) stringList(id int16, vals []string) {
+	t.listHeader(id, thriftBinary, len(vals))
+	for _, v := range vals {
+		t.varint(uint64(len(v)))
+		t.buf = append(t.buf, v...)
+	}
+}
+
+// beginStruct 作为字段的结构体，id为0时表示list的元素
+func (t *thriftWriter) beginStruct(id int16) {
+	if id != 0 {
+		t.fieldHeader(id, thriftStruct)
+	}
+	t.last = append(t.last, t.field)
+	t.field = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	t.field = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+func appendFloat64(buf []byte, v float64) []byte {
+	return appendUint64(buf, math.Float64bits(v))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
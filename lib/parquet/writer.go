@@ -0,0 +1,268 @@
+// Package parquet 不依赖第三方库的Parquet写入，只支持平铺的必填列，
+// PLAIN编码、不压缩，每个行组的每列写成一个数据页
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const magic = "PAR1"
+
+// DefaultRowGroupRows 行组的默认行数，Write达到该行数时自动写出
+const DefaultRowGroupRows = 100000
+
+type Type int
+
+const (
+	Int64     Type = iota
+	Double         // float64
+	String         // UTF8字符串
+	Timestamp      // 毫秒时间戳，值为time.Time
+)
+
+// 物理类型、converted type和页头的枚举值
+const (
+	physicalInt64     = 2
+	physicalDouble    = 5
+	physicalByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	pageData      = 0
+	repetitionReq = 0
+	codecNone     = 0
+	fileVersion   = 1
+	createdBy     = "tmios"
+)
+
+type Column struct {
+	Name string
+	Type Type
+}
+
+func (c Column) physical() int32 {
+	switch c.Type {
+	case Double:
+		return physicalDouble
+	case String:
+		return physicalByteArray
+	}
+	return physicalInt64
+}
+
+type chunk struct {
+	offset int64
+	size   int64
+}
+
+type rowGroup struct {
+	chunks []chunk
+	rows   int64
+	size   int64
+}
+
+type Writer struct {
+	RowGroupRows int
+
+	w       io.Writer
+	offset  int64
+	columns []Column
+	pages   [][]byte
+	rows    int
+	total   int64
+	groups  []rowGroup
+}
+
+func NewWriter(w io.Writer, columns []Column) *Writer {
+	return &Writer{
+		RowGroupRows: DefaultRowGroupRows,
+		w:            w,
+		columns:      columns,
+		pages:        make([][]byte, len(columns)),
+	}
+}
+
+// Write 写入一行，值的类型与列对应: Int64为int64或int，Double为float64，String为string，Timestamp为time.Time
+func (w *Writer) Write(row []interface{}) error {
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("parquet: expect %d values, got %d", len(w.columns), len(row))
+	}
+
+	// 先检查全部类型，避免写入半行
+	for i, col := range w.columns {
+		ok := false
+		switch row[i].(type) {
+		case int64, int:
+			ok = col.Type == Int64
+		case float64:
+			ok = col.Type == Double
+		case string:
+			ok = col.Type == String
+		case time.Time:
+			ok = col.Type == Timestamp
+		}
+		if !ok {
+			return fmt.Errorf("parquet: column %s: unexpected %T", col.Name, row[i])
+		}
+	}
+
+	for i, val := range row {
+		page := w.pages[i]
+		switch v := val.(type) {
+		case int64:
+			page = appendUint64(page, uint64(v))
+		case int:
+			page = appendUint64(page, uint64(v))
+		case float64:
+			page = appendFloat64(page, v)
+		case string:
+			var n [4]byte
+			binary.LittleEndian.PutUint32(n[:], uint32(len(v)))
+			page = append(append(page, n[:]...), v...)
+		case time.Time:
+			page = appendUint64(page, uint64(v.UnixMilli()))
+		}
+		w.pages[i] = page
+	}
+	w.rows++
+
+	if w.RowGroupRows > 0 && w.rows >= w.RowGroupRows {
+		return w.Flush()
+	}
+	return nil
+}
+
+// begin 写入文件头
+func (w *Writer) begin() error {
+	if w.offset > 0 {
+		return nil
+	}
+	return w.write([]byte(magic))
+}
+
+func (w *Writer) write(data []byte) error {
+	n, err := w.w.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+// Flush 把缓存的行写成一个行组
+func (w *Writer) Flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	if err := w.begin(); err != nil {
+		return err
+	}
+
+	group := rowGroup{rows: int64(w.rows)}
+	for i, page := range w.pages {
+		t := &thriftWriter{}
+		t.beginStruct(0)
+		t.i32(1, pageData)
+		t.i32(2, int32(len(page)))
+		t.i32(3, int32(len(page)))
+		t.beginStruct(5)
+		t.i32(1, int32(w.rows))
+		t.i32(2, encodingPlain)
+		t.i32(3, encodingRLE)
+		t.i32(4, encodingRLE)
+		t.endStruct()
+		t.endStruct()
+
+		c := chunk{offset: w.offset, size: int64(len(t.buf) + len(page))}
+		if err := w.write(t.buf); err != nil {
+			return err
+		}
+		if err := w.write(page); err != nil {
+			return err
+		}
+
+		group.chunks = append(group.chunks, c)
+		group.size += c.size
+		w.pages[i] = page[:0]
+	}
+
+	w.groups = append(w.groups, group)
+	w.total += int64(w.rows)
+	w.rows = 0
+
+	return nil
+}
+
+// Close 写出剩余的行和文件尾，不关闭底层的io.Writer
+func (w *Writer) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := w.begin(); err != nil {
+		return err
+	}
+
+	t := &thriftWriter{}
+	t.beginStruct(0)
+	t.i32(1, fileVersion)
+
+	t.listHeader(2, thriftStruct, len(w.columns)+1)
+	t.beginStruct(0)
+	t.binary(4, "schema")
+	t.i32(5, int32(len(w.columns)))
+	t.endStruct()
+	for _, col := range w.columns {
+		t.beginStruct(0)
+		t.i32(1, col.physical())
+		t.i32(3, repetitionReq)
+		t.binary(4, col.Name)
+		switch col.Type {
+		case String:
+			t.i32(6, convertedUTF8)
+		case Timestamp:
+			t.i32(6, convertedTimestampMillis)
+		}
+		t.endStruct()
+	}
+
+	t.i64(3, w.total)
+
+	t.listHeader(4, thriftStruct, len(w.groups))
+	for _, group := range w.groups {
+		t.beginStruct(0)
+		t.listHeader(1, thriftStruct, len(group.chunks))
+		for i, c := range group.chunks {
+			t.beginStruct(0)
+			t.i64(2, c.offset)
+			t.beginStruct(3)
+			t.i32(1, w.columns[i].physical())
+			t.i32List(2, []int32{encodingPlain})
+			t.stringList(3, []string{w.columns[i].Name})
+			t.i32(4, codecNone)
+			t.i64(5, group.rows)
+			t.i64(6, c.size)
+			t.i64(7, c.size)
+			t.i64(9, c.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, group.size)
+		t.i64(3, group.rows)
+		t.endStruct()
+	}
+
+	t.binary(6, createdBy)
+	t.endStruct()
+
+	footer := t.buf
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(footer)))
+	footer = append(footer, n[:]...)
+	footer = append(footer, magic...)
+
+	return w.write(footer)
+}
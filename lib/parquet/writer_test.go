@@ -0,0 +1,347 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// tstruct 按thrift compact协议解出的结构体，字段ID到值，值为int64、string、[]interface{}或tstruct
+type tstruct map[int16]interface{}
+
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		panic(fmt.Sprintf("invalid varint at %d", r.pos))
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		s := string(r.buf[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case thriftList:
+		h := r.byte()
+		size := int(h >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.value(h & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d at %d", typ, r.pos))
+}
+
+func (r *thriftReader) readStruct() tstruct {
+	s := make(tstruct)
+	var id int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return s
+		}
+		if delta := int16(h >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.zigzag())
+		}
+		s[id] = r.value(h & 0x0f)
+	}
+}
+
+func (s tstruct) int(id int16) int64 {
+	return s[id].(int64)
+}
+
+func (s tstruct) list(id int16) []interface{} {
+	return s[id].([]interface{})
+}
+
+type parsed struct {
+	meta    tstruct
+	schema  []tstruct
+	rows    [][]interface{}
+	groups  int
+	numRows int64
+}
+
+// readFile 按Parquet规范解析Writer写出的文件，检查magic、footer长度、列块的偏移和大小，返回全部行
+func readFile(t *testing.T, data []byte) *parsed {
+	t.Helper()
+
+	if !bytes.HasPrefix(data, []byte(magic)) || !bytes.HasSuffix(data, []byte(magic)) {
+		t.Fatalf("missing magic")
+	}
+	n := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footerStart := len(data) - 8 - n
+	r := &thriftReader{buf: data[footerStart : len(data)-8]}
+	meta := r.readStruct()
+	if r.pos != n {
+		t.Fatalf("footer length %d, decoded %d", n, r.pos)
+	}
+
+	p := &parsed{meta: meta, numRows: meta.int(3)}
+	for _, e := range meta.list(2) {
+		p.schema = append(p.schema, e.(tstruct))
+	}
+	columns := p.schema[1:]
+	if int(p.schema[0].int(5)) != len(columns) {
+		t.Fatalf("root num_children %d, got %d columns", p.schema[0].int(5), len(columns))
+	}
+
+	end := int64(len(magic))
+	for _, g := range meta.list(4) {
+		group := g.(tstruct)
+		p.groups++
+		rows := int(group.int(3))
+		values := make([][]interface{}, len(columns))
+		var size int64
+		for i, c := range group.list(1) {
+			cc := c.(tstruct)
+			cm := cc[3].(tstruct)
+			offset := cm.int(9)
+			if offset != cc.int(2) || offset != end {
+				t.Fatalf("column %d: offset %d, file_offset %d, expect %d", i, offset, cc.int(2), end)
+			}
+			if cm.int(1) != columns[i].int(1) {
+				t.Fatalf("column %d: type %d, schema type %d", i, cm.int(1), columns[i].int(1))
+			}
+			if path := cm.list(3); len(path) != 1 || path[0] != columns[i][4] {
+				t.Fatalf("column %d: path %v", i, path)
+			}
+			if cm.int(5) != int64(rows) {
+				t.Fatalf("column %d: num_values %d, expect %d", i, cm.int(5), rows)
+			}
+
+			pr := &thriftReader{buf: data[offset:]}
+			header := pr.readStruct()
+			dph := header[5].(tstruct)
+			if header.int(1) != pageData || dph.int(1) != int64(rows) || dph.int(2) != encodingPlain {
+				t.Fatalf("column %d: unexpected page header %v", i, header)
+			}
+			pageSize := int(header.int(2))
+			if int64(pr.pos+pageSize) != cm.int(6) {
+				t.Fatalf("column %d: chunk size %d, expect %d", i, cm.int(6), pr.pos+pageSize)
+			}
+			values[i] = decodePlain(t, columns[i], pr.buf[pr.pos:pr.pos+pageSize], rows)
+			end += cm.int(6)
+			size += cm.int(6)
+		}
+		if group.int(2) != size {
+			t.Fatalf("row group total_byte_size %d, expect %d", group.int(2), size)
+		}
+
+		for j := 0; j < rows; j++ {
+			row := make([]interface{}, len(columns))
+			for i := range columns {
+				row[i] = values[i][j]
+			}
+			p.rows = append(p.rows, row)
+		}
+	}
+	if end != int64(footerStart) {
+		t.Fatalf("column chunks end at %d, footer starts at %d", end, footerStart)
+	}
+
+	return p
+}
+
+func decodePlain(t *testing.T, col tstruct, page []byte, rows int) []interface{} {
+	t.Helper()
+
+	var (
+		vals      []interface{}
+		converted = int64(-1)
+	)
+	if v, ok := col[6]; ok {
+		converted = v.(int64)
+	}
+	for len(page) > 0 {
+		switch col.int(1) {
+		case physicalInt64:
+			v := int64(binary.LittleEndian.Uint64(page))
+			if converted == convertedTimestampMillis {
+				vals = append(vals, time.UnixMilli(v))
+			} else {
+				vals = append(vals, v)
+			}
+			page = page[8:]
+		case physicalDouble:
+			vals = append(vals, math.Float64frombits(binary.LittleEndian.Uint64(page)))
+			page = page[8:]
+		case physicalByteArray:
+			n := binary.LittleEndian.Uint32(page)
+			vals = append(vals, string(page[4:4+n]))
+			page = page[4+n:]
+		default:
+			t.Fatalf("unexpected physical type %d", col.int(1))
+		}
+	}
+	if len(vals) != rows {
+		t.Fatalf("decoded %d values, expect %d", len(vals), rows)
+	}
+	return vals
+}
+
+func TestRoundTrip(t *testing.T) {
+	columns := []Column{
+		{Name: "time", Type: Timestamp},
+		{Name: "device", Type: String},
+		{Name: "count", Type: Int64},
+		{Name: "value", Type: Double},
+	}
+	base := time.UnixMilli(1700000000123)
+
+	var (
+		buf  bytes.Buffer
+		rows [][]interface{}
+	)
+	w := NewWriter(&buf, columns)
+	w.RowGroupRows = 3
+	for i := 0; i < 8; i++ {
+		row := []interface{}{base.Add(time.Duration(i) * time.Second), fmt.Sprintf("设备%d", i), int64(-i), float64(i) / 3}
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := readFile(t, buf.Bytes())
+	if p.groups != 3 || p.numRows != 8 {
+		t.Fatalf("expect 3 row groups and 8 rows, got %d and %d", p.groups, p.numRows)
+	}
+	if p.meta.int(1) != fileVersion || p.meta[6] != createdBy {
+		t.Fatalf("unexpected file metadata %v", p.meta)
+	}
+	for i, col := range columns {
+		if p.schema[i+1][4] != col.Name {
+			t.Fatalf("schema %d: name %v, expect %s", i, p.schema[i+1][4], col.Name)
+		}
+	}
+	if !reflect.DeepEqual(p.rows, rows) {
+		t.Fatalf("rows differ:\n got %v\nwant %v", p.rows, rows)
+	}
+}
+
+// TestManyColumns 超过14个元素的list使用长格式的头
+func TestManyColumns(t *testing.T) {
+	var (
+		columns []Column
+		row     []interface{}
+	)
+	for i := 0; i < 20; i++ {
+		columns = append(columns, Column{Name: fmt.Sprintf("c%d", i), Type: Int64})
+		row = append(row, int64(i))
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, columns)
+	if err := w.Write(row); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := readFile(t, buf.Bytes())
+	if len(p.schema) != 21 || !reflect.DeepEqual(p.rows, [][]interface{}{row}) {
+		t.Fatalf("unexpected schema %d or rows %v", len(p.schema), p.rows)
+	}
+}
+
+// TestEmpty 没有行时仍是合法的文件
+func TestEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriter(&buf, []Column{{Name: "v", Type: Double}}).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := readFile(t, buf.Bytes())
+	if p.groups != 0 || p.numRows != 0 {
+		t.Fatalf("expect no rows, got %d groups and %d rows", p.groups, p.numRows)
+	}
+}
+
+func TestWriteMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, []Column{{Name: "a", Type: Int64}, {Name: "b", Type: String}})
+	if err := w.Write([]interface{}{int64(1), 2.0}); err == nil {
+		t.Fatal("expect type error")
+	}
+	if err := w.Write([]interface{}{int64(1)}); err == nil {
+		t.Fatal("expect length error")
+	}
+	if err := w.Write([]interface{}{1, "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 出错的行没有写入一半
+	p := readFile(t, buf.Bytes())
+	if !reflect.DeepEqual(p.rows, [][]interface{}{{int64(1), "x"}}) {
+		t.Fatalf("unexpected rows %v", p.rows)
+	}
+}
+
+// TestThriftCompact 按thrift compact协议规范手算的字节
+func TestThriftCompact(t *testing.T) {
+	w := &thriftWriter{}
+	w.beginStruct(0)
+	w.i32(1, 1)       // 短格式头 0x15，zigzag(1)=2
+	w.binary(4, "ab") // delta 3
+	w.i64(20, -3)     // delta 16，长格式头 0x06 + zigzag(20)
+	w.beginStruct(21)
+	w.i32List(1, []int32{0, -1})
+	w.endStruct()
+	w.endStruct()
+
+	expect := []byte{
+		0x15, 0x02,
+		0x38, 0x02, 'a', 'b',
+		0x06, 0x28, 0x05,
+		0x1c,
+		0x19, 0x25, 0x00, 0x01,
+		0x00,
+		0x00,
+	}
+	if !bytes.Equal(w.buf, expect) {
+		t.Fatalf("got % x\nwant % x", w.buf, expect)
+	}
+}
@@ -0,0 +1,239 @@
+// Package redis 最小的RESP客户端，只实现会话等简单键值需要的命令
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ErrNil 键不存在
+var ErrNil = errors.New("redis: nil")
+
+// Error 服务端返回的错误
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+const (
+	defaultTimeout = 5 * time.Second
+	maxIdle        = 8
+)
+
+type Client struct {
+	addr     string
+	password string
+	db       int
+	idle     chan *conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func NewClient(addr, password string, db int) *Client {
+	return &Client{
+		addr:     addr,
+		password: password,
+		db:       db,
+		idle:     make(chan *conn, maxIdle),
+	}
+}
+
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	d := net.Dialer{Timeout: defaultTimeout}
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if c.password != "" {
+		if _, err := cn.do(ctx, "AUTH", c.password); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := cn.do(ctx, "SELECT", c.db); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+
+	return cn, nil
+}
+
+// Do 执行命令，返回string、int64、[]interface{}或nil，nil的bulk返回ErrNil
+func (c *Client) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	var cn *conn
+	select {
+	case cn = <-c.idle:
+	default:
+		var err error
+		if cn, err = c.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := cn.do(ctx, args...)
+	var redisErr Error
+	if err != nil && err != ErrNil && !errors.As(err, &redisErr) {
+		// 网络错误后连接状态未知，不再复用
+		cn.Close()
+		return nil, err
+	}
+
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+
+	return reply, err
+}
+
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	reply, err := c.Do(ctx, "GET", key)
+	if err != nil {
+		return "", err
+	}
+	s, _ := reply.(string)
+	return s, nil
+}
+
+// Set ttl为0时不过期
+func (c *Client) Set(ctx context.Context, key, val string, ttl time.Duration) error {
+	args := []interface{}{"SET", key, val}
+	if ttl > 0 {
+		args = append(args, "PX", ttl.Milliseconds())
+	}
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+func (c *Client) Del(ctx context.Context, keys ...string) error {
+	args := []interface{}{"DEL"}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+func (c *Client) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := c.Do(ctx, "PEXPIRE", key, ttl.Milliseconds())
+	return err
+}
+
+func (cn *conn) do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(cn.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		default:
+			s = fmt.Sprint(v)
+		}
+		fmt.Fprintf(cn.w, "$%d\r\n%s\r\n", len(s), s)
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return cn.read()
+}
+
+func (cn *conn) line() (string, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: invalid reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func (cn *conn) read() (interface{}, error) {
+	line, err := cn.line()
+	if err != nil {
+		return nil, err
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		arr := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := cn.read()
+			if err != nil && err != ErrNil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	}
+
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
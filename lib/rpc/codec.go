@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc"
+)
+
+// Name content-subtype，请求头为 application/grpc+json
+const Name = "json"
+
+// Codec 用JSON编码gRPC消息，服务描述手写，不依赖protoc生成代码。
+// 服务是JSON-over-gRPC，不兼容protobuf生成的客户端和grpcurl等工具，
+// 客户端需要用CallJSON，服务端用ServerJSON，不向grpc注册全局codec
+type Codec struct{}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (Codec) Name() string {
+	return Name
+}
+
+// CallJSON 客户端使用JSON编码，请求头为application/grpc+json
+func CallJSON() grpc.DialOption {
+	return grpc.WithDefaultCallOptions(grpc.ForceCodec(Codec{}))
+}
+
+// ServerJSON 服务端按JSON解码全部请求，protobuf编码的请求返回解码错误
+func ServerJSON() grpc.ServerOption {
+	return grpc.ForceServerCodec(Codec{})
+}
+
+// Empty 无参数或无返回
+type Empty struct{}
@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Unary 手写ServiceDesc时构造一元方法，S为服务接口
+func Unary[S any, Req any, Resp any](service, method string, call func(S, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(Req)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(S), ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + service + "/" + method,
+			}
+			return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(S), ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+// Invoke 客户端调用一元方法
+func Invoke[Resp any](ctx context.Context, cc grpc.ClientConnInterface, service, method string, in interface{}) (*Resp, error) {
+	out := new(Resp)
+	if err := cc.Invoke(ctx, "/"+service+"/"+method, in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
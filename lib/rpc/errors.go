@@ -0,0 +1,110 @@
+package rpc
+
+import (
+	"context"
+	stderrors "errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tmios/lib/errors"
+)
+
+func statusCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case 400:
+		return codes.InvalidArgument
+	case 401:
+		return codes.Unauthenticated
+	case 403:
+		return codes.PermissionDenied
+	case 404:
+		return codes.NotFound
+	case 409, 423:
+		return codes.FailedPrecondition
+	case 429:
+		return codes.ResourceExhausted
+	case 503:
+		return codes.Unavailable
+	case 504:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
+
+// ToStatus lib/errors.Error 转为gRPC status，message为Error的JSON，客户端可用FromStatus还原
+func ToStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	// 例如等待设备的Gate时调用方取消或超时
+	switch {
+	case stderrors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case stderrors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	e, ok := err.(errors.Error)
+	if !ok {
+		e = errors.Internal("internal error", err)
+	}
+
+	return status.Error(statusCode(e.Status), e.Error())
+}
+
+// FromStatus 还原服务端返回的lib/errors.Error
+func FromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	e := errors.Parse(st.Message())
+	if e.Code != 0 {
+		return e
+	}
+	switch st.Code() {
+	case codes.Canceled:
+		return context.Canceled
+	case codes.DeadlineExceeded:
+		return context.DeadlineExceeded
+	}
+
+	return errors.Internal(st.Message(), err)
+}
+
+func logCall(method string, start time.Time, err error) {
+	entry := logrus.WithField("method", method).WithField("latency", time.Since(start))
+	if err != nil {
+		entry.WithError(err).Warn("grpc call failed")
+	} else {
+		entry.Debug("grpc call")
+	}
+}
+
+// UnaryInterceptor 记录调用日志并转换错误
+func UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logCall(info.FullMethod, start, err)
+		return resp, ToStatus(err)
+	}
+}
+
+// StreamInterceptor 记录调用日志并转换错误
+func StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logCall(info.FullMethod, start, err)
+		return ToStatus(err)
+	}
+}
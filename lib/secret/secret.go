@@ -0,0 +1,192 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Prefix 加密值的前缀，完整格式为enc:v1:<key id>:<base64(nonce+密文)>，使用AES-256-GCM
+const Prefix = "enc:v1:"
+
+const keySize = 32
+
+// Pattern 匹配文本中的加密值
+var Pattern = regexp.MustCompile(`enc:v1:[A-Za-z0-9_.-]+:[A-Za-z0-9+/]+=*`)
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Key 一个密钥，ID写在加密值中，解密时按ID选择密钥
+type Key struct {
+	ID  string
+	Key []byte
+}
+
+// Keyring 第一个密钥用于加密，其余的只用于解密，轮换时把新密钥放在最前面
+type Keyring struct {
+	keys []Key
+}
+
+// IsEncrypted 是否为加密值
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// GenerateKey 生成密钥，返回可以直接写入密钥文件的一行"id:base64"
+func GenerateKey(id string) (string, error) {
+	if !idPattern.MatchString(id) {
+		return "", fmt.Errorf("secret: invalid key id %q", id)
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ParseKeyring 每行或每个逗号分隔一个"id:base64"，忽略空行和#开头的注释
+func ParseKeyring(text string) (*Keyring, error) {
+	var (
+		k    = &Keyring{}
+		seen = make(map[string]bool)
+	)
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(line, ":")
+		if !ok || !idPattern.MatchString(id) {
+			return nil, errors.New("secret: key must be id:base64")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("secret: key %s must be %d bytes in base64", id, keySize)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("secret: duplicate key %s", id)
+		}
+		seen[id] = true
+		k.keys = append(k.keys, Key{ID: id, Key: key})
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("secret: no key found")
+	}
+	return k, nil
+}
+
+// LoadKeyring 从文件读取密钥
+func LoadKeyring(file string) (*Keyring, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyring(string(data))
+}
+
+// Primary 加密使用的密钥ID
+func (k *Keyring) Primary() string {
+	return k.keys[0].ID
+}
+
+func (k *Keyring) key(id string) ([]byte, bool) {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key.Key, true
+		}
+	}
+	return nil, false
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt 用第一个密钥加密，密钥ID作为附加数据，改动ID后不能解密
+func (k *Keyring) Encrypt(plain string) (string, error) {
+	key := k.keys[0]
+	gcm, err := newGCM(key.Key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	header := Prefix + key.ID + ":"
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), []byte(header))
+	return header + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 按加密值中的密钥ID解密，不是加密值时原样返回
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, Prefix), ":")
+	if !ok {
+		return "", errors.New("secret: malformed encrypted value")
+	}
+	key, ok := k.key(id)
+	if !ok {
+		return "", fmt.Errorf("secret: unknown key %s", id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.New("secret: malformed encrypted value")
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("secret: malformed encrypted value")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(Prefix+id+":"))
+	if err != nil {
+		return "", fmt.Errorf("secret: decrypt with key %s failed", id)
+	}
+	return string(plain), nil
+}
+
+// Rotate 把text中的加密值全部改用第一个密钥重新加密，返回新的文本和改动的数量
+func (k *Keyring) Rotate(text string) (string, int, error) {
+	var (
+		n        int
+		firstErr error
+	)
+	out := Pattern.ReplaceAllStringFunc(text, func(value string) string {
+		if firstErr != nil || strings.HasPrefix(value, Prefix+k.Primary()+":") {
+			return value
+		}
+		plain, err := k.Decrypt(value)
+		if err == nil {
+			value, err = k.Encrypt(plain)
+		}
+		if err != nil {
+			firstErr = err
+			return value
+		}
+		n++
+		return value
+	})
+	if firstErr != nil {
+		return "", 0, firstErr
+	}
+	return out, n, nil
+}
@@ -0,0 +1,161 @@
+package secret
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func newKeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+
+	var lines []string
+	for _, id := range ids {
+		line, err := GenerateKey(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	k, err := ParseKeyring(strings.Join(lines, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := newKeyring(t, "k1")
+	for _, plain := range []string{"", "123456", "密码 with spaces\n"} {
+		enc, err := k.Encrypt(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(enc) || !Pattern.MatchString(enc) || !strings.HasPrefix(enc, Prefix+"k1:") {
+			t.Fatalf("unexpected encrypted value %s", enc)
+		}
+		got, err := k.Decrypt(enc)
+		if err != nil || got != plain {
+			t.Errorf("decrypt %q: %q %v", plain, got, err)
+		}
+	}
+
+	// 相同明文每次的nonce不同
+	a, _ := k.Encrypt("same")
+	b, _ := k.Encrypt("same")
+	if a == b {
+		t.Error("expect different ciphertexts")
+	}
+
+	// 不是加密值时原样返回
+	if got, err := k.Decrypt("plain"); err != nil || got != "plain" {
+		t.Errorf("decrypt plain: %q %v", got, err)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	enc, err := newKeyring(t, "k1").Encrypt("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ID相同但密钥不同
+	if _, err := newKeyring(t, "k1").Decrypt(enc); err == nil {
+		t.Error("expect error with another k1")
+	}
+	// 没有该ID的密钥
+	if _, err := newKeyring(t, "k2").Decrypt(enc); err == nil {
+		t.Error("expect error with unknown key id")
+	}
+}
+
+func TestTampered(t *testing.T) {
+	k := newKeyring(t, "k1", "k2")
+	enc, err := k.Encrypt("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := strings.TrimPrefix(enc, Prefix+"k1:")
+	sealed, _ := base64.StdEncoding.DecodeString(encoded)
+
+	flip := func(i int) string {
+		b := append([]byte(nil), sealed...)
+		b[i] ^= 1
+		return Prefix + "k1:" + base64.StdEncoding.EncodeToString(b)
+	}
+	cases := map[string]string{
+		"nonce":      flip(0),
+		"ciphertext": flip(len(sealed) - 20),
+		"tag":        flip(len(sealed) - 1),
+		"truncated":  Prefix + "k1:" + base64.StdEncoding.EncodeToString(sealed[:8]),
+		"not base64": Prefix + "k1:!!!",
+		"no id":      Prefix + encoded,
+		// 密钥ID是附加数据，换成其他密钥的ID也不能解密
+		"other id": Prefix + "k2:" + encoded,
+	}
+	for name, value := range cases {
+		if _, err := k.Decrypt(value); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	old := newKeyring(t, "old")
+	a, _ := old.Encrypt("a")
+	b, _ := old.Encrypt("b")
+	text := "Password=\"" + a + "\"\nToken=\"" + b + "\"\nPlain=\"c\"\n"
+
+	line, err := GenerateKey("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldLine := "old:" + base64.StdEncoding.EncodeToString(old.keys[0].Key)
+	k, err := ParseKeyring(line + "\n# 旧密钥只用于解密\n" + oldLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, n, err := k.Rotate(text)
+	if err != nil || n != 2 {
+		t.Fatalf("rotate: %d %v", n, err)
+	}
+	if strings.Contains(out, Prefix+"old:") || !strings.Contains(out, "Plain=\"c\"") {
+		t.Fatalf("unexpected rotated text %s", out)
+	}
+	values := Pattern.FindAllString(out, -1)
+	if len(values) != 2 {
+		t.Fatalf("expect 2 values, got %v", values)
+	}
+	for i, want := range []string{"a", "b"} {
+		got, err := k.Decrypt(values[i])
+		if err != nil || got != want {
+			t.Errorf("value %d: %q %v", i, got, err)
+		}
+	}
+
+	// 已经是主密钥加密的值不再改动
+	again, n, err := k.Rotate(out)
+	if err != nil || n != 0 || again != out {
+		t.Errorf("rotate again: %d %v", n, err)
+	}
+
+	// 缺少旧密钥时报错，不返回部分结果
+	if _, _, err := newKeyring(t, "new").Rotate(text); err == nil {
+		t.Error("expect error without old key")
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	line, _ := GenerateKey("k1")
+	short := "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 16))
+	for _, text := range []string{"", "# only comment", "k1", "bad id:" + line[3:], short, line + "," + line} {
+		if _, err := ParseKeyring(text); err == nil {
+			t.Errorf("%q: expect error", text)
+		}
+	}
+
+	if _, err := GenerateKey("bad id"); err == nil {
+		t.Error("expect error for invalid id")
+	}
+}
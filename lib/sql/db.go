@@ -133,7 +133,7 @@ func UpdateModelsInTx[T interface{}](
 	}
 
 	if err := updateFunc(models); err != nil {
-		return nil
+		return err
 	}
 
 	return tx.Save(models).Error
@@ -0,0 +1,305 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tmios/internal/http"
+	"tmios/internal/iot"
+	"tmios/internal/rbac"
+	"tmios/internal/utils"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+type DeviceListReq struct {
+	Model     string `form:"model"`
+	ForeignID string `form:"foreign_id"`
+}
+
+type DeviceGetReq struct {
+	ID        uint   `form:"id"`
+	Model     string `form:"model"`
+	ForeignID string `form:"foreign_id"`
+}
+
+// DeviceReq ID为0时创建，Config、Virtuals为JSON
+type DeviceReq struct {
+	ID       uint            `json:"id"`
+	Name     string          `json:"name" validate:"required,max=64"`
+	Model    string          `json:"model" validate:"required"`
+	Config   json.RawMessage `json:"config"`
+	Virtuals json.RawMessage `json:"virtuals"`
+	Debug    bool            `json:"debug"`
+
+	DepartmentID uint `json:"department_id"`
+}
+
+func (req *DeviceReq) row() *model.Device {
+	row := &model.Device{
+		Name:      req.Name,
+		ModelName: req.Model,
+		Config:    string(req.Config),
+		Debug:     req.Debug,
+
+		DepartmentID: req.DepartmentID,
+	}
+	row.ID = req.ID
+	if len(req.Virtuals) > 0 && string(req.Virtuals) != "null" {
+		row.Virtuals = string(req.Virtuals)
+	}
+
+	return row
+}
+
+// DeviceCheckReq ID非0时表示更新该设备
+type DeviceCheckReq struct {
+	ID     uint            `json:"id"`
+	Model  string          `json:"model" validate:"required"`
+	Config json.RawMessage `json:"config"`
+}
+
+type DeviceIDReq struct {
+	ID uint `json:"id" validate:"required"`
+}
+
+// DeviceConflictResp DeviceID为外部ID相同的已有设备，该设备在数据范围内时Device为设备详情
+type DeviceConflictResp struct {
+	ForeignID string        `json:"foreign_id"`
+	Conflict  bool          `json:"conflict"`
+	DeviceID  uint          `json:"device_id,omitempty"`
+	Device    *model.Device `json:"device,omitempty"`
+}
+
+// DeviceImportReq multipart表单，文件字段为file，Format为空时按文件扩展名判断
+type DeviceImportReq struct {
+	Format string `form:"format" validate:"omitempty,oneof=csv json"`
+	DryRun bool   `form:"dry_run"`
+	Upsert bool   `form:"upsert"`
+}
+
+// DeviceExportReq Format默认为csv
+type DeviceExportReq struct {
+	Format string `form:"format"`
+	Model  string `form:"model"`
+}
+
+// scopedDevice 数据范围外的设备按没有权限处理
+func scopedDevice(ctx *utils.ReqContext, reg *iot.Registry, id uint) (*iot.Instance, error) {
+	inst, err := reg.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !rbac.ScopeFrom(ctx).Device(inst.Row()) {
+		return nil, errm.ErrNoPermission.SetDetail("device %d", id)
+	}
+
+	return inst, nil
+}
+
+// scopedDepartment 部门范围的用户创建设备时默认为自己的部门
+func scopedDepartment(ctx *utils.ReqContext, row *model.Device) error {
+	scope := rbac.ScopeFrom(ctx)
+	if row.DepartmentID == 0 && !scope.All {
+		row.DepartmentID = scope.Department
+	}
+	if !scope.Contains(row.DepartmentID) {
+		return errm.ErrNoPermission.SetDetail("department %d", row.DepartmentID)
+	}
+
+	return nil
+}
+
+func WithDevice() http.Option {
+	return func(api *http.Api) {
+		reg := iot.NewRegistry()
+		read := rbac.Require(model.ResourceDevice, model.VerbRead)
+		write := rbac.Require(model.ResourceDevice, model.VerbWrite)
+
+		group := api.Group("api/v1/devices")
+		http.GET(group, "", func(ctx *utils.ReqContext, req *DeviceListReq) (interface{}, error) {
+			scope := rbac.ScopeFrom(ctx)
+			rows := make([]model.Device, 0)
+			for _, inst := range reg.List() {
+				row := inst.Row()
+				if !scope.Device(row) {
+					continue
+				}
+				if req.Model != "" && row.ModelName != req.Model {
+					continue
+				}
+				if req.ForeignID != "" && string(row.ForeignID) != req.ForeignID {
+					continue
+				}
+				rows = append(rows, row)
+			}
+
+			return rows, nil
+		}, read, utils.WithSummary("设备列表"), utils.WithResponse([]model.Device{}))
+		// 按ID或型号+外部ID查找
+		http.GET(group, "/get", func(ctx *utils.ReqContext, req *DeviceGetReq) (interface{}, error) {
+			var (
+				inst *iot.Instance
+				err  error
+			)
+			switch {
+			case req.ID != 0:
+				inst, err = reg.Get(req.ID)
+			case req.Model != "" && req.ForeignID != "":
+				inst, err = reg.ByForeignID(req.Model, req.ForeignID)
+			default:
+				return nil, errm.ErrParam.SetDetail("id or model and foreign_id is required")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !rbac.ScopeFrom(ctx).Device(inst.Row()) {
+				return nil, errm.ErrNoPermission.SetDetail("device %d", inst.Row().ID)
+			}
+
+			return inst.Row(), nil
+		}, read, utils.WithSummary("按ID或型号+外部ID查找设备"), utils.WithResponse(model.Device{}),
+			utils.WithErrors(errm.ErrNotFound))
+		http.POST(group, "/create", func(ctx *utils.ReqContext, req *DeviceReq) (interface{}, error) {
+			row := req.row()
+			row.ID = 0
+			if err := scopedDepartment(ctx, row); err != nil {
+				return nil, err
+			}
+			if err := reg.Create(row); err != nil {
+				return nil, err
+			}
+
+			return row, nil
+		}, write, utils.WithSummary("创建设备"), utils.WithResponse(model.Device{}),
+			utils.WithErrors(errm.ErrDeviceModel, errm.ErrDeviceConfig, errm.ErrDeviceVirtual, errm.ErrDeviceForeignID, errm.ErrForeignIDUnavailable, errm.ErrDBCurd))
+		http.POST(group, "/update", func(ctx *utils.ReqContext, req *DeviceReq) (interface{}, error) {
+			if req.ID == 0 {
+				return nil, errm.ErrParam.SetDetail("id is required")
+			}
+			if _, err := scopedDevice(ctx, reg, req.ID); err != nil {
+				return nil, err
+			}
+			update := req.row()
+			if err := scopedDepartment(ctx, update); err != nil {
+				return nil, err
+			}
+
+			return reg.Update(req.ID, func(row *model.Device) error {
+				row.Name = update.Name
+				row.ModelName = update.ModelName
+				row.Config = update.Config
+				row.Virtuals = update.Virtuals
+				row.Debug = update.Debug
+				row.DepartmentID = update.DepartmentID
+				return nil
+			})
+		}, write, utils.WithSummary("修改设备"), utils.WithResponse(model.Device{}),
+			utils.WithErrors(errm.ErrNotFound, errm.ErrDeviceModel, errm.ErrDeviceConfig, errm.ErrDeviceVirtual, errm.ErrDeviceForeignID, errm.ErrForeignIDUnavailable, errm.ErrDBCurd))
+		http.POST(group, "/delete", func(ctx *utils.ReqContext, req *DeviceIDReq) (interface{}, error) {
+			if _, err := scopedDevice(ctx, reg, req.ID); err != nil {
+				return nil, err
+			}
+
+			return nil, reg.Delete(req.ID)
+		}, write, utils.WithSummary("删除设备"), utils.WithErrors(errm.ErrNotFound, errm.ErrDBCurd))
+		// 有任一行出错时不提交，返回每行的结果；按外部ID更新时可能涉及其他部门的设备，只允许数据范围为all的用户导入
+		http.POST(group, "/import", func(ctx *utils.ReqContext, req *DeviceImportReq) (interface{}, error) {
+			if !rbac.ScopeFrom(ctx).All {
+				return nil, errm.ErrNoPermission.SetDetail("import requires scope %s", model.ScopeAll)
+			}
+			header, err := ctx.Gin.FormFile("file")
+			if err != nil {
+				return nil, errm.ErrParseFormFile
+			}
+			file, err := header.Open()
+			if err != nil {
+				return nil, errm.ErrParseFormFile
+			}
+			defer file.Close()
+
+			format := req.Format
+			if format == "" {
+				format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+			}
+
+			var rows []iot.ImportRow
+			switch format {
+			case "csv":
+				rows, err = iot.DecodeCSV(file)
+			case "json":
+				rows, err = iot.DecodeJSON(file)
+			default:
+				return nil, errm.ErrParam.SetDetail("unknown format %q", format)
+			}
+			if err != nil {
+				return nil, errm.ErrParam.SetDetail("%s", err.Error())
+			}
+
+			return reg.Import(rows, req.Upsert, req.DryRun)
+		}, write, utils.WithSummary("从CSV或JSON批量导入设备"), utils.WithResponse(iot.ImportResult{}), utils.WithUpload("file"),
+			utils.WithErrors(errm.ErrParseFormFile, errm.ErrDBCurd))
+		// 自己写响应，经过utils.Handler以使用相同的权限校验
+		http.GET(group, "/export", func(ctx *utils.ReqContext, req *DeviceExportReq) (interface{}, error) {
+			c := ctx.Gin
+			switch req.Format {
+			case "":
+				req.Format = "csv"
+			case "csv", "json":
+			default:
+				err := errm.ErrParam.SetDetail("unknown format %q", req.Format)
+				utils.ApiErr(c, err)
+				return nil, err
+			}
+
+			all, err := reg.Export(req.Model)
+			if err != nil {
+				utils.ApiErr(c, err)
+				return nil, err
+			}
+			scope := rbac.ScopeFrom(ctx)
+			rows := make([]model.Device, 0, len(all))
+			for _, row := range all {
+				if scope.Device(row) {
+					rows = append(rows, row)
+				}
+			}
+
+			filename := fmt.Sprintf("devices-%s.%s", time.Now().Format("20060102150405"), req.Format)
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+			if req.Format == "json" {
+				c.Header("Content-Type", "application/json; charset=utf-8")
+				err = iot.EncodeJSON(c.Writer, rows)
+			} else {
+				c.Header("Content-Type", "text/csv; charset=utf-8")
+				err = iot.EncodeCSV(c.Writer, rows)
+			}
+			if err != nil {
+				c.Error(err)
+			}
+			return nil, nil
+		}, read, utils.WithReturnType(utils.ReturnTypeNone), utils.WithSummary("导出设备为CSV或JSON"))
+		// 导入前检查配置的外部ID是否与已有设备重复
+		http.POST(group, "/check", func(ctx *utils.ReqContext, req *DeviceCheckReq) (interface{}, error) {
+			row := &model.Device{ModelName: req.Model, Config: string(req.Config)}
+			row.ID = req.ID
+			other, err := reg.Conflict(row)
+			if err != nil {
+				return nil, err
+			}
+
+			resp := &DeviceConflictResp{ForeignID: string(row.ForeignID), Conflict: other != nil}
+			if other != nil {
+				resp.DeviceID = other.ID
+				if rbac.ScopeFrom(ctx).Device(*other) {
+					resp.Device = other
+				}
+			}
+			return resp, nil
+		}, write, utils.WithSummary("检查设备外部ID是否与已有设备重复"), utils.WithResponse(DeviceConflictResp{}),
+			utils.WithErrors(errm.ErrDeviceModel, errm.ErrDeviceConfig))
+	}
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+	"tmios/internal/grpc"
+	"tmios/internal/iot"
+	"tmios/internal/rbac"
+	"tmios/internal/utils"
+	"tmios/lib/iot/device"
+	"tmios/lib/rpc"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+	"tmios/pkg/proto/devicev1"
+)
+
+type deviceService struct {
+	reg  *iot.Registry
+	rbac *rbac.RBAC
+}
+
+// WithDeviceService 证书之外按metadata中的应用Token和[[Apps]]的角色鉴权，与HTTP设备接口的权限和数据范围一致
+func WithDeviceService() grpc.Option {
+	return func(s *grpc.Server) {
+		devicev1.RegisterDeviceServer(s.Grpc, &deviceService{reg: iot.NewRegistry(), rbac: rbac.NewRBAC()})
+	}
+}
+
+func (s *deviceService) scope(ctx context.Context, verb string) (*rbac.Scope, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(devicev1.MetadataToken); len(vals) > 0 {
+			token = vals[0]
+		}
+	}
+
+	return s.rbac.TokenScope(token, model.ResourceDevice, verb)
+}
+
+// device 数据范围外的设备按没有权限处理
+func (s *deviceService) device(ctx context.Context, verb string, id uint) error {
+	scope, err := s.scope(ctx, verb)
+	if err != nil {
+		return err
+	}
+	inst, err := s.reg.Get(id)
+	if err != nil {
+		return err
+	}
+	if !scope.Device(inst.Row()) {
+		return errm.ErrNoPermission.SetDetail("device %d", id)
+	}
+
+	return nil
+}
+
+func (s *deviceService) ListMetas(ctx context.Context, _ *rpc.Empty) (*devicev1.ListMetasResp, error) {
+	if _, err := s.scope(ctx, model.VerbRead); err != nil {
+		return nil, err
+	}
+
+	metas := device.Metas()
+	utils.Sort(metas, func(a, b *device.DeviceMeta) bool {
+		return a.Model < b.Model
+	})
+
+	return &devicev1.ListMetasResp{Metas: metas}, nil
+}
+
+func (s *deviceService) ListDevices(ctx context.Context, _ *rpc.Empty) (*devicev1.ListDevicesResp, error) {
+	scope, err := s.scope(ctx, model.VerbRead)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp devicev1.ListDevicesResp
+	for _, inst := range s.reg.List() {
+		row := inst.Row()
+		if !scope.Device(row) {
+			continue
+		}
+		resp.Devices = append(resp.Devices, devicev1.DeviceInfo{
+			ID:        row.ID,
+			Name:      row.Name,
+			Model:     row.ModelName,
+			ForeignID: string(row.ForeignID),
+			UpdateAt:  inst.UpdateAt(),
+		})
+	}
+
+	return &resp, nil
+}
+
+func (s *deviceService) GetProps(ctx context.Context, req *devicev1.GetPropsReq) (*devicev1.GetPropsResp, error) {
+	if err := s.device(ctx, model.VerbRead, req.DeviceID); err != nil {
+		return nil, err
+	}
+	vals, err := s.reg.GetProps(req.DeviceID, req.Names)
+	if err != nil {
+		return nil, err
+	}
+
+	return &devicev1.GetPropsResp{Vals: vals}, nil
+}
+
+func (s *deviceService) SetProps(ctx context.Context, req *devicev1.SetPropsReq) (*rpc.Empty, error) {
+	if err := s.device(ctx, model.VerbWrite, req.DeviceID); err != nil {
+		return nil, err
+	}
+	if err := s.reg.SetProps(req.DeviceID, req.Vals); err != nil {
+		return nil, err
+	}
+
+	return &rpc.Empty{}, nil
+}
+
+func (s *deviceService) Action(ctx context.Context, req *devicev1.ActionReq) (*devicev1.ActionResp, error) {
+	if err := s.device(ctx, model.VerbWrite, req.DeviceID); err != nil {
+		return nil, err
+	}
+	rets, err := s.reg.Action(ctx, req.DeviceID, req.Name, req.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &devicev1.ActionResp{Rets: rets}, nil
+}
+
+func (s *deviceService) WatchProps(req *devicev1.WatchPropsReq, stream devicev1.WatchPropsServer) error {
+	scope, err := s.scope(stream.Context(), model.VerbRead)
+	if err != nil {
+		return err
+	}
+
+	sub := s.reg.Hub().Subscribe(64, func(e iot.Event) bool {
+		return e.Kind == iot.EventProps &&
+			(len(req.DeviceIDs) == 0 || utils.OneOf(e.DeviceID, req.DeviceIDs))
+	})
+	defer sub.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+
+			// Publish时可能持有registry的锁，数据范围在这里检查
+			if !scope.All {
+				if inst, err := s.reg.Get(e.DeviceID); err != nil || !scope.Device(inst.Row()) {
+					continue
+				}
+			}
+
+			vals := e.Vals
+			if len(req.Names) > 0 {
+				vals = make(map[string]interface{})
+				for name, val := range e.Vals {
+					if utils.OneOf(name, req.Names) {
+						vals[name] = val
+					}
+				}
+				if len(vals) == 0 {
+					continue
+				}
+			}
+
+			if err := stream.Send(&devicev1.PropChange{
+				DeviceID: e.DeviceID,
+				Model:    e.Model,
+				Vals:     vals,
+				Time:     e.Time,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+
+	"tmios/internal/discovery"
+	"tmios/internal/http"
+	"tmios/internal/rbac"
+	"tmios/internal/utils"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+// DiscoveryPromoteReq Config为空时使用匹配型号时生成的配置，DepartmentID与创建设备相同，
+// 部门范围的用户为0时使用自己的部门
+type DiscoveryPromoteReq struct {
+	IP     string          `json:"ip" validate:"required,ip"`
+	Model  string          `json:"model" validate:"required"`
+	Name   string          `json:"name" validate:"required,max=64"`
+	Config json.RawMessage `json:"config"`
+
+	DepartmentID uint `json:"department_id"`
+}
+
+func WithDiscovery() http.Option {
+	return func(api *http.Api) {
+		d := discovery.NewDiscovery()
+
+		read := rbac.Require(model.ResourceDiscovery, model.VerbRead)
+		write := rbac.Require(model.ResourceDiscovery, model.VerbWrite)
+		// 纳管会创建设备，同时需要设备的写权限
+		deviceWrite := rbac.Require(model.ResourceDevice, model.VerbWrite)
+
+		group := api.Group("api/v1/discovery")
+		http.GET(group, "/unclaimed", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			return d.Unclaimed()
+		}, read, utils.WithSummary("发现的未纳管设备"), utils.WithResponse([]discovery.Unclaimed{}),
+			utils.WithErrors(errm.ErrDiscoveryDisabled))
+		http.POST(group, "/scan", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			return nil, d.Scan(ctx.Gin.Request.Context())
+		}, write, utils.WithSummary("立即扫描"), utils.WithErrors(errm.ErrDiscoveryDisabled, errm.ErrDiscoveryBusy))
+		http.POST(group, "/promote", func(ctx *utils.ReqContext, req *DiscoveryPromoteReq) (interface{}, error) {
+			row := &model.Device{DepartmentID: req.DepartmentID}
+			if err := scopedDepartment(ctx, row); err != nil {
+				return nil, err
+			}
+			return d.Promote(req.IP, req.Model, req.Name, row.DepartmentID, req.Config)
+		}, write, deviceWrite, utils.WithSummary("把发现的设备创建为设备"), utils.WithResponse(model.Device{}),
+			utils.WithErrors(errm.ErrDiscoveryDisabled, errm.ErrNotFound, errm.ErrDiscoveryClaimed, errm.ErrDeviceModel, errm.ErrDeviceConfig, errm.ErrDeviceForeignID, errm.ErrForeignIDUnavailable))
+	}
+}
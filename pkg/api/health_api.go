@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"tmios/internal/cmp"
+	"tmios/internal/http"
+	"tmios/internal/utils"
+	errm "tmios/pkg/model/errors"
+)
+
+// healthTTL 检查结果的缓存时间，探针频繁访问时不重复执行各组件的检查
+const healthTTL = 2 * time.Second
+
+// HealthSummary 不需要鉴权的检查只返回整体状态，不暴露组件的错误和重启记录
+type HealthSummary struct {
+	State     string    `json:"state"`
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type healthCache struct {
+	mutex  sync.Mutex
+	report *cmp.Report
+}
+
+// get 缓存过期时重新检查，同时到达的请求等待同一次检查
+func (c *healthCache) get() *cmp.Report {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.report == nil || time.Since(c.report.CheckedAt) >= healthTTL {
+		// 各组件的检查有CheckTimeout，不受请求取消的影响
+		c.report = cmp.Health(context.Background())
+	}
+	return c.report
+}
+
+func summary(report *cmp.Report) HealthSummary {
+	return HealthSummary{State: report.State, Status: report.Status, CheckedAt: report.CheckedAt}
+}
+
+// WithHealth 负载均衡和进程守护使用的存活、就绪检查，不需要鉴权，不可用时返回503，只有整体状态；
+// /health 需要鉴权，返回各组件的状态、错误和重启记录
+func WithHealth() http.Option {
+	return func(api *http.Api) {
+		api.Public("/healthz", "/readyz")
+
+		var cache healthCache
+		group := api.Group("")
+		http.GET(group, "/healthz", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			report := cache.get()
+			if !report.Live() {
+				err := errm.ErrNotLive
+				err.Content = summary(report)
+				return nil, err
+			}
+			return summary(report), nil
+		}, utils.WithSummary("存活检查，依赖的故障不影响存活"), utils.WithResponse(HealthSummary{}),
+			utils.WithErrors(errm.ErrNotLive))
+		http.GET(group, "/readyz", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			report := cache.get()
+			if !report.Ready() {
+				err := errm.ErrNotReady
+				err.Content = summary(report)
+				return nil, err
+			}
+			return summary(report), nil
+		}, utils.WithSummary("就绪检查，启动、停止过程中或有组件不可用时返回503，降级时仍就绪"), utils.WithResponse(HealthSummary{}),
+			utils.WithErrors(errm.ErrNotReady))
+		http.GET(group, "/health", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			return cache.get(), nil
+		}, utils.WithSummary("各组件的状态、最近的错误和重启记录"), utils.WithResponse(cmp.Report{}))
+	}
+}
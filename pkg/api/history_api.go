@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"tmios/internal/history"
+	"tmios/internal/http"
+	"tmios/internal/iot"
+	"tmios/internal/rbac"
+	"tmios/internal/utils"
+	hist "tmios/lib/iot/history"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+// HistoryQueryReq Start、End为unix秒，End为0时为当前时间
+type HistoryQueryReq struct {
+	Measurement string   `form:"measurement"`
+	DeviceID    string   `form:"device_id"`
+	Fields      []string `form:"field"`
+	Start       int64    `form:"start"`
+	End         int64    `form:"end"`
+	MaxPoints   int      `form:"max_points"`
+}
+
+type HistoryExportIDReq struct {
+	ID uint `json:"id" form:"id" validate:"required"`
+}
+
+// scopedHistory 部门范围的用户只能查询自己部门设备的数据，必须指定device_id
+func scopedHistory(ctx *utils.ReqContext, reg *iot.Registry, deviceID string) error {
+	if rbac.ScopeFrom(ctx).All {
+		return nil
+	}
+
+	id, err := strconv.ParseUint(deviceID, 10, 64)
+	if err != nil {
+		return errm.ErrNoPermission.SetDetail("device_id is required")
+	}
+	_, err = scopedDevice(ctx, reg, uint(id))
+	return err
+}
+
+// exportInScope 导出任务的设备全部在数据范围内才能访问，已删除的设备视为范围外
+func exportInScope(ctx *utils.ReqContext, reg *iot.Registry, job *model.HistoryExport) bool {
+	scope := rbac.ScopeFrom(ctx)
+	if scope.All {
+		return true
+	}
+
+	var devices []uint
+	if err := json.Unmarshal([]byte(job.Devices), &devices); err != nil {
+		return false
+	}
+	for _, id := range devices {
+		inst, err := reg.Get(id)
+		if err != nil || !scope.Device(inst.Row()) {
+			return false
+		}
+	}
+	return true
+}
+
+func scopedExport(ctx *utils.ReqContext, h *history.History, reg *iot.Registry, id uint) (*model.HistoryExport, error) {
+	job, err := h.Export(id)
+	if err != nil {
+		return nil, err
+	}
+	if !exportInScope(ctx, reg, job) {
+		return nil, errm.ErrNoPermission.SetDetail("export %d", id)
+	}
+
+	return job, nil
+}
+
+func WithHistory() http.Option {
+	return func(api *http.Api) {
+		h := history.NewHistory()
+		reg := iot.NewRegistry()
+		read := rbac.Require(model.ResourceHistory, model.VerbRead)
+		write := rbac.Require(model.ResourceHistory, model.VerbWrite)
+
+		group := api.Group("api/v1/history")
+		http.GET(group, "/query", func(ctx *utils.ReqContext, req *HistoryQueryReq) (interface{}, error) {
+			if req.Measurement == "" {
+				return nil, errm.ErrParam.SetDetail("measurement is required")
+			}
+
+			end := time.Now()
+			if req.End > 0 {
+				end = time.Unix(req.End, 0)
+			}
+			if req.Start >= end.Unix() {
+				return nil, errm.ErrParam.SetDetail("start must be before end")
+			}
+
+			q := hist.Query{
+				Measurement: req.Measurement,
+				Fields:      req.Fields,
+				Start:       time.Unix(req.Start, 0),
+				End:         end,
+				MaxPoints:   req.MaxPoints,
+			}
+			if req.DeviceID != "" {
+				q.Tags = map[string]string{"device_id": req.DeviceID}
+			}
+			if err := scopedHistory(ctx, reg, req.DeviceID); err != nil {
+				return nil, err
+			}
+
+			return h.Query(ctx.Gin.Request.Context(), q)
+		}, read, utils.WithSummary("查询历史数据，点数超过max_points时自动降采样"), utils.WithResponse(hist.Result{}),
+			utils.WithErrors(errm.ErrHistoryDisabled))
+
+		http.POST(group, "/exports", func(ctx *utils.ReqContext, req *history.ExportReq) (interface{}, error) {
+			for _, id := range req.Devices {
+				if _, err := scopedDevice(ctx, reg, id); err != nil {
+					return nil, err
+				}
+			}
+			return h.CreateExport(req)
+		}, write, utils.WithSummary("创建导出任务"), utils.WithResponse(model.HistoryExport{}),
+			utils.WithErrors(errm.ErrHistoryDisabled, errm.ErrNotFound, errm.ErrDBCurd))
+		http.GET(group, "/exports", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			jobs, err := h.Exports()
+			if err != nil {
+				return nil, err
+			}
+			arr := make([]model.HistoryExport, 0, len(jobs))
+			for i := range jobs {
+				if exportInScope(ctx, reg, &jobs[i]) {
+					arr = append(arr, jobs[i])
+				}
+			}
+			return arr, nil
+		}, read, utils.WithSummary("导出任务列表"), utils.WithResponse([]model.HistoryExport{}))
+		http.GET(group, "/exports/get", func(ctx *utils.ReqContext, req *HistoryExportIDReq) (interface{}, error) {
+			return scopedExport(ctx, h, reg, req.ID)
+		}, read, utils.WithSummary("导出任务详情"), utils.WithResponse(model.HistoryExport{}),
+			utils.WithErrors(errm.ErrNotFound))
+		http.GET(group, "/exports/download", func(ctx *utils.ReqContext, req *HistoryExportIDReq) (interface{}, error) {
+			job, err := scopedExport(ctx, h, reg, req.ID)
+			if err == nil && job.Status != model.ExportDone {
+				err = errm.ErrExportState.SetDetail("export %d is %s", job.ID, job.Status)
+			}
+			if err != nil {
+				utils.ApiErr(ctx.Gin, err)
+				return nil, err
+			}
+			ctx.Gin.FileAttachment(job.Path, job.Filename)
+			return nil, nil
+		}, read, utils.WithReturnType(utils.ReturnTypeNone), utils.WithSummary("下载导出文件"),
+			utils.WithErrors(errm.ErrNotFound, errm.ErrExportState))
+		http.POST(group, "/exports/cancel", func(ctx *utils.ReqContext, req *HistoryExportIDReq) (interface{}, error) {
+			if _, err := scopedExport(ctx, h, reg, req.ID); err != nil {
+				return nil, err
+			}
+			return nil, h.CancelExport(req.ID)
+		}, write, utils.WithSummary("取消导出任务"), utils.WithErrors(errm.ErrNotFound, errm.ErrExportState))
+		http.POST(group, "/exports/delete", func(ctx *utils.ReqContext, req *HistoryExportIDReq) (interface{}, error) {
+			if _, err := scopedExport(ctx, h, reg, req.ID); err != nil {
+				return nil, err
+			}
+			return nil, h.DeleteExport(req.ID)
+		}, write, utils.WithSummary("删除导出任务和文件"), utils.WithErrors(errm.ErrNotFound, errm.ErrExportState))
+	}
+}
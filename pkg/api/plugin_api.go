@@ -0,0 +1,38 @@
+package api
+
+import (
+	"tmios/internal/http"
+	"tmios/internal/plugin"
+	"tmios/internal/rbac"
+	"tmios/internal/utils"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+type PluginSchemaReq struct {
+	Model string `form:"model" validate:"required"`
+}
+
+// WithPlugin 插件提供设备型号，使用设备的读权限
+func WithPlugin() http.Option {
+	return func(api *http.Api) {
+		m := plugin.NewManager()
+
+		read := rbac.Require(model.ResourceDevice, model.VerbRead)
+
+		group := api.Group("api/v1/plugins")
+		http.GET(group, "", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			return m.Status(), nil
+		}, read, utils.WithSummary("插件进程状态"), utils.WithResponse([]plugin.Status{}))
+		http.GET(group, "/schema", func(ctx *utils.ReqContext, req *PluginSchemaReq) (interface{}, error) {
+			if req.Model == "" {
+				return nil, errm.ErrParam.SetDetail("model is required")
+			}
+			schema := m.Schema(req.Model)
+			if schema == nil {
+				return nil, errm.ErrNotFound.SetDetail("plugin model %s", req.Model)
+			}
+			return schema, nil
+		}, read, utils.WithSummary("插件上报的型号配置JSON Schema"), utils.WithErrors(errm.ErrParam, errm.ErrNotFound))
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"tmios/internal/http"
+	"tmios/internal/rbac"
+	"tmios/internal/user"
+	"tmios/internal/utils"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+type RoleIDReq struct {
+	ID uint `json:"id" form:"id" validate:"required"`
+}
+
+type RoleReq struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name" validate:"required,max=64"`
+	Desc  string `json:"desc" validate:"max=256"`
+	Scope string `json:"scope" validate:"required"`
+}
+
+type RoleRightReq struct {
+	RoleID   uint   `json:"role_id" validate:"required"`
+	Resource string `json:"resource" validate:"required"`
+	Verb     string `json:"verb" validate:"required"`
+}
+
+type DepartmentIDReq struct {
+	ID uint `json:"id" validate:"required"`
+}
+
+// DepartmentReq ParentID为0时为顶级部门
+type DepartmentReq struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name" validate:"required,max=64"`
+	ParentID uint   `json:"parent_id"`
+}
+
+// UserAssignReq RoleID、DepartmentID为0时清除
+type UserAssignReq struct {
+	UserID       uint `json:"user_id" validate:"required"`
+	RoleID       uint `json:"role_id"`
+	DepartmentID uint `json:"department_id"`
+}
+
+type MineRsp struct {
+	Role         *model.Role `json:"role"`
+	DepartmentID uint        `json:"department_id"`
+}
+
+func WithRBAC() http.Option {
+	return func(api *http.Api) {
+		r := rbac.NewRBAC()
+		read := rbac.Require(model.ResourceRBAC, model.VerbRead)
+		write := rbac.Require(model.ResourceRBAC, model.VerbWrite)
+
+		group := api.Group("api/v1/rbac")
+		// 当前用户的角色，只需要登录
+		http.GET(group, "/mine", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			usr := user.FromContext(ctx)
+			rsp := &MineRsp{DepartmentID: usr.DepartmentID}
+			if usr.RoleID != 0 {
+				role, err := r.Role(usr.RoleID)
+				if err != nil {
+					return nil, err
+				}
+				rsp.Role = role
+			}
+			return rsp, nil
+		}, user.RequireLogin(), utils.WithSummary("当前用户的角色和部门"), utils.WithResponse(MineRsp{}))
+		http.GET(group, "/rights", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			return r.Rights()
+		}, read, utils.WithSummary("权限列表"), utils.WithResponse([]model.Right{}))
+
+		http.GET(group, "/roles", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			return r.Roles()
+		}, read, utils.WithSummary("角色列表"), utils.WithResponse([]model.Role{}))
+		http.GET(group, "/roles/get", func(ctx *utils.ReqContext, req *RoleIDReq) (interface{}, error) {
+			return r.Role(req.ID)
+		}, read, utils.WithSummary("角色详情"), utils.WithResponse(model.Role{}), utils.WithErrors(errm.ErrInvalidRole))
+		http.POST(group, "/roles/create", func(ctx *utils.ReqContext, req *RoleReq) (interface{}, error) {
+			return r.CreateRole(req.Name, req.Desc, req.Scope)
+		}, write, utils.WithSummary("创建角色"), utils.WithResponse(model.Role{}),
+			utils.WithErrors(errm.ErrRoleScope, errm.ErrDuplicateEntry))
+		// 角色名不能修改
+		http.POST(group, "/roles/update", func(ctx *utils.ReqContext, req *RoleReq) (interface{}, error) {
+			if req.ID == 0 {
+				return nil, errm.ErrParam.SetDetail("id is required")
+			}
+			return r.UpdateRole(req.ID, req.Desc, req.Scope)
+		}, write, utils.WithSummary("修改角色的描述和数据范围"), utils.WithResponse(model.Role{}),
+			utils.WithErrors(errm.ErrInvalidRole, errm.ErrRoleScope))
+		http.POST(group, "/roles/delete", func(ctx *utils.ReqContext, req *RoleIDReq) (interface{}, error) {
+			return nil, r.DeleteRole(req.ID)
+		}, write, utils.WithSummary("删除角色"), utils.WithErrors(errm.ErrInvalidRole, errm.ErrDeleteRole))
+		http.POST(group, "/roles/rights/add", func(ctx *utils.ReqContext, req *RoleRightReq) (interface{}, error) {
+			return r.AddRoleRight(req.RoleID, req.Resource, req.Verb)
+		}, write, utils.WithSummary("为角色添加权限"), utils.WithResponse(model.Role{}),
+			utils.WithErrors(errm.ErrInvalidRole, errm.ErrAddRoleRight))
+		http.POST(group, "/roles/rights/remove", func(ctx *utils.ReqContext, req *RoleRightReq) (interface{}, error) {
+			return r.RemoveRoleRight(req.RoleID, req.Resource, req.Verb)
+		}, write, utils.WithSummary("移除角色的权限"), utils.WithResponse(model.Role{}),
+			utils.WithErrors(errm.ErrInvalidRole, errm.ErrRightNotInRole))
+
+		http.GET(group, "/departments", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			return r.Departments()
+		}, read, utils.WithSummary("部门列表，按Path排序"), utils.WithResponse([]model.Department{}))
+		http.POST(group, "/departments/create", func(ctx *utils.ReqContext, req *DepartmentReq) (interface{}, error) {
+			return r.CreateDepartment(req.Name, req.ParentID)
+		}, write, utils.WithSummary("创建部门"), utils.WithResponse(model.Department{}), utils.WithErrors(errm.ErrNotFound))
+		http.POST(group, "/departments/update", func(ctx *utils.ReqContext, req *DepartmentReq) (interface{}, error) {
+			if req.ID == 0 {
+				return nil, errm.ErrParam.SetDetail("id is required")
+			}
+			return r.UpdateDepartment(req.ID, req.Name, req.ParentID)
+		}, write, utils.WithSummary("修改部门名称和上级部门"), utils.WithResponse(model.Department{}),
+			utils.WithErrors(errm.ErrNotFound))
+		http.POST(group, "/departments/delete", func(ctx *utils.ReqContext, req *DepartmentIDReq) (interface{}, error) {
+			return nil, r.DeleteDepartment(req.ID)
+		}, write, utils.WithSummary("删除部门"), utils.WithErrors(errm.ErrNotFound, errm.ErrDeleteDepartment))
+
+		http.POST(group, "/users/assign", func(ctx *utils.ReqContext, req *UserAssignReq) (interface{}, error) {
+			return nil, r.AssignUser(req.UserID, req.RoleID, req.DepartmentID)
+		}, write, utils.WithSummary("设置用户的角色和部门"), utils.WithErrors(errm.ErrNotFound, errm.ErrInvalidRole))
+	}
+}
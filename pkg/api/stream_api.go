@@ -0,0 +1,221 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"tmios/internal/http"
+	"tmios/internal/iot"
+	"tmios/internal/rbac"
+	"tmios/internal/utils"
+	"tmios/lib/iot/device"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+// streamBuffer 每个连接缓存的事件数，客户端消费过慢时丢弃新事件
+const streamBuffer = 256
+
+// StreamReq Feed为props、alarm、device，为空时订阅props和alarm；DeviceID、Model为空时不过滤设备；
+// Prop只过滤props的属性；Level为告警的最低级别
+type StreamReq struct {
+	Feeds     []string `form:"feed"`
+	DeviceIDs []uint   `form:"device_id"`
+	Model     string   `form:"model"`
+	Props     []string `form:"prop"`
+	Level     string   `form:"level"`
+}
+
+var alarmLevels = map[string]int{
+	device.AlarmInfo:     0,
+	device.AlarmWarning:  1,
+	device.AlarmCritical: 2,
+}
+
+type streamFilter struct {
+	reg     *iot.Registry
+	scope   *rbac.Scope
+	feeds   map[string]bool
+	devices map[uint]bool
+	model   string
+	props   map[string]bool
+	level   int
+}
+
+func newStreamFilter(req *StreamReq, reg *iot.Registry, scope *rbac.Scope) (*streamFilter, error) {
+	f := &streamFilter{
+		reg:   reg,
+		scope: scope,
+		feeds: make(map[string]bool),
+		model: req.Model,
+	}
+
+	feeds := req.Feeds
+	if len(feeds) == 0 {
+		feeds = []string{iot.EventProps, iot.EventAlarm}
+	}
+	for _, feed := range feeds {
+		switch feed {
+		case iot.EventProps, iot.EventAlarm, iot.EventDevice:
+			f.feeds[feed] = true
+		default:
+			return nil, errm.ErrParam.SetDetail("unknown feed %q", feed)
+		}
+	}
+
+	if req.Level != "" {
+		level, ok := alarmLevels[req.Level]
+		if !ok {
+			return nil, errm.ErrParam.SetDetail("unknown alarm level %q", req.Level)
+		}
+		f.level = level
+	}
+
+	if len(req.DeviceIDs) > 0 {
+		f.devices = make(map[uint]bool)
+		for _, id := range req.DeviceIDs {
+			f.devices[id] = true
+		}
+	}
+	if len(req.Props) > 0 {
+		f.props = make(map[string]bool)
+		for _, prop := range req.Props {
+			f.props[prop] = true
+		}
+	}
+
+	return f, nil
+}
+
+// device 数据范围按注册表中设备当前的部门判断，设备已删除时只有all范围可见
+func (f *streamFilter) device(id uint, modelName string) bool {
+	if f.devices != nil && !f.devices[id] {
+		return false
+	}
+	if !f.scope.All {
+		inst, err := f.reg.Get(id)
+		if err != nil || !f.scope.Device(inst.Row()) {
+			return false
+		}
+	}
+	return f.model == "" || f.model == modelName
+}
+
+func (f *streamFilter) vals(vals map[string]interface{}) map[string]interface{} {
+	if f.props == nil {
+		return vals
+	}
+
+	out := make(map[string]interface{})
+	for k, v := range vals {
+		if f.props[k] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// match 过滤事件，props事件只保留订阅的属性
+func (f *streamFilter) match(e iot.Event) (iot.Event, bool) {
+	if !f.feeds[e.Kind] || !f.device(e.DeviceID, e.Model) {
+		return e, false
+	}
+
+	switch e.Kind {
+	case iot.EventProps:
+		e.Vals = f.vals(e.Vals)
+		return e, len(e.Vals) > 0
+	case iot.EventAlarm:
+		return e, e.Alarm != nil && alarmLevels[e.Alarm.Level] >= f.level
+	}
+
+	return e, true
+}
+
+// snapshot 当前设备、属性值和最近的告警，作为一条snapshot消息
+func (f *streamFilter) snapshot(reg *iot.Registry) http.StreamMessage {
+	events := make([]iot.Event, 0)
+	for _, inst := range reg.List() {
+		row := inst.Row()
+		if !f.device(row.ID, row.ModelName) {
+			continue
+		}
+
+		if f.feeds[iot.EventDevice] {
+			events = append(events, iot.Event{Kind: iot.EventDevice, DeviceID: row.ID, Model: row.ModelName,
+				Device: &row, Time: time.Now()})
+		}
+		if f.feeds[iot.EventProps] {
+			if vals := f.vals(inst.Vals()); len(vals) > 0 {
+				events = append(events, iot.Event{Kind: iot.EventProps, DeviceID: row.ID, Model: row.ModelName,
+					Vals: vals, Time: time.Unix(inst.UpdateAt(), 0)})
+			}
+		}
+	}
+
+	if f.feeds[iot.EventAlarm] {
+		for _, e := range reg.Hub().Alarms() {
+			if e, ok := f.match(e); ok {
+				events = append(events, e)
+			}
+		}
+	}
+
+	return http.StreamMessage{Event: "snapshot", Data: events}
+}
+
+// stream 先订阅再生成快照，快照和增量之间的事件不会丢失，但可能重复
+func (f *streamFilter) stream(reg *iot.Registry) *http.Stream {
+	sub := reg.Hub().Subscribe(streamBuffer, func(e iot.Event) bool {
+		_, ok := f.match(e)
+		return ok
+	})
+
+	c := make(chan http.StreamMessage)
+	go func() {
+		defer close(c)
+		for e := range sub.C {
+			e, _ = f.match(e)
+			c <- http.StreamMessage{Event: e.Kind, Data: e}
+		}
+	}()
+
+	return &http.Stream{
+		Snapshot: []http.StreamMessage{f.snapshot(reg)},
+		C:        c,
+		Dropped:  sub.Dropped,
+		Close: func() {
+			sub.Close()
+			// 取走转发中的事件，使转发协程退出
+			for range c {
+			}
+		},
+	}
+}
+
+func WithStream() http.Option {
+	return func(api *http.Api) {
+		reg := iot.NewRegistry()
+
+		// 经过utils.Handler，与其他接口使用相同的鉴权
+		serve := func(serveFunc func(*gin.Context, *http.Stream)) func(*utils.ReqContext, *StreamReq) (interface{}, error) {
+			return func(ctx *utils.ReqContext, req *StreamReq) (interface{}, error) {
+				f, err := newStreamFilter(req, reg, rbac.ScopeFrom(ctx))
+				if err != nil {
+					utils.ApiErr(ctx.Gin, err)
+					return nil, err
+				}
+
+				serveFunc(ctx.Gin, f.stream(reg))
+				return nil, nil
+			}
+		}
+		require := rbac.Require(model.ResourceDevice, model.VerbRead)
+		stream := utils.WithReturnType(utils.ReturnTypeNone)
+
+		group := api.Group("api/v1/stream")
+		http.GET(group, "/sse", serve(http.ServeSSE), require, stream, utils.WithSummary("SSE推送设备属性、告警和设备变更"))
+		http.GET(group, "/ws", serve(http.ServeWebSocket), require, stream, utils.WithSummary("WebSocket推送设备属性、告警和设备变更"),
+			utils.WithErrors(errm.ErrOriginForbidden))
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"tmios/internal/cloudsync"
+	"tmios/internal/grpc"
+	"tmios/internal/http"
+	"tmios/internal/rbac"
+	"tmios/internal/utils"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+	"tmios/pkg/proto/syncv1"
+)
+
+const syncActionTimeout = 30 * time.Second
+
+// WithSyncService 中心端接收边缘节点的同步连接
+func WithSyncService() grpc.Option {
+	return func(s *grpc.Server) {
+		syncv1.RegisterSyncServer(s.Grpc, cloudsync.NewCentral())
+	}
+}
+
+type SyncActionReq struct {
+	EdgeID   string          `json:"edge_id" validate:"required"`
+	DeviceID uint            `json:"device_id" validate:"required"`
+	Name     string          `json:"name" validate:"required"`
+	Args     json.RawMessage `json:"args"`
+}
+
+func WithSync() http.Option {
+	return func(api *http.Api) {
+		central := cloudsync.NewCentral()
+
+		read := rbac.Require(model.ResourceSync, model.VerbRead)
+		write := rbac.Require(model.ResourceSync, model.VerbWrite)
+
+		group := api.Group("api/v1/sync")
+		http.GET(group, "/edges", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			return central.Edges(), nil
+		}, read, utils.WithSummary("边缘节点列表"), utils.WithResponse([]cloudsync.EdgeStatus{}))
+		http.POST(group, "/action", func(ctx *utils.ReqContext, req *SyncActionReq) (interface{}, error) {
+			c, cancel := context.WithTimeout(ctx.Gin.Request.Context(), syncActionTimeout)
+			defer cancel()
+
+			rets, err := central.Action(c, req.EdgeID, req.DeviceID, req.Name, req.Args)
+			if err != nil {
+				return nil, err
+			}
+
+			return json.RawMessage(rets), nil
+		}, write, utils.WithSummary("调用边缘节点设备的操作"), utils.WithErrors(errm.ErrEdgeOffline, errm.ErrDeviceAction))
+	}
+}
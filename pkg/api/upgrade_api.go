@@ -0,0 +1,105 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"tmios/internal/http"
+	"tmios/internal/rbac"
+	"tmios/internal/upgrade"
+	"tmios/internal/utils"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+// FirmwareUploadReq multipart表单，固件文件字段为file，SHA256为空时不校验
+type FirmwareUploadReq struct {
+	Model   string `form:"model" validate:"required"`
+	Version string `form:"version" validate:"required,max=64"`
+	SHA256  string `form:"sha256" validate:"omitempty,len=64,hexadecimal"`
+	Desc    string `form:"desc" validate:"max=512"`
+}
+
+type FirmwareListReq struct {
+	Model string `form:"model"`
+}
+
+type UpgradeIDReq struct {
+	ID uint `json:"id" form:"id" validate:"required"`
+}
+
+func WithUpgrade() http.Option {
+	return func(api *http.Api) {
+		u := upgrade.NewUpgrader()
+		read := rbac.Require(model.ResourceUpgrade, model.VerbRead)
+		write := rbac.Require(model.ResourceUpgrade, model.VerbWrite)
+
+		group := api.Group("api/v1/upgrade")
+		http.POST(group, "/firmwares", func(ctx *utils.ReqContext, req *FirmwareUploadReq) (interface{}, error) {
+			header, err := ctx.Gin.FormFile("file")
+			if err != nil {
+				return nil, errm.ErrParseFormFile
+			}
+			file, err := header.Open()
+			if err != nil {
+				return nil, errm.ErrParseFormFile
+			}
+			defer file.Close()
+
+			return u.Upload(file, header.Filename, req.Model, req.Version, req.SHA256, req.Desc)
+		}, write, utils.WithSummary("上传固件"), utils.WithResponse(model.Firmware{}), utils.WithUpload("file"),
+			utils.WithErrors(errm.ErrParseFormFile, errm.ErrDeviceModel, errm.ErrUpgradeUnsupported, errm.ErrFirmwareChecksum, errm.ErrDuplicateEntry))
+		http.GET(group, "/firmwares", func(ctx *utils.ReqContext, req *FirmwareListReq) (interface{}, error) {
+			return u.Firmwares(req.Model)
+		}, read, utils.WithSummary("固件列表"), utils.WithResponse([]model.Firmware{}))
+		http.GET(group, "/firmwares/download", func(ctx *utils.ReqContext, req *UpgradeIDReq) (interface{}, error) {
+			fw, err := u.Firmware(req.ID)
+			if err != nil {
+				utils.ApiErr(ctx.Gin, err)
+				return nil, err
+			}
+			sendFirmware(ctx.Gin, fw)
+			return nil, nil
+		}, read, utils.WithReturnType(utils.ReturnTypeNone), utils.WithSummary("下载固件"),
+			utils.WithErrors(errm.ErrNotFound))
+		// 设备通过UpgradeArgs.URL下载固件，设备不持有应用Token，由链接中的签名鉴权
+		api.Public(upgrade.DownloadPath)
+		http.GET(group, "/firmwares/fetch", func(ctx *utils.ReqContext, req *upgrade.DownloadReq) (interface{}, error) {
+			fw, err := u.Download(req)
+			if err != nil {
+				utils.ApiErr(ctx.Gin, err)
+				return nil, err
+			}
+			sendFirmware(ctx.Gin, fw)
+			return nil, nil
+		}, utils.WithReturnType(utils.ReturnTypeNone), utils.WithSummary("设备下载固件，使用升级时下发的签名链接，不需要鉴权"),
+			utils.WithErrors(errm.ErrDownloadToken))
+		http.POST(group, "/firmwares/delete", func(ctx *utils.ReqContext, req *UpgradeIDReq) (interface{}, error) {
+			return nil, u.DeleteFirmware(req.ID)
+		}, write, utils.WithSummary("删除固件"), utils.WithErrors(errm.ErrNotFound, errm.ErrRolloutState))
+
+		http.POST(group, "/rollouts", func(ctx *utils.ReqContext, req *upgrade.RolloutReq) (interface{}, error) {
+			return u.CreateRollout(rbac.ScopeFrom(ctx), req)
+		}, write, utils.WithSummary("创建升级任务，部门范围的用户需要指定范围内的设备"), utils.WithResponse(model.Rollout{}),
+			utils.WithErrors(errm.ErrNotFound, errm.ErrUpgradeUnsupported))
+		http.GET(group, "/rollouts", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			return u.Rollouts()
+		}, read, utils.WithSummary("升级任务列表"), utils.WithResponse([]model.Rollout{}))
+		http.GET(group, "/rollouts/get", func(ctx *utils.ReqContext, req *UpgradeIDReq) (interface{}, error) {
+			return u.Rollout(req.ID)
+		}, read, utils.WithSummary("升级任务和每个设备的状态"), utils.WithResponse(upgrade.RolloutDetail{}),
+			utils.WithErrors(errm.ErrNotFound))
+		http.POST(group, "/rollouts/start", func(ctx *utils.ReqContext, req *UpgradeIDReq) (interface{}, error) {
+			return nil, u.StartRollout(req.ID)
+		}, write, utils.WithSummary("开始升级任务"), utils.WithErrors(errm.ErrNotFound, errm.ErrRolloutState))
+		http.POST(group, "/rollouts/advance", func(ctx *utils.ReqContext, req *UpgradeIDReq) (interface{}, error) {
+			return nil, u.Advance(req.ID)
+		}, write, utils.WithSummary("进入升级任务的下一阶段"), utils.WithErrors(errm.ErrNotFound, errm.ErrRolloutState))
+		http.POST(group, "/rollouts/cancel", func(ctx *utils.ReqContext, req *UpgradeIDReq) (interface{}, error) {
+			return nil, u.Cancel(req.ID)
+		}, write, utils.WithSummary("取消升级任务"), utils.WithErrors(errm.ErrNotFound, errm.ErrRolloutState))
+	}
+}
+
+func sendFirmware(c *gin.Context, fw *model.Firmware) {
+	c.Header("X-Checksum-Sha256", fw.SHA256)
+	c.FileAttachment(fw.Path, fw.Filename)
+}
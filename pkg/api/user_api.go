@@ -0,0 +1,100 @@
+package api
+
+import (
+	"tmios/internal/http"
+	"tmios/internal/rbac"
+	"tmios/internal/user"
+	"tmios/internal/utils"
+	"tmios/pkg/model"
+	errm "tmios/pkg/model/errors"
+)
+
+type LoginReq struct {
+	Username string `json:"username" form:"username" validate:"required"`
+	Password string `json:"password" form:"password" validate:"required"`
+}
+
+type LoginRsp struct {
+	Token string      `json:"token"`
+	User  interface{} `json:"user"`
+}
+
+type ChangePasswordReq struct {
+	OriPassword string `json:"ori_password" form:"ori_password" validate:"required"`
+	Password    string `json:"password" form:"password" validate:"required,min=6,max=64"`
+}
+
+type UserIDReq struct {
+	ID uint `json:"id" form:"id" validate:"required"`
+}
+
+type UserCreateReq struct {
+	Username string `json:"username" form:"username" validate:"required,max=64"`
+	Password string `json:"password" form:"password" validate:"required,min=6,max=64"`
+	Name     string `json:"name" form:"name" validate:"max=64"`
+}
+
+// UserUpdateReq Password非空时重置密码
+type UserUpdateReq struct {
+	ID       uint   `json:"id" form:"id" validate:"required"`
+	Name     string `json:"name" form:"name" validate:"max=64"`
+	Disabled bool   `json:"disabled" form:"disabled"`
+	Password string `json:"password" form:"password" validate:"omitempty,min=6,max=64"`
+}
+
+func WithUser() http.Option {
+	return func(api *http.Api) {
+		u := user.NewUsers()
+		required := user.RequireLogin()
+
+		auth := api.Group("api/v1/auth")
+		http.POST(auth, "/login", func(ctx *utils.ReqContext, req *LoginReq) (interface{}, error) {
+			token, usr, err := u.Login(ctx.Gin.Request.Context(), req.Username, req.Password)
+			if err != nil {
+				return nil, err
+			}
+			u.SetCookie(ctx.Gin.Writer, token)
+			return &LoginRsp{Token: token, User: usr}, nil
+		}, utils.WithSummary("登录，会话也写入session Cookie"), utils.WithResponse(LoginRsp{}),
+			utils.WithErrors(errm.ErrInvalidUserOrPassword, errm.ErrLocked, errm.ErrUserDisabled))
+		http.POST(auth, "/logout", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			u.SetCookie(ctx.Gin.Writer, "")
+			return nil, u.Logout(ctx.Gin.Request.Context(), user.Token(ctx.Gin.Request))
+		}, required, utils.WithSummary("退出登录"))
+		http.GET(auth, "/me", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			return user.FromContext(ctx), nil
+		}, required, utils.WithSummary("当前用户"), utils.WithResponse(model.User{}))
+		http.POST(auth, "/password", func(ctx *utils.ReqContext, req *ChangePasswordReq) (interface{}, error) {
+			return nil, u.ChangePassword(user.FromContext(ctx).ID, req.OriPassword, req.Password)
+		}, required, utils.WithSummary("修改密码，所有会话失效"), utils.WithErrors(errm.ErrInvalidOriPassword))
+
+		read := rbac.Require(model.ResourceUser, model.VerbRead)
+		write := rbac.Require(model.ResourceUser, model.VerbWrite)
+
+		group := api.Group("api/v1/users")
+		http.GET(group, "", func(ctx *utils.ReqContext, req *struct{}) (interface{}, error) {
+			return u.Users()
+		}, read, utils.WithSummary("用户列表"), utils.WithResponse([]model.User{}))
+		http.GET(group, "/get", func(ctx *utils.ReqContext, req *UserIDReq) (interface{}, error) {
+			return u.User(req.ID)
+		}, read, utils.WithSummary("用户详情"), utils.WithResponse(model.User{}), utils.WithErrors(errm.ErrNotFound))
+		http.POST(group, "/create", func(ctx *utils.ReqContext, req *UserCreateReq) (interface{}, error) {
+			return u.CreateUser(req.Username, req.Password, req.Name)
+		}, write, utils.WithSummary("创建用户"), utils.WithResponse(model.User{}), utils.WithErrors(errm.ErrUserExisted))
+		http.POST(group, "/update", func(ctx *utils.ReqContext, req *UserUpdateReq) (interface{}, error) {
+			return u.UpdateUser(req.ID, req.Name, req.Disabled, req.Password)
+		}, write, utils.WithSummary("修改用户，password非空时重置密码"), utils.WithResponse(model.User{}),
+			utils.WithErrors(errm.ErrNotFound))
+		http.POST(group, "/delete", func(ctx *utils.ReqContext, req *UserIDReq) (interface{}, error) {
+			// 通过应用Token访问时没有当前用户
+			var current uint
+			if usr := user.FromContext(ctx); usr != nil {
+				current = usr.ID
+			}
+			return nil, u.DeleteUser(req.ID, current)
+		}, write, utils.WithSummary("删除用户"), utils.WithErrors(errm.ErrNotFound))
+		http.POST(group, "/unlock", func(ctx *utils.ReqContext, req *UserIDReq) (interface{}, error) {
+			return nil, u.Unlock(req.ID)
+		}, write, utils.WithSummary("解除用户锁定"), utils.WithErrors(errm.ErrNotFound))
+	}
+}
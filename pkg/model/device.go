@@ -0,0 +1,21 @@
+package model
+
+import (
+	"tmios/internal/utils"
+)
+
+// Device 设备实例，Config为按DeviceMeta.Config校验后的JSON，
+// Virtuals为实例的虚拟属性([]device.Virtual的JSON)，ForeignID由DeviceMeta.ForeignIDFunc按Config生成；
+// 同一型号的ForeignID唯一，没有外部ID时为NULL；
+// DepartmentID为所属部门，0表示未分配，只有数据范围为all的角色可以访问
+type Device struct {
+	utils.Model
+	Name      string           `gorm:"size:64" json:"name"`
+	ModelName string           `gorm:"column:model;size:64;index;uniqueIndex:idx_device_foreign" json:"model"`
+	Config    string           `gorm:"type:text" json:"config"`
+	ForeignID utils.NullString `gorm:"size:128;uniqueIndex:idx_device_foreign" json:"foreign_id"`
+	Virtuals  string           `gorm:"type:text" json:"virtuals"`
+	Debug     bool             `json:"debug"`
+
+	DepartmentID uint `gorm:"index" json:"department_id"`
+}
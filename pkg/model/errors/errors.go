@@ -13,19 +13,29 @@ var (
 	ErrNoTokenFound          = errors.BadRequest(400010, "No token found in headers")
 	ErrInvalidAppToken       = errors.BadRequest(400011, "Invalid app token")
 	ErrNoAuth                = errors.BadRequest(400020, "没有登录")
-	ErrLocked                = errors.BadRequest(400021, "账号已锁定")
+	ErrLocked                = errors.Locked(400021, "账号已锁定")
 	ErrInvalidUserOrPassword = errors.BadRequest(400040, "账号或密码错误")
 	ErrInvalidLoginType      = errors.BadRequest(400050, "Invalid login type")
 	ErrInvalidOriPassword    = errors.BadRequest(400060, "原始密码错误")
 	ErrParseFormFile         = errors.BadRequest(400100, "Parse FormFile failed")
 	ErrInvalidRequest        = errors.BadRequest(400101, "非法请求")
 	ErrInvalidResponse       = errors.BadRequest(400103, "非法返回")
+	ErrDeviceConfig          = errors.BadRequest(400110, "设备配置错误:")
+	ErrDeviceProp            = errors.BadRequest(400111, "设备属性错误:")
+	ErrDeviceAction          = errors.BadRequest(400112, "设备操作错误:")
+	ErrDeviceVirtual         = errors.BadRequest(400113, "虚拟属性错误:")
+	ErrSyncProtocol          = errors.BadRequest(400120, "同步协议错误:")
+	ErrUpgradeUnsupported    = errors.BadRequest(400130, "设备不支持升级:")
+	ErrFirmwareChecksum      = errors.BadRequest(400131, "固件校验失败:")
+	ErrDownloadToken         = errors.BadRequest(400132, "固件下载链接无效或已过期")
 
 	ErrNotFound       = errors.Conflict(400404, "记录不存在:")
 	ErrNoPermission   = errors.Conflict(409010, "没有权限")
 	ErrInvalidRole    = errors.Conflict(409011, "错误的角色")
 	ErrDuplicateEntry = errors.Conflict(400102, "记录重复:")
 
+	ErrOriginForbidden = errors.Forbidden(403010, "不允许的来源:")
+
 	ErrUserExisted      = errors.Conflict(409110, "该用户已存在")
 	ErrUserDisabled     = errors.Conflict(409112, "用户被禁用")
 	ErrDeleteRole       = errors.Conflict(409160, "删除角色失败:")
@@ -39,4 +49,24 @@ var (
 	ErrClientAuth      = errors.Conflict(410120, "客户端未授权:")
 
 	ErrDBCurd = errors.Conflict(410210, "数据库错误:")
+
+	ErrDeviceModel     = errors.Conflict(410300, "设备型号不存在:")
+	ErrDeviceForeignID = errors.Conflict(410310, "设备外部ID重复:")
+	ErrEdgeOffline     = errors.Conflict(410400, "边缘节点不在线:")
+
+	ErrHistoryDisabled = errors.Conflict(410500, "历史数据未启用")
+	ErrExportState     = errors.Conflict(410510, "导出任务状态错误:")
+
+	ErrDiscoveryDisabled = errors.Conflict(410600, "设备发现未启用")
+	ErrDiscoveryBusy     = errors.Conflict(410610, "设备发现正在扫描")
+	ErrDiscoveryClaimed  = errors.Conflict(410620, "候选设备已纳管:")
+
+	ErrRolloutState = errors.Conflict(410700, "升级任务状态错误:")
+
+	ErrNotLive  = errors.ServiceUnavailable(503100, "服务已停止")
+	ErrNotReady = errors.ServiceUnavailable(503110, "服务未就绪")
+
+	ErrPluginUnavailable = errors.ServiceUnavailable(503120, "插件不可用:")
+
+	ErrForeignIDUnavailable = errors.ServiceUnavailable(503130, "无法生成设备外部ID:")
 )
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"tmios/internal/utils"
+)
+
+// 历史数据导出任务状态
+const (
+	ExportPending  = "pending"
+	ExportRunning  = "running"
+	ExportDone     = "done"
+	ExportFailed   = "failed"
+	ExportCanceled = "canceled"
+)
+
+// HistoryExport 历史数据导出任务，Devices、Fields为JSON数组，时间范围为[Start, End)；
+// 每个设备按时间分块读取，Chunks为总块数，DoneChunks为已完成的块数
+type HistoryExport struct {
+	utils.Model
+	Format     string    `gorm:"size:16" json:"format"`
+	Devices    string    `gorm:"size:1024" json:"devices"`
+	Fields     string    `gorm:"size:1024" json:"fields"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Resolution string    `gorm:"size:16" json:"resolution"`
+	Ftp        bool      `json:"ftp"`
+	Status     string    `gorm:"size:16" json:"status"`
+	Chunks     int       `json:"chunks"`
+	DoneChunks int       `json:"done_chunks"`
+	Rows       int64     `json:"rows"`
+	Filename   string    `gorm:"size:255" json:"filename"`
+	Path       string    `gorm:"size:512" json:"-"`
+	Size       int64     `json:"size"`
+	Pushed     bool      `json:"pushed"` // 已推送到Ftp服务器
+	Message    string    `gorm:"size:512" json:"message"`
+}
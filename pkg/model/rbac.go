@@ -0,0 +1,72 @@
+package model
+
+import (
+	"tmios/internal/utils"
+)
+
+// 权限的操作
+const (
+	VerbRead  = "read"
+	VerbWrite = "write"
+)
+
+// 权限的资源，Global的资源与部门无关，只能授予数据范围为all的角色
+const (
+	ResourceDevice    = "device"
+	ResourceHistory   = "history"
+	ResourceUpgrade   = "upgrade"
+	ResourceDiscovery = "discovery"
+	ResourceSync      = "sync"
+	ResourceUser      = "user"
+	ResourceRBAC      = "rbac"
+)
+
+// 角色的数据范围
+const (
+	ScopeAll        = "all"        // 所有设备
+	ScopeDepartment = "department" // 用户所在部门及下级部门的设备
+)
+
+// ResourceDef 启动时按定义为每个资源创建read、write权限
+type ResourceDef struct {
+	Resource string
+	Desc     string
+	Global   bool
+}
+
+var Resources = []ResourceDef{
+	{ResourceDevice, "设备", false},
+	{ResourceHistory, "历史数据", false},
+	{ResourceUpgrade, "固件升级", true},
+	{ResourceDiscovery, "设备发现", true},
+	{ResourceSync, "云边同步", true},
+	{ResourceUser, "用户", true},
+	{ResourceRBAC, "角色、部门", true},
+}
+
+// Right 权限为资源+操作
+type Right struct {
+	utils.Model
+	Resource string `gorm:"size:64;uniqueIndex:idx_right" json:"resource"`
+	Verb     string `gorm:"size:16;uniqueIndex:idx_right" json:"verb"`
+	Desc     string `gorm:"size:128" json:"desc"`
+	Global   bool   `json:"global"`
+}
+
+// Role Builtin的角色拥有所有权限，不能修改和删除
+type Role struct {
+	utils.Model
+	Name    string  `gorm:"size:64;uniqueIndex" json:"name"`
+	Desc    string  `gorm:"size:256" json:"desc"`
+	Scope   string  `gorm:"size:16" json:"scope"`
+	Builtin bool    `json:"builtin"`
+	Rights  []Right `gorm:"many2many:role_rights" json:"rights"`
+}
+
+// Department 部门树，Path为根到自身的ID路径，如"/1/3/"，下级部门的Path以上级的Path开头
+type Department struct {
+	utils.Model
+	Name     string `gorm:"size:64" json:"name"`
+	ParentID uint   `gorm:"index" json:"parent_id"`
+	Path     string `gorm:"size:255;index" json:"path"`
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"time"
+
+	"tmios/internal/utils"
+)
+
+// SyncRecord 边缘端待上送的记录，中心确认后删除
+type SyncRecord struct {
+	Seq       uint64    `gorm:"primaryKey;autoIncrement" json:"seq"`
+	Kind      string    `gorm:"size:16" json:"kind"`
+	Data      string    `gorm:"type:text" json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SyncCursor 同步游标，边缘端记录已确认的Seq，中心按边缘节点记录已应用的Seq
+type SyncCursor struct {
+	Name      string    `gorm:"primaryKey;size:128" json:"name"`
+	Seq       uint64    `json:"seq"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// EdgeDevice 中心端保存的边缘设备镜像
+type EdgeDevice struct {
+	utils.Model
+	EdgeID    string `gorm:"size:64;uniqueIndex:idx_edge_device" json:"edge_id"`
+	DeviceID  uint   `gorm:"uniqueIndex:idx_edge_device" json:"device_id"`
+	Name      string `gorm:"size:64" json:"name"`
+	ModelName string `gorm:"column:model;size:64" json:"model"`
+	Config    string `gorm:"type:text" json:"config"`
+	Vals      string `gorm:"type:text" json:"vals"`
+	Deleted   bool   `json:"deleted"`
+	UpdateAt  int64  `json:"update_at"`
+}
+
+type EdgeAlarm struct {
+	utils.Model
+	EdgeID   string    `gorm:"size:64;index" json:"edge_id"`
+	DeviceID uint      `json:"device_id"`
+	Level    string    `gorm:"size:16" json:"level"`
+	Name     string    `gorm:"size:64" json:"name"`
+	Message  string    `gorm:"size:512" json:"message"`
+	Time     time.Time `json:"time"`
+}
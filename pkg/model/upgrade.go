@@ -0,0 +1,66 @@
+package model
+
+import (
+	"time"
+
+	"tmios/internal/utils"
+)
+
+// Firmware 上传的固件，同一型号的Version唯一
+type Firmware struct {
+	utils.Model
+	ModelName string `gorm:"column:model;size:64;uniqueIndex:idx_firmware_version" json:"model"`
+	Version   string `gorm:"size:64;uniqueIndex:idx_firmware_version" json:"version"`
+	Filename  string `gorm:"size:255" json:"filename"`
+	Path      string `gorm:"size:512" json:"-"`
+	Size      int64  `json:"size"`
+	SHA256    string `gorm:"column:sha256;size:64" json:"sha256"`
+	Desc      string `gorm:"size:512" json:"desc"`
+}
+
+// 升级任务状态
+const (
+	RolloutPending  = "pending"
+	RolloutRunning  = "running"
+	RolloutPaused   = "paused" // 当前阶段完成，等待进入下一阶段
+	RolloutHalted   = "halted" // 失败数超过阈值
+	RolloutDone     = "done"
+	RolloutCanceled = "canceled"
+)
+
+// Rollout 固件在一组设备上的分阶段升级，Stages为各阶段累计覆盖设备的百分比(JSON)，
+// 失败设备数超过MaxFailures时停止
+type Rollout struct {
+	utils.Model
+	Name        string `gorm:"size:64" json:"name"`
+	FirmwareID  uint   `gorm:"index" json:"firmware_id"`
+	Stages      string `gorm:"size:255" json:"stages"`
+	Stage       int    `json:"stage"`
+	MaxFailures int    `json:"max_failures"`
+	Parallel    int    `json:"parallel"`
+	AutoAdvance bool   `json:"auto_advance"`
+	Status      string `gorm:"size:16" json:"status"`
+	Message     string `gorm:"size:512" json:"message"`
+}
+
+// 单个设备的升级状态
+const (
+	UpgradePending      = "pending"
+	UpgradeTransferring = "transferring"
+	UpgradeVerifying    = "verifying"
+	UpgradeDone         = "done"
+	UpgradeFailed       = "failed"
+)
+
+// RolloutDevice Stage为设备所在阶段，Method为action或ftp
+type RolloutDevice struct {
+	utils.Model
+	RolloutID  uint       `gorm:"uniqueIndex:idx_rollout_device" json:"rollout_id"`
+	DeviceID   uint       `gorm:"uniqueIndex:idx_rollout_device" json:"device_id"`
+	Stage      int        `json:"stage"`
+	State      string     `gorm:"size:16" json:"state"`
+	Method     string     `gorm:"size:16" json:"method"`
+	Error      string     `gorm:"size:512" json:"error"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"tmios/internal/utils"
+)
+
+// User 登录用户，连续密码错误达到上限后锁定到LockedUntil；
+// 修改密码后Version加1，之前登录的会话失效；RoleID为0时没有任何权限
+type User struct {
+	utils.Model
+	Username    string     `gorm:"size:64;uniqueIndex" json:"username"`
+	Password    string     `gorm:"size:128" json:"-"`
+	Name        string     `gorm:"size:64" json:"name"`
+	Disabled    bool       `json:"disabled"`
+	Failures    int        `json:"failures"`
+	LockedUntil *time.Time `json:"locked_until"`
+	LastLogin   *time.Time `json:"last_login"`
+	Version     int        `json:"-"`
+
+	RoleID       uint `gorm:"index" json:"role_id"`
+	DepartmentID uint `gorm:"index" json:"department_id"`
+}
@@ -0,0 +1,196 @@
+// Package devicev1 tmios.device.v1 设备服务。
+//
+// 与标准gRPC服务的差异:
+//   - 没有.proto定义，消息是本包的Go结构体，使用JSON编码(lib/rpc.Codec，content-subtype为json)，
+//     DeviceMeta等动态结构无法用protobuf描述。Go客户端使用本包的DeviceClient，
+//     其他语言的客户端需要注册json codec，按本包结构体的json tag收发消息
+//   - 方法为/tmios.device.v1.DeviceService/{ListMetas,ListDevices,GetProps,SetProps,Action,WatchProps}，
+//     WatchProps为服务端流
+//   - 错误的status message为lib/errors.Error的JSON，code按HTTP状态映射(见lib/rpc.ToStatus)，
+//     如400为InvalidArgument、403为PermissionDenied、404为NotFound、409/423为FailedPrecondition、
+//     429为ResourceExhausted、503为Unavailable
+//   - 除了双向证书，配置了[[Apps]]时需要在metadata的x-token中带应用Token(见WithToken)，
+//     按应用的Role和Department鉴权：ListMetas、ListDevices、GetProps、WatchProps需要设备读权限，
+//     SetProps、Action需要设备写权限，数据范围外的设备不返回或按没有权限处理
+package devicev1
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"tmios/lib/iot/device"
+	"tmios/lib/rpc"
+)
+
+const ServiceName = "tmios.device.v1.DeviceService"
+
+// MetadataToken 应用Token所在的metadata key
+const MetadataToken = "x-token"
+
+// WithToken 调用时携带应用Token
+func WithToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, MetadataToken, token)
+}
+
+type ListMetasResp struct {
+	Metas []*device.DeviceMeta `json:"metas"`
+}
+
+type DeviceInfo struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Model     string `json:"model"`
+	ForeignID string `json:"foreign_id,omitempty"`
+	UpdateAt  int64  `json:"update_at"`
+}
+
+type ListDevicesResp struct {
+	Devices []DeviceInfo `json:"devices"`
+}
+
+type GetPropsReq struct {
+	DeviceID uint     `json:"device_id"`
+	Names    []string `json:"names"`
+}
+
+type GetPropsResp struct {
+	Vals map[string]interface{} `json:"vals"`
+}
+
+type SetPropsReq struct {
+	DeviceID uint                       `json:"device_id"`
+	Vals     map[string]json.RawMessage `json:"vals"`
+}
+
+type ActionReq struct {
+	DeviceID uint            `json:"device_id"`
+	Name     string          `json:"name"`
+	Args     json.RawMessage `json:"args"`
+}
+
+type ActionResp struct {
+	Rets json.RawMessage `json:"rets"`
+}
+
+// WatchPropsReq DeviceIDs/Names为空表示不过滤
+type WatchPropsReq struct {
+	DeviceIDs []uint   `json:"device_ids"`
+	Names     []string `json:"names"`
+}
+
+type PropChange struct {
+	DeviceID uint                   `json:"device_id"`
+	Model    string                 `json:"model"`
+	Vals     map[string]interface{} `json:"vals"`
+	Time     time.Time              `json:"time"`
+}
+
+type DeviceServer interface {
+	ListMetas(context.Context, *rpc.Empty) (*ListMetasResp, error)
+	ListDevices(context.Context, *rpc.Empty) (*ListDevicesResp, error)
+	GetProps(context.Context, *GetPropsReq) (*GetPropsResp, error)
+	SetProps(context.Context, *SetPropsReq) (*rpc.Empty, error)
+	Action(context.Context, *ActionReq) (*ActionResp, error)
+	WatchProps(*WatchPropsReq, WatchPropsServer) error
+}
+
+type WatchPropsServer interface {
+	Send(*PropChange) error
+	grpc.ServerStream
+}
+
+type watchPropsServer struct {
+	grpc.ServerStream
+}
+
+func (s *watchPropsServer) Send(m *PropChange) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*DeviceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		rpc.Unary(ServiceName, "ListMetas", DeviceServer.ListMetas),
+		rpc.Unary(ServiceName, "ListDevices", DeviceServer.ListDevices),
+		rpc.Unary(ServiceName, "GetProps", DeviceServer.GetProps),
+		rpc.Unary(ServiceName, "SetProps", DeviceServer.SetProps),
+		rpc.Unary(ServiceName, "Action", DeviceServer.Action),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "WatchProps",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				m := new(WatchPropsReq)
+				if err := stream.RecvMsg(m); err != nil {
+					return err
+				}
+				return srv.(DeviceServer).WatchProps(m, &watchPropsServer{stream})
+			},
+			ServerStreams: true,
+		},
+	},
+	Metadata: "tmios/device/v1",
+}
+
+func RegisterDeviceServer(s grpc.ServiceRegistrar, srv DeviceServer) {
+	s.RegisterService(&ServiceDesc, srv)
+}
+
+type DeviceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDeviceClient(cc grpc.ClientConnInterface) *DeviceClient {
+	return &DeviceClient{cc}
+}
+
+func (c *DeviceClient) ListMetas(ctx context.Context) (*ListMetasResp, error) {
+	return rpc.Invoke[ListMetasResp](ctx, c.cc, ServiceName, "ListMetas", &rpc.Empty{})
+}
+
+func (c *DeviceClient) ListDevices(ctx context.Context) (*ListDevicesResp, error) {
+	return rpc.Invoke[ListDevicesResp](ctx, c.cc, ServiceName, "ListDevices", &rpc.Empty{})
+}
+
+func (c *DeviceClient) GetProps(ctx context.Context, in *GetPropsReq) (*GetPropsResp, error) {
+	return rpc.Invoke[GetPropsResp](ctx, c.cc, ServiceName, "GetProps", in)
+}
+
+func (c *DeviceClient) SetProps(ctx context.Context, in *SetPropsReq) error {
+	_, err := rpc.Invoke[rpc.Empty](ctx, c.cc, ServiceName, "SetProps", in)
+	return err
+}
+
+func (c *DeviceClient) Action(ctx context.Context, in *ActionReq) (*ActionResp, error) {
+	return rpc.Invoke[ActionResp](ctx, c.cc, ServiceName, "Action", in)
+}
+
+type WatchPropsClient struct {
+	grpc.ClientStream
+}
+
+func (c *WatchPropsClient) Recv() (*PropChange, error) {
+	m := new(PropChange)
+	if err := c.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *DeviceClient) WatchProps(ctx context.Context, in *WatchPropsReq) (*WatchPropsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ServiceDesc.Streams[0], "/"+ServiceName+"/WatchProps")
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &WatchPropsClient{stream}, nil
+}
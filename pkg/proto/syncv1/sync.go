@@ -0,0 +1,164 @@
+// Package syncv1 tmios.sync.v1 边缘到中心的同步协议，消息使用JSON编码(lib/rpc.Codec)
+package syncv1
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const ServiceName = "tmios.sync.v1.Sync"
+
+const (
+	KindDevice   = "device"   // 设备实例新增、修改、删除
+	KindSnapshot = "snapshot" // 设备全部属性
+	KindPoint    = "point"    // 一次提交的属性，即历史数据
+	KindAlarm    = "alarm"
+)
+
+// Record 边缘端outbox中的一条记录，Seq单调递增
+type Record struct {
+	Seq  uint64          `json:"seq"`
+	Kind string          `json:"kind"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// DeviceData KindDevice的Data
+type DeviceData struct {
+	ID      uint            `json:"id"`
+	Name    string          `json:"name"`
+	Model   string          `json:"model"`
+	Config  json.RawMessage `json:"config,omitempty"`
+	Deleted bool            `json:"deleted"`
+}
+
+// PropsData KindSnapshot和KindPoint的Data
+type PropsData struct {
+	DeviceID uint                   `json:"device_id"`
+	Model    string                 `json:"model"`
+	Vals     map[string]interface{} `json:"vals"`
+}
+
+// AlarmData KindAlarm的Data
+type AlarmData struct {
+	DeviceID uint   `json:"device_id"`
+	Model    string `json:"model"`
+	Level    string `json:"level"`
+	Name     string `json:"name"`
+	Message  string `json:"message"`
+}
+
+// Hello 连接后边缘端发送的第一条消息，Cursor为已确认的最大Seq
+type Hello struct {
+	EdgeID string `json:"edge_id"`
+	Cursor uint64 `json:"cursor"`
+}
+
+type ActionResult struct {
+	ID    string          `json:"id"`
+	Rets  json.RawMessage `json:"rets,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// Upstream 边缘端到中心。Snapshot为连接后补发的设备和属性快照，不在outbox中，Seq为0，不需要确认
+type Upstream struct {
+	Hello        *Hello        `json:"hello,omitempty"`
+	Records      []Record      `json:"records,omitempty"`
+	Snapshot     []Record      `json:"snapshot,omitempty"`
+	ActionResult *ActionResult `json:"action_result,omitempty"`
+}
+
+type Action struct {
+	ID       string          `json:"id"`
+	DeviceID uint            `json:"device_id"`
+	Name     string          `json:"name"`
+	Args     json.RawMessage `json:"args"`
+}
+
+// Downstream 中心到边缘端，Ack为中心已持久化的最大Seq
+type Downstream struct {
+	Ack    *uint64 `json:"ack,omitempty"`
+	Action *Action `json:"action,omitempty"`
+}
+
+type SyncServer interface {
+	Stream(StreamServer) error
+}
+
+type StreamServer interface {
+	Send(*Downstream) error
+	Recv() (*Upstream, error)
+	grpc.ServerStream
+}
+
+type streamServer struct {
+	grpc.ServerStream
+}
+
+func (s *streamServer) Send(m *Downstream) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *streamServer) Recv() (*Upstream, error) {
+	m := new(Upstream)
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*SyncServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Stream",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(SyncServer).Stream(&streamServer{stream})
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "tmios/sync/v1",
+}
+
+func RegisterSyncServer(s grpc.ServiceRegistrar, srv SyncServer) {
+	s.RegisterService(&ServiceDesc, srv)
+}
+
+type SyncClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSyncClient(cc grpc.ClientConnInterface) *SyncClient {
+	return &SyncClient{cc}
+}
+
+type StreamClient struct {
+	grpc.ClientStream
+}
+
+func (c *StreamClient) Send(m *Upstream) error {
+	return c.ClientStream.SendMsg(m)
+}
+
+func (c *StreamClient) Recv() (*Downstream, error) {
+	m := new(Downstream)
+	if err := c.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *SyncClient) Stream(ctx context.Context) (*StreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ServiceDesc.Streams[0], "/"+ServiceName+"/Stream")
+	if err != nil {
+		return nil, err
+	}
+	return &StreamClient{stream}, nil
+}
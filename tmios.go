@@ -1,21 +1,52 @@
 package main
 
 import (
-	"tmios/internal/cmp"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"tmios/internal/cmd"
 	"tmios/internal/config"
-	"tmios/internal/http"
-	"tmios/pkg/api"
+	"tmios/internal/gen"
 )
 
 func main() {
-	err := cmp.NewCmp(
-		config.NewConfig(
-			config.WithConf(config.DefaultConfigFile, true),
-			config.WithMysql(),
-		),
-		http.NewHttp(api.WithTest()),
-	).Run()
-	if err != nil {
+	commands := cmd.NewCmd(
+		&cmd.Command{Name: "gen driver", Usage: "generate device driver from spec", Run: gen.DriverCmd},
+		&cmd.Command{Name: "config check", Usage: "check the config without starting anything", Run: checkCmd},
+		&cmd.Command{Name: "secret keygen", Usage: "generate a key for encrypted config values", Run: config.SecretKeygenCmd},
+		&cmd.Command{Name: "secret encrypt", Usage: "encrypt a config value as enc:v1:...", Run: config.SecretEncryptCmd},
+		&cmd.Command{Name: "secret rotate", Usage: "re-encrypt config values with the first key", Run: config.SecretRotateCmd},
+		&cmd.Command{Name: "config print", Usage: "print the effective config and where each field comes from", Run: config.PrintCmd},
+	)
+	if commands.Match(os.Args[1:]) {
+		if err := commands.Run(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		return
 	}
+
+	// 配置依次来自默认值、配置文件、TMIOS_开头的环境变量和命令行参数
+	loader := config.NewLoader(config.DefaultConfigFile)
+	fs := flag.NewFlagSet("tmios", flag.ExitOnError)
+	loader.RegisterFlags(fs)
+	_ = fs.Parse(os.Args[1:])
+
+	cnf := config.NewConfig(config.WithLoader(loader, true), config.WithLog())
+	if err := checkComponents(cnf.Conf()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", loader.File(), err)
+		os.Exit(1)
+	}
+	cnf.AddValidator(checkComponents)
+	c, err := newCmp(cnf.Conf().Components)
+	if err == nil {
+		err = c.Run()
+	}
+	if err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
 }